@@ -0,0 +1,71 @@
+/*
+ * Adaptive block granularity
+ *
+ * Fixed-width blocks (in key space) put many keys in blocks covering dense
+ * regions and few keys in blocks covering sparse ones. Cutting a block every
+ * targetKeysPerBlock keys instead makes blocks narrow where keys are dense and
+ * wide where they are sparse. The boundaries are stored alongside the model,
+ * so the model only has to get close and a short binary search over the
+ * boundaries yields the exact block.
+ */
+
+package y
+
+import (
+	"sort"
+)
+
+// AdaptiveBlocks is a block layout with variable key-space widths together
+// with the learned model that predicts it.
+type AdaptiveBlocks struct {
+	Model      *LearnedIndex // Predicts block index from key
+	Boundaries []uint32      // First key of each block, ascending
+}
+
+// TrainAdaptiveBlocks cuts sortedKeys into blocks of about targetKeysPerBlock
+// keys each and trains a model mapping keys to those blocks. Equal keys are
+// never split across two blocks, so a block may hold a few more keys than the
+// target.
+func TrainAdaptiveBlocks(sortedKeys []uint32, targetKeysPerBlock int) *AdaptiveBlocks {
+	if targetKeysPerBlock < 1 {
+		targetKeysPerBlock = 1
+	}
+	ab := &AdaptiveBlocks{}
+	blockIndices := make([]uint32, len(sortedKeys))
+	inBlock := 0
+	for i, k := range sortedKeys {
+		if len(ab.Boundaries) == 0 || (inBlock >= targetKeysPerBlock && k != sortedKeys[i-1]) {
+			ab.Boundaries = append(ab.Boundaries, k)
+			inBlock = 0
+		}
+		blockIndices[i] = uint32(len(ab.Boundaries) - 1)
+		inBlock++
+	}
+	ab.Model = TrainLearnedIndex(sortedKeys, blockIndices, len(ab.Boundaries))
+	return ab
+}
+
+// NumBlocks returns the number of blocks in the layout.
+func (ab *AdaptiveBlocks) NumBlocks() int {
+	return len(ab.Boundaries)
+}
+
+// Predict returns the block that contains key: the last block whose first key
+// is <= key, or 0 if key precedes every block. The model's predicted range is
+// searched first and the full boundary list only if the key falls outside it.
+func (ab *AdaptiveBlocks) Predict(key uint32) int {
+	n := len(ab.Boundaries)
+	if n == 0 {
+		return 0
+	}
+	_, minBlock, maxBlock := ab.Model.Predict(key)
+	lo, hi := 0, n
+	if ab.Boundaries[minBlock] <= key && (maxBlock+1 >= n || ab.Boundaries[maxBlock+1] > key) {
+		lo, hi = minBlock, maxBlock+1
+	}
+	// Index of the first block in [lo, hi) whose first key is > key.
+	idx := lo + sort.Search(hi-lo, func(i int) bool {
+		return ab.Boundaries[lo+i] > key
+	})
+	return max(idx-1, 0)
+}
@@ -0,0 +1,79 @@
+/*
+ * Tests for adaptive block granularity
+ */
+
+package y
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestTrainAdaptiveBlocksSkewed(t *testing.T) {
+	// Half the keys are packed into [0, 20000), the other half spread over
+	// [1<<20, 1<<31).
+	rng := rand.New(rand.NewSource(1))
+	keys := make([]uint32, 0, 20000)
+	for i := 0; i < 10000; i++ {
+		keys = append(keys, uint32(i*2))
+	}
+	for i := 0; i < 10000; i++ {
+		keys = append(keys, 1<<20+uint32(rng.Int31n(1<<31-1<<20)))
+	}
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+
+	target := 100
+	ab := TrainAdaptiveBlocks(keys, target)
+
+	// Dense region blocks must be much narrower in key space than sparse ones.
+	var denseWidth, sparseWidth float64
+	var denseBlocks, sparseBlocks int
+	for b := 0; b+1 < ab.NumBlocks(); b++ {
+		width := float64(ab.Boundaries[b+1] - ab.Boundaries[b])
+		if ab.Boundaries[b+1] < 20000 {
+			denseWidth += width
+			denseBlocks++
+		} else if ab.Boundaries[b] >= 1<<20 {
+			sparseWidth += width
+			sparseBlocks++
+		}
+	}
+	denseWidth /= float64(denseBlocks)
+	sparseWidth /= float64(sparseBlocks)
+	t.Logf("%d blocks: dense avg width %.0f (%d blocks), sparse avg width %.0f (%d blocks)",
+		ab.NumBlocks(), denseWidth, denseBlocks, sparseWidth, sparseBlocks)
+	if denseWidth*100 > sparseWidth {
+		t.Errorf("Expected finer blocks in the dense region: %.0f vs %.0f", denseWidth, sparseWidth)
+	}
+
+	for i, k := range keys {
+		if got, want := ab.Predict(k), i/target; got != want {
+			t.Fatalf("Key %d (%d): predicted block %d, want %d", i, k, got, want)
+		}
+	}
+}
+
+func TestTrainAdaptiveBlocksDuplicates(t *testing.T) {
+	keys := []uint32{1, 2, 3, 3, 3, 3, 4, 5, 6, 7}
+	ab := TrainAdaptiveBlocks(keys, 3)
+	want := []uint32{1, 4, 7}
+	if !slices.Equal(ab.Boundaries, want) {
+		t.Fatalf("Expected boundaries %v, got %v", want, ab.Boundaries)
+	}
+	for _, tc := range []struct {
+		key   uint32
+		block int
+	}{
+		{0, 0}, {1, 0}, {3, 0}, {4, 1}, {6, 1}, {7, 2}, {100, 2},
+	} {
+		if got := ab.Predict(tc.key); got != tc.block {
+			t.Errorf("Predict(%d) = %d, want %d", tc.key, got, tc.block)
+		}
+	}
+
+	if empty := TrainAdaptiveBlocks(nil, 10); empty.NumBlocks() != 0 || empty.Predict(5) != 0 {
+		t.Error("Expected no blocks for empty input")
+	}
+}
@@ -0,0 +1,53 @@
+/*
+ * BlockIndex - maps raw keys to block positions for the learned index
+ *
+ * Learned indexes only work on an ordering-preserving input: a key hash
+ * scatters neighbouring keys across the hash space, so it does not correlate
+ * with the block a key lands in, while the key's position in sorted order
+ * does. A lookup key must therefore first be converted to an ordinal
+ * position. BlockIndex does this with a binary search over the first key of
+ * each block, which SSTables already keep in their table index.
+ */
+
+package y
+
+import (
+	"bytes"
+	"sort"
+)
+
+// BlockIndex holds the sorted first key of each block of a table.
+type BlockIndex struct {
+	firstKeys [][]byte
+}
+
+// NewBlockIndex creates a BlockIndex from the first key of each block. The keys
+// must be sorted in ascending order and are not copied, so the caller must not
+// modify them afterwards.
+func NewBlockIndex(firstKeys [][]byte) *BlockIndex {
+	return &BlockIndex{firstKeys: firstKeys}
+}
+
+// NumBlocks returns the number of blocks in the index.
+func (bi *BlockIndex) NumBlocks() int {
+	if bi == nil {
+		return 0
+	}
+	return len(bi.firstKeys)
+}
+
+// Position returns the ordinal of the block that may contain key, i.e. the last
+// block whose first key is <= key. found is false if the key sorts before the
+// first block (or the index is empty), in which case the key cannot be in the
+// table. A key after the last block's first key maps to the last block.
+func (bi *BlockIndex) Position(key []byte) (pos uint32, found bool) {
+	n := bi.NumBlocks()
+	// Index of the first block whose first key is > key.
+	idx := sort.Search(n, func(i int) bool {
+		return bytes.Compare(bi.firstKeys[i], key) > 0
+	})
+	if idx == 0 {
+		return 0, false
+	}
+	return uint32(idx - 1), true
+}
@@ -0,0 +1,78 @@
+/*
+ * Tests for the BlockIndex key-to-position helper
+ */
+
+package y
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBlockIndexPosition(t *testing.T) {
+	firstKeys := [][]byte{
+		[]byte("apple"),
+		[]byte("cherry"),
+		[]byte("grape"),
+		[]byte("mango"),
+	}
+	bi := NewBlockIndex(firstKeys)
+	if bi.NumBlocks() != 4 {
+		t.Fatalf("Expected 4 blocks, got %d", bi.NumBlocks())
+	}
+
+	tests := []struct {
+		key   string
+		pos   uint32
+		found bool
+	}{
+		// Before the first key.
+		{"aardvark", 0, false},
+		// Exactly at block boundaries.
+		{"apple", 0, true},
+		{"cherry", 1, true},
+		{"grape", 2, true},
+		{"mango", 3, true},
+		// Within blocks.
+		{"banana", 0, true},
+		{"date", 1, true},
+		{"kiwi", 2, true},
+		// After the last block's first key.
+		{"zucchini", 3, true},
+	}
+	for _, tc := range tests {
+		pos, found := bi.Position([]byte(tc.key))
+		if pos != tc.pos || found != tc.found {
+			t.Errorf("Position(%q) = (%d, %v), want (%d, %v)",
+				tc.key, pos, found, tc.pos, tc.found)
+		}
+	}
+}
+
+func TestBlockIndexEmpty(t *testing.T) {
+	bi := NewBlockIndex(nil)
+	if pos, found := bi.Position([]byte("key")); pos != 0 || found {
+		t.Errorf("Expected (0, false) for empty index, got (%d, %v)", pos, found)
+	}
+}
+
+func TestBlockIndexSortedKeys(t *testing.T) {
+	keyCount := 1000
+	keysPerBlock := 10
+	keys := make([][]byte, keyCount)
+	var firstKeys [][]byte
+	for i := 0; i < keyCount; i++ {
+		keys[i] = []byte(fmt.Sprintf("key_%010d", i))
+		if i%keysPerBlock == 0 {
+			firstKeys = append(firstKeys, keys[i])
+		}
+	}
+
+	bi := NewBlockIndex(firstKeys)
+	for i, key := range keys {
+		pos, found := bi.Position(key)
+		if !found || int(pos) != i/keysPerBlock {
+			t.Fatalf("Key %d: got (%d, %v), want (%d, true)", i, pos, found, i/keysPerBlock)
+		}
+	}
+}
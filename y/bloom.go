@@ -4,13 +4,24 @@
 
 package y
 
-import "math"
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"slices"
+)
 
-// Filter is an encoded set of []byte keys.
+// Filter is an encoded set of []byte keys. A Filter is never modified after
+// it is built, so it is safe for concurrent use.
+// Format: [bits:n][k:1], so a filter of len(f) bytes has 8*(len(f)-1) bits.
+// HybridFilter.BloomBits holds only the bits, with k kept alongside; see
+// HybridFilter.ToFilter.
 type Filter []byte
 
+// MayContainKey hashes k with the default hash function (see SetDefaultHash)
+// and checks it against the filter.
 func (f Filter) MayContainKey(k []byte) bool {
-	return f.MayContain(Hash(k))
+	return f.MayContain(defaultHash(k))
 }
 
 // MayContain returns whether the filter may contain given key. False positives
@@ -26,6 +37,7 @@ func (f Filter) MayContain(h uint32) bool {
 		return true
 	}
 	nBits := uint32(8 * (len(f) - 1))
+	// The layout has no version, so the probe step is fixed; see SizedFilter.
 	delta := h>>17 | h<<15
 	for j := uint8(0); j < k; j++ {
 		bitPos := h % nBits
@@ -37,11 +49,279 @@ func (f Filter) MayContain(h uint32) bool {
 	return true
 }
 
+// DeserializeFilter validates data as an encoded bloom filter and returns it
+// without copying. The returned error wraps ErrShortBuffer if there is no room
+// for both bits and the trailing k byte, or ErrUnsupportedVersion if k uses one
+// of the values reserved for other encodings.
+func DeserializeFilter(data []byte) (Filter, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("bloom filter: got %d bytes, want at least 2: %w", len(data), ErrShortBuffer)
+	}
+	if k := data[len(data)-1]; k > 30 {
+		return nil, fmt.Errorf("bloom filter encoding k=%d: %w", k, ErrUnsupportedVersion)
+	}
+	return Filter(data), nil
+}
+
+// filterGobVersion tags the gob encoding of a Filter, ahead of the filter
+// bytes themselves.
+const filterGobVersion = 1
+
+// GobEncode implements gob.GobEncoder: a version byte followed by the filter.
+func (f Filter) GobEncode() ([]byte, error) {
+	return append([]byte{filterGobVersion}, f...), nil
+}
+
+// GobDecode implements gob.GobDecoder, validating the payload as
+// DeserializeFilter does. The filter is copied out of data. An empty filter
+// decodes to nil.
+func (f *Filter) GobDecode(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("bloom filter gob: empty payload: %w", ErrShortBuffer)
+	}
+	if data[0] != filterGobVersion {
+		return fmt.Errorf("bloom filter gob version %d: %w", data[0], ErrUnsupportedVersion)
+	}
+	if len(data) == 1 {
+		*f = nil
+		return nil
+	}
+	decoded, err := DeserializeFilter(data[1:])
+	if err != nil {
+		return err
+	}
+	*f = slices.Clone(decoded)
+	return nil
+}
+
+// FilterFromBytes returns data as a Filter without copying or validating it,
+// e.g. to query a filter in place in an mmap'd SSTable. The filter aliases
+// data: the caller must keep the backing memory alive (and mapped) for as
+// long as the filter is used, and any writes to it are seen by queries. Use
+// DeserializeFilter to validate the encoding first.
+func FilterFromBytes(data []byte) Filter {
+	return Filter(data)
+}
+
+// BitDensity returns the fraction of bits set in the filter. A density near 0.5
+// indicates an optimally sized filter; near 1.0 the filter is saturated and
+// returns true for almost every key.
+func (f Filter) BitDensity() float64 {
+	if len(f) < 2 {
+		return 0
+	}
+	return bitDensity(f[:len(f)-1])
+}
+
+// CountSetBits returns the number of bits set in the filter, excluding the
+// trailing byte that holds k.
+func (f Filter) CountSetBits() int {
+	if len(f) < 2 {
+		return 0
+	}
+	return countSetBits(f[:len(f)-1])
+}
+
+// VerifyFPRate estimates the false positive rate analytically from the bit
+// density as density^k, and returns an error if it deviates from target by more
+// than tolerance. Unlike probing with random keys, this is deterministic.
+func (f Filter) VerifyFPRate(target float64, tolerance float64) error {
+	if len(f) < 2 {
+		return fmt.Errorf("bloom filter too short to estimate: %d bytes", len(f))
+	}
+	k := int(f[len(f)-1])
+	estimated := math.Pow(f.BitDensity(), float64(k))
+	if math.Abs(estimated-target) > tolerance {
+		return fmt.Errorf("estimated bloom FP rate %.4f (density %.3f, k=%d) deviates from target %.4f by more than %.4f",
+			estimated, f.BitDensity(), k, target, tolerance)
+	}
+	return nil
+}
+
+// Stats reports the parameters and load of the filter, decoded from its bytes.
+// EstimatedFPRate is density^k, as VerifyFPRate computes it; a filter with a
+// reserved k matches every key and reports 1.
+func (f Filter) Stats() FilterStats {
+	if len(f) < 2 {
+		return FilterStats{ByteLen: len(f)}
+	}
+	k := int(f[len(f)-1])
+	stats := FilterStats{
+		Bits:      8 * (len(f) - 1),
+		HashFuncs: k,
+		ByteLen:   len(f),
+		SetBits:   f.CountSetBits(),
+		Density:   f.BitDensity(),
+	}
+	if k > 30 {
+		stats.EstimatedFPRate = 1
+	} else {
+		stats.EstimatedFPRate = math.Pow(stats.Density, float64(k))
+	}
+	return stats
+}
+
+// FilterStats contains statistics about a bloom filter
+type FilterStats struct {
+	Bits            int     // Number of filter bits, excluding the k byte
+	HashFuncs       int     // k, the number of probes per key
+	ByteLen         int     // Encoded length, including the k byte
+	SetBits         int     // Number of bits set
+	Density         float64 // Fraction of bits set; near 1.0 means saturated
+	EstimatedFPRate float64 // Analytical false positive rate, density^k
+}
+
+func countSetBits(b []byte) int {
+	setBits := 0
+	for _, x := range b {
+		setBits += bits.OnesCount8(x)
+	}
+	return setBits
+}
+
+// bitDensity returns the fraction of set bits in b.
+func bitDensity(b []byte) float64 {
+	if len(b) == 0 {
+		return 0
+	}
+	return float64(countSetBits(b)) / float64(len(b)*8)
+}
+
+// MayContainBatch returns MayContain for each of keyHashes. The filter length
+// and k are decoded once for the whole batch, which helps when probing one
+// filter with many keys, e.g. in a bloom join.
+func (f Filter) MayContainBatch(keyHashes []uint32) []bool {
+	return f.AppendMayContainBatch(make([]bool, 0, len(keyHashes)), keyHashes)
+}
+
+// AppendMayContainBatch appends MayContainBatch(keyHashes) to dst and returns
+// the extended slice. Passing the previous result truncated to dst[:0] reuses
+// its array, so repeated batches need not allocate.
+func (f Filter) AppendMayContainBatch(dst []bool, keyHashes []uint32) []bool {
+	if len(f) < 2 {
+		return append(dst, make([]bool, len(keyHashes))...)
+	}
+	k := f[len(f)-1]
+	if k > 30 {
+		// Reserved encoding, see MayContain.
+		for range keyHashes {
+			dst = append(dst, true)
+		}
+		return dst
+	}
+	nBits := uint32(8 * (len(f) - 1))
+	for _, h := range keyHashes {
+		delta := h>>17 | h<<15
+		j := uint8(0)
+		for ; j < k; j++ {
+			bitPos := h % nBits
+			if f[bitPos/8]&(1<<(bitPos%8)) == 0 {
+				break
+			}
+			h += delta
+		}
+		dst = append(dst, j == k)
+	}
+	return dst
+}
+
+// ContainsAll reports whether MayContain is true for every one of keyHashes,
+// e.g. as a self-check that a filter built or merged from a key set has no
+// false negatives for it. Like MayContainBatch, it decodes the filter once,
+// and it stops at the first hash the filter rejects. It is true for no hashes.
+func (f Filter) ContainsAll(keyHashes []uint32) bool {
+	if len(keyHashes) == 0 {
+		return true
+	}
+	if len(f) < 2 {
+		return false
+	}
+	k := f[len(f)-1]
+	if k > 30 {
+		// Reserved encoding, see MayContain.
+		return true
+	}
+	nBits := uint32(8 * (len(f) - 1))
+	for _, h := range keyHashes {
+		delta := h>>17 | h<<15
+		for j := uint8(0); j < k; j++ {
+			bitPos := h % nBits
+			if f[bitPos/8]&(1<<(bitPos%8)) == 0 {
+				return false
+			}
+			h += delta
+		}
+	}
+	return true
+}
+
+// Downsize returns a copy of the filter shrunk by factor, without the original
+// keys: bit i of the result is the OR of bits i, i+m', i+2m', ... of f, where
+// m' is the new bit count. Since m' divides the old bit count m, a key's bit
+// positions h mod m' are its old positions folded the same way, so every key
+// in f is still reported present. k is unchanged. factor must divide the
+// number of filter bytes (excluding the trailing k byte).
+//
+// The price is a higher false positive rate: n keys in m bits with k hashes
+// give about (1 - e^(-kn/m))^k, and folding replaces m with m/factor. A
+// 10 bits/key filter with k=6 goes from 0.8% to about 12% when folded by 2,
+// and is no longer using its optimal k.
+func (f Filter) Downsize(factor int) (Filter, error) {
+	if len(f) < 2 {
+		return nil, fmt.Errorf("bloom filter: got %d bytes, want at least 2: %w", len(f), ErrShortBuffer)
+	}
+	if k := f[len(f)-1]; k > 30 {
+		return nil, fmt.Errorf("bloom filter encoding k=%d: %w", k, ErrUnsupportedVersion)
+	}
+	nBytes := len(f) - 1
+	if factor < 1 || nBytes%factor != 0 {
+		return nil, fmt.Errorf("bloom filter of %d bytes cannot be folded by %d", nBytes, factor)
+	}
+	newBytes := nBytes / factor
+	folded := make(Filter, newBytes+1)
+	for i, b := range f[:nBytes] {
+		folded[i%newBytes] |= b
+	}
+	folded[newBytes] = f[nBytes]
+	return folded, nil
+}
+
+// MightIntersect reports whether the key sets of a and b may share a key. A
+// shared key sets the same bits in both filters, so if no bit is set in both,
+// the sets are certainly disjoint; otherwise they may or may not intersect.
+// The check is only meaningful for filters of equal size and k: for any other
+// pair, and for reserved encodings, it conservatively returns true.
+//
+// Overlapping bits are very likely in dense filters even for disjoint sets,
+// so this mostly helps with sparsely populated filters.
+func MightIntersect(a, b Filter) bool {
+	if len(a) < 2 || len(b) < 2 {
+		// An empty filter holds no keys.
+		return false
+	}
+	if len(a) != len(b) || a[len(a)-1] != b[len(b)-1] || a[len(a)-1] > 30 {
+		return true
+	}
+	for i := 0; i < len(a)-1; i++ {
+		if a[i]&b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // NewFilter returns a new Bloom filter that encodes a set of []byte keys with
 // the given number of bits per key, approximately.
 //
 // A good bitsPerKey value is 10, which yields a filter with ~ 1% false
 // positive rate.
+//
+// With no keys, the filter has no bits set and MayContain returns false for
+// every key. The same holds for TrainLearnedIndex, TrainHybridFilter and
+// TrainCompactHybridFilter: a table with no keys cannot hold the one looked
+// up, so rejecting it is exact, never a false negative, and lets a read skip
+// the table without searching it. Accepting every key would only cost a
+// pointless search.
 func NewFilter(keys []uint32, bitsPerKey int) Filter {
 	return Filter(appendFilter(nil, keys, bitsPerKey))
 }
@@ -54,33 +334,57 @@ func BloomBitsPerKey(numEntries int, fp float64) int {
 	return int(locs)
 }
 
+// NewFilterK returns a new Bloom filter of nBits bits (rounded up to a whole
+// byte) using exactly k hash functions, independently of the number of keys.
+// k is clamped to [1, 30] and stored in the trailing byte, as with NewFilter.
+// nBits is clamped to [8, maxFilterBits].
+func NewFilterK(keys []uint32, nBits, k int) Filter {
+	return Filter(appendFilterK(nil, keys, int64(nBits), k))
+}
+
 func appendFilter(buf []byte, keys []uint32, bitsPerKey int) []byte {
-	if bitsPerKey < 0 {
-		bitsPerKey = 0
+	return appendFilterK(buf, keys, filterBits(len(keys), bitsPerKey), filterK(bitsPerKey))
+}
+
+// maxFilterBits caps the size of a bloom filter. Bit positions are computed
+// modulo the bit count in uint32 arithmetic, so the count must fit in a
+// uint32; it is kept a multiple of 8 so that every position maps to a byte.
+const maxFilterBits = math.MaxUint32 &^ 7
+
+// filterBits returns the number of bits for a bloom filter over keyCount keys
+// at bitsPerKey bits each: at least 64, at most maxFilterBits, and rounded up
+// to a whole byte. The product is computed in int64, so it cannot overflow on
+// 32-bit platforms, and saturates rather than wrapping for absurd inputs.
+func filterBits(keyCount, bitsPerKey int) int64 {
+	n, b := int64(max(keyCount, 0)), int64(max(bitsPerKey, 0))
+	var nBits int64
+	if b != 0 && n > maxFilterBits/b {
+		nBits = maxFilterBits
+	} else {
+		nBits = n * b
 	}
-	// 0.69 is approximately ln(2).
-	k := uint32(float64(bitsPerKey) * 0.69)
+	// For small len(keys), we can see a very high false positive rate. Fix it
+	// by enforcing a minimum bloom filter length.
+	nBits = max(nBits, 64)
+	return min((nBits+7)/8*8, maxFilterBits)
+}
+
+func appendFilterK(buf []byte, keys []uint32, nBits int64, k int) []byte {
 	if k < 1 {
 		k = 1
 	}
 	if k > 30 {
 		k = 30
 	}
-
-	nBits := len(keys) * bitsPerKey
-	// For small len(keys), we can see a very high false positive rate. Fix it
-	// by enforcing a minimum bloom filter length.
-	if nBits < 64 {
-		nBits = 64
-	}
-	nBytes := (nBits + 7) / 8
-	nBits = nBytes * 8
+	nBits = min(max(nBits, 8), maxFilterBits)
+	nBytes := int((nBits + 7) / 8)
+	modulus := uint32(nBytes * 8)
 	buf, filter := extend(buf, nBytes+1)
 
 	for _, h := range keys {
 		delta := h>>17 | h<<15
-		for j := uint32(0); j < k; j++ {
-			bitPos := h % uint32(nBits)
+		for j := 0; j < k; j++ {
+			bitPos := h % modulus
 			filter[bitPos/8] |= 1 << (bitPos % 8)
 			h += delta
 		}
@@ -140,6 +444,24 @@ func Hash(b []byte) uint32 {
 	return h
 }
 
+// HashSuffix hashes data[prefixLen:], ignoring a fixed-length key prefix such
+// as a tenant or table ID. If prefixLen exceeds len(data) the empty suffix is
+// hashed; a negative prefixLen hashes all of data.
+//
+// Stripping the prefix makes hashes depend only on the variable part of the
+// key, so tables that differ only in their prefix ("tenant1_", "tenant2_")
+// produce the same hashes, block layouts and trained models, and a model
+// trained on one can be reused for the others. It does not make hashes
+// follow key order: Hash mixes every input byte, so the suffix hash is no
+// better correlated with position than the full-key hash, and models that
+// need order-preserving inputs should be trained on positions instead. Note
+// also that keys with equal suffixes collide across prefixes, so a filter
+// shared between prefixes must not use HashSuffix.
+func HashSuffix(data []byte, prefixLen int) uint32 {
+	prefixLen = min(max(prefixLen, 0), len(data))
+	return Hash(data[prefixLen:])
+}
+
 // FilterPolicy implements the db.FilterPolicy interface from the leveldb/db
 // package.
 //
@@ -5,6 +5,15 @@
 package y
 
 import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
 	"testing"
 )
 
@@ -124,6 +133,409 @@ loop:
 	}
 }
 
+func TestBloomBitDensity(t *testing.T) {
+	hashes := make([]uint32, 10000)
+	for i := range hashes {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+	}
+
+	// NewFilter picks k ≈ 0.69 * bitsPerKey, which keeps density near 0.5 once
+	// there are enough bits per key. Below that, k is clamped to 1 and the
+	// filter saturates, so density falls as bits are added.
+	under := NewFilter(hashes, 1).BitDensity()
+	t.Logf("bitsPerKey=1: density %.3f", under)
+	for _, bitsPerKey := range []int{2, 5, 10, 20} {
+		density := NewFilter(hashes, bitsPerKey).BitDensity()
+		t.Logf("bitsPerKey=%d: density %.3f", bitsPerKey, density)
+		if density >= under {
+			t.Errorf("bitsPerKey=%d: density %.3f not below under-sized density %.3f",
+				bitsPerKey, density, under)
+		}
+		if density < 0.35 || density > 0.55 {
+			t.Errorf("bitsPerKey=%d: density %.3f not near 0.5", bitsPerKey, density)
+		}
+	}
+
+	if d := NewFilter(nil, 10).BitDensity(); d != 0 {
+		t.Errorf("Expected density 0 for empty filter, got %.3f", d)
+	}
+
+	// A tiny bloom component with many keys is saturated.
+	blocks := make([]uint32, len(hashes))
+	stats := TrainHybridFilter(hashes, blocks, 1, HybridFilterConfig{BloomSizeBytes: 16}).Stats()
+	if stats.BloomBitDensity < 0.99 {
+		t.Errorf("Expected saturated hybrid bloom, got density %.3f", stats.BloomBitDensity)
+	}
+}
+
+func TestNewFilterKSweep(t *testing.T) {
+	keyCount := 10000
+	nBits := 100000
+	hashes := make([]uint32, keyCount)
+	for i := range hashes {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+	}
+	probes := make([]uint32, 200000)
+	rng := rand.New(rand.NewSource(1))
+	for i := range probes {
+		probes[i] = rng.Uint32()
+	}
+
+	// The optimum is k = (m/n) * ln(2).
+	optimalK := float64(nBits) / float64(keyCount) * math.Ln2
+	bestK, bestFP := 0, 1.0
+	for k := 1; k <= 14; k++ {
+		f := NewFilterK(hashes, nBits, k)
+		if len(f) != nBits/8+1 || int(f[len(f)-1]) != k {
+			t.Fatalf("k=%d: unexpected filter layout: %d bytes, trailing k %d", k, len(f), f[len(f)-1])
+		}
+		fp := 0
+		for _, h := range probes {
+			if f.MayContain(h) {
+				fp++
+			}
+		}
+		fpRate := float64(fp) / float64(len(probes))
+		t.Logf("k=%2d: FP %.3f%%", k, fpRate*100)
+		if fpRate < bestFP {
+			bestK, bestFP = k, fpRate
+		}
+	}
+	t.Logf("Minimum FP %.3f%% at k=%d (theoretical optimum %.2f)", bestFP*100, bestK, optimalK)
+	if math.Abs(float64(bestK)-optimalK) > 2 {
+		t.Errorf("FP minimum at k=%d, too far from theoretical optimum %.2f", bestK, optimalK)
+	}
+}
+
+func TestNewFilterMatchesNewFilterK(t *testing.T) {
+	hashes := []uint32{Hash([]byte("hello")), Hash([]byte("world"))}
+	// NewFilter(hashes, 10) uses the 64-bit minimum and k = int(10 * 0.69).
+	if got, want := NewFilter(hashes, 10).String(), NewFilterK(hashes, 64, 6).String(); got != want {
+		t.Errorf("NewFilter and NewFilterK disagree:\n%s\n%s", got, want)
+	}
+}
+
+func TestBloomVerifyFPRate(t *testing.T) {
+	hashes := make([]uint32, 10000)
+	for i := range hashes {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+	}
+
+	f := NewFilter(hashes, 10)
+	if got := f.CountSetBits(); got <= 0 || got > 8*(len(f)-1) {
+		t.Fatalf("CountSetBits out of range: %d", got)
+	}
+	if err := f.VerifyFPRate(0.01, 0.005); err != nil {
+		t.Errorf("Expected 10 bits/key filter to meet 1%% target: %v", err)
+	}
+
+	// 2 bits/key cannot reach a 1% false positive rate.
+	under := NewFilter(hashes, 2)
+	err := under.VerifyFPRate(0.01, 0.005)
+	if err == nil {
+		t.Fatal("Expected an error for an under-sized filter")
+	}
+	t.Logf("Under-sized filter: %v", err)
+
+	if err := Filter(nil).VerifyFPRate(0.01, 0.005); err == nil {
+		t.Error("Expected an error for an empty filter")
+	}
+}
+
+func TestFilterStats(t *testing.T) {
+	hashes := GenerateSortedKeyHashes(10000)
+	for _, bitsPerKey := range []int{2, 10, 20} {
+		f := NewFilter(hashes, bitsPerKey)
+		stats := f.Stats()
+		// k = bitsPerKey * ln(2), truncated, as NewFilter chooses it.
+		if want := int(float64(bitsPerKey) * 0.69); stats.HashFuncs != want {
+			t.Errorf("bitsPerKey=%d: HashFuncs = %d, want %d", bitsPerKey, stats.HashFuncs, want)
+		}
+		if want := len(hashes) * bitsPerKey; stats.Bits != want {
+			t.Errorf("bitsPerKey=%d: Bits = %d, want %d", bitsPerKey, stats.Bits, want)
+		}
+		if stats.ByteLen != len(f) || stats.SetBits != f.CountSetBits() || stats.Density != f.BitDensity() {
+			t.Errorf("bitsPerKey=%d: inconsistent stats %+v", bitsPerKey, stats)
+		}
+		if err := f.VerifyFPRate(stats.EstimatedFPRate, 1e-12); err != nil {
+			t.Errorf("bitsPerKey=%d: %v", bitsPerKey, err)
+		}
+	}
+	if got := NewFilter(hashes, 10).Stats().EstimatedFPRate; got < 0.005 || got > 0.015 {
+		t.Errorf("10 bits/key: EstimatedFPRate = %.4f, want about 1%%", got)
+	}
+
+	if got := Filter(nil).Stats(); got != (FilterStats{}) {
+		t.Errorf("Empty filter: got %+v", got)
+	}
+	if got := (Filter{0xff, 31}).Stats(); got.EstimatedFPRate != 1 {
+		t.Errorf("Reserved k: EstimatedFPRate = %v, want 1", got.EstimatedFPRate)
+	}
+}
+
+func TestDeserializeFilter(t *testing.T) {
+	f := NewFilter([]uint32{Hash([]byte("hello")), Hash([]byte("world"))}, 10)
+	got, err := DeserializeFilter(f)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got.String() != f.String() {
+		t.Error("Roundtrip mismatch")
+	}
+
+	if _, err := DeserializeFilter([]byte{0x01}); !errors.Is(err, ErrShortBuffer) {
+		t.Errorf("Expected ErrShortBuffer, got %v", err)
+	}
+	reserved := append(bytes.Clone(f[:len(f)-1]), 31)
+	if _, err := DeserializeFilter(reserved); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestFilterFromBytes(t *testing.T) {
+	hashes := []uint32{Hash([]byte("hello")), Hash([]byte("world"))}
+	backing := bytes.Clone(NewFilter(hashes, 10))
+	f := FilterFromBytes(backing)
+	for _, h := range hashes {
+		if !f.MayContain(h) {
+			t.Fatalf("False negative for %d", h)
+		}
+	}
+
+	// Clearing the backing array empties the view.
+	clear(backing[:len(backing)-1])
+	for _, h := range hashes {
+		if f.MayContain(h) {
+			t.Errorf("Hash %d still present after clearing the backing array", h)
+		}
+	}
+}
+
+func TestFilterGobRoundtrip(t *testing.T) {
+	hashes := make([]uint32, 1000)
+	for i := range hashes {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+	}
+	type table struct {
+		Bloom Filter
+		Empty Filter
+	}
+	in := table{Bloom: NewFilter(hashes, 10)}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(in); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	var out table
+	if err := gob.NewDecoder(&buf).Decode(&out); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !bytes.Equal(out.Bloom, in.Bloom) || out.Empty != nil {
+		t.Fatal("Gob roundtrip changed the filter")
+	}
+	for i := 0; i < 2000; i++ {
+		h := Hash([]byte(fmt.Sprintf("key_%010d", i)))
+		if out.Bloom.MayContain(h) != in.Bloom.MayContain(h) {
+			t.Fatalf("Decoded filter disagrees on key %d", i)
+		}
+	}
+
+	var f Filter
+	if err := f.GobDecode([]byte{99, 0, 1}); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Bad version: expected ErrUnsupportedVersion, got %v", err)
+	}
+	if err := f.GobDecode([]byte{filterGobVersion, 0}); !errors.Is(err, ErrShortBuffer) {
+		t.Errorf("Short filter: expected ErrShortBuffer, got %v", err)
+	}
+}
+
+func TestBloomMayContainBatch(t *testing.T) {
+	hashes := make([]uint32, 10000)
+	for i := range hashes {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+	}
+	f := NewFilter(hashes, 10)
+
+	queries := make([]uint32, 20000)
+	copy(queries, hashes)
+	rng := rand.New(rand.NewSource(1))
+	for i := len(hashes); i < len(queries); i++ {
+		queries[i] = rng.Uint32()
+	}
+
+	for _, filter := range []Filter{f, nil, append(bytes.Clone(f[:len(f)-1]), 31)} {
+		got := filter.MayContainBatch(queries)
+		if len(got) != len(queries) {
+			t.Fatalf("Expected %d results, got %d", len(queries), len(got))
+		}
+		for i, q := range queries {
+			if got[i] != filter.MayContain(q) {
+				t.Fatalf("Query %d: batch %v, single %v", i, got[i], filter.MayContain(q))
+			}
+		}
+	}
+}
+
+func TestBloomContainsAll(t *testing.T) {
+	keys := GenerateSortedKeyHashes(10000)
+	f := NewFilter(keys, 10)
+	if !f.ContainsAll(keys) {
+		t.Fatal("Filter reports a false negative for its own keys")
+	}
+	if small, err := f.Downsize(4); err != nil || !small.ContainsAll(keys) {
+		t.Fatalf("Downsized filter reports a false negative for the original keys: %v", err)
+	}
+
+	// A superset fails exactly when an extra key is rejected, i.e. is not a
+	// false positive.
+	rng := rand.New(rand.NewSource(1))
+	rejected := 0
+	for i := 0; i < 1000; i++ {
+		h := rng.Uint32()
+		superset := append(slices.Clone(keys), h)
+		if got, want := f.ContainsAll(superset), f.MayContain(h); got != want {
+			t.Fatalf("Superset with %d: ContainsAll %v, MayContain %v", h, got, want)
+		}
+		if !f.MayContain(h) {
+			rejected++
+		}
+	}
+	if rejected == 0 {
+		t.Error("No absent key was rejected")
+	}
+
+	if !f.ContainsAll(nil) || !Filter(nil).ContainsAll(nil) {
+		t.Error("ContainsAll of no hashes is false")
+	}
+	if Filter(nil).ContainsAll(keys[:1]) {
+		t.Error("Empty filter contains a key")
+	}
+}
+
+func TestFilterDownsize(t *testing.T) {
+	hashes := make([]uint32, 10000)
+	for i := range hashes {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+	}
+	f := NewFilter(hashes, 10)
+	fpRate := func(f Filter) float64 {
+		fp := 0
+		for i := 0; i < 100000; i++ {
+			if f.MayContainKey([]byte(fmt.Sprintf("absent_%010d", i))) {
+				fp++
+			}
+		}
+		return float64(fp) / 100000
+	}
+
+	folded, err := f.Downsize(2)
+	if err != nil {
+		t.Fatalf("Downsize(2): %v", err)
+	}
+	if len(folded) != (len(f)-1)/2+1 || folded[len(folded)-1] != f[len(f)-1] {
+		t.Fatalf("Folded filter is %d bytes with k=%d", len(folded), folded[len(folded)-1])
+	}
+	for i, h := range hashes {
+		if !folded.MayContain(h) {
+			t.Fatalf("False negative for key %d after folding", i)
+		}
+	}
+	before, after := fpRate(f), fpRate(folded)
+	t.Logf("FP rate %.2f%% before folding, %.2f%% after", before*100, after*100)
+	if after <= before {
+		t.Errorf("Folding did not raise the FP rate: %.4f -> %.4f", before, after)
+	}
+
+	// Folding is an OR, so folding twice by 2 equals folding once by 4.
+	twice, _ := folded.Downsize(2)
+	once, _ := f.Downsize(4)
+	if !bytes.Equal(twice, once) {
+		t.Error("Folding by 2 twice differs from folding by 4")
+	}
+	if same, _ := f.Downsize(1); !bytes.Equal(same, f) {
+		t.Error("Folding by 1 changed the filter")
+	}
+
+	for _, factor := range []int{0, -2, 3} {
+		if _, err := f.Downsize(factor); err == nil {
+			t.Errorf("Downsize(%d) of a %d-byte filter: expected an error", factor, len(f)-1)
+		}
+	}
+	if _, err := Filter(nil).Downsize(2); !errors.Is(err, ErrShortBuffer) {
+		t.Errorf("Empty filter: expected ErrShortBuffer, got %v", err)
+	}
+}
+
+func TestMightIntersect(t *testing.T) {
+	const nBits, k = 1 << 16, 2
+	keysA := []uint32{1, 2, 3, 4, 5, 6, 7, 8}
+	keysB := []uint32{101, 102, 103, 104, 105, 106, 107, 108}
+	a := NewFilterK(keysA, nBits, k)
+	if MightIntersect(a, NewFilterK(keysB, nBits, k)) {
+		t.Error("Sparse filters over disjoint keys reported as intersecting")
+	}
+
+	// Never a false negative: any shared key must be reported.
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		shared := rng.Uint32()
+		left := []uint32{shared, rng.Uint32(), rng.Uint32()}
+		right := []uint32{rng.Uint32(), shared}
+		if !MightIntersect(NewFilterK(left, nBits, k), NewFilterK(right, nBits, k)) {
+			t.Fatalf("Filters sharing key %d reported as disjoint", shared)
+		}
+	}
+
+	// Dense filters share bits even for disjoint sets, so the answer is yes.
+	dense := make([]uint32, 1000)
+	for i := range dense {
+		dense[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+	}
+	other := make([]uint32, 1000)
+	for i := range other {
+		other[i] = Hash([]byte(fmt.Sprintf("other_%010d", i)))
+	}
+	if !MightIntersect(NewFilter(dense, 10), NewFilter(other, 10)) {
+		t.Error("Dense filters reported as disjoint")
+	}
+
+	// Incomparable pairs are conservatively reported as intersecting, and an
+	// empty filter intersects nothing.
+	if !MightIntersect(a, NewFilterK(keysB, nBits*2, k)) {
+		t.Error("Filters of different sizes reported as disjoint")
+	}
+	if !MightIntersect(a, NewFilterK(keysB, nBits, k+1)) {
+		t.Error("Filters with different k reported as disjoint")
+	}
+	if MightIntersect(a, nil) || MightIntersect(nil, a) {
+		t.Error("Empty filter reported as intersecting")
+	}
+}
+
+func BenchmarkBloomMayContainBatch(b *testing.B) {
+	hashes := GenerateSortedKeyHashes(100000)
+	f := NewFilter(hashes, 10)
+	queries := make([]uint32, 100000)
+	rng := rand.New(rand.NewSource(1))
+	for i := range queries {
+		queries[i] = rng.Uint32()
+	}
+
+	b.Run("Loop", func(b *testing.B) {
+		res := make([]bool, len(queries))
+		for i := 0; i < b.N; i++ {
+			for j, q := range queries {
+				res[j] = f.MayContain(q)
+			}
+		}
+	})
+
+	b.Run("Batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			f.MayContainBatch(queries)
+		}
+	})
+}
+
 func TestHash(t *testing.T) {
 	// The magic want numbers come from running the C++ leveldb code in hash.cc.
 	testCases := []struct {
@@ -145,3 +557,138 @@ func TestHash(t *testing.T) {
 		}
 	}
 }
+
+func TestHashSuffix(t *testing.T) {
+	key := []byte("tenant42_000001")
+	if got, want := HashSuffix(key, len("tenant42_")), Hash([]byte("000001")); got != want {
+		t.Errorf("HashSuffix: got 0x%08x, want 0x%08x", got, want)
+	}
+	if HashSuffix(key, len("tenant42_")) != HashSuffix([]byte("tenant7__000001"), len("tenant7__")) {
+		t.Error("Keys with equal suffixes should hash equally")
+	}
+	if HashSuffix(key, -1) != Hash(key) {
+		t.Error("Negative prefixLen should hash the whole key")
+	}
+	if HashSuffix(key, 100) != Hash(nil) {
+		t.Error("prefixLen beyond the key should hash the empty suffix")
+	}
+}
+
+// TestHashSuffixPositionCorrelation measures the rank correlation between key
+// position and hash for prefixed sorted keys. Hash mixes every byte, so
+// stripping the prefix does not make hashes follow key order; this pins down
+// the limitation documented on HashSuffix.
+func TestHashSuffixPositionCorrelation(t *testing.T) {
+	n := 100000
+	prefix := "tenant42_"
+	full := make([]uint32, n)
+	suffix := make([]uint32, n)
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("%s%06d", prefix, i))
+		full[i] = Hash(key)
+		suffix[i] = HashSuffix(key, len(prefix))
+	}
+	fullCorr := rankCorrelation(full)
+	suffixCorr := rankCorrelation(suffix)
+	t.Logf("Rank correlation with position: full key %.4f, suffix %.4f", fullCorr, suffixCorr)
+	for name, c := range map[string]float64{"full": fullCorr, "suffix": suffixCorr} {
+		if math.Abs(c) > 0.05 {
+			t.Errorf("%s hash unexpectedly correlated with position: %.4f", name, c)
+		}
+	}
+}
+
+// rankCorrelation returns the Spearman rank correlation between the index of
+// each value and the value itself.
+func rankCorrelation(values []uint32) float64 {
+	n := len(values)
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return values[order[a]] < values[order[b]] })
+	var sumD2 float64
+	for rank, i := range order {
+		d := float64(rank - i)
+		sumD2 += d * d
+	}
+	nf := float64(n)
+	return 1 - 6*sumD2/(nf*(nf*nf-1))
+}
+
+func TestFilterBitsOverflow(t *testing.T) {
+	tests := []struct {
+		keyCount, bitsPerKey int
+		want                 int64
+	}{
+		{0, 10, 64},
+		{1, 10, 64},
+		{1000, 10, 10000},
+		{1001, 10, 10016}, // rounded up to a whole byte
+		{-5, 10, 64},
+		{1000, -1, 64},
+		// n*bitsPerKey overflows int32 here.
+		{math.MaxInt32/10 + 1, 10, (int64(math.MaxInt32/10+1)*10 + 7) / 8 * 8},
+		// ... and exceeds the cap here.
+		{math.MaxInt32, 10, maxFilterBits},
+		// ... and overflows int64 here.
+		{math.MaxInt, math.MaxInt, maxFilterBits},
+	}
+	for _, tt := range tests {
+		got := filterBits(tt.keyCount, tt.bitsPerKey)
+		if got != tt.want {
+			t.Errorf("filterBits(%d, %d) = %d, want %d", tt.keyCount, tt.bitsPerKey, got, tt.want)
+		}
+		if got%8 != 0 || got > maxFilterBits {
+			t.Errorf("filterBits(%d, %d) = %d is not a capped whole byte count",
+				tt.keyCount, tt.bitsPerKey, got)
+		}
+		// appendFilterK probes modulo the bit count of the whole bytes, as a
+		// uint32; at the cap it must not wrap.
+		if modulus := uint32((got + 7) / 8 * 8); int64(modulus) != got {
+			t.Errorf("filterBits(%d, %d) = %d wraps to a modulus of %d",
+				tt.keyCount, tt.bitsPerKey, got, modulus)
+		}
+	}
+}
+
+var largeAlloc = flag.Bool("large-alloc", false, "Set to run tests that allocate hundreds of MiB.")
+
+// TestBloomFilterAtMaxBits builds a filter whose requested size overflows a
+// 32-bit bit count and checks it is capped, free of false negatives, and has
+// the FP rate expected at that size.
+func TestBloomFilterAtMaxBits(t *testing.T) {
+	if !*largeAlloc {
+		t.Skip("Allocates a 512 MiB filter; run with -large-alloc.")
+	}
+	keyCount := 1000
+	hashes := make([]uint32, keyCount)
+	for i := range hashes {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+	}
+	// Previously len(keys)*bitsPerKey wrapped around and the filter silently
+	// fell back to 64 bits.
+	f := NewFilter(hashes, math.MaxInt/keyCount)
+	if int64(len(f)-1)*8 != maxFilterBits {
+		t.Fatalf("Expected a capped filter of %d bits, got %d", int64(maxFilterBits), (len(f)-1)*8)
+	}
+	for i, h := range hashes {
+		if !f.MayContain(h) {
+			t.Fatalf("key %d missing from filter", i)
+		}
+	}
+	// At ~4M bits per key the FP rate is essentially zero; the 64-bit
+	// fallback it used to get would be close to 100%.
+	rng := rand.New(rand.NewSource(1))
+	tests := 10000
+	fp := 0
+	for i := 0; i < tests; i++ {
+		if f.MayContain(rng.Uint32()) {
+			fp++
+		}
+	}
+	if fpRate := float64(fp) / float64(tests); fpRate > 0.001 {
+		t.Errorf("FP rate %.4f%% too high for %d bits over %d keys",
+			fpRate*100, int64(maxFilterBits), keyCount)
+	}
+}
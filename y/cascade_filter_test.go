@@ -0,0 +1,136 @@
+package y
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestCascadeFilterNoFalseNegatives(t *testing.T) {
+	for _, keyCount := range []int{0, 1, 100, 10000} {
+		keys := GenerateSortedKeyHashes(keyCount)
+		cf := NewCascadeFilter(keys, 3, 10)
+		for i, h := range keys {
+			if !cf.Small.MayContain(h) {
+				t.Fatalf("n=%d: blocked filter false negative for key %d", keyCount, i)
+			}
+			if !cf.MayContain(h) {
+				t.Fatalf("n=%d: cascade false negative for key %d", keyCount, i)
+			}
+		}
+		restored, err := DeserializeCascadeFilter(cf.Serialize())
+		if err != nil {
+			t.Fatalf("n=%d: %v", keyCount, err)
+		}
+		for i, h := range keys {
+			if !restored.MayContain(h) {
+				t.Fatalf("n=%d: false negative after roundtrip for key %d", keyCount, i)
+			}
+		}
+	}
+}
+
+func TestCascadeFilterFPRate(t *testing.T) {
+	keyCount := 10000
+	keys := GenerateSortedKeyHashes(keyCount)
+	cf := NewCascadeFilter(keys, 3, 10)
+
+	rng := rand.New(rand.NewSource(1))
+	trials := 200000
+	smallFP, fullFP, cascadeFP := 0, 0, 0
+	for i := 0; i < trials; i++ {
+		h := rng.Uint32()
+		small, full := cf.Small.MayContain(h), cf.Full.MayContain(h)
+		if small {
+			smallFP++
+		}
+		if full {
+			fullFP++
+		}
+		if cf.MayContain(h) {
+			cascadeFP++
+		}
+	}
+	// Nearly all random hashes are misses, so each count is a FP rate.
+	pSmall, pFull := float64(smallFP)/float64(trials), float64(fullFP)/float64(trials)
+	pCascade := float64(cascadeFP) / float64(trials)
+	t.Logf("FP rates: small %.4f, full %.4f, cascade %.5f (product %.5f)", pSmall, pFull, pCascade, pSmall*pFull)
+	if pSmall > 0.5 {
+		t.Errorf("Small stage FP rate %.2f rejects too few misses", pSmall)
+	}
+	// Independent stages: the cascade is close to the product, well below
+	// either stage alone.
+	if pCascade > 2*pSmall*pFull+0.0005 {
+		t.Errorf("Cascade FP rate %.5f, expected about %.5f", pCascade, pSmall*pFull)
+	}
+}
+
+func TestDeserializeBlockedFilterErrors(t *testing.T) {
+	f := NewBlockedFilter(GenerateSortedKeyHashes(100), 4)
+	if _, err := DeserializeBlockedFilter(f[:len(f)-1]); !errors.Is(err, ErrShortBuffer) {
+		t.Errorf("Partial block: got %v, want ErrShortBuffer", err)
+	}
+	bad := append(BlockedFilter(nil), f...)
+	bad[len(bad)-1] = 0
+	if _, err := DeserializeBlockedFilter(bad); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("k=0: got %v, want ErrUnsupportedVersion", err)
+	}
+}
+
+// bloomLinesTouched returns the number of probes Filter.MayContain makes for h
+// before it can answer, each at an unrelated byte and so, in a filter larger
+// than a few cache lines, a separate cache line.
+func bloomLinesTouched(f Filter, h uint32) int {
+	k := int(f[len(f)-1])
+	nBits := uint32(8 * (len(f) - 1))
+	delta := h>>17 | h<<15
+	for j := 0; j < k; j++ {
+		bitPos := h % nBits
+		if f[bitPos/8]&(1<<(bitPos%8)) == 0 {
+			return j + 1
+		}
+		h += delta
+	}
+	return k
+}
+
+// BenchmarkCascadeFilter queries a miss-heavy workload (1% hits) and reports
+// the average number of filter bytes touched per query, counting a whole
+// cache line per probed line.
+func BenchmarkCascadeFilter(b *testing.B) {
+	keyCount := 100000
+	keys := GenerateSortedKeyHashes(keyCount)
+	cf := NewCascadeFilter(keys, 3, 10)
+	rng := rand.New(rand.NewSource(1))
+	queries := make([]uint32, 1<<16)
+	for i := range queries {
+		if i%100 == 0 {
+			queries[i] = keys[rng.Intn(keyCount)]
+		} else {
+			queries[i] = rng.Uint32()
+		}
+	}
+
+	b.Run("Bloom", func(b *testing.B) {
+		lines := 0
+		for i := 0; i < b.N; i++ {
+			h := queries[i&(len(queries)-1)]
+			cf.Full.MayContain(h)
+			lines += bloomLinesTouched(cf.Full, h)
+		}
+		b.ReportMetric(float64(lines*64)/float64(b.N), "touched-B/op")
+	})
+
+	b.Run("Cascade", func(b *testing.B) {
+		lines := 0
+		for i := 0; i < b.N; i++ {
+			h := queries[i&(len(queries)-1)]
+			cf.MayContain(h)
+			lines++
+			if cf.Small.MayContain(h) {
+				lines += bloomLinesTouched(cf.Full, h)
+			}
+		}
+		b.ReportMetric(float64(lines*64)/float64(b.N), "touched-B/op")
+	})
+}
@@ -0,0 +1,312 @@
+/*
+ * Compact Hybrid Filter for LSM-Tree Storage
+ *
+ * A "Compact Hybrid Filter" combines:
+ * 1. A size-optimized Bloom filter for table filtering
+ * 2. Key position metadata for search range hints
+ *
+ * The key insight: We DON'T need a full learned index when we have Bloom filters.
+ * Instead, we can store just MIN/MAX key positions to bound the search.
+ */
+
+package y
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// CompactHybridFilter combines:
+// - A small but effective Bloom filter (for table filtering)
+// - Simple min/max position bounds (for search narrowing)
+//
+// Total size: configurable bloom + 20 bytes for bounds = very compact!
+type CompactHybridFilter struct {
+	// Bloom filter component
+	BloomBits []byte
+	BloomK    uint8 // Number of hash functions
+
+	// Position bounds (not a learned model, just min/max)
+	MinKeyHash uint32 // Minimum hash value seen
+	MaxKeyHash uint32 // Maximum hash value seen
+	NumBlocks  uint32 // Total number of blocks
+
+	// Spread of the training keys' blocks around the interpolated block, as
+	// for HybridFilter's error bounds. Without known blocks it spans the
+	// whole table.
+	MinErr int32
+	MaxErr int32
+}
+
+// compactHybridTrailerSize is the size of everything Serialize writes after
+// the bloom: MinKeyHash, MaxKeyHash, NumBlocks, MinErr and MaxErr.
+const compactHybridTrailerSize = 4 + 4 + 4 + 4 + 4
+
+// CompactHybridConfig configures the compact hybrid filter
+type CompactHybridConfig struct {
+	BloomBitsPerKey int     // Bits per key for bloom filter (10 = ~1% FP)
+	TargetFPRate    float64 // Target false positive rate
+}
+
+// DefaultCompactConfig returns sensible defaults
+func DefaultCompactConfig() CompactHybridConfig {
+	return CompactHybridConfig{
+		BloomBitsPerKey: 10, // ~1% false positive rate
+		TargetFPRate:    0.01,
+	}
+}
+
+// TrainCompactHybridFilter builds a compact hybrid filter. With no keys, its
+// bloom has no bits set and MayContain rejects every key, as for NewFilter.
+func TrainCompactHybridFilter(keyHashes []uint32, numBlocks int, config CompactHybridConfig) *CompactHybridFilter {
+	n := len(keyHashes)
+	if n == 0 {
+		return &CompactHybridFilter{
+			BloomBits:  []byte{0, 0, 0, 0, 0, 0, 0, 1}, // No bits set, k = 1
+			BloomK:     1,
+			MinKeyHash: 0,
+			MaxKeyHash: math.MaxUint32,
+			NumBlocks:  uint32(numBlocks),
+			MinErr:     -int32(max(numBlocks-1, 0)),
+			MaxErr:     int32(max(numBlocks-1, 0)),
+		}
+	}
+
+	chf := &CompactHybridFilter{
+		NumBlocks:  uint32(numBlocks),
+		MinKeyHash: math.MaxUint32,
+		MaxKeyHash: 0,
+		MinErr:     -int32(max(numBlocks-1, 0)),
+		MaxErr:     int32(max(numBlocks-1, 0)),
+	}
+
+	// Find min/max hashes
+	for _, h := range keyHashes {
+		if h < chf.MinKeyHash {
+			chf.MinKeyHash = h
+		}
+		if h > chf.MaxKeyHash {
+			chf.MaxKeyHash = h
+		}
+	}
+
+	// Build optimally-sized bloom filter
+	bitsPerKey := config.BloomBitsPerKey
+	if bitsPerKey < 1 {
+		bitsPerKey = 10
+	}
+
+	// filterBits rounds to a whole byte, so building and MayContain (which
+	// derives the bit count from the byte length) use the same modulus.
+	nBits := filterBits(n, bitsPerKey)
+	nBytes := int(nBits / 8)
+
+	// Optimal k for given bits per key
+	k := uint8(float64(bitsPerKey) * 0.69) // ln(2) ≈ 0.69
+	if k < 1 {
+		k = 1
+	}
+	if k > 30 {
+		k = 30
+	}
+
+	chf.BloomBits = make([]byte, nBytes+1) // +1 for storing k
+	chf.BloomBits[nBytes] = k
+	chf.BloomK = k
+
+	// Add all keys to bloom filter
+	for _, h := range keyHashes {
+		delta := h>>17 | h<<15
+		for j := uint8(0); j < k; j++ {
+			bitPos := h % uint32(nBits)
+			chf.BloomBits[bitPos/8] |= 1 << (bitPos % 8)
+			h += delta
+		}
+	}
+
+	return chf
+}
+
+// TrainCompactHybridFilterWithBlocks builds a compact hybrid filter like
+// TrainCompactHybridFilter and records how far the block of each key lies
+// from the interpolated one, so that EstimateRange returns a search range that
+// contains every training key. It returns an error if keyHashes and
+// blockIndices differ in length.
+func TrainCompactHybridFilterWithBlocks(keyHashes, blockIndices []uint32, numBlocks int,
+	config CompactHybridConfig) (*CompactHybridFilter, error) {
+	if len(keyHashes) != len(blockIndices) {
+		return nil, fmt.Errorf("TrainCompactHybridFilterWithBlocks: %d key hashes but %d block indices",
+			len(keyHashes), len(blockIndices))
+	}
+	chf := TrainCompactHybridFilter(keyHashes, numBlocks, config)
+	if len(keyHashes) == 0 {
+		return chf, nil
+	}
+	chf.MinErr, chf.MaxErr = math.MaxInt32, math.MinInt32
+	for i, h := range keyHashes {
+		err := int32(blockIndices[i]) - int32(chf.interpolate(h))
+		chf.MinErr, chf.MaxErr = min(chf.MinErr, err), max(chf.MaxErr, err)
+	}
+	return chf, nil
+}
+
+// WrapBloomWithBounds builds a compact hybrid filter around an existing bloom
+// filter (as returned by NewFilter) without re-hashing any keys. The bloom
+// bytes are shared, not copied.
+func WrapBloomWithBounds(bloom []byte, minHash, maxHash uint32, numBlocks int) *CompactHybridFilter {
+	chf := &CompactHybridFilter{
+		BloomBits:  bloom,
+		MinKeyHash: minHash,
+		MaxKeyHash: maxHash,
+		NumBlocks:  uint32(numBlocks),
+		MinErr:     -int32(max(numBlocks-1, 0)),
+		MaxErr:     int32(max(numBlocks-1, 0)),
+	}
+	if len(bloom) > 0 {
+		chf.BloomK = bloom[len(bloom)-1] // NewFilter stores k in the trailing byte
+	}
+	return chf
+}
+
+// Valid reports whether the filter's structural invariants hold: a bloom of
+// at least one bit byte and the trailing k byte, a k in [1, 30] that matches
+// BloomK, and MinKeyHash <= MaxKeyHash and MinErr <= MaxErr. A filter built by
+// this package is valid; a zero filter, or one read from corrupted bytes, may
+// not be.
+func (chf *CompactHybridFilter) Valid() bool {
+	if len(chf.BloomBits) < 2 {
+		return false
+	}
+	k := chf.BloomBits[len(chf.BloomBits)-1]
+	return k >= 1 && k <= 30 && k == chf.BloomK &&
+		chf.MinKeyHash <= chf.MaxKeyHash && chf.MinErr <= chf.MaxErr
+}
+
+// MayContain checks if a key might be in the filter. Without a bloom it
+// returns true. It does not check Valid, which DeserializeCompactHybridFilter
+// has already done for a filter read from bytes.
+func (chf *CompactHybridFilter) MayContain(keyHash uint32) bool {
+	if len(chf.BloomBits) < 2 {
+		return true
+	}
+
+	nBytes := len(chf.BloomBits) - 1
+	nBits := nBytes * 8
+	k := chf.BloomK
+
+	h := keyHash
+	delta := h>>17 | h<<15
+
+	for j := uint8(0); j < k; j++ {
+		bitPos := h % uint32(nBits)
+		if chf.BloomBits[bitPos/8]&(1<<(bitPos%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+// EstimatePosition estimates where a key might be based on hash interpolation
+// Returns (estimatedBlock, confidence) where confidence is 0-1
+func (chf *CompactHybridFilter) EstimatePosition(keyHash uint32) (block int, confidence float64) {
+	block = chf.interpolate(keyHash)
+
+	// Confidence based on how well-distributed the data is
+	// Higher hash range = more distributed = lower confidence in position
+	confidence = 0.5 // Base confidence
+
+	return block, confidence
+}
+
+// interpolate returns the block a key hash falls in if the keys are spread
+// evenly between MinKeyHash and MaxKeyHash, or the middle block if they span
+// no range.
+func (chf *CompactHybridFilter) interpolate(keyHash uint32) int {
+	if chf.MaxKeyHash <= chf.MinKeyHash {
+		return int(chf.NumBlocks / 2)
+	}
+
+	// Linear interpolation based on hash position
+	hashRange := float64(chf.MaxKeyHash - chf.MinKeyHash)
+	position := float64(keyHash) - float64(chf.MinKeyHash)
+
+	// Estimate block based on relative position
+	ratio := min(max(position/hashRange, 0), 1)
+	return int(ratio * float64(max(chf.NumBlocks, 1)-1))
+}
+
+// EstimateRange returns the blocks to search for a key: the interpolated block
+// widened by the spread recorded at train time and clamped to the table. For
+// a filter trained with TrainCompactHybridFilterWithBlocks, the range contains
+// the block of every training key.
+func (chf *CompactHybridFilter) EstimateRange(keyHash uint32) (minBlock, maxBlock int) {
+	lastBlock := max(int(chf.NumBlocks)-1, 0)
+	block := chf.interpolate(keyHash)
+	minBlock = min(max(block+int(chf.MinErr), 0), lastBlock)
+	maxBlock = min(max(block+int(chf.MaxErr), 0), lastBlock)
+	return minBlock, maxBlock
+}
+
+// Query performs a complete lookup like HybridFilter.Query: if the bloom rules
+// the key out it returns maybePresent=false with a zero block and confidence,
+// otherwise the result of EstimatePosition.
+func (chf *CompactHybridFilter) Query(keyHash uint32) (maybePresent bool, block int, confidence float64) {
+	if !chf.MayContain(keyHash) {
+		return false, 0, 0
+	}
+	block, confidence = chf.EstimatePosition(keyHash)
+	return true, block, confidence
+}
+
+// Size returns the total size in bytes, as Serialize writes it
+func (chf *CompactHybridFilter) Size() int {
+	return len(chf.BloomBits) + compactHybridTrailerSize
+}
+
+// Serialize the filter
+func (chf *CompactHybridFilter) Serialize() []byte {
+	buf := make([]byte, chf.Size())
+
+	copy(buf, chf.BloomBits)
+	offset := len(chf.BloomBits)
+	binary.LittleEndian.PutUint32(buf[offset:], chf.MinKeyHash)
+	binary.LittleEndian.PutUint32(buf[offset+4:], chf.MaxKeyHash)
+	binary.LittleEndian.PutUint32(buf[offset+8:], chf.NumBlocks)
+	putInt32(buf[offset+12:], chf.MinErr)
+	putInt32(buf[offset+16:], chf.MaxErr)
+
+	return buf
+}
+
+// DeserializeCompactHybridFilter reads a CompactHybridFilter written by
+// Serialize. The returned error wraps ErrShortBuffer or, for a bloom part with
+// a reserved k or a filter that is not Valid, ErrUnsupportedVersion.
+func DeserializeCompactHybridFilter(data []byte) (*CompactHybridFilter, error) {
+	if len(data) < 2+compactHybridTrailerSize {
+		return nil, fmt.Errorf("compact hybrid filter: got %d bytes, want at least %d: %w",
+			len(data), 2+compactHybridTrailerSize, ErrShortBuffer)
+	}
+	offset := len(data) - compactHybridTrailerSize
+	bloom, err := DeserializeFilter(data[:offset])
+	if err != nil {
+		return nil, fmt.Errorf("compact hybrid filter: %w", err)
+	}
+	chf := &CompactHybridFilter{
+		BloomBits:  make([]byte, len(bloom)),
+		BloomK:     bloom[len(bloom)-1],
+		MinKeyHash: binary.LittleEndian.Uint32(data[offset:]),
+		MaxKeyHash: binary.LittleEndian.Uint32(data[offset+4:]),
+		NumBlocks:  binary.LittleEndian.Uint32(data[offset+8:]),
+		MinErr:     getInt32(data[offset+12:]),
+		MaxErr:     getInt32(data[offset+16:]),
+	}
+	copy(chf.BloomBits, bloom)
+	if !chf.Valid() {
+		return nil, fmt.Errorf("compact hybrid filter: %d bloom bytes, k=%d, hashes [%d,%d], errors [%d,%d]: %w",
+			len(chf.BloomBits), chf.BloomK, chf.MinKeyHash, chf.MaxKeyHash, chf.MinErr, chf.MaxErr,
+			ErrUnsupportedVersion)
+	}
+	return chf, nil
+}
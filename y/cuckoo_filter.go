@@ -0,0 +1,171 @@
+/*
+ * Cuckoo Filter - approximate membership with deletion
+ *
+ * Tables with many tombstones want to drop keys from their filter, which a
+ * bloom filter cannot do. A cuckoo filter stores an 8-bit fingerprint per key
+ * in one of two candidate buckets of four slots. The second bucket is derived
+ * from the first and the fingerprint alone (partial-key cuckoo hashing), so a
+ * fingerprint can be moved between its buckets without the original key,
+ * which is what makes both relocation and deletion possible.
+ *
+ * With b slots per bucket and f-bit fingerprints the false positive rate is
+ * about 2b / 2^f, ~3% here, and stays flat up to ~95% load.
+ *
+ * Reference: Fan et al., "Cuckoo Filter: Practically Better Than Bloom" (2014).
+ */
+
+package y
+
+const (
+	// cuckooBucketSize is the number of fingerprint slots per bucket.
+	cuckooBucketSize = 4
+	// cuckooMaxKicks bounds the relocation chain of a single insertion.
+	cuckooMaxKicks = 500
+)
+
+// CuckooFilter is a cuckoo filter over key hashes (from y.Hash). It is not
+// safe for concurrent use: Add and Delete must be serialized with each other
+// and with MayContain by the caller.
+type CuckooFilter struct {
+	buckets [][cuckooBucketSize]uint8 // 0 marks an empty slot
+	mask    uint32                    // len(buckets) - 1, a power of two
+	count   int
+	rng     uint64 // picks eviction victims
+}
+
+// cuckooKick records a slot overwritten while relocating fingerprints.
+type cuckooKick struct {
+	bucket uint32
+	slot   int
+}
+
+// NewCuckooFilter creates a cuckoo filter sized to hold capacity keys.
+func NewCuckooFilter(capacity int) *CuckooFilter {
+	numBuckets := uint32(1)
+	for int(numBuckets)*cuckooBucketSize*95/100 < capacity {
+		numBuckets <<= 1
+	}
+	return &CuckooFilter{
+		buckets: make([][cuckooBucketSize]uint8, numBuckets),
+		mask:    numBuckets - 1,
+		rng:     0x9e3779b97f4a7c15,
+	}
+}
+
+// Add inserts a key hash. Returns false if the filter is too full to place it,
+// in which case the filter is left unchanged.
+func (cf *CuckooFilter) Add(keyHash uint32) bool {
+	fp, i1 := cf.fingerprintAndIndex(keyHash)
+	i2 := cf.altIndex(i1, fp)
+	if cf.insert(i1, fp) || cf.insert(i2, fp) {
+		cf.count++
+		return true
+	}
+
+	i := i1
+	if cf.nextRand()&1 == 1 {
+		i = i2
+	}
+	path := make([]cuckooKick, 0, cuckooMaxKicks)
+	for n := 0; n < cuckooMaxKicks; n++ {
+		slot := int(cf.nextRand() % cuckooBucketSize)
+		fp, cf.buckets[i][slot] = cf.buckets[i][slot], fp
+		path = append(path, cuckooKick{bucket: i, slot: slot})
+		i = cf.altIndex(i, fp)
+		if cf.insert(i, fp) {
+			cf.count++
+			return true
+		}
+	}
+
+	// Walk the chain backwards so every displaced fingerprint returns home.
+	for j := len(path) - 1; j >= 0; j-- {
+		k := path[j]
+		fp, cf.buckets[k.bucket][k.slot] = cf.buckets[k.bucket][k.slot], fp
+	}
+	return false
+}
+
+// Delete removes one copy of a key hash. Returns false if it was not found.
+// Only delete keys that were added: deleting an absent key that shares a
+// fingerprint with a present one removes the present key.
+func (cf *CuckooFilter) Delete(keyHash uint32) bool {
+	fp, i1 := cf.fingerprintAndIndex(keyHash)
+	if cf.remove(i1, fp) || cf.remove(cf.altIndex(i1, fp), fp) {
+		cf.count--
+		return true
+	}
+	return false
+}
+
+// MayContain returns whether the filter may contain the given key hash. False
+// positives are possible, false negatives are not.
+func (cf *CuckooFilter) MayContain(keyHash uint32) bool {
+	if cf == nil || len(cf.buckets) == 0 {
+		return false
+	}
+	fp, i1 := cf.fingerprintAndIndex(keyHash)
+	return cf.contains(i1, fp) || cf.contains(cf.altIndex(i1, fp), fp)
+}
+
+// Count returns the number of keys currently in the filter.
+func (cf *CuckooFilter) Count() int {
+	return cf.count
+}
+
+// LoadFactor returns the fraction of occupied slots.
+func (cf *CuckooFilter) LoadFactor() float64 {
+	return float64(cf.count) / float64(len(cf.buckets)*cuckooBucketSize)
+}
+
+// Size returns the memory used by the fingerprint table in bytes.
+func (cf *CuckooFilter) Size() int {
+	return len(cf.buckets) * cuckooBucketSize
+}
+
+// fingerprintAndIndex derives a non-zero fingerprint and the primary bucket
+// from independent bits of the mixed key hash.
+func (cf *CuckooFilter) fingerprintAndIndex(keyHash uint32) (uint8, uint32) {
+	h := murmurMix64(uint64(keyHash))
+	fp := uint8(h >> 56)
+	if fp == 0 {
+		fp = 1
+	}
+	return fp, uint32(h) & cf.mask
+}
+
+// altIndex returns the other candidate bucket for fp. It is its own inverse:
+// altIndex(altIndex(i, fp), fp) == i.
+func (cf *CuckooFilter) altIndex(i uint32, fp uint8) uint32 {
+	return (i ^ uint32(murmurMix64(uint64(fp)))) & cf.mask
+}
+
+func (cf *CuckooFilter) insert(i uint32, fp uint8) bool {
+	for s := range cf.buckets[i] {
+		if cf.buckets[i][s] == 0 {
+			cf.buckets[i][s] = fp
+			return true
+		}
+	}
+	return false
+}
+
+func (cf *CuckooFilter) remove(i uint32, fp uint8) bool {
+	for s := range cf.buckets[i] {
+		if cf.buckets[i][s] == fp {
+			cf.buckets[i][s] = 0
+			return true
+		}
+	}
+	return false
+}
+
+func (cf *CuckooFilter) contains(i uint32, fp uint8) bool {
+	b := &cf.buckets[i]
+	return b[0] == fp || b[1] == fp || b[2] == fp || b[3] == fp
+}
+
+func (cf *CuckooFilter) nextRand() uint64 {
+	cf.rng = splitmix64(cf.rng)
+	return cf.rng
+}
@@ -0,0 +1,147 @@
+/*
+ * Tests for the cuckoo filter
+ */
+
+package y
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestCuckooFilterAddDeleteContain(t *testing.T) {
+	keyCount := 1000
+	hashes := make([]uint32, keyCount)
+	for i := range hashes {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+	}
+	cf := NewCuckooFilter(keyCount)
+
+	for i, h := range hashes {
+		if !cf.Add(h) {
+			t.Fatalf("Add failed for key %d", i)
+		}
+	}
+	if cf.Count() != keyCount {
+		t.Errorf("Expected count %d, got %d", keyCount, cf.Count())
+	}
+	for i, h := range hashes {
+		if !cf.MayContain(h) {
+			t.Fatalf("key %d missing from filter", i)
+		}
+	}
+
+	// Delete every other key; the rest must still be present.
+	for i := 0; i < keyCount; i += 2 {
+		if !cf.Delete(hashes[i]) {
+			t.Fatalf("Delete failed for key %d", i)
+		}
+	}
+	if cf.Count() != keyCount/2 {
+		t.Errorf("Expected count %d after deletes, got %d", keyCount/2, cf.Count())
+	}
+	for i := 1; i < keyCount; i += 2 {
+		if !cf.MayContain(hashes[i]) {
+			t.Fatalf("key %d missing after deleting its neighbours", i)
+		}
+	}
+	deleted := 0
+	for i := 0; i < keyCount; i += 2 {
+		if !cf.MayContain(hashes[i]) {
+			deleted++
+		}
+	}
+	if deleted < keyCount/2*9/10 {
+		t.Errorf("Only %d of %d deleted keys are gone", deleted, keyCount/2)
+	}
+
+	var nilFilter *CuckooFilter
+	if nilFilter.MayContain(hashes[0]) {
+		t.Error("nil filter should contain nothing")
+	}
+}
+
+func TestCuckooFilterDuplicates(t *testing.T) {
+	cf := NewCuckooFilter(16)
+	h := Hash([]byte("dup"))
+	cf.Add(h)
+	cf.Add(h)
+	if !cf.Delete(h) {
+		t.Fatal("first Delete failed")
+	}
+	if !cf.MayContain(h) {
+		t.Error("one copy should remain after a single Delete")
+	}
+	if !cf.Delete(h) {
+		t.Fatal("second Delete failed")
+	}
+	if cf.Delete(h) {
+		t.Error("Delete of an absent key should return false")
+	}
+}
+
+// TestCuckooFilterLoadFactor fills a filter until insertion fails and checks
+// that it gets close to full without losing any key.
+func TestCuckooFilterLoadFactor(t *testing.T) {
+	capacity := 100000
+	cf := NewCuckooFilter(capacity)
+	rng := rand.New(rand.NewSource(1))
+
+	var added []uint32
+	failures := 0
+	for failures == 0 {
+		h := rng.Uint32()
+		if cf.Add(h) {
+			added = append(added, h)
+		} else {
+			failures++
+		}
+	}
+	t.Logf("Filled %d keys into %d slots (load %.1f%%)",
+		len(added), cf.Size(), cf.LoadFactor()*100)
+
+	if cf.LoadFactor() < 0.9 {
+		t.Errorf("Load factor %.3f at first failure, expected >= 0.9", cf.LoadFactor())
+	}
+	if len(added) < capacity {
+		t.Errorf("Filter sized for %d keys only held %d", capacity, len(added))
+	}
+	// A failed Add must not evict anything already stored.
+	for i, h := range added {
+		if !cf.MayContain(h) {
+			t.Fatalf("key %d lost after failed insertion", i)
+		}
+	}
+
+	tests := 100000
+	fp := 0
+	for i := 0; i < tests; i++ {
+		if cf.MayContain(rng.Uint32()) {
+			fp++
+		}
+	}
+	fpRate := float64(fp) / float64(tests) * 100
+	t.Logf("FP rate at full load: %.2f%%", fpRate)
+	if fpRate > 4 {
+		t.Errorf("FP rate %.2f%% above the expected ~3%%", fpRate)
+	}
+}
+
+func BenchmarkCuckooFilter(b *testing.B) {
+	keyCount := 100000
+	hashes := make([]uint32, keyCount)
+	for i := 0; i < keyCount; i++ {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+	}
+	cf := NewCuckooFilter(keyCount)
+	for _, h := range hashes {
+		cf.Add(h)
+	}
+
+	b.Run("Query", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			cf.MayContain(rand.Uint32())
+		}
+	})
+}
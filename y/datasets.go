@@ -0,0 +1,36 @@
+/*
+ * Deterministic datasets for benchmarks and experiments
+ *
+ * The tests and benchmarks in this package use keys of the form
+ * "key_%010d" hashed with Hash, spread evenly over a number of blocks. These
+ * helpers generate exactly those datasets so that other packages can reproduce
+ * them without copying the loops.
+ */
+
+package y
+
+import "fmt"
+
+// GenerateSortedKeyHashes returns Hash of the sorted keys "key_0000000000",
+// "key_0000000001", ..., for n keys. The keys are sorted; their hashes are not.
+func GenerateSortedKeyHashes(n int) []uint32 {
+	hashes := make([]uint32, n)
+	var buf []byte
+	for i := range hashes {
+		buf = fmt.Appendf(buf[:0], "key_%010d", i)
+		hashes[i] = Hash(buf)
+	}
+	return hashes
+}
+
+// GenerateBlockIndices assigns n sorted keys to numBlocks blocks of n/numBlocks
+// keys each. Leftover keys go to the last block.
+func GenerateBlockIndices(n, numBlocks int) []uint32 {
+	blockIndices := make([]uint32, n)
+	keysPerBlock := max(n/max(numBlocks, 1), 1)
+	lastBlock := uint32(max(numBlocks-1, 0))
+	for i := range blockIndices {
+		blockIndices[i] = min(uint32(i/keysPerBlock), lastBlock)
+	}
+	return blockIndices
+}
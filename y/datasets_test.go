@@ -0,0 +1,50 @@
+/*
+ * Tests for the dataset generators
+ */
+
+package y
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGenerateSortedKeyHashes(t *testing.T) {
+	n := 1000
+	hashes := GenerateSortedKeyHashes(n)
+	if len(hashes) != n {
+		t.Fatalf("Expected %d hashes, got %d", n, len(hashes))
+	}
+	for i, h := range hashes {
+		if want := Hash([]byte(fmt.Sprintf("key_%010d", i))); h != want {
+			t.Fatalf("Hash %d: got %d, want %d", i, h, want)
+		}
+	}
+}
+
+func TestGenerateBlockIndices(t *testing.T) {
+	n := 1050
+	numBlocks := 100
+	blocks := GenerateBlockIndices(n, numBlocks)
+	keysPerBlock := n / numBlocks
+	for i, b := range blocks {
+		want := uint32(i / keysPerBlock)
+		if want >= uint32(numBlocks) {
+			want = uint32(numBlocks - 1)
+		}
+		if b != want {
+			t.Fatalf("Key %d: got block %d, want %d", i, b, want)
+		}
+	}
+
+	// Fewer keys than blocks: one key per block.
+	if got := GenerateBlockIndices(3, 10); got[0] != 0 || got[1] != 1 || got[2] != 2 {
+		t.Errorf("Expected one key per block, got %v", got)
+	}
+}
+
+func BenchmarkGenerateSortedKeyHashes(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		GenerateSortedKeyHashes(100000)
+	}
+}
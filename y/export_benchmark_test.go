@@ -0,0 +1,208 @@
+/*
+ * Machine-readable benchmark export
+ *
+ * The comparison tests print human-formatted tables; BenchmarkExport writes
+ * the same kind of measurements as CSV for plotting scripts.
+ *
+ * Run: BENCH_EXPORT_CSV=results.csv go test -run '^$' -bench BenchmarkExport ./y/
+ */
+
+package y
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// benchExportEnv names the environment variable holding the CSV output path.
+const benchExportEnv = "BENCH_EXPORT_CSV"
+
+var exportHeader = []string{"keyCount", "approach", "sizeBytes", "fpRate", "avgSearchRange", "buildNs", "queryNs"}
+
+// exportApproaches are the filters measured, in output order.
+var exportApproaches = []string{"bloom", "learned", "hybrid", "xor"}
+
+type exportRow struct {
+	keyCount       int
+	approach       string
+	sizeBytes      int
+	fpRate         float64 // Fraction of absent keys reported present
+	avgSearchRange float64 // Average blocks searched for a present key
+	buildNs        int64
+	queryNs        float64 // Per query
+}
+
+// exportModel is one built filter, as seen by the measurement loop.
+type exportModel struct {
+	size      int
+	mayHave   func(h uint32) bool
+	searchLen func(h uint32) int
+}
+
+// measureApproaches builds every approach for each key count over numBlocks
+// blocks and measures it on the keys and on numProbes absent hashes.
+func measureApproaches(keyCounts []int, numBlocks, numProbes int) []exportRow {
+	var rows []exportRow
+	for _, keyCount := range keyCounts {
+		hashes := GenerateSortedKeyHashes(keyCount)
+		blocks := GenerateBlockIndices(keyCount, numBlocks)
+		present := make(map[uint32]bool, keyCount)
+		for _, h := range hashes {
+			present[h] = true
+		}
+		rng := rand.New(rand.NewSource(int64(keyCount)))
+		probes := make([]uint32, 0, numProbes)
+		for len(probes) < numProbes {
+			if h := rng.Uint32(); !present[h] {
+				probes = append(probes, h)
+			}
+		}
+
+		builders := map[string]func() exportModel{
+			"bloom": func() exportModel {
+				f := NewFilter(hashes, 10)
+				return exportModel{len(f), f.MayContain, func(uint32) int { return numBlocks }}
+			},
+			"learned": func() exportModel {
+				li := TrainLearnedIndex(hashes, blocks, numBlocks)
+				return exportModel{LearnedIndexSize, li.MayContainInRange, func(h uint32) int {
+					_, lo, hi := li.Predict(h)
+					return hi - lo + 1
+				}}
+			},
+			"hybrid": func() exportModel {
+				hf := TrainHybridFilter(hashes, blocks, numBlocks, DefaultHybridConfig())
+				return exportModel{hf.SerializedSize(), hf.MayContain, func(h uint32) int {
+					lo, hi := hf.PredictRange(h)
+					return hi - lo + 1
+				}}
+			},
+			"xor": func() exportModel {
+				xf, err := NewXORFilter(hashes)
+				Check(err)
+				return exportModel{xf.Size(), xf.MayContain, func(uint32) int { return numBlocks }}
+			},
+		}
+
+		for _, approach := range exportApproaches {
+			start := time.Now()
+			m := builders[approach]()
+			build := time.Since(start)
+
+			searched := 0
+			for _, h := range hashes {
+				searched += m.searchLen(h)
+			}
+			fp := 0
+			start = time.Now()
+			for _, h := range probes {
+				if m.mayHave(h) {
+					fp++
+				}
+			}
+			query := time.Since(start)
+
+			rows = append(rows, exportRow{
+				keyCount:       keyCount,
+				approach:       approach,
+				sizeBytes:      m.size,
+				fpRate:         float64(fp) / float64(len(probes)),
+				avgSearchRange: float64(searched) / float64(max(keyCount, 1)),
+				buildNs:        build.Nanoseconds(),
+				queryNs:        float64(query.Nanoseconds()) / float64(len(probes)),
+			})
+		}
+	}
+	return rows
+}
+
+// writeExportCSV writes rows as CSV with exportHeader.
+func writeExportCSV(w io.Writer, rows []exportRow) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportHeader); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		record := []string{
+			strconv.Itoa(r.keyCount),
+			r.approach,
+			strconv.Itoa(r.sizeBytes),
+			strconv.FormatFloat(r.fpRate, 'g', 6, 64),
+			strconv.FormatFloat(r.avgSearchRange, 'g', 6, 64),
+			strconv.FormatInt(r.buildNs, 10),
+			strconv.FormatFloat(r.queryNs, 'g', 6, 64),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// BenchmarkExport writes the comparison as CSV to the path in
+// BENCH_EXPORT_CSV. It is skipped when the variable is unset.
+func BenchmarkExport(b *testing.B) {
+	path := os.Getenv(benchExportEnv)
+	if path == "" {
+		b.Skipf("set %s to the CSV output path", benchExportEnv)
+	}
+	rows := measureApproaches([]int{1000, 10000, 100000, 1000000}, 100, 100000)
+	f, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	if err := writeExportCSV(f, rows); err != nil {
+		b.Fatal(err)
+	}
+	b.Logf("wrote %d rows to %s", len(rows), path)
+}
+
+func TestExportCSV(t *testing.T) {
+	keyCounts := []int{1000, 5000}
+	rows := measureApproaches(keyCounts, 50, 1000)
+
+	var buf bytes.Buffer
+	if err := writeExportCSV(&buf, rows); err != nil {
+		t.Fatalf("writeExportCSV: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Reading CSV back: %v", err)
+	}
+	if fmt.Sprint(records[0]) != fmt.Sprint(exportHeader) {
+		t.Errorf("Header %v, want %v", records[0], exportHeader)
+	}
+	records = records[1:]
+	if len(records) != len(keyCounts)*len(exportApproaches) {
+		t.Fatalf("Got %d rows, want %d", len(records), len(keyCounts)*len(exportApproaches))
+	}
+	seen := make(map[string]bool)
+	for _, rec := range records {
+		key := rec[0] + "/" + rec[1]
+		if seen[key] {
+			t.Errorf("Duplicate row for %s", key)
+		}
+		seen[key] = true
+		for col := 2; col < len(rec); col++ {
+			if _, err := strconv.ParseFloat(rec[col], 64); err != nil {
+				t.Errorf("%s: column %s is not numeric: %q", key, exportHeader[col], rec[col])
+			}
+		}
+	}
+	for _, n := range keyCounts {
+		for _, a := range exportApproaches {
+			if !seen[fmt.Sprintf("%d/%s", n, a)] {
+				t.Errorf("Missing row for %d/%s", n, a)
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+/*
+ * FilterBuilder - building a bloom filter one key at a time
+ *
+ * NewFilter needs every key hash up front. A memtable fill sees keys one at a
+ * time, so FilterBuilder sizes the filter from the expected key count and sets
+ * bits as keys arrive. Its result is byte for byte the filter NewFilter builds
+ * from the same keys.
+ *
+ * A single builder is a serialization point when many goroutines fill the same
+ * table. ShardedFilterBuilder gives each shard, chosen by the top bits of the
+ * hash, its own copy of the bit array, set with atomic ORs so that Add takes no
+ * lock, and ORs the copies together in Finish.
+ */
+
+package y
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"sync/atomic"
+)
+
+// maxFilterShards caps the number of shards of a ShardedFilterBuilder, each of
+// which holds a full copy of the filter's bits.
+const maxFilterShards = 256
+
+// filterK returns the number of hash functions NewFilter uses at bitsPerKey
+// bits per key.
+func filterK(bitsPerKey int) int {
+	// 0.69 is approximately ln(2).
+	return min(max(int(float64(max(bitsPerKey, 0))*0.69), 1), 30)
+}
+
+// FilterBuilder builds a bloom Filter from key hashes added one at a time. It
+// is not safe for concurrent use; see ShardedFilterBuilder.
+type FilterBuilder struct {
+	filter []byte // nBits/8 bytes of bits, then k
+	nBits  uint32
+	k      int
+}
+
+// NewFilterBuilder returns a builder for a filter sized as NewFilter sizes one
+// for expectedKeys keys at bitsPerKey bits per key. Adding more keys than
+// expected raises the false positive rate beyond the one bitsPerKey gives.
+func NewFilterBuilder(expectedKeys, bitsPerKey int) *FilterBuilder {
+	nBits := filterBits(expectedKeys, bitsPerKey)
+	fb := &FilterBuilder{
+		filter: make([]byte, nBits/8+1),
+		nBits:  uint32(nBits),
+		k:      filterK(bitsPerKey),
+	}
+	fb.filter[len(fb.filter)-1] = uint8(fb.k)
+	return fb
+}
+
+// Add adds a key hash to the filter.
+func (fb *FilterBuilder) Add(h uint32) {
+	delta := h>>17 | h<<15
+	for j := 0; j < fb.k; j++ {
+		bitPos := h % fb.nBits
+		fb.filter[bitPos/8] |= 1 << (bitPos % 8)
+		h += delta
+	}
+}
+
+// Finish returns the filter. The builder must not be used afterwards.
+func (fb *FilterBuilder) Finish() Filter {
+	f := Filter(fb.filter)
+	fb.filter = nil
+	return f
+}
+
+// ShardedFilterBuilder is a FilterBuilder whose Add may be called from many
+// goroutines at once. Each shard holds a full-size copy of the filter's bits,
+// so while building it takes shards times the filter's size in memory, on top
+// of the filter Finish returns.
+type ShardedFilterBuilder struct {
+	shards [][]uint32 // Bits in little-endian words, so that bit i is bit i%8 of byte i/8
+	shift  uint       // A hash's shard is h >> shift
+	nBits  uint32
+	k      int
+}
+
+// NewShardedFilterBuilder returns a builder for the filter NewFilterBuilder
+// would build, split over shards shards. shards is rounded up to a power of
+// two and clamped to [1, 256].
+func NewShardedFilterBuilder(expectedKeys, bitsPerKey, shards int) *ShardedFilterBuilder {
+	shardBits := bits.Len(uint(min(max(shards, 1), maxFilterShards) - 1))
+	nBits := filterBits(expectedKeys, bitsPerKey)
+	fb := &ShardedFilterBuilder{
+		shards: make([][]uint32, 1<<shardBits),
+		shift:  uint(32 - shardBits),
+		nBits:  uint32(nBits),
+		k:      filterK(bitsPerKey),
+	}
+	for i := range fb.shards {
+		fb.shards[i] = make([]uint32, (nBits+31)/32)
+	}
+	return fb
+}
+
+// Add adds a key hash to the filter. It is safe to call concurrently with
+// itself, but not with Finish.
+func (fb *ShardedFilterBuilder) Add(h uint32) {
+	// Shifting a uint32 by 32, with a single shard, yields 0.
+	shard := fb.shards[h>>fb.shift]
+	delta := h>>17 | h<<15
+	for j := 0; j < fb.k; j++ {
+		bitPos := h % fb.nBits
+		atomic.OrUint32(&shard[bitPos/32], 1<<(bitPos%32))
+		h += delta
+	}
+}
+
+// Finish ORs the shards into the filter and returns it. It must be called
+// after every Add has returned, and the builder must not be used afterwards.
+func (fb *ShardedFilterBuilder) Finish() Filter {
+	nBytes := int(fb.nBits / 8)
+	words := make([]byte, 4*len(fb.shards[0]))
+	for i := range fb.shards[0] {
+		var w uint32
+		for _, shard := range fb.shards {
+			w |= shard[i]
+		}
+		binary.LittleEndian.PutUint32(words[4*i:], w)
+	}
+	fb.shards = nil
+	return Filter(append(words[:nBytes:nBytes], uint8(fb.k)))
+}
@@ -0,0 +1,121 @@
+package y
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestFilterBuilderMatchesNewFilter(t *testing.T) {
+	for _, keyCount := range []int{0, 1, 100, 10000} {
+		keys := GenerateSortedKeyHashes(keyCount)
+		for _, bitsPerKey := range []int{0, 4, 10} {
+			want := NewFilter(keys, bitsPerKey)
+			fb := NewFilterBuilder(keyCount, bitsPerKey)
+			for _, h := range keys {
+				fb.Add(h)
+			}
+			if got := fb.Finish(); !bytes.Equal(got, want) {
+				t.Errorf("n=%d bitsPerKey=%d: builder filter differs from NewFilter", keyCount, bitsPerKey)
+			}
+		}
+	}
+}
+
+// TestShardedFilterBuilderConcurrent is meant to be run with -race.
+func TestShardedFilterBuilderConcurrent(t *testing.T) {
+	keyCount, workers := 100000, 8
+	keys := GenerateSortedKeyHashes(keyCount)
+	want := NewFilter(keys, 10)
+
+	for _, shards := range []int{1, 3, 16, 1000} {
+		fb := NewShardedFilterBuilder(keyCount, 10, shards)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				// Interleave the workers so that they add to the same shards.
+				for i := w; i < keyCount; i += workers {
+					fb.Add(keys[i])
+				}
+			}(w)
+		}
+		wg.Wait()
+		if got := fb.Finish(); !bytes.Equal(got, want) {
+			t.Errorf("shards=%d: concurrent build differs from NewFilter", shards)
+		}
+	}
+}
+
+// BenchmarkFilterBuilderAdd measures Add on a filter filled to a share of its
+// expected key count, as the bit array grows denser. Every addWindow adds the
+// filter is restored to its fill level, outside the timer, so that b.N does
+// not move it.
+func BenchmarkFilterBuilderAdd(b *testing.B) {
+	const expectedKeys, bitsPerKey, addWindow = 100000, 10, 1000
+	keys := GenerateSortedKeyHashes(expectedKeys + addWindow)
+	fresh := keys[expectedKeys:]
+
+	for _, fill := range []int{10, 50, 90} {
+		b.Run(fmt.Sprintf("fill=%d%%", fill), func(b *testing.B) {
+			fb := NewFilterBuilder(expectedKeys, bitsPerKey)
+			for _, h := range keys[:expectedKeys*fill/100] {
+				fb.Add(h)
+			}
+			filled := slices.Clone(fb.filter)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				j := i % addWindow
+				if j == 0 && i > 0 {
+					b.StopTimer()
+					copy(fb.filter, filled)
+					b.StartTimer()
+				}
+				fb.Add(fresh[j])
+			}
+			b.ReportMetric(100*bitDensity(filled[:len(filled)-1]), "%bits-set")
+		})
+	}
+}
+
+// BenchmarkFilterBuilderFinish measures Finish on full builders. Finish leaves
+// the builder's bits untouched, so each iteration hands them back to it.
+func BenchmarkFilterBuilderFinish(b *testing.B) {
+	const expectedKeys, bitsPerKey = 100000, 10
+	keys := GenerateSortedKeyHashes(expectedKeys)
+
+	b.Run("FilterBuilder", func(b *testing.B) {
+		fb := NewFilterBuilder(expectedKeys, bitsPerKey)
+		for _, h := range keys {
+			fb.Add(h)
+		}
+		filter := fb.filter
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			fb.filter = filter
+			fb.Finish()
+		}
+	})
+
+	for _, shards := range []int{1, 16} {
+		b.Run(fmt.Sprintf("Sharded/shards=%d", shards), func(b *testing.B) {
+			fb := NewShardedFilterBuilder(expectedKeys, bitsPerKey, shards)
+			for _, h := range keys {
+				fb.Add(h)
+			}
+			shardBits := fb.shards
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				fb.shards = shardBits
+				fb.Finish()
+			}
+		})
+	}
+}
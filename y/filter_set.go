@@ -0,0 +1,75 @@
+/*
+ * FilterSet - the filters of many tables, probed together
+ *
+ * A point lookup has to ask every table that may hold the key. Keeping their
+ * filters deserialized in a FilterSet, e.g. the ones DeserializeFilters reads
+ * back after a compaction, turns that into one Probe call. Each table also
+ * carries the range of key hashes it holds, so tables that cannot hold the key
+ * are ruled out by two comparisons before their filter is touched. The tables
+ * of a sorted level hold disjoint key ranges, but hashing scatters each
+ * table's keys over nearly the whole hash space, so their hash ranges
+ * overlap and the comparisons rule out few of them. They pay off for small
+ * tables, whose few hashes span a narrow range, or for filters keyed on an
+ * order-preserving value instead of a hash.
+ */
+
+package y
+
+import (
+	"fmt"
+	"math"
+)
+
+// FilterSet holds the filters of many tables together with the range of key
+// hashes each table holds. Tables are numbered in the order they are added.
+// A FilterSet is safe for concurrent Probe calls once it is filled.
+type FilterSet struct {
+	filters []TableFilter
+	bounds  [][2]uint32 // [minHash, maxHash] of each table
+}
+
+// Add adds a table's filter and the smallest and largest key hash the table
+// holds, and returns the table's index. A table whose range is unknown is
+// added with 0 and math.MaxUint32, or with AddFilter. A minHash above maxHash
+// is an error.
+func (fs *FilterSet) Add(f TableFilter, minHash, maxHash uint32) (int, error) {
+	if minHash > maxHash {
+		return 0, fmt.Errorf("FilterSet.Add: min hash %d above max hash %d", minHash, maxHash)
+	}
+	fs.filters = append(fs.filters, f)
+	fs.bounds = append(fs.bounds, [2]uint32{minHash, maxHash})
+	return len(fs.filters) - 1, nil
+}
+
+// AddFilter adds a table's filter with the hash range the filter records, and
+// returns the table's index. Only a trained *HybridFilter records one; any
+// other filter is added with the whole hash range.
+func (fs *FilterSet) AddFilter(f TableFilter) int {
+	minHash, maxHash := uint32(0), uint32(math.MaxUint32)
+	if hf, ok := f.(*HybridFilter); ok && hf.MaxHash != 0 {
+		minHash, maxHash = hf.MinHash, hf.MaxHash
+	}
+	i, _ := fs.Add(f, minHash, maxHash) // The range is never inverted.
+	return i
+}
+
+// Len returns the number of tables in the set.
+func (fs *FilterSet) Len() int {
+	return len(fs.filters)
+}
+
+// Probe returns the indices, in increasing order, of the tables that may hold
+// the key: those whose hash range covers keyHash and whose filter may contain
+// it. It returns nil if no table may hold the key.
+func (fs *FilterSet) Probe(keyHash uint32) []int {
+	var candidates []int
+	for i, b := range fs.bounds {
+		if keyHash < b[0] || keyHash > b[1] {
+			continue
+		}
+		if fs.filters[i].MayContain(keyHash) {
+			candidates = append(candidates, i)
+		}
+	}
+	return candidates
+}
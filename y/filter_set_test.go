@@ -0,0 +1,68 @@
+package y
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestFilterSetProbe(t *testing.T) {
+	numTables, keysPerTable := 50, 200
+	var fs FilterSet
+	tables := make([][]uint32, numTables)
+	for i := range tables {
+		// Table i holds hashes in [i<<20, (i+1)<<20), spaced apart.
+		for j := 0; j < keysPerTable; j++ {
+			tables[i] = append(tables[i], uint32(i)<<20+uint32(j)*1000)
+		}
+		keys := tables[i]
+		if got, err := fs.Add(BloomTableFilter{NewFilter(keys, 10)}, keys[0], keys[len(keys)-1]); err != nil || got != i {
+			t.Fatalf("Add returned index %d, %v, want %d", got, err, i)
+		}
+	}
+	if fs.Len() != numTables {
+		t.Fatalf("Len %d, want %d", fs.Len(), numTables)
+	}
+
+	for i, keys := range tables {
+		for _, h := range keys {
+			if got := fs.Probe(h); !slices.Equal(got, []int{i}) {
+				t.Fatalf("Key hash %d of table %d: candidates %v", h, i, got)
+			}
+		}
+	}
+	for _, h := range []uint32{uint32(numTables) << 20, math.MaxUint32} {
+		if got := fs.Probe(h); got != nil {
+			t.Errorf("Out-of-range key hash %d: candidates %v", h, got)
+		}
+	}
+
+	// A table with an unknown range is probed for every key.
+	all := fs.AddFilter(BloomTableFilter{NewFilter(tables[3], 10)})
+	if got := fs.Probe(tables[3][0]); !slices.Equal(got, []int{3, all}) {
+		t.Errorf("Candidates %v, want [3 %d]", got, all)
+	}
+
+	if _, err := fs.Add(BloomTableFilter{NewFilter(nil, 10)}, 2, 1); err == nil {
+		t.Error("Add accepted an inverted hash range")
+	}
+	if fs.Len() != numTables+1 {
+		t.Errorf("Len %d after a rejected Add, want %d", fs.Len(), numTables+1)
+	}
+}
+
+func TestFilterSetAddFilter(t *testing.T) {
+	// A hybrid filter records its hash range; the set uses it.
+	keys := []uint32{1000, 2000, 3000}
+	hf := TrainHybridFilter(keys, []uint32{0, 0, 1}, 2, DefaultHybridConfig())
+	var fs FilterSet
+	i := fs.AddFilter(hf)
+	if got := fs.Probe(2000); !slices.Equal(got, []int{i}) {
+		t.Errorf("Key hash in range: candidates %v, want [%d]", got, i)
+	}
+	for _, h := range []uint32{999, 3001} {
+		if got := fs.Probe(h); got != nil {
+			t.Errorf("Key hash %d outside [%d, %d]: candidates %v", h, hf.MinHash, hf.MaxHash, got)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+/*
+ * Pluggable key hash functions
+ *
+ * Filters in this package take precomputed 32-bit key hashes, so any hash
+ * function can be used by hashing keys before building and querying. The few
+ * helpers that hash raw keys themselves (Filter.MayContainKey and
+ * HybridFilter.Lookup) use a package-level default, Hash unless replaced with
+ * SetDefaultHash, so that hash functions can be compared for their effect on
+ * filter quality without changing call sites.
+ *
+ * The table builder and read path of the database always use Hash, whatever
+ * the default is; changing it only affects filters built and queried through
+ * this package's helpers.
+ */
+
+package y
+
+import "math/bits"
+
+// HashFunc maps a key to a 32-bit hash.
+type HashFunc func([]byte) uint32
+
+// defaultHash is used by the helpers that hash raw keys.
+var defaultHash HashFunc = Hash
+
+// SetDefaultHash replaces the hash function used by the helpers that hash raw
+// keys and returns the previous one; nil restores Hash. It is not safe to call
+// concurrently with those helpers, and filters must be queried with the same
+// function they were built with, so call it once during initialization (or
+// set and restore it around an experiment).
+func SetDefaultHash(h HashFunc) HashFunc {
+	prev := defaultHash
+	if h == nil {
+		h = Hash
+	}
+	defaultHash = h
+	return prev
+}
+
+// DefaultHash returns the hash function used by the helpers that hash raw keys.
+func DefaultHash() HashFunc {
+	return defaultHash
+}
+
+// FNV1aHash is the 32-bit FNV-1a hash. It is simple and fast on short keys but
+// mixes poorly in the high bits.
+func FNV1aHash(b []byte) uint32 {
+	const (
+		offset = 0x811c9dc5
+		prime  = 0x01000193
+	)
+	h := uint32(offset)
+	for _, c := range b {
+		h ^= uint32(c)
+		h *= prime
+	}
+	return h
+}
+
+// Murmur3Hash is the 32-bit MurmurHash3 (x86_32 variant) with seed 0.
+func Murmur3Hash(b []byte) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+	n := len(b)
+	var h uint32
+	for ; len(b) >= 4; b = b[4:] {
+		k := uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+	var k uint32
+	switch len(b) {
+	case 3:
+		k ^= uint32(b[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(b[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(b[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+	return fmix32(h ^ uint32(n))
+}
+
+// fmix32 is MurmurHash3's finalizer: a bijection on uint32 in which every
+// input bit affects every output bit.
+func fmix32(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
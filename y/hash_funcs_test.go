@@ -0,0 +1,121 @@
+/*
+ * Tests for the pluggable hash functions
+ */
+
+package y
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestHashFuncKnownValues(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   HashFunc
+		s    string
+		want uint32
+	}{
+		{"fnv1a", FNV1aHash, "", 0x811c9dc5},
+		{"fnv1a", FNV1aHash, "a", 0xe40c292c},
+		{"fnv1a", FNV1aHash, "foobar", 0xbf9cf968},
+		{"murmur3", Murmur3Hash, "", 0},
+		{"murmur3", Murmur3Hash, "hello", 0x248bfa47},
+		{"murmur3", Murmur3Hash, "The quick brown fox jumps over the lazy dog", 0x2e4ff723},
+	}
+	for _, tt := range tests {
+		if got := tt.fn([]byte(tt.s)); got != tt.want {
+			t.Errorf("%s(%q) = 0x%08x, want 0x%08x", tt.name, tt.s, got, tt.want)
+		}
+	}
+}
+
+// TestSetDefaultHash builds filters with each hash function. The filters
+// differ, but each answers correctly when queried with its own function.
+func TestSetDefaultHash(t *testing.T) {
+	keyCount := 10000
+	keys := make([][]byte, keyCount)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key_%010d", i))
+	}
+
+	if DefaultHash()(keys[0]) != Hash(keys[0]) {
+		t.Fatal("Default hash should be Hash")
+	}
+
+	var filters []Filter
+	for _, fn := range []HashFunc{Hash, FNV1aHash, Murmur3Hash} {
+		hashes := make([]uint32, keyCount)
+		for i, k := range keys {
+			hashes[i] = fn(k)
+		}
+		f := NewFilter(hashes, 10)
+		filters = append(filters, f)
+
+		prev := SetDefaultHash(fn)
+		for i, k := range keys {
+			if !f.MayContainKey(k) {
+				SetDefaultHash(prev)
+				t.Fatalf("False negative for key %d", i)
+			}
+		}
+		fp := 0
+		for i := 0; i < keyCount; i++ {
+			if f.MayContainKey([]byte(fmt.Sprintf("absent_%010d", i))) {
+				fp++
+			}
+		}
+		SetDefaultHash(prev)
+		if fpRate := float64(fp) / float64(keyCount); fpRate > 0.03 {
+			t.Errorf("FP rate %.2f%% too high", fpRate*100)
+		}
+	}
+	for i := 1; i < len(filters); i++ {
+		if bytes.Equal(filters[0], filters[i]) {
+			t.Errorf("Filter %d has the same contents as the Hash filter", i)
+		}
+	}
+
+	if prev := SetDefaultHash(nil); prev(keys[0]) != Hash(keys[0]) {
+		t.Error("Expected Hash to have been restored")
+	}
+	if DefaultHash()(keys[0]) != Hash(keys[0]) {
+		t.Error("SetDefaultHash(nil) should restore Hash")
+	}
+}
+
+// BenchmarkHashOnly measures Hash by itself, so that its cost can be told
+// apart from the filter operations the other benchmarks time on pre-hashed
+// keys. "Keys" hashes the keys of GenerateSortedKeyHashes; the len=N cases
+// hash random keys of N bytes, to show how the cost grows with key length.
+func BenchmarkHashOnly(b *testing.B) {
+	keys := make([][]byte, 1<<12)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key_%010d", i))
+	}
+	var sink uint32
+	b.Run("Keys", func(b *testing.B) {
+		b.SetBytes(int64(len(keys[0])))
+		for i := 0; i < b.N; i++ {
+			sink += Hash(keys[i&(len(keys)-1)])
+		}
+	})
+
+	rng := rand.New(rand.NewSource(1))
+	for _, length := range []int{8, 32, 128} {
+		fixed := make([][]byte, 1<<12)
+		for i := range fixed {
+			fixed[i] = make([]byte, length)
+			rng.Read(fixed[i])
+		}
+		b.Run(fmt.Sprintf("len=%d", length), func(b *testing.B) {
+			b.SetBytes(int64(length))
+			for i := 0; i < b.N; i++ {
+				sink += Hash(fixed[i&(len(fixed)-1)])
+			}
+		})
+	}
+	b.Logf("sink %d", sink)
+}
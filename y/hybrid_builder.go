@@ -0,0 +1,197 @@
+/*
+ * HybridFilterBuilder - training a hybrid filter without holding its keys
+ *
+ * TrainHybridFilter makes two passes over the keys: one to fit the line, and
+ * one to measure how far each key lies from it, which gives the error bounds.
+ * It therefore needs every key hash and block index in memory at once. A
+ * builder fed from a stream of keys, e.g. a table being written, can instead
+ * read the keys twice from wherever they are stored:
+ *
+ *   1. Add every key, in chunks of any size. Only the regression sums, the key
+ *      count and the hash range are kept.
+ *   2. FitBounds every key again, in the same order. The line is fixed by the
+ *      first call; each key is added to the bloom, whose number of hash
+ *      functions depends on the key count, and its residual to the bounds.
+ *   3. Finish returns the filter.
+ *
+ * The result matches TrainHybridFilter over the same keys, up to rounding in
+ * the last bits of the regression sums.
+ */
+
+package y
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// HybridFilterBuilder trains a HybridFilter from keys passed to it twice,
+// without retaining them; see Add, FitBounds and Finish. The filter's model is
+// fitted on key hashes, as by TrainHybridFilter. It is not safe for concurrent
+// use.
+type HybridFilterBuilder struct {
+	config      HybridFilterConfig
+	numBlocks   int
+	tableBlocks int // numBlocks as passed, before MaxPosOverride
+
+	// First pass.
+	sums             regressionSums
+	keyCount         int
+	minHash, maxHash uint32
+
+	// Second pass, from the first FitBounds call on.
+	hf             *HybridFilter
+	replayed       int
+	minErr, maxErr int64
+	maxAbsResidual float64
+	residualSum    float64
+	residualSumSq  float64
+}
+
+// NewHybridFilterBuilder returns a builder for a filter over numBlocks blocks.
+// Percentile bounds and the residual histogram need every residual at once,
+// so a config with ErrorPercentile in (0, 1) or ResidualHistogram set is an
+// error.
+func NewHybridFilterBuilder(numBlocks int, config HybridFilterConfig) (*HybridFilterBuilder, error) {
+	if config.ErrorPercentile > 0 && config.ErrorPercentile < 1 {
+		return nil, fmt.Errorf("HybridFilterBuilder: error percentile %v needs all residuals",
+			config.ErrorPercentile)
+	}
+	if config.ResidualHistogram {
+		return nil, fmt.Errorf("HybridFilterBuilder: residual histogram needs all residuals")
+	}
+	return &HybridFilterBuilder{config: config, numBlocks: config.blockCount(numBlocks), tableBlocks: numBlocks}, nil
+}
+
+// Add adds keys to the first pass. It must not be called after FitBounds.
+// Block indices out of range are clamped or rejected per
+// config.StrictBlockIndices, as in training.
+func (b *HybridFilterBuilder) Add(keyHashes, blockIndices []uint32) error {
+	if b.hf != nil {
+		return fmt.Errorf("HybridFilterBuilder.Add after FitBounds")
+	}
+	blockIndices, err := b.checkChunk(keyHashes, blockIndices)
+	if err != nil || len(keyHashes) == 0 {
+		return err
+	}
+	if b.keyCount == 0 {
+		b.minHash, b.maxHash = keyHashes[0], keyHashes[0]
+	}
+	for _, h := range keyHashes {
+		b.minHash, b.maxHash = min(b.minHash, h), max(b.maxHash, h)
+	}
+	b.sums.merge(accumulateSums(keyHashes, blockIndices))
+	b.keyCount += len(keyHashes)
+	return nil
+}
+
+// FitBounds replays keys for the second pass. Across all calls, the keys must
+// be those passed to Add, in the same order; replaying more keys than were
+// added is an error.
+func (b *HybridFilterBuilder) FitBounds(keyHashes, blockIndices []uint32) error {
+	blockIndices, err := b.checkChunk(keyHashes, blockIndices)
+	if err != nil {
+		return err
+	}
+	if b.hf == nil {
+		b.start()
+	}
+	if b.replayed+len(keyHashes) > b.keyCount {
+		return fmt.Errorf("HybridFilterBuilder: replayed %d keys, but %d were added",
+			b.replayed+len(keyHashes), b.keyCount)
+	}
+	b.replayed += len(keyHashes)
+
+	hf := b.hf
+	if err := fillHybridBloom(context.Background(), hf.BloomBits, hf.BloomHashK, keyHashes); err != nil {
+		return err
+	}
+	if b.config.SkipLearned {
+		return nil
+	}
+	for i, h := range keyHashes {
+		predicted := hf.Slope*float64(h) + hf.Intercept
+		actual := float64(blockIndices[i])
+		r := actual - predicted
+		b.maxAbsResidual = math.Max(b.maxAbsResidual, math.Abs(r))
+		b.residualSum += r
+		b.residualSumSq += r * r
+		err := hf.RoundMode.residual(predicted, actual)
+		b.minErr, b.maxErr = min(b.minErr, err), max(b.maxErr, err)
+	}
+	return nil
+}
+
+// Finish returns the filter once every added key has been replayed. The
+// builder must not be used afterwards. The DuplicateKeys of its Stats are not
+// counted, as that needs the keys sorted by hash.
+func (b *HybridFilterBuilder) Finish() (*HybridFilter, error) {
+	if b.hf == nil {
+		b.start()
+	}
+	if b.replayed != b.keyCount {
+		return nil, fmt.Errorf("HybridFilterBuilder: replayed %d keys, but %d were added",
+			b.replayed, b.keyCount)
+	}
+	hf := b.hf
+	b.hf = nil
+	if b.keyCount > 0 && !b.config.SkipLearned {
+		hf.residualMoments = newResidualMoments(b.keyCount, b.residualSum, b.residualSumSq)
+	}
+	switch {
+	case b.keyCount == 0:
+	case b.config.SkipLearned:
+		// A model that always predicts block 0, as in training.
+		hf.MaxErr = int32(hf.MaxPos)
+	case b.keyCount == 1:
+		hf.MinErr, hf.MaxErr = -1, 1
+	case b.maxAbsResidual < exactFitEpsilon && hf.RoundMode == RoundNearest:
+		// Every key lies on the line; see fitHybridModel.
+	default:
+		margin := int64(1)
+		if hf.RoundMode != RoundNearest {
+			margin = 0
+		}
+		hf.MinErr, hf.MaxErr = int32(b.minErr-margin), int32(b.maxErr+margin)
+	}
+	return hf, nil
+}
+
+// checkChunk validates a chunk of keys passed to Add or FitBounds.
+func (b *HybridFilterBuilder) checkChunk(keyHashes, blockIndices []uint32) ([]uint32, error) {
+	if len(keyHashes) != len(blockIndices) {
+		return nil, fmt.Errorf("HybridFilterBuilder: %d key hashes but %d block indices",
+			len(keyHashes), len(blockIndices))
+	}
+	return checkBlockIndices(blockIndices, uint64(max(b.numBlocks, 0)), b.config.StrictBlockIndices)
+}
+
+// start ends the first pass: it sizes the bloom for the number of keys added
+// and fits the line.
+func (b *HybridFilterBuilder) start() {
+	hf := &HybridFilter{
+		BloomBits: make([]byte, b.config.BloomSizeBytes),
+		RoundMode: b.config.RoundMode,
+		MaxPos:    uint32(max(0, b.numBlocks-1)),
+
+		MaxRangeBlocks: uint32(max(0, b.config.MaxRangeBlocks)),
+	}
+	if b.config.MaxPosOverride > 0 {
+		hf.numBlocks = uint32(max(b.tableBlocks, 1))
+	}
+	b.hf = hf
+	if b.keyCount == 0 {
+		hf.BloomHashK = 1
+		return
+	}
+	hf.KeyCount = uint32(b.keyCount)
+	hf.MinHash, hf.MaxHash = b.minHash, b.maxHash
+	hf.BloomHashK = b.config.bloomK(b.keyCount)
+	if b.config.SkipLearned {
+		return
+	}
+	hf.Slope, hf.Intercept = b.sums.fit()
+	hf.setSums(b.sums)
+	hf.minX, hf.maxX = float64(b.minHash), float64(b.maxHash)
+}
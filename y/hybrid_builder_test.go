@@ -0,0 +1,112 @@
+package y
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// buildStreamed trains a filter with a HybridFilterBuilder, passing the keys
+// in chunks of chunkSize in both passes.
+func buildStreamed(t *testing.T, keyHashes, blockIndices []uint32, numBlocks, chunkSize int,
+	config HybridFilterConfig) *HybridFilter {
+	t.Helper()
+	b, err := NewHybridFilterBuilder(numBlocks, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pass := range []func([]uint32, []uint32) error{b.Add, b.FitBounds} {
+		for lo := 0; lo < len(keyHashes); lo += chunkSize {
+			hi := min(lo+chunkSize, len(keyHashes))
+			if err := pass(keyHashes[lo:hi], blockIndices[lo:hi]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	hf, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hf
+}
+
+func TestHybridFilterBuilderMatchesBatch(t *testing.T) {
+	seeds := int64(100)
+	if testing.Short() {
+		seeds = 20
+	}
+	for seed := int64(0); seed < seeds; seed++ {
+		keys, blocks, numBlocks := randomTrainingSet(seed)
+		for _, config := range []HybridFilterConfig{
+			DefaultHybridConfig(),
+			{BloomSizeBytes: 128, RoundMode: RoundFloor},
+			{BloomSizeBytes: 128, RoundMode: RoundCeil},
+			{BloomSizeBytes: 32, SkipLearned: true},
+		} {
+			want := TrainHybridFilter(keys, blocks, numBlocks, config)
+			got := buildStreamed(t, keys, blocks, numBlocks, 1+int(seed)*37, config)
+			if !bytes.Equal(got.BloomBits, want.BloomBits) || got.BloomHashK != want.BloomHashK {
+				t.Fatalf("seed %d %+v: bloom differs from batch training", seed, config)
+			}
+			if got.MinErr != want.MinErr || got.MaxErr != want.MaxErr {
+				t.Fatalf("seed %d %+v: streamed bounds [%d, %d], batch [%d, %d]",
+					seed, config, got.MinErr, got.MaxErr, want.MinErr, want.MaxErr)
+			}
+			if math.Abs(got.Slope-want.Slope) > 1e-9*math.Abs(want.Slope) ||
+				math.Abs(got.Intercept-want.Intercept) > 1e-6*max(math.Abs(want.Intercept), 1) {
+				t.Fatalf("seed %d %+v: streamed line %g x + %g, batch %g x + %g",
+					seed, config, got.Slope, got.Intercept, want.Slope, want.Intercept)
+			}
+			gotMean, gotStdDev, gotOK := got.ResidualMoments()
+			wantMean, wantStdDev, wantOK := want.ResidualMoments()
+			if gotOK != wantOK || math.Abs(gotMean-wantMean) > 1e-6 || math.Abs(gotStdDev-wantStdDev) > 1e-6 {
+				t.Fatalf("seed %d %+v: streamed residual moments %v, %v, %v, batch %v, %v, %v",
+					seed, config, gotMean, gotStdDev, gotOK, wantMean, wantStdDev, wantOK)
+			}
+			if got.KeyCount != want.KeyCount || got.MaxPos != want.MaxPos ||
+				got.MinHash != want.MinHash || got.MaxHash != want.MaxHash {
+				t.Fatalf("seed %d %+v: streamed %d keys, MaxPos %d, hashes [%d, %d], batch %d, %d, [%d, %d]",
+					seed, config, got.KeyCount, got.MaxPos, got.MinHash, got.MaxHash,
+					want.KeyCount, want.MaxPos, want.MinHash, want.MaxHash)
+			}
+		}
+	}
+
+	empty := buildStreamed(t, nil, nil, 10, 1, DefaultHybridConfig())
+	if want := TrainHybridFilter(nil, nil, 10, DefaultHybridConfig()); !bytes.Equal(empty.Serialize(), want.Serialize()) {
+		t.Error("Empty streamed filter differs from batch training")
+	}
+}
+
+func TestHybridFilterBuilderErrors(t *testing.T) {
+	config := DefaultHybridConfig()
+	config.ErrorPercentile = 0.9
+	if _, err := NewHybridFilterBuilder(10, config); err == nil {
+		t.Error("No error for percentile bounds")
+	}
+
+	keys := GenerateSortedKeyHashes(100)
+	blocks := GenerateBlockIndices(len(keys), 10)
+	b, err := NewHybridFilterBuilder(10, DefaultHybridConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Add(keys, blocks[:50]); err == nil {
+		t.Error("No error for mismatched lengths")
+	}
+	if err := b.Add(keys[:50], blocks[:50]); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.FitBounds(keys[:40], blocks[:40]); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Add(keys[50:], blocks[50:]); err == nil {
+		t.Error("No error for Add after FitBounds")
+	}
+	if _, err := b.Finish(); err == nil {
+		t.Error("No error for Finish with keys not replayed")
+	}
+	if err := b.FitBounds(keys[40:], blocks[40:]); err == nil {
+		t.Error("No error for replaying more keys than were added")
+	}
+}
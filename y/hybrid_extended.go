@@ -0,0 +1,169 @@
+/*
+ * Extended hybrid filter with per-block key counts
+ *
+ * PredictRange narrows a lookup to a few blocks, but each block still needs a
+ * binary search whose cost depends on how many keys it holds. Storing the key
+ * count of every block lets the read path estimate the total work of a
+ * lookup, e.g. log2(TotalKeysInRange) comparisons, before doing it.
+ *
+ * Block sizes of a table are usually similar, so they are stored as
+ * zigzag-varint deltas from the previous block, mostly one byte each.
+ */
+
+package y
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// ExtendedHybridFilter is a HybridFilter with the number of keys in each block.
+// Only Serialize and the gob encoding include the block sizes: the promoted
+// methods of the embedded filter (WriteTo, MarshalJSON, Update, ...) see the
+// HybridFilter alone and do not maintain them.
+type ExtendedHybridFilter struct {
+	*HybridFilter
+	BlockSizes []uint32 // Number of keys in each block
+
+	// cumulative[i] is the number of keys in blocks [0, i), computed when the
+	// filter is built so that queries never write to it.
+	cumulative []uint64
+}
+
+// TrainExtendedHybridFilter trains a hybrid filter as TrainHybridFilter does
+// and records the number of keys in each of the numBlocks blocks.
+func TrainExtendedHybridFilter(keyHashes []uint32, blockIndices []uint32, numBlocks int,
+	config HybridFilterConfig) *ExtendedHybridFilter {
+	sizes := make([]uint32, max(numBlocks, 0))
+	for _, b := range blockIndices {
+		if int(b) < len(sizes) {
+			sizes[b]++
+		}
+	}
+	return NewExtendedHybridFilter(TrainHybridFilter(keyHashes, blockIndices, numBlocks, config), sizes)
+}
+
+// NewExtendedHybridFilter attaches per-block key counts to a trained filter.
+// The filter and sizes are used as is, not copied.
+func NewExtendedHybridFilter(hf *HybridFilter, blockSizes []uint32) *ExtendedHybridFilter {
+	ehf := &ExtendedHybridFilter{
+		HybridFilter: hf,
+		BlockSizes:   blockSizes,
+		cumulative:   make([]uint64, len(blockSizes)+1),
+	}
+	for i, size := range blockSizes {
+		ehf.cumulative[i+1] = ehf.cumulative[i] + uint64(size)
+	}
+	return ehf
+}
+
+// TotalKeysInRange returns the number of keys in blocks [minBlock, maxBlock],
+// clamped to the blocks the filter knows about. An empty range yields 0.
+func (ehf *ExtendedHybridFilter) TotalKeysInRange(minBlock, maxBlock int) int {
+	minBlock = max(minBlock, 0)
+	maxBlock = min(maxBlock, len(ehf.BlockSizes)-1)
+	if minBlock > maxBlock {
+		return 0
+	}
+	return int(ehf.cumulative[maxBlock+1] - ehf.cumulative[minBlock])
+}
+
+// Serialize converts the filter to bytes: the HybridFilter as Serialize writes
+// it, followed by the block sizes.
+// Format: [hybrid filter][numBlocks:uvarint][size delta:varint]...
+func (ehf *ExtendedHybridFilter) Serialize() []byte {
+	return ehf.appendBlockSizes(ehf.HybridFilter.Serialize())
+}
+
+// Size returns the number of bytes Serialize produces, block sizes included.
+func (ehf *ExtendedHybridFilter) Size() int {
+	return ehf.HybridFilter.SerializedSize() + len(ehf.appendBlockSizes(nil))
+}
+
+// appendBlockSizes appends the block count and the delta-encoded block sizes
+// to buf.
+func (ehf *ExtendedHybridFilter) appendBlockSizes(buf []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(ehf.BlockSizes)))
+	prev := int64(0)
+	for _, size := range ehf.BlockSizes {
+		buf = binary.AppendVarint(buf, int64(size)-prev)
+		prev = int64(size)
+	}
+	return buf
+}
+
+// DeserializeExtendedHybridFilter reads a filter written by
+// ExtendedHybridFilter.Serialize with a bloom component of bloomSize bytes.
+// The returned error wraps ErrShortBuffer, ErrBadMagic or
+// ErrUnsupportedVersion.
+func DeserializeExtendedHybridFilter(data []byte, bloomSize int) (*ExtendedHybridFilter, error) {
+	hf, n, err := decodeHybridFilter(data, bloomSize)
+	if err != nil {
+		return nil, err
+	}
+	sizes, _, err := decodeBlockSizes(data[n:])
+	if err != nil {
+		return nil, err
+	}
+	return NewExtendedHybridFilter(hf, sizes), nil
+}
+
+// decodeBlockSizes reads the block sizes written by appendBlockSizes and
+// returns them with the number of bytes consumed.
+func decodeBlockSizes(data []byte) ([]uint32, int, error) {
+	rest := data
+	numBlocks, n := binary.Uvarint(rest)
+	if n <= 0 {
+		return nil, 0, fmt.Errorf("extended hybrid filter block count: %w", ErrShortBuffer)
+	}
+	rest = rest[n:]
+	// Every size takes at least one byte, which bounds the allocation.
+	if numBlocks > uint64(len(rest)) {
+		return nil, 0, fmt.Errorf("extended hybrid filter: %d block sizes in %d bytes: %w",
+			numBlocks, len(rest), ErrShortBuffer)
+	}
+	sizes := make([]uint32, numBlocks)
+	prev := int64(0)
+	for i := range sizes {
+		delta, n := binary.Varint(rest)
+		if n <= 0 {
+			return nil, 0, fmt.Errorf("extended hybrid filter block size %d: %w", i, ErrShortBuffer)
+		}
+		rest = rest[n:]
+		prev += delta
+		sizes[i] = uint32(prev)
+	}
+	return sizes, len(data) - len(rest), nil
+}
+
+// GobEncode implements gob.GobEncoder: the embedded filter's gob encoding
+// followed by the block sizes. Without it, gob would pick up the promoted
+// HybridFilter methods and drop the block sizes.
+func (ehf *ExtendedHybridFilter) GobEncode() ([]byte, error) {
+	buf, err := ehf.HybridFilter.GobEncode()
+	if err != nil {
+		return nil, err
+	}
+	return ehf.appendBlockSizes(buf), nil
+}
+
+// GobDecode implements gob.GobDecoder, reading a payload written by
+// GobEncode.
+func (ehf *ExtendedHybridFilter) GobDecode(data []byte) error {
+	r := bytes.NewReader(data)
+	hf := &HybridFilter{}
+	if _, err := hf.ReadFrom(r); err != nil {
+		return err
+	}
+	rest := data[len(data)-r.Len():]
+	sizes, n, err := decodeBlockSizes(rest)
+	if err != nil {
+		return err
+	}
+	if n != len(rest) {
+		return fmt.Errorf("extended hybrid filter gob: %d trailing bytes", len(rest)-n)
+	}
+	*ehf = *NewExtendedHybridFilter(hf, sizes)
+	return nil
+}
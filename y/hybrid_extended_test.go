@@ -0,0 +1,100 @@
+/*
+ * Tests for the extended hybrid filter
+ */
+
+package y
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestExtendedHybridFilterTotalKeysInRange(t *testing.T) {
+	// Blocks of 1 to 200 keys.
+	rng := rand.New(rand.NewSource(1))
+	numBlocks := 100
+	var blockStarts []int
+	keyCount := 0
+	for b := 0; b < numBlocks; b++ {
+		blockStarts = append(blockStarts, keyCount)
+		keyCount += 1 + rng.Intn(200)
+	}
+	blocks := BlockIndicesFromBoundaries(keyCount, blockStarts)
+	hashes := GenerateSortedKeyHashes(keyCount)
+
+	ehf := TrainExtendedHybridFilter(hashes, blocks, numBlocks, DefaultHybridConfig())
+
+	// Ground truth straight from the block assignment.
+	count := func(minBlock, maxBlock int) int {
+		n := 0
+		for _, b := range blocks {
+			if int(b) >= minBlock && int(b) <= maxBlock {
+				n++
+			}
+		}
+		return n
+	}
+	for i := 0; i < 200; i++ {
+		lo := rng.Intn(numBlocks)
+		hi := lo + rng.Intn(numBlocks-lo)
+		if got, want := ehf.TotalKeysInRange(lo, hi), count(lo, hi); got != want {
+			t.Fatalf("TotalKeysInRange(%d, %d) = %d, want %d", lo, hi, got, want)
+		}
+	}
+	if got := ehf.TotalKeysInRange(-5, numBlocks+5); got != keyCount {
+		t.Errorf("Clamped full range has %d keys, want %d", got, keyCount)
+	}
+	if got := ehf.TotalKeysInRange(10, 9); got != 0 {
+		t.Errorf("Empty range has %d keys, want 0", got)
+	}
+
+	// The embedded filter answers queries as before.
+	minBlock, maxBlock := ehf.PredictRange(hashes[0])
+	if ehf.TotalKeysInRange(minBlock, maxBlock) == 0 {
+		t.Error("Predicted range for a present key should hold keys")
+	}
+
+	data := ehf.Serialize()
+	overhead := len(data) - ehf.HybridFilter.SerializedSize()
+	t.Logf("Block sizes for %d blocks take %d bytes", numBlocks, overhead)
+	if overhead > 2*numBlocks+2 {
+		t.Errorf("Block sizes take %d bytes, expected at most %d", overhead, 2*numBlocks+2)
+	}
+	restored, err := DeserializeExtendedHybridFilter(data, len(ehf.BloomBits))
+	if err != nil {
+		t.Fatalf("DeserializeExtendedHybridFilter: %v", err)
+	}
+	if !bytes.Equal(restored.Serialize(), data) {
+		t.Error("Reserialized bytes differ")
+	}
+	if restored.TotalKeysInRange(3, 42) != count(3, 42) {
+		t.Error("Restored filter gives a different count")
+	}
+	if _, err := DeserializeExtendedHybridFilter(data[:len(data)-1], len(ehf.BloomBits)); !errors.Is(err, ErrShortBuffer) {
+		t.Errorf("Expected ErrShortBuffer for truncated data, got %v", err)
+	}
+}
+
+func TestExtendedHybridFilterGob(t *testing.T) {
+	keyCount := 3000
+	blocks := BlockIndicesFromBoundaries(keyCount, []int{0, 10, 500, 2999})
+	ehf := TrainExtendedHybridFilter(GenerateSortedKeyHashes(keyCount), blocks, 4, DefaultHybridConfig())
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(ehf); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	var got ExtendedHybridFilter
+	if err := gob.NewDecoder(&buf).Decode(&got); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !bytes.Equal(got.Serialize(), ehf.Serialize()) {
+		t.Fatal("Gob roundtrip changed the filter")
+	}
+	if got.TotalKeysInRange(1, 2) != 490+2499 {
+		t.Errorf("TotalKeysInRange(1, 2) = %d after gob, want %d", got.TotalKeysInRange(1, 2), 490+2499)
+	}
+}
@@ -15,16 +15,36 @@
 package y
 
 import (
+	"bytes"
+	"cmp"
+	"context"
 	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
 	"math"
+	"runtime"
+	"slices"
 )
 
 // HybridFilter combines a compact Bloom filter with a Learned Index
 // for optimal SSTable lookup performance.
 //
 // Total size: ~64-128 bytes (configurable) vs. kilobytes for Bloom alone
+//
+// A trained filter is immutable: no query method writes to it and nothing is
+// computed lazily, so MayContain, PredictRange, Query and the other read
+// methods are safe to call from any number of goroutines at once. The
+// methods that rebuild the filter in place (Reset, TrainHybridFilterInto,
+// Update, WidenBounds, ReadFrom, UnmarshalJSON) are not, and must not run while the
+// filter is being queried; build into a fresh filter and publish it once
+// complete instead.
 type HybridFilter struct {
-	// Compact Bloom filter (reduced size since we have learned index backup)
+	// Compact Bloom filter (reduced size since we have learned index backup).
+	// Unlike a Filter, which keeps k in its last byte and so has 8*(len-1)
+	// bits, every byte of BloomBits holds bits: it has 8*len of them, and k
+	// is kept in BloomHashK (serialized as the byte after the bits). Bits are
+	// set and probed as in Filter; ToFilter converts between the two.
 	BloomBits  []byte // Small bloom filter
 	BloomHashK uint8  // Number of hash functions
 
@@ -35,6 +55,75 @@ type HybridFilter struct {
 	MaxErr    int32
 	MaxPos    uint32
 	KeyCount  uint32
+
+	// Insertion timestamp range of the table's keys, for TTL-based skipping.
+	// A MaxTimestamp of 0 means no timestamps were recorded.
+	MinTimestamp int64
+	MaxTimestamp int64
+
+	// Smallest and largest key hash inserted into the bloom. A hash outside
+	// them was never inserted, so MayContain rejects it without probing the
+	// bloom. A MaxHash of 0 means no bounds were recorded, as for a
+	// deserialized filter; like the sums below, they are not serialized.
+	MinHash uint32
+	MaxHash uint32
+
+	// ProbabilisticBounds is set when MinErr/MaxErr were trained at an
+	// ErrorPercentile below 1.0, so a few present keys fall outside the
+	// predicted range and callers must fall back to a full scan on a miss.
+	ProbabilisticBounds bool
+
+	// RoundMode is how the model's prediction is rounded to the center block
+	// of PredictRange and to the result of PredictBlock. It is chosen at train
+	// time, and MinErr/MaxErr are relative to the center it gives.
+	RoundMode RoundMode
+
+	// numBlocks is the table's own block count when MaxPosOverride put the
+	// model in a global block space, and 0 when it is MaxPos+1; see NumBlocks.
+	numBlocks uint32
+
+	// MaxRangeBlocks caps the predicted range Query, QueryDetailed and Lookup
+	// act on; see HybridFilterConfig.MaxRangeBlocks. It is a read-path policy
+	// rather than part of the model, so it is not serialized and may be set on
+	// a deserialized filter before it is published.
+	MaxRangeBlocks uint32
+
+	// Regression sums over the training keys, retained so that Update can
+	// refit the model without revisiting them, together with the range of
+	// positions they covered: the means of positions and blocks, and the sums
+	// of squared and cross deviations from them. They are kept in memory only
+	// and are not serialized; n is 0 for a deserialized filter.
+	meanX, meanY float64
+	sxx, sxy     float64
+	n            uint64
+	minX, maxX   float64
+
+	// duplicateKeys counts the training keys that share their position with a
+	// key in a different block, e.g. because of a hash collision; Stats
+	// reports it. Like the sums, it is not serialized.
+	duplicateKeys uint32
+
+	// residualHist is the histogram returned by ResidualHistogram, kept only
+	// when the filter was trained with HybridFilterConfig.ResidualHistogram.
+	residualHist []int
+
+	// residualMoments are the moments of the training residuals used by
+	// PredictRangeSigma. They are nil when unknown: for a deserialized filter,
+	// one trained with SkipLearned, or one whose model Update has refit.
+	residualMoments *residualMoments
+}
+
+// residualMoments are the mean and standard deviation of the residuals,
+// block minus unrounded prediction, of a model's training keys.
+type residualMoments struct {
+	mean, stdDev float64
+}
+
+// newResidualMoments returns the moments of n residuals with the given sum and
+// sum of squares.
+func newResidualMoments(n int, sum, sumSq float64) *residualMoments {
+	mean := sum / float64(n)
+	return &residualMoments{mean: mean, stdDev: math.Sqrt(math.Max(sumSq/float64(n)-mean*mean, 0))}
 }
 
 // HybridFilterConfig controls the hybrid filter parameters
@@ -46,6 +135,105 @@ type HybridFilterConfig struct {
 	// TargetFPRate is the target false positive rate for bloom (default: 5%)
 	// Higher than traditional 1% since we prioritize space efficiency
 	TargetFPRate float64
+
+	// ErrorPercentile is the fraction of training keys the error bounds must
+	// cover (default: 1.0, the worst case). At 0.99, the 1% most extreme
+	// residuals on each side are left out, so a single outlier no longer
+	// widens the search range of every lookup. Values <= 0 or >= 1 mean 1.0.
+	ErrorPercentile float64
+
+	// SkipLearned builds only the bloom component, for inputs such as hashed
+	// keys where the model could not narrow the search anyway. The learned
+	// fields are left zeroed except for MaxErr, which is set to MaxPos so that
+	// PredictRange returns the whole table and Query acts as a plain bloom.
+	SkipLearned bool
+
+	// RoundMode selects how predictions are rounded to a block (default:
+	// RoundNearest).
+	RoundMode RoundMode
+
+	// ResidualHistogram keeps a histogram of the training residuals on the
+	// filter for diagnosing model fit; see HybridFilter.ResidualHistogram.
+	// Building it costs one more pass over the keys.
+	ResidualHistogram bool
+
+	// StrictBlockIndices rejects block indices >= numBlocks with
+	// ErrBlockOutOfRange instead of clamping them to the last block, which is
+	// the default since assigning blocks by integer division can yield
+	// numBlocks for the trailing keys. Only TrainHybridFilterContext and
+	// HybridFilterBuilder honour it; the builders without an error result
+	// always clamp.
+	StrictBlockIndices bool
+
+	// MaxPosOverride, if nonzero, sets MaxPos in place of numBlocks-1, for a
+	// model trained on positions and blocks in a global space spanning many
+	// tables rather than on one table's own blocks. Predictions are clamped to
+	// it, and block indices are checked against it instead of numBlocks.
+	MaxPosOverride uint32
+
+	// BloomHashK, if nonzero, pins the number of bloom hash functions, which
+	// is otherwise chosen from the key count and TargetFPRate. MergeHybrid
+	// can only OR blooms with the same k, so filters of tables that may be
+	// merged later should be trained with the same BloomHashK. Values above
+	// 30 mean 30.
+	BloomHashK int
+
+	// MaxRangeBlocks, if nonzero, is the widest predicted range worth a
+	// bounded search. When PredictRange spans more blocks, Query returns the
+	// whole table instead, and QueryDetailed sets Fallback, so that the read
+	// path ignores the model and scans as it would with a plain bloom: under
+	// a strict latency budget, a model that cannot narrow the search to a few
+	// blocks only adds its own cost. PredictRange itself is not capped.
+	MaxRangeBlocks int
+}
+
+// blockCount returns the number of blocks a filter trained with c over
+// numBlocks blocks spans: MaxPosOverride+1 if it is set.
+func (c HybridFilterConfig) blockCount(numBlocks int) int {
+	if c.MaxPosOverride > 0 {
+		return int(c.MaxPosOverride) + 1
+	}
+	return numBlocks
+}
+
+// bloomK returns the number of bloom hash functions for a filter trained with
+// c on n keys: BloomHashK if set, and otherwise the count hybridBloomK picks.
+func (c HybridFilterConfig) bloomK(n int) uint8 {
+	if c.BloomHashK > 0 {
+		return uint8(min(c.BloomHashK, 30))
+	}
+	return uint8(hybridBloomK(c.BloomSizeBytes*8, n, c.TargetFPRate))
+}
+
+// RoundMode selects how a HybridFilter rounds its fractional prediction to a
+// block index.
+//
+// With RoundNearest, the error bounds are trained on the truncated residuals
+// and padded by one block on each side, which also absorbs the rounding. With
+// RoundFloor and RoundCeil, the bounds are trained on the exact offsets of
+// each key's block from the rounded center and need no padding: under
+// RoundFloor the center is at or below the prediction, so MaxErr tends to
+// grow and MinErr to shrink, and RoundCeil does the opposite. The width of
+// the range is about the same in every mode; only its placement around the
+// prediction changes.
+type RoundMode uint8
+
+const (
+	RoundNearest RoundMode = iota // Round half away from zero, as math.Round
+	RoundFloor                    // Round down, as math.Floor
+	RoundCeil                     // Round up, as math.Ceil
+)
+
+// round rounds a model prediction to a block index according to m.
+func (m RoundMode) round(pos float64) int {
+	switch m {
+	case RoundFloor:
+		return int(math.Floor(pos))
+	case RoundCeil:
+		return int(math.Ceil(pos))
+	default:
+		return int(math.Round(pos))
+	}
 }
 
 // DefaultHybridConfig returns sensible defaults for the hybrid filter
@@ -56,111 +244,706 @@ func DefaultHybridConfig() HybridFilterConfig {
 	}
 }
 
+// hybridLearnedSizeBytes is the size of the learned component as Stats
+// reports it: BloomHashK and the fields a serialized LearnedIndex holds, Slope,
+// Intercept, MinErr, MaxErr, KeyCount and MaxPos.
+const hybridLearnedSizeBytes = 1 + LearnedIndexSize
+
+// hybridMinBloomBytes is the smallest bloom SolveHybridConfig will allocate,
+// the same 64-bit floor NewFilter applies.
+const hybridMinBloomBytes = 8
+
+// hybridMaxBloomBytes is the largest bloom a filter can probe, whose bit
+// count must fit in a uint32 as for maxFilterBits.
+const hybridMaxBloomBytes = maxFilterBits / 8
+
+// SolveHybridConfig splits budgetBytes between the bloom and learned
+// components of a filter over keyCount keys in numBlocks blocks. The learned
+// component has a fixed size of 33 bytes, so the bloom gets the rest; the
+// returned TargetFPRate is the analytical FP rate that bloom can reach for
+// keyCount keys, which makes training pick the FP-optimal k.
+//
+// The budget covers the two components as Stats counts them. The serialized
+// filter also has a header and timestamps: HybridFilterSize of the result is
+// 20 bytes more than budgetBytes. The returned error wraps ErrBudgetTooSmall
+// if the budget leaves less than 8 bytes for the bloom.
+func SolveHybridConfig(keyCount, numBlocks, budgetBytes int) (HybridFilterConfig, error) {
+	if keyCount < 0 || numBlocks < 0 {
+		return HybridFilterConfig{}, fmt.Errorf("SolveHybridConfig: %d keys in %d blocks", keyCount, numBlocks)
+	}
+	bloomBytes := budgetBytes - hybridLearnedSizeBytes
+	if bloomBytes < hybridMinBloomBytes {
+		return HybridFilterConfig{}, fmt.Errorf("hybrid filter budget of %d bytes, want at least %d: %w",
+			budgetBytes, hybridLearnedSizeBytes+hybridMinBloomBytes, ErrBudgetTooSmall)
+	}
+	config := HybridFilterConfig{BloomSizeBytes: bloomBytes}
+	if keyCount > 0 {
+		nBits := bloomBytes * 8
+		config.TargetFPRate = bloomFPRate(nBits, keyCount, hybridBloomK(nBits, keyCount, 0))
+	}
+	return config, nil
+}
+
+// Serialized HybridFilters start with a 2-byte magic followed by a 1-byte
+// format version and a 1-byte set of flags. Version 3 is the fixed-width
+// format written by Serialize, version 2 the varint format written by
+// SerializeCompact. Version 1 is the fixed-width format from before the flags
+// byte, which is still read but no longer written. A filter trained with
+// MaxPosOverride ends with its table's block count, and is written as version
+// 4 in the fixed-width format or 5 in the varint one, so that readers that
+// predate the count reject it rather than misparse it; every other filter is
+// written as version 2 or 3. Flag bits not defined here are rejected.
+const (
+	hybridFilterMagic                   = "HF"
+	hybridFilterVersionLegacy           = 1
+	hybridFilterVersionCompact          = 2
+	hybridFilterVersion                 = 3
+	hybridFilterVersionNumBlocks        = 4
+	hybridFilterVersionCompactNumBlocks = 5
+	hybridFilterHeaderSize              = len(hybridFilterMagic) + 2
+
+	// hybridFilterLegacyHeaderSize is the header size of version 1, which
+	// has no flags byte.
+	hybridFilterLegacyHeaderSize = len(hybridFilterMagic) + 1
+
+	hybridFlagProbabilisticBounds = 1 << 0
+
+	// Bits 1-2 of the flags hold the RoundMode.
+	hybridFlagRoundModeShift = 1
+	hybridFlagRoundModeMask  = 0b11 << hybridFlagRoundModeShift
+
+	hybridFlagsKnown = hybridFlagProbabilisticBounds | hybridFlagRoundModeMask
+
+	// hybridNumBlocksSize is the size of the block count of versions 4 and 5
+	// in the fixed-width format; the compact one writes it as a uvarint.
+	hybridNumBlocksSize = 4
+)
+
 // HybridFilterSize returns the total size of a hybrid filter with given config
 func HybridFilterSize(config HybridFilterConfig) int {
-	// BloomBits + BloomHashK + Slope + Intercept + MinErr + MaxErr + MaxPos + KeyCount
-	return config.BloomSizeBytes + 1 + 8 + 8 + 4 + 4 + 4 + 4
+	// Header + BloomBits + BloomHashK + Slope + Intercept + MinErr + MaxErr + MaxPos + KeyCount
+	// + MinTimestamp + MaxTimestamp [+ NumBlocks]
+	return hybridFilterHeaderSize + config.BloomSizeBytes + hybridTrailerSize(config.MaxPosOverride > 0)
 }
 
-// TrainHybridFilter creates a hybrid filter from sorted key data
+// TrainHybridFilter creates a hybrid filter from sorted key data. With no
+// keys, MayContain rejects every key, as for NewFilter, whatever the bloom
+// size; PredictRange still spans every block.
 func TrainHybridFilter(keyHashes []uint32, blockIndices []uint32, numBlocks int, config HybridFilterConfig) *HybridFilter {
-	if len(keyHashes) == 0 {
-		return &HybridFilter{
-			BloomBits:  make([]byte, config.BloomSizeBytes),
-			BloomHashK: 1,
-			MaxPos:     uint32(max(0, numBlocks-1)),
+	hf := &HybridFilter{}
+	TrainHybridFilterInto(hf, keyHashes, blockIndices, numBlocks, config)
+	return hf
+}
+
+// Reset zeroes the bloom bits in place and clears the learned fields, so that
+// the allocation can be reused for another table.
+func (hf *HybridFilter) Reset() {
+	clear(hf.BloomBits)
+	*hf = HybridFilter{BloomBits: hf.BloomBits}
+}
+
+// TrainHybridFilterInto retrains an existing HybridFilter from sorted key data.
+// The BloomBits slice is reused if it already has config.BloomSizeBytes bytes,
+// which avoids an allocation per table when building many tables in a row.
+// The result is identical to that of TrainHybridFilter.
+func TrainHybridFilterInto(hf *HybridFilter, keyHashes []uint32, blockIndices []uint32, numBlocks int,
+	config HybridFilterConfig) {
+	// Without a deadline or strict block indices, training cannot fail.
+	config.StrictBlockIndices = false
+	Check(trainHybridInto(context.Background(), hf, keyHashes, keyHashes, blockIndices, numBlocks, config))
+}
+
+// TrainHybridFilterFiltered creates a hybrid filter from only the keys for which
+// keep(i) returns true, e.g. to leave out tombstoned keys during compaction.
+// Excluded keys are neither added to the bloom nor used to fit the model, so
+// their hashes are rejected (up to false positives) unless a live key shares
+// them. The block indices of the live keys are used unchanged.
+func TrainHybridFilterFiltered(keyHashes []uint32, blockIndices []uint32, numBlocks int,
+	config HybridFilterConfig, keep func(i int) bool) *HybridFilter {
+	liveHashes := make([]uint32, 0, len(keyHashes))
+	liveBlocks := make([]uint32, 0, len(blockIndices))
+	for i := range keyHashes {
+		if keep(i) {
+			liveHashes = append(liveHashes, keyHashes[i])
+			liveBlocks = append(liveBlocks, blockIndices[i])
 		}
 	}
+	return TrainHybridFilter(liveHashes, liveBlocks, numBlocks, config)
+}
+
+// TrainHybridFilterContext is TrainHybridFilter for inputs large enough that
+// the build may need to be abandoned, e.g. when a compaction is aborted. The
+// context is checked every ctxCheckInterval keys during the bloom fill and the
+// regression, and ctx.Err() is returned as soon as it is non-nil.
+func TrainHybridFilterContext(ctx context.Context, keyHashes []uint32, blockIndices []uint32, numBlocks int,
+	config HybridFilterConfig) (*HybridFilter, error) {
+	hf := &HybridFilter{}
+	if err := trainHybridInto(ctx, hf, keyHashes, keyHashes, blockIndices, numBlocks, config); err != nil {
+		return nil, err
+	}
+	return hf, nil
+}
 
-	hf := &HybridFilter{
-		KeyCount: uint32(len(keyHashes)),
-		MaxPos:   uint32(max(0, numBlocks-1)),
+// TrainHybridFilterWithPositions creates a hybrid filter whose bloom component
+// is built from key hashes and whose learned component is trained on key
+// positions (an ordering-preserving input, e.g. from BlockIndex.Position).
+// Hashes are what membership checks need, while positions are what makes the
+// model's predictions narrow; see Lookup.
+func TrainHybridFilterWithPositions(keyHashes []uint32, positions []uint32, blockIndices []uint32,
+	numBlocks int, config HybridFilterConfig) *HybridFilter {
+	hf := &HybridFilter{}
+	config.StrictBlockIndices = false // See TrainHybridFilterInto.
+	Check(trainHybridInto(context.Background(), hf, keyHashes, positions, blockIndices, numBlocks, config))
+	return hf
+}
+
+// trainHybridInto builds the bloom component from keyHashes and fits the
+// learned component on positions, which may be the same slice. It fails if ctx
+// is done before the build completes, or on a block index out of range under
+// StrictBlockIndices.
+func trainHybridInto(ctx context.Context, hf *HybridFilter, keyHashes []uint32, positions []uint32,
+	blockIndices []uint32, numBlocks int, config HybridFilterConfig) error {
+	tableBlocks := numBlocks
+	numBlocks = config.blockCount(numBlocks)
+	blockIndices, err := checkBlockIndices(blockIndices, uint64(max(numBlocks, 0)), config.StrictBlockIndices)
+	if err != nil {
+		return err
+	}
+	if err := trainHybridComponents(ctx, hf, keyHashes, positions, blockIndices, numBlocks, config); err != nil {
+		return err
 	}
+	if config.MaxPosOverride > 0 {
+		hf.numBlocks = uint32(max(tableBlocks, 1))
+	}
+	if config.ResidualHistogram && !config.SkipLearned {
+		hf.residualHist = hf.buildResidualHistogram(positions, blockIndices)
+	}
+	return nil
+}
+
+// checkBlockIndices returns blockIndices with every index >= numBlocks
+// clamped to the last block, copying them only if one needs clamping. With
+// strict set, such an index is an error wrapping ErrBlockOutOfRange instead.
+func checkBlockIndices[B blockIndex](blockIndices []B, numBlocks uint64, strict bool) ([]B, error) {
+	lastBlock := B(max(numBlocks, 1) - 1)
+	i := slices.IndexFunc(blockIndices, func(b B) bool { return b > lastBlock })
+	if i < 0 {
+		return blockIndices, nil
+	}
+	if strict {
+		return nil, fmt.Errorf("block index %d of key %d with %d blocks: %w",
+			blockIndices[i], i, numBlocks, ErrBlockOutOfRange)
+	}
+	clamped := slices.Clone(blockIndices)
+	for j := i; j < len(clamped); j++ {
+		clamped[j] = min(clamped[j], lastBlock)
+	}
+	return clamped, nil
+}
+
+// trainHybridComponents builds the bloom and the model for trainHybridInto.
+func trainHybridComponents(ctx context.Context, hf *HybridFilter, keyHashes []uint32, positions []uint32,
+	blockIndices []uint32, numBlocks int, config HybridFilterConfig) error {
+	hf.Reset()
+	if len(hf.BloomBits) != config.BloomSizeBytes {
+		hf.BloomBits = make([]byte, config.BloomSizeBytes)
+	}
+	hf.RoundMode = config.RoundMode
+	hf.MaxPos = uint32(max(0, numBlocks-1))
+	hf.MaxRangeBlocks = uint32(max(0, config.MaxRangeBlocks))
+
+	if len(keyHashes) == 0 {
+		hf.BloomHashK = 1
+		return nil
+	}
+	hf.KeyCount = uint32(len(keyHashes))
+	hf.MinHash, hf.MaxHash = slices.Min(keyHashes), slices.Max(keyHashes)
 
 	// === Build compact Bloom filter ===
-	nBits := config.BloomSizeBytes * 8
-	// Calculate optimal k based on size and number of keys
-	// k = (m/n) * ln(2), where m = bits, n = keys
-	kFloat := float64(nBits) / float64(len(keyHashes)) * 0.693
-	k := uint8(max(1, min(30, int(kFloat))))
+	k := config.bloomK(len(keyHashes))
 	hf.BloomHashK = k
-	hf.BloomBits = make([]byte, config.BloomSizeBytes)
 
-	// Add all keys to bloom filter
-	for _, h := range keyHashes {
+	if err := fillHybridBloom(ctx, hf.BloomBits, k, keyHashes); err != nil {
+		return err
+	}
+
+	if config.SkipLearned {
+		// A model that always predicts block 0, with an error bound that
+		// reaches the last block.
+		hf.MaxErr = int32(hf.MaxPos)
+		return nil
+	}
+
+	// === Build Learned Index (same as before) ===
+	m, err := fitHybridModel(ctx, positions, blockIndices, config)
+	if err != nil {
+		return err
+	}
+	hf.Slope, hf.Intercept = m.slope, m.intercept
+	hf.MinErr, hf.MaxErr = int32(m.minErr), int32(m.maxErr)
+	hf.ProbabilisticBounds = m.probabilistic
+	hf.duplicateKeys = uint32(m.duplicateKeys)
+	hf.setSums(m.sums)
+	hf.minX, hf.maxX = m.minX, m.maxX
+	hf.residualMoments = m.residuals
+	return nil
+}
+
+// fillHybridBloom adds keyHashes to the bloom bits with k probes each,
+// checking ctx every ctxCheckInterval keys.
+func fillHybridBloom(ctx context.Context, bloomBits []byte, k uint8, keyHashes []uint32) error {
+	if len(bloomBits) == 0 {
+		// No bloom: hybridBloomMayContain admits every key.
+		return nil
+	}
+	nBits := uint32(len(bloomBits) * 8)
+	for i, h := range keyHashes {
+		if i%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
 		delta := h>>17 | h<<15
 		for j := uint8(0); j < k; j++ {
-			bitPos := h % uint32(nBits)
-			hf.BloomBits[bitPos/8] |= 1 << (bitPos % 8)
+			bitPos := h % nBits
+			bloomBits[bitPos/8] |= 1 << (bitPos % 8)
 			h += delta
 		}
 	}
+	return nil
+}
 
-	// === Build Learned Index (same as before) ===
-	n := len(keyHashes)
+// hybridModel is the learned component fitted by fitHybridModel. The error
+// bounds are wide enough for 64-bit block indices; HybridFilter narrows them.
+type hybridModel struct {
+	slope, intercept float64
+	minErr, maxErr   int64
+	probabilistic    bool
+	duplicateKeys    int
+	sums             regressionSums
+	minX, maxX       float64
+	residuals        *residualMoments
+}
 
+// fitHybridModel fits the line and error bounds of a hybrid filter on at
+// least one key, with positions and blockIndices of equal length.
+func fitHybridModel[B blockIndex](ctx context.Context, positions []uint32, blockIndices []B,
+	config HybridFilterConfig) (hybridModel, error) {
+	n := len(positions)
 	if n == 1 {
-		hf.Slope = 0
-		hf.Intercept = float64(blockIndices[0])
-		hf.MinErr = -1
-		hf.MaxErr = 1
-		return hf
+		x := float64(positions[0])
+		return hybridModel{
+			intercept: float64(blockIndices[0]),
+			minErr:    -1,
+			maxErr:    1,
+			sums:      accumulateSums(positions, blockIndices),
+			minX:      x,
+			maxX:      x,
+			residuals: &residualMoments{},
+		}, nil
 	}
 
 	// Linear regression
-	var sumX, sumY, sumXY, sumX2 float64
+	sums, err := computeRegressionSumsContext(ctx, positions, blockIndices)
+	if err != nil {
+		return hybridModel{}, err
+	}
+	m := hybridModel{sums: sums, minX: math.Inf(1), maxX: math.Inf(-1)}
+	m.slope, m.intercept = sums.fit()
+	mode := config.RoundMode
+
+	// Calculate error bounds
+	percentile := config.ErrorPercentile
+	var residuals []int64
+	if percentile > 0 && percentile < 1 {
+		residuals = make([]int64, 0, n)
+	}
+	var minErr, maxErr int64
+	var maxAbsResidual, residualSum, residualSumSq float64
 	for i := 0; i < n; i++ {
-		x := float64(keyHashes[i])
-		y := float64(blockIndices[i])
-		sumX += x
-		sumY += y
-		sumXY += x * y
-		sumX2 += x * x
+		if i%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return hybridModel{}, err
+			}
+		}
+		x := float64(positions[i])
+		m.minX, m.maxX = math.Min(m.minX, x), math.Max(m.maxX, x)
+		predicted := m.slope*x + m.intercept
+		actual := float64(blockIndices[i])
+		r := actual - predicted
+		maxAbsResidual = math.Max(maxAbsResidual, math.Abs(r))
+		residualSum += r
+		residualSumSq += r * r
+		err := mode.residual(predicted, actual)
+		if residuals != nil {
+			residuals = append(residuals, err)
+			continue
+		}
+		minErr = min(minErr, err)
+		maxErr = max(maxErr, err)
+	}
+	m.residuals = newResidualMoments(n, residualSum, residualSumSq)
+	if maxAbsResidual < exactFitEpsilon && mode == RoundNearest {
+		// Every key lies on the line, so rounding the prediction gives its
+		// block exactly and no margin is needed. Floor and ceil can still
+		// land one block off when the prediction is a hair from an integer,
+		// which their exact offsets already account for.
+		return m, nil
+	}
+	if residuals != nil {
+		slices.Sort(residuals)
+		lo := int(math.Floor((1 - percentile) * float64(n-1)))
+		hi := int(math.Ceil(percentile * float64(n-1)))
+		minErr = min(0, residuals[lo])
+		maxErr = max(0, residuals[hi])
+		m.probabilistic = true
+	}
+	// Keys at the same position get the same prediction, so the range must
+	// span all of their blocks. The exact bounds above already do; percentile
+	// bounds are widened to cover the residuals of every such key, or a
+	// colliding key would be missed even though the bloom admits it.
+	conflicts := conflictingPositions(positions, blockIndices)
+	m.duplicateKeys = len(conflicts)
+	for _, i := range conflicts {
+		x := float64(positions[i])
+		err := mode.residual(m.slope*x+m.intercept, float64(blockIndices[i]))
+		minErr, maxErr = min(minErr, err), max(maxErr, err)
+	}
+	margin := int64(1)
+	if mode != RoundNearest {
+		margin = 0
+	}
+	m.minErr = minErr - margin
+	m.maxErr = maxErr + margin
+	return m, nil
+}
+
+// residual returns the error of a prediction for a key in block actual, in the
+// form the bounds are trained on: truncated under RoundNearest, where the
+// bounds are then padded by one block, and the exact offset from the rounded
+// center otherwise.
+func (m RoundMode) residual(predicted, actual float64) int64 {
+	if m == RoundNearest {
+		return int64(actual - predicted)
 	}
+	return int64(actual) - int64(m.round(predicted))
+}
 
-	nf := float64(n)
-	denominator := nf*sumX2 - sumX*sumX
+// residualHistogramBuckets is the number of buckets ResidualHistogram splits
+// [MinErr, MaxErr] into. It is odd so that a centered range has a bucket
+// around zero.
+const residualHistogramBuckets = 21
 
-	if math.Abs(denominator) < 1e-10 {
-		hf.Slope = 0
-		hf.Intercept = sumY / nf
+// buildResidualHistogram counts the model errors actual - predicted of the
+// training keys into residualHistogramBuckets equal buckets spanning
+// [MinErr, MaxErr]. Errors outside the bounds, which percentile bounds leave,
+// are counted in the first or last bucket.
+func (hf *HybridFilter) buildResidualHistogram(positions, blockIndices []uint32) []int {
+	hist := make([]int, residualHistogramBuckets)
+	lo := float64(hf.MinErr)
+	width := float64(hf.MaxErr) - lo
+	for i, p := range positions {
+		r := float64(blockIndices[i]) - (hf.Slope*float64(p) + hf.Intercept)
+		b := 0
+		if width > 0 {
+			b = min(max(int((r-lo)/width*residualHistogramBuckets), 0), residualHistogramBuckets-1)
+		}
+		hist[b]++
+	}
+	return hist
+}
+
+// ResidualHistogram returns the distribution of the model's errors over the
+// training keys, as counts in 21 equal buckets from MinErr to MaxErr, or nil
+// if the filter was not trained with HybridFilterConfig.ResidualHistogram.
+// A tall peak with a few stragglers in the outer buckets means the width
+// comes from a few outliers; a broad or lopsided spread means the keys follow
+// a curve the line cannot fit. If MinErr equals MaxErr, every key is in the first bucket.
+//
+// The histogram is not serialized and is dropped by Update.
+func (hf *HybridFilter) ResidualHistogram() []int {
+	return slices.Clone(hf.residualHist)
+}
+
+// hybridOverProvisionedDensity is the bloom bit density below which Stats
+// reports the bloom as over-provisioned. An optimally loaded bloom is about
+// half full; at a tenth, it is several times larger than it needs to be.
+const hybridOverProvisionedDensity = 0.1
+
+// bloomFPRate is the analytical false positive rate of a bloom filter of m
+// bits holding n keys with k hash functions: (1 - e^(-kn/m))^k.
+func bloomFPRate(m, n, k int) float64 {
+	return math.Pow(1-math.Exp(-float64(k)*float64(n)/float64(m)), float64(k))
+}
+
+// hybridBloomK returns the number of hash functions for a bloom of nBits bits
+// over n keys. It starts from the k in [1, 30] that minimizes the analytical
+// FP rate, i.e. the integer nearest m/n * ln(2) in the better direction. If
+// targetFP is set and a smaller k already meets it, the smallest such k is
+// used instead: when m is large for n, the optimal k can be in the tens while
+// one or two hashes already give a negligible FP rate, and every extra hash
+// costs a probe on each query.
+func hybridBloomK(nBits, n int, targetFP float64) int {
+	best := 1
+	for k := 2; k <= 30; k++ {
+		if bloomFPRate(nBits, n, k) < bloomFPRate(nBits, n, best) {
+			best = k
+		}
+	}
+	if targetFP > 0 {
+		for k := 1; k < best; k++ {
+			if bloomFPRate(nBits, n, k) <= targetFP {
+				return k
+			}
+		}
+	}
+	return best
+}
+
+// setSums records the regression sums of the training keys.
+func (hf *HybridFilter) setSums(sums regressionSums) {
+	hf.meanX, hf.meanY, hf.sxx, hf.sxy = sums.meanX, sums.meanY, sums.sxx, sums.sxy
+	hf.n = uint64(sums.n)
+}
+
+// sums returns the regression sums recorded by setSums.
+func (hf *HybridFilter) sums() regressionSums {
+	return regressionSums{n: float64(hf.n), meanX: hf.meanX, meanY: hf.meanY, sxx: hf.sxx, sxy: hf.sxy}
+}
+
+// Update adds keys to a trained filter without retraining from scratch, for
+// memtables that keep receiving keys. The new hashes are added to the bloom
+// with the existing number of hash functions, so its false positive rate
+// rises as keys are added; retrain once it matters. The retained regression
+// sums are extended with the new keys to refit Slope and Intercept exactly as
+// a full retrain would. MaxPos grows to cover the new block indices, and
+// [MinHash, MaxHash] to cover the new hashes.
+//
+// Exact error bounds need a second pass over all keys, which Update avoids by
+// using a residual summary instead: the old keys' residuals were within
+// [MinErr, MaxErr] of the old line, and the gap between the old and the new
+// line is linear in the position, so it is bounded by its values at the
+// smallest and largest positions trained on. The old bounds are widened by
+// that gap and combined with the exact residuals of the new keys. The result
+// covers every key but may be wider than a retrain would give; percentile
+// bounds are not maintained.
+//
+// The learned component must use keyHashes as positions, as TrainHybridFilter
+// does. Returns an error if the filter does not carry regression sums, e.g.
+// because it was deserialized.
+func (hf *HybridFilter) Update(newKeyHashes, newBlockIndices []uint32) error {
+	if uint64(hf.KeyCount) != hf.n {
+		return fmt.Errorf("HybridFilter.Update: filter has %d keys but regression sums for %d",
+			hf.KeyCount, hf.n)
+	}
+	if len(newKeyHashes) != len(newBlockIndices) {
+		return fmt.Errorf("HybridFilter.Update: %d key hashes but %d block indices",
+			len(newKeyHashes), len(newBlockIndices))
+	}
+	if len(newKeyHashes) == 0 {
+		return nil
+	}
+	hf.residualHist = nil
+	hf.residualMoments = nil
+
+	if nBits := uint32(len(hf.BloomBits) * 8); nBits > 0 {
+		for _, h := range newKeyHashes {
+			delta := h>>17 | h<<15
+			for j := uint8(0); j < hf.BloomHashK; j++ {
+				bitPos := h % nBits
+				hf.BloomBits[bitPos/8] |= 1 << (bitPos % 8)
+				h += delta
+			}
+		}
+	}
+
+	if hf.KeyCount == 0 {
+		hf.MinHash, hf.MaxHash = math.MaxUint32, 0
+	}
+	if hf.KeyCount == 0 || hf.MaxHash != 0 {
+		hf.MinHash = min(hf.MinHash, slices.Min(newKeyHashes))
+		hf.MaxHash = max(hf.MaxHash, slices.Max(newKeyHashes))
+	}
+
+	oldCount := hf.n
+	oldSlope, oldIntercept := hf.Slope, hf.Intercept
+	oldMinErr, oldMaxErr := float64(hf.MinErr), float64(hf.MaxErr)
+
+	sums := hf.sums()
+	sums.merge(accumulateSums(newKeyHashes, newBlockIndices))
+	n := int(oldCount) + len(newKeyHashes)
+	hf.setSums(sums)
+	hf.KeyCount = uint32(n)
+	if n == 1 {
+		hf.Slope, hf.Intercept = 0, float64(newBlockIndices[0])
 	} else {
-		hf.Slope = (nf*sumXY - sumX*sumY) / denominator
-		hf.Intercept = (sumY - hf.Slope*sumX) / nf
+		hf.Slope, hf.Intercept = sums.fit()
 	}
 
-	// Calculate error bounds
-	var minErr, maxErr int32
-	for i := 0; i < n; i++ {
-		predicted := hf.Slope*float64(keyHashes[i]) + hf.Intercept
-		actual := float64(blockIndices[i])
-		err := int32(actual - predicted)
-		if err < minErr {
-			minErr = err
+	minErr, maxErr := math.Inf(1), math.Inf(-1)
+	if oldCount > 0 {
+		for _, x := range []float64{hf.minX, hf.maxX} {
+			gap := (oldSlope*x + oldIntercept) - (hf.Slope*x + hf.Intercept)
+			minErr = math.Min(minErr, gap+oldMinErr)
+			maxErr = math.Max(maxErr, gap+oldMaxErr)
 		}
-		if err > maxErr {
-			maxErr = err
+	} else {
+		hf.minX, hf.maxX = math.Inf(1), math.Inf(-1)
+	}
+	for i, h := range newKeyHashes {
+		x := float64(h)
+		hf.minX, hf.maxX = math.Min(hf.minX, x), math.Max(hf.maxX, x)
+		hf.MaxPos = max(hf.MaxPos, newBlockIndices[i])
+		err := float64(newBlockIndices[i]) - (hf.Slope*x + hf.Intercept)
+		minErr = math.Min(minErr, err)
+		maxErr = math.Max(maxErr, err)
+	}
+	hf.MinErr = int32(math.Floor(minErr)) - 1
+	hf.MaxErr = int32(math.Ceil(maxErr)) + 1
+	return nil
+}
+
+// WidenBounds multiplies MinErr and MaxErr by factor, rounding outwards, for a
+// read path that sees lookups miss the predicted range because queries have
+// drifted from the trained keys and that cannot retrain right away. Each bound
+// moves by at least one block for a factor above 1, so that bounds of 0 widen
+// too, but not past MaxPos blocks, beyond which the range is clamped to the
+// table anyway. A factor <= 1 leaves the bounds as they are: they never
+// narrow. The residual histogram, kept relative to the old bounds, is dropped.
+//
+// Like Update, it modifies the filter in place; widen a copy and publish it
+// if the filter is being queried.
+func (hf *HybridFilter) WidenBounds(factor float64) {
+	if !(factor > 1) {
+		return
+	}
+	hf.residualHist = nil
+	maxPos := float64(hf.MaxPos)
+	minErr := math.Min(math.Floor(float64(hf.MinErr)*factor), float64(hf.MinErr)-1)
+	maxErr := math.Max(math.Ceil(float64(hf.MaxErr)*factor), float64(hf.MaxErr)+1)
+	hf.MinErr = min(hf.MinErr, int32(math.Max(minErr, -maxPos)))
+	hf.MaxErr = max(hf.MaxErr, int32(math.Min(maxErr, maxPos)))
+}
+
+// Thresholds for ShouldRebuild. A line fitted to a new sample of the same
+// distribution lies within a fraction of a block of the old one; a shift or a
+// change in keys per block moves it by whole blocks. The old bounds must
+// still cover nearly all new keys, short of those a percentile-trained filter
+// leaves out by design.
+const (
+	rebuildMaxDriftBlocks = 1.0
+	rebuildMinHitRate     = 0.99
+)
+
+// ShouldRebuild reports whether the filter of a table being compacted should
+// be retrained for its new keys, or whether old still describes them. It fits
+// a line to the new keys and compares it with old's over the new keys' hash
+// range: it reports true if the two predictions drift apart by more than a
+// block anywhere in it, or if old's PredictRange misses the true block of more
+// than 1% of the new keys. A filter without a model is always rebuilt, and one
+// for no new keys never is. It returns an error if newKeyHashes and
+// newBlockIndices differ in length.
+//
+// Only the model can be kept: old's bloom and MinHash/MaxHash describe the
+// old keys and reject new ones, so they must be rebuilt for the new keys even
+// when ShouldRebuild reports false.
+func ShouldRebuild(old *HybridFilter, newKeyHashes, newBlockIndices []uint32) (bool, error) {
+	if len(newKeyHashes) != len(newBlockIndices) {
+		return false, fmt.Errorf("ShouldRebuild: %d key hashes but %d block indices",
+			len(newKeyHashes), len(newBlockIndices))
+	}
+	if len(newKeyHashes) == 0 {
+		return false, nil
+	}
+	if old == nil || old.KeyCount == 0 {
+		return true, nil
+	}
+	slope, intercept := computeRegressionSums(newKeyHashes, newBlockIndices).fit()
+	for _, h := range []uint32{slices.Min(newKeyHashes), slices.Max(newKeyHashes)} {
+		x := float64(h)
+		if math.Abs((old.Slope*x+old.Intercept)-(slope*x+intercept)) > rebuildMaxDriftBlocks {
+			return true, nil
 		}
 	}
-	hf.MinErr = minErr - 1
-	hf.MaxErr = maxErr + 1
+	report, err := old.EvaluateAccuracy(newKeyHashes, newBlockIndices)
+	if err != nil {
+		return false, err
+	}
+	return report.HitRate < rebuildMinHitRate, nil
+}
 
-	return hf
+// TrainHybridFilterMemProfiled is TrainHybridFilter instrumented to report the
+// number of bytes allocated during the build. Since nothing is freed until the
+// build returns, this is the transient high-water mark of the build and can be
+// used to decide how many builds fit in a memory budget.
+//
+// This is opt-in: reading runtime.MemStats stops the world, and a GC is forced
+// beforehand so that the measurement starts from a clean heap. Allocations made
+// concurrently by other goroutines are included in the result.
+func TrainHybridFilterMemProfiled(keyHashes []uint32, blockIndices []uint32, numBlocks int,
+	config HybridFilterConfig) (*HybridFilter, uint64) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	hf := TrainHybridFilter(keyHashes, blockIndices, numBlocks, config)
+	runtime.ReadMemStats(&after)
+	return hf, after.TotalAlloc - before.TotalAlloc
 }
 
-// MayContain returns true if the key MIGHT be in the table (Bloom filter check)
+// MayContain returns true if the key MIGHT be in the table (Bloom filter check).
+// A key hash outside [MinHash, MaxHash] is rejected before the bloom, and a
+// filter trained on no keys rejects every key.
 func (hf *HybridFilter) MayContain(keyHash uint32) bool {
-	if hf == nil || len(hf.BloomBits) == 0 {
+	if hf == nil {
+		return true // No filter = assume present
+	}
+	if hf.KeyCount == 0 || !hf.inHashBounds(keyHash) {
+		return false
+	}
+	return hybridBloomMayContain(hf.BloomBits, hf.BloomHashK, keyHash)
+}
+
+// inHashBounds reports whether keyHash lies in [MinHash, MaxHash], or true if
+// no bounds were recorded.
+func (hf *HybridFilter) inHashBounds(keyHash uint32) bool {
+	return hf.MaxHash == 0 || (keyHash >= hf.MinHash && keyHash <= hf.MaxHash)
+}
+
+// ToFilter returns the bloom component as a standalone Filter: a copy of
+// BloomBits with BloomHashK appended as the trailing k byte. Both probe the
+// same bits, so the Filter's MayContain equals the bloom check of the hybrid's;
+// it lacks the hash bounds, so it may accept a key outside [MinHash, MaxHash]
+// that the hybrid rejects. A filter with no bloom bits, which admits every
+// key, converts to one byte of set bits with k=1 (or of clear bits, rejecting
+// every key, if it was trained on none).
+func (hf *HybridFilter) ToFilter() Filter {
+	if len(hf.BloomBits) == 0 {
+		if hf.KeyCount == 0 {
+			return Filter{0, 1}
+		}
+		return Filter{0xff, 1}
+	}
+	f := make(Filter, len(hf.BloomBits)+1)
+	copy(f, hf.BloomBits)
+	f[len(hf.BloomBits)] = hf.BloomHashK
+	return f
+}
+
+// hybridBloomMayContain checks keyHash against bloom bits filled by
+// fillHybridBloom with k probes. Empty bits admit every key.
+func hybridBloomMayContain(bloomBits []byte, k uint8, keyHash uint32) bool {
+	if len(bloomBits) == 0 {
 		return true // No filter = assume present
 	}
 
-	nBits := uint32(len(hf.BloomBits) * 8)
+	nBits := uint32(len(bloomBits) * 8)
 	h := keyHash
+	// Probed as Filter is, so that ToFilter is a plain copy; see SizedFilter.
 	delta := h>>17 | h<<15
 
-	for j := uint8(0); j < hf.BloomHashK; j++ {
+	for j := uint8(0); j < k; j++ {
 		bitPos := h % nBits
-		if hf.BloomBits[bitPos/8]&(1<<(bitPos%8)) == 0 {
+		if bloomBits[bitPos/8]&(1<<(bitPos%8)) == 0 {
 			return false // Definitely not present
 		}
 		h += delta
@@ -168,34 +951,264 @@ func (hf *HybridFilter) MayContain(keyHash uint32) bool {
 	return true // Might be present
 }
 
-// PredictRange returns the predicted block range for a key (Learned Index)
+// PredictRange returns the predicted block range for a key (Learned Index).
+// The range is ordered, minBlock <= maxBlock, whatever the sign of Slope: a
+// model trained on keys stored in descending order has a negative slope, but
+// its error bounds are still offsets below and above the prediction.
 func (hf *HybridFilter) PredictRange(keyHash uint32) (minBlock, maxBlock int) {
 	if hf == nil || hf.KeyCount == 0 {
 		return 0, int(hf.MaxPos)
 	}
 
 	pos := hf.Slope*float64(keyHash) + hf.Intercept
-	predicted := int(math.Round(pos))
+	predicted := hf.RoundMode.round(pos)
 
 	minBlock = predicted + int(hf.MinErr)
 	maxBlock = predicted + int(hf.MaxErr)
 
-	// Clamp to valid range
+	// Clamp both ends to valid blocks, as LearnedIndex.Predict does, so that a
+	// prediction far outside the table still yields its edge block rather than
+	// an empty range.
 	maxPosInt := int(hf.MaxPos)
-	if minBlock < 0 {
-		minBlock = 0
+	minBlock = min(max(minBlock, 0), maxPosInt)
+	maxBlock = min(max(maxBlock, 0), maxPosInt)
+
+	return minBlock, maxBlock
+}
+
+// PredictRangeExtrapolated is PredictRange that also reports whether keyHash
+// lies outside [MinHash, MaxHash], where the model extrapolates beyond the
+// keys it was fitted on and its range is least trustworthy. MayContain
+// rejects such keys, but a caller that uses the range without it, e.g. to
+// seek to where an absent key would be, can widen its search or fall back to
+// a full scan. With no bounds recorded, as for a deserialized filter,
+// extrapolated is always false.
+func (hf *HybridFilter) PredictRangeExtrapolated(keyHash uint32) (minBlock, maxBlock int, extrapolated bool) {
+	minBlock, maxBlock = hf.PredictRange(keyHash)
+	return minBlock, maxBlock, hf != nil && !hf.inHashBounds(keyHash)
+}
+
+// PredictRangeSigma returns a statistical alternative to PredictRange: the
+// blocks within sigmas standard deviations of the training residuals around
+// the model's prediction, corrected by their mean, clamped to the table. Where
+// PredictRange covers every training key, this range trades recall for
+// precision: with roughly normal residuals, 2 sigmas cover about 95% of keys
+// and 3 about 99.7%, and the read path falls back to a wider search on a miss.
+// If no block lies that close, the nearest one is returned. Without residual
+// statistics (see ResidualMoments), it returns PredictRange.
+func (hf *HybridFilter) PredictRangeSigma(keyHash uint32, sigmas float64) (minBlock, maxBlock int) {
+	if hf == nil || hf.KeyCount == 0 || hf.residualMoments == nil {
+		return hf.PredictRange(keyHash)
 	}
-	if maxBlock > maxPosInt {
-		maxBlock = maxPosInt
+	m := hf.residualMoments
+	center := hf.Slope*float64(keyHash) + hf.Intercept + m.mean
+	spread := math.Max(sigmas, 0) * m.stdDev
+	lo, hi := math.Ceil(center-spread), math.Floor(center+spread)
+	if lo > hi {
+		lo = math.Round(center)
+		hi = lo
 	}
+	maxPos := float64(hf.MaxPos)
+	return int(math.Min(math.Max(lo, 0), maxPos)), int(math.Min(math.Max(hi, 0), maxPos))
+}
 
-	return minBlock, maxBlock
+// ResidualMoments returns the mean and standard deviation of the residuals,
+// block minus unrounded prediction, of the training keys, which
+// PredictRangeSigma uses. ok is false when they are unknown: for a
+// deserialized filter, one trained with SkipLearned or without keys, or after
+// Update refits the model. Like the regression sums, they are not serialized.
+func (hf *HybridFilter) ResidualMoments() (mean, stdDev float64, ok bool) {
+	if hf == nil || hf.residualMoments == nil {
+		return 0, 0, false
+	}
+	return hf.residualMoments.mean, hf.residualMoments.stdDev, true
+}
+
+// PredictBlock returns only the single most likely block for a key, i.e. the
+// model prediction rounded per RoundMode and clamped at the center of PredictRange. Callers
+// that probe this block first and fall back to a full scan on a miss skip the
+// error bound arithmetic.
+func (hf *HybridFilter) PredictBlock(keyHash uint32) int {
+	if hf == nil || hf.KeyCount == 0 {
+		return 0
+	}
+	pos := hf.Slope*float64(keyHash) + hf.Intercept
+	if pos <= 0 {
+		return 0
+	}
+	if hf.RoundMode == RoundNearest {
+		// For positive values, adding 0.5 and truncating rounds like math.Round.
+		return min(int(pos+0.5), int(hf.MaxPos))
+	}
+	return min(hf.RoundMode.round(pos), int(hf.MaxPos))
+}
+
+// KeysPerBlockEstimate returns the number of keys per block the model implies,
+// 1/Slope, for checking that a model trained on key positions matches the
+// table's block layout. Like LearnedIndex.ApproxKeysPerBlock, it assumes
+// roughly uniform blocks. It returns 0 when the slope is not positive, as for
+// an untrained filter, one trained with SkipLearned, or a model that maps every
+// key to the same block: such a model says nothing about the layout.
+func (hf *HybridFilter) KeysPerBlockEstimate() float64 {
+	if hf == nil || !(hf.Slope > 0) {
+		return 0
+	}
+	return 1 / hf.Slope
+}
+
+// BloomProbe is one bloom bit checked by a query.
+type BloomProbe struct {
+	Bit uint32 // Index of the bit in BloomBits
+	Set bool   // Whether the bit is set
+}
+
+// QueryTrace records the steps of a lookup in a HybridFilter, for debugging
+// lookups that touch unexpected blocks; see HybridFilter.QueryTrace.
+type QueryTrace struct {
+	KeyHash      uint32
+	InHashBounds bool         // Whether KeyHash lies in [MinHash, MaxHash], if recorded
+	Probes       []BloomProbe // All BloomHashK bits, including those after the first unset one
+	MayContain   bool         // The result of MayContain
+
+	PredictedPos   float64 // Slope*KeyHash + Intercept, before rounding
+	PredictedBlock int     // PredictedPos rounded per RoundMode, before clamping
+	MinErr, MaxErr int32   // Error bounds added to PredictedBlock
+	MinBlock       int     // The range PredictRange returns
+	MaxBlock       int
+}
+
+// QueryTrace returns the intermediate values of MayContain and PredictRange
+// for keyHash. Unlike MayContain it checks every bloom bit rather than
+// stopping at the first unset one. With no trained model, the prediction is
+// block 0 and the range spans every block.
+func (hf *HybridFilter) QueryTrace(keyHash uint32) QueryTrace {
+	qt := QueryTrace{
+		KeyHash:      keyHash,
+		InHashBounds: hf.inHashBounds(keyHash),
+		MayContain:   hf.MayContain(keyHash),
+		MinErr:       hf.MinErr,
+		MaxErr:       hf.MaxErr,
+	}
+	if nBits := uint32(len(hf.BloomBits) * 8); nBits > 0 {
+		h := keyHash
+		delta := h>>17 | h<<15
+		for j := uint8(0); j < hf.BloomHashK; j++ {
+			bitPos := h % nBits
+			qt.Probes = append(qt.Probes, BloomProbe{Bit: bitPos, Set: hf.BloomBits[bitPos/8]&(1<<(bitPos%8)) != 0})
+			h += delta
+		}
+	}
+	if hf.KeyCount > 0 {
+		qt.PredictedPos = hf.Slope*float64(keyHash) + hf.Intercept
+		qt.PredictedBlock = hf.RoundMode.round(qt.PredictedPos)
+	}
+	qt.MinBlock, qt.MaxBlock = hf.PredictRange(keyHash)
+	return qt
+}
+
+// QueryBatch appends QueryDetailed of each of keyHashes to dst and returns
+// the extended slice, for probing one table with many keys. Passing the
+// previous result truncated to dst[:0] reuses its array, so a read path that
+// keeps one buffer per goroutine queries without allocating. The filter keeps
+// no reference to dst.
+func (hf *HybridFilter) QueryBatch(dst []QueryResult, keyHashes []uint32) []QueryResult {
+	dst = slices.Grow(dst, len(keyHashes))
+	for _, h := range keyHashes {
+		dst = append(dst, hf.QueryDetailed(h))
+	}
+	return dst
+}
+
+// AccuracyReport summarizes how well a filter's predicted ranges match the
+// true blocks of a set of keys; see EvaluateAccuracy.
+type AccuracyReport struct {
+	HitRate       float64 // Fraction of keys whose true block lies in the predicted range
+	AvgRangeWidth float64 // Mean number of blocks per predicted range
+	MaxRangeWidth int     // Widest predicted range
+}
+
+// EvaluateAccuracy checks PredictRange against the true block of each key,
+// e.g. on a held-out set the filter was not trained on. The bloom is not
+// consulted, so keys it would reject still count. An empty set yields a zero
+// report. Returns an error if keyHashes and trueBlocks differ in length.
+func (hf *HybridFilter) EvaluateAccuracy(keyHashes, trueBlocks []uint32) (AccuracyReport, error) {
+	var report AccuracyReport
+	if len(keyHashes) != len(trueBlocks) {
+		return report, fmt.Errorf("HybridFilter.EvaluateAccuracy: %d key hashes but %d true blocks",
+			len(keyHashes), len(trueBlocks))
+	}
+	if len(keyHashes) == 0 {
+		return report, nil
+	}
+	hits, totalWidth := 0, 0
+	for i, h := range keyHashes {
+		minBlock, maxBlock := hf.PredictRange(h)
+		if b := int(trueBlocks[i]); b >= minBlock && b <= maxBlock {
+			hits++
+		}
+		width := max(0, maxBlock-minBlock+1)
+		totalWidth += width
+		report.MaxRangeWidth = max(report.MaxRangeWidth, width)
+	}
+	report.HitRate = float64(hits) / float64(len(keyHashes))
+	report.AvgRangeWidth = float64(totalWidth) / float64(len(keyHashes))
+	return report, nil
+}
+
+// BlockScore is a candidate block for a key with its relative likelihood.
+type BlockScore struct {
+	Block int
+	Score float64 // In (0, 1]; higher means more likely to hold the key
+}
+
+// PredictWeighted returns the blocks of PredictRange in the order they should
+// be probed: by distance from the model's unrounded prediction, nearest
+// first, ties going to the lower block. Each block is scored 1/(1+d), where d
+// is that distance in blocks, so a block the prediction falls right on scores
+// 1 and scores decrease along the slice. The read path can probe in this
+// order and stop at the first hit.
+//
+// Without a model every block is equally likely; they are returned in
+// ascending order with a score of 1.
+func (hf *HybridFilter) PredictWeighted(keyHash uint32) []BlockScore {
+	return hf.AppendPredictWeighted(nil, keyHash)
+}
+
+// AppendPredictWeighted appends the blocks PredictWeighted returns to dst and
+// returns the extended slice. A read path that passes the previous result
+// truncated to dst[:0] reuses its array and allocates only when a range is
+// wider than any before it. The filter keeps no reference to dst.
+func (hf *HybridFilter) AppendPredictWeighted(dst []BlockScore, keyHash uint32) []BlockScore {
+	minBlock, maxBlock := hf.PredictRange(keyHash)
+	dst = slices.Grow(dst, max(0, maxBlock-minBlock+1))
+	if hf == nil || hf.KeyCount == 0 {
+		for b := minBlock; b <= maxBlock; b++ {
+			dst = append(dst, BlockScore{Block: b, Score: 1})
+		}
+		return dst
+	}
+
+	start := len(dst)
+	center := hf.Slope*float64(keyHash) + hf.Intercept
+	center = math.Min(math.Max(center, 0), float64(hf.MaxPos))
+	for b := minBlock; b <= maxBlock; b++ {
+		dst = append(dst, BlockScore{Block: b, Score: 1 / (1 + math.Abs(float64(b)-center))})
+	}
+	// Blocks were appended in ascending order, so a stable sort keeps the
+	// lower block first among equal scores.
+	slices.SortStableFunc(dst[start:], func(a, b BlockScore) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	return dst
 }
 
 // Query performs a complete hybrid lookup:
 // 1. Check Bloom filter - if negative, key definitely not present
 // 2. If positive, use learned index to get search range
 // Returns: (maybePresent, minBlock, maxBlock)
+//
+// A range wider than MaxRangeBlocks is replaced by the whole table,
+// [0, MaxPos]; QueryDetailed reports this as Fallback.
 func (hf *HybridFilter) Query(keyHash uint32) (maybePresent bool, minBlock, maxBlock int) {
 	// Step 1: Bloom filter check
 	if !hf.MayContain(keyHash) {
@@ -203,19 +1216,244 @@ func (hf *HybridFilter) Query(keyHash uint32) (maybePresent bool, minBlock, maxB
 	}
 
 	// Step 2: Learned index prediction
-	minBlock, maxBlock = hf.PredictRange(keyHash)
+	minBlock, maxBlock, _ = hf.capRange(hf.PredictRange(keyHash))
+	return true, minBlock, maxBlock
+}
+
+// capRange returns [minBlock, maxBlock], or the whole table and true if the
+// range is wider than MaxRangeBlocks.
+func (hf *HybridFilter) capRange(minBlock, maxBlock int) (int, int, bool) {
+	if hf.MaxRangeBlocks > 0 && maxBlock-minBlock+1 > int(hf.MaxRangeBlocks) {
+		return 0, int(hf.MaxPos), true
+	}
+	return minBlock, maxBlock, false
+}
+
+// QueryResult is the outcome of QueryDetailed.
+type QueryResult struct {
+	MaybePresent   bool
+	SkippedByBloom bool // The bloom ruled the key out; the table can be skipped
+	MinBlock       int
+	MaxBlock       int
+	RangeWidth     int // Number of blocks to search, 0 if skipped
+	// Confidence is the chance that the first block searched holds the key,
+	// assuming it is equally likely to be anywhere in the range: 1/RangeWidth.
+	// It is 1 for a bloom miss and 1/numBlocks when the model has nothing
+	// better than a full table scan to offer.
+	Confidence float64
+	// Fallback is set when the predicted range was wider than MaxRangeBlocks
+	// and was replaced by the whole table: the model should be ignored and
+	// the table scanned as with a plain bloom filter.
+	Fallback bool
+}
+
+// QueryDetailed is like Query but also reports why the table was or was not
+// skipped and how narrow the prediction is, so the read path can tell a
+// confident prediction from a full-table fallback.
+func (hf *HybridFilter) QueryDetailed(keyHash uint32) QueryResult {
+	if !hf.MayContain(keyHash) {
+		return QueryResult{SkippedByBloom: true, Confidence: 1}
+	}
+	minBlock, maxBlock, fallback := hf.capRange(hf.PredictRange(keyHash))
+	width := max(maxBlock-minBlock+1, 0)
+	r := QueryResult{
+		MaybePresent: true,
+		MinBlock:     minBlock,
+		MaxBlock:     maxBlock,
+		RangeWidth:   width,
+		Fallback:     fallback,
+	}
+	if width > 0 {
+		r.Confidence = 1 / float64(width)
+	}
+	return r
+}
+
+// SetTimestampRange records the range of insertion timestamps of the table's
+// keys, enabling ExpiredBefore.
+func (hf *HybridFilter) SetTimestampRange(minTs, maxTs int64) {
+	hf.MinTimestamp = minTs
+	hf.MaxTimestamp = maxTs
+}
+
+// ExpiredBefore returns true if every key in the table was inserted before
+// cutoff, so a query that requires fresher data can skip the table entirely.
+// Returns false if no timestamps were recorded.
+func (hf *HybridFilter) ExpiredBefore(cutoff int64) bool {
+	if hf == nil || hf.MaxTimestamp == 0 {
+		return false
+	}
+	return hf.MaxTimestamp < cutoff
+}
+
+// Lookup performs a complete lookup of a raw key against a table:
+//  1. The key is hashed with the default hash function (Hash unless changed
+//     with SetDefaultHash) and checked against the bloom component. If
+//     the bloom says no, the key is definitely not present.
+//  2. The key is mapped to its position with bi.Position. If it sorts before
+//     the first block, it is not present either.
+//  3. The position is fed to PredictRange to get the blocks to search.
+//
+// Note the two different inputs: the bloom works on hashes, while the learned
+// component only predicts well on positions, so the filter should have been
+// built with TrainHybridFilterWithPositions using the same BlockIndex.
+func (hf *HybridFilter) Lookup(key []byte, bi *BlockIndex) (maybePresent bool, minBlock, maxBlock int) {
+	if !hf.MayContain(defaultHash(key)) {
+		return false, 0, 0
+	}
+	pos, found := bi.Position(key)
+	if !found {
+		return false, 0, 0
+	}
+	minBlock, maxBlock, _ = hf.capRange(hf.PredictRange(pos))
 	return true, minBlock, maxBlock
 }
 
+// CostOnHit returns the expected number of blocks searched for a key that is
+// present, i.e. the predicted range width averaged over the key domain. Keys
+// are assumed to be spread evenly across blocks, so near the ends of the table
+// the range is narrowed by clamping.
+func (hf *HybridFilter) CostOnHit() float64 {
+	numBlocks := int(hf.MaxPos) + 1
+	if hf.KeyCount == 0 {
+		return float64(numBlocks)
+	}
+	var total int
+	for p := 0; p < numBlocks; p++ {
+		minBlock := min(max(p+int(hf.MinErr), 0), numBlocks-1)
+		maxBlock := min(max(p+int(hf.MaxErr), 0), numBlocks-1)
+		total += maxBlock - minBlock + 1
+	}
+	return float64(total) / float64(numBlocks)
+}
+
+// CostOnMiss returns the expected number of blocks searched for a key that is
+// absent. The bloom rejects most such keys at no cost; the rest are false
+// positives and pay the full range width, so this is CostOnHit times the
+// estimated false positive rate.
+func (hf *HybridFilter) CostOnMiss() float64 {
+	return hf.estimatedFPRate() * hf.CostOnHit()
+}
+
+// EstimateBlockReads returns the number of blocks read by numTables table
+// probes, of which a fraction hitRate hold the key. A filter rejects each of
+// the others unless it is a false positive, at rate fpRate; every probe that
+// passes reads avgRange blocks, clamped to [0, numBlocks]. Counts are
+// truncated at each step, as a simulation of whole lookups would give them.
+//
+// The same formula covers each kind of filter: a bloom alone has avgRange =
+// numBlocks, a learned index alone cannot skip tables and has fpRate = 1, and
+// a hybrid filter has both a low fpRate and a narrow avgRange, e.g. from
+// CostOnHit.
+func EstimateBlockReads(numTables int, hitRate float64, fpRate float64, numBlocks int, avgRange float64) int {
+	hits := int(float64(numTables) * hitRate)
+	misses := numTables - hits
+	skipped := int(float64(misses) * (1 - fpRate))
+	blocksPerSearch := min(max(int(avgRange), 0), numBlocks)
+	return (numTables - skipped) * blocksPerSearch
+}
+
+// estimatedFPRate estimates the bloom false positive rate from the fraction of
+// set bits: a random key passes when all of its k probed bits are set.
+func (hf *HybridFilter) estimatedFPRate() float64 {
+	if len(hf.BloomBits) == 0 {
+		return 1
+	}
+	return math.Pow(bitDensity(hf.BloomBits), float64(hf.BloomHashK))
+}
+
+// SerializedSize returns the number of bytes Serialize produces for this filter.
+// It always equals HybridFilterSize for the config the filter was trained
+// with, or one with the same bloom size and use of MaxPosOverride.
+func (hf *HybridFilter) SerializedSize() int {
+	return hybridFilterHeaderSize + len(hf.BloomBits) + hybridTrailerSize(hf.numBlocks != 0)
+}
+
+// NumBlocks returns the number of blocks of the table the filter was trained
+// for. It is MaxPos+1 unless the filter was trained with MaxPosOverride, whose
+// predictions are block indices in a global space of MaxPos+1 blocks; then it
+// is the numBlocks passed to training, the table's own count. Unlike MaxPos
+// alone, it survives serialization in every format, so tooling can tell the
+// two apart without outside metadata.
+func (hf *HybridFilter) NumBlocks() int {
+	if hf.numBlocks != 0 {
+		return int(hf.numBlocks)
+	}
+	return int(hf.MaxPos) + 1
+}
+
+// Size returns the serialized size in bytes, as SerializedSize does.
+func (hf *HybridFilter) Size() int {
+	return hf.SerializedSize()
+}
+
 // Serialize converts the HybridFilter to bytes
 func (hf *HybridFilter) Serialize() []byte {
-	size := len(hf.BloomBits) + 1 + 8 + 8 + 4 + 4 + 4 + 4
-	buf := make([]byte, size)
+	buf := make([]byte, hf.SerializedSize())
+	offset := hf.encodeHeader(buf, false)
+	// Bloom filter
+	offset += copy(buf[offset:], hf.BloomBits)
+	hf.encodeTrailer(buf[offset:])
+	return buf
+}
+
+// hybridFilterTrailerSize is the size of everything Serialize writes after the
+// bloom bits, short of the block count of versions 4 and 5.
+const hybridFilterTrailerSize = 1 + 8 + 8 + 4 + 4 + 4 + 4 + 8 + 8
+
+// hybridTrailerSize returns the size of the fixed-width trailer, with or
+// without a block count.
+func hybridTrailerSize(withNumBlocks bool) int {
+	if withNumBlocks {
+		return hybridFilterTrailerSize + hybridNumBlocksSize
+	}
+	return hybridFilterTrailerSize
+}
 
+// hybridFormat is the layout of a serialized filter, as decodeHybridHeader
+// reads it from the header.
+type hybridFormat struct {
+	version       byte
+	headerSize    int  // Magic, version and, but for version 1, flags
+	compact       bool // Varint trailer, as written by SerializeCompact
+	withNumBlocks bool // Trailer ends with the table's block count
+}
+
+// trailerSize returns the size of the fixed-width trailer in format f.
+func (f hybridFormat) trailerSize() int {
+	return hybridTrailerSize(f.withNumBlocks)
+}
+
+// encodeHeader writes the magic, the format version and the flags to buf and
+// returns the number of bytes written. The version is that of the compact or
+// the fixed-width format, with a block count if the filter has one.
+func (hf *HybridFilter) encodeHeader(buf []byte, compact bool) int {
+	offset := copy(buf, hybridFilterMagic)
+	switch {
+	case compact && hf.numBlocks != 0:
+		buf[offset] = hybridFilterVersionCompactNumBlocks
+	case compact:
+		buf[offset] = hybridFilterVersionCompact
+	case hf.numBlocks != 0:
+		buf[offset] = hybridFilterVersionNumBlocks
+	default:
+		buf[offset] = hybridFilterVersion
+	}
+	offset++
+	buf[offset] = 0
+	if hf.ProbabilisticBounds {
+		buf[offset] |= hybridFlagProbabilisticBounds
+	}
+	buf[offset] |= byte(hf.RoundMode) << hybridFlagRoundModeShift & hybridFlagRoundModeMask
+	offset++
+	return offset
+}
+
+// encodeTrailer writes the bloom hash count, the learned index, the
+// timestamps and any block count to buf and returns the number of bytes
+// written.
+func (hf *HybridFilter) encodeTrailer(buf []byte) int {
 	offset := 0
-	// Bloom filter
-	copy(buf[offset:], hf.BloomBits)
-	offset += len(hf.BloomBits)
 	buf[offset] = hf.BloomHashK
 	offset++
 
@@ -224,29 +1462,169 @@ func (hf *HybridFilter) Serialize() []byte {
 	offset += 8
 	binary.LittleEndian.PutUint64(buf[offset:], math.Float64bits(hf.Intercept))
 	offset += 8
-	binary.LittleEndian.PutUint32(buf[offset:], uint32(hf.MinErr))
+	putInt32(buf[offset:], hf.MinErr)
 	offset += 4
-	binary.LittleEndian.PutUint32(buf[offset:], uint32(hf.MaxErr))
+	putInt32(buf[offset:], hf.MaxErr)
 	offset += 4
 	binary.LittleEndian.PutUint32(buf[offset:], hf.MaxPos)
 	offset += 4
 	binary.LittleEndian.PutUint32(buf[offset:], hf.KeyCount)
+	offset += 4
 
+	// Timestamps
+	binary.LittleEndian.PutUint64(buf[offset:], uint64(hf.MinTimestamp))
+	offset += 8
+	binary.LittleEndian.PutUint64(buf[offset:], uint64(hf.MaxTimestamp))
+	offset += 8
+	if hf.numBlocks != 0 {
+		binary.LittleEndian.PutUint32(buf[offset:], hf.numBlocks)
+		offset += hybridNumBlocksSize
+	}
+	return offset
+}
+
+// SerializeCompact is like Serialize, but writes BloomHashK, KeyCount and
+// MaxPos as uvarints and MinErr and MaxErr as zigzag varints. These are small
+// for typical tables: on a 10000-key, 100-block filter the trailer shrinks
+// from 49 to 38 bytes, so a filter with the default 64-byte bloom takes 106
+// bytes instead of 117 (9.4% less). The version byte marks the payload as compact,
+// so DeserializeHybridFilter reads either format.
+func (hf *HybridFilter) SerializeCompact() []byte {
+	buf := make([]byte, hybridFilterHeaderSize, hybridFilterHeaderSize+len(hf.BloomBits)+hybridFilterTrailerSize)
+	hf.encodeHeader(buf, true)
+	buf = append(buf, hf.BloomBits...)
+
+	buf = binary.AppendUvarint(buf, uint64(hf.BloomHashK))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(hf.Slope))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(hf.Intercept))
+	buf = binary.AppendVarint(buf, int64(hf.MinErr))
+	buf = binary.AppendVarint(buf, int64(hf.MaxErr))
+	buf = binary.AppendUvarint(buf, uint64(hf.MaxPos))
+	buf = binary.AppendUvarint(buf, uint64(hf.KeyCount))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(hf.MinTimestamp))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(hf.MaxTimestamp))
+	if hf.numBlocks != 0 {
+		buf = binary.AppendUvarint(buf, uint64(hf.numBlocks))
+	}
 	return buf
 }
 
-// DeserializeHybridFilter reads a HybridFilter from bytes
-func DeserializeHybridFilter(data []byte, bloomSize int) *HybridFilter {
-	if len(data) < bloomSize+33 {
-		return nil
+// DeserializeHybridFilter reads a HybridFilter with a bloom component of
+// bloomSize bytes, written by either Serialize or SerializeCompact, including
+// the version 1 payloads Serialize wrote before the flags byte. The returned
+// error wraps ErrShortBuffer, ErrBadMagic or ErrUnsupportedVersion.
+func DeserializeHybridFilter(data []byte, bloomSize int) (*HybridFilter, error) {
+	hf, _, err := decodeHybridFilter(data, bloomSize)
+	return hf, err
+}
+
+// decodeHybridFilter is DeserializeHybridFilter, also returning the number of
+// bytes the filter takes up at the start of data.
+func decodeHybridFilter(data []byte, bloomSize int) (*HybridFilter, int, error) {
+	hf, format, err := decodeHybridHeader(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	bloomEnd := format.headerSize + bloomSize
+	if format.compact {
+		if len(data) < bloomEnd {
+			return nil, 0, fmt.Errorf("compact hybrid filter with %d-byte bloom: got %d bytes: %w",
+				bloomSize, len(data), ErrShortBuffer)
+		}
+		hf.BloomBits = slices.Clone(data[format.headerSize:bloomEnd])
+		n, err := hf.decodeCompactTrailer(data[bloomEnd:], format.withNumBlocks)
+		if err != nil {
+			return nil, 0, err
+		}
+		return hf, bloomEnd + n, nil
+	}
+	size := bloomEnd + format.trailerSize()
+	if len(data) < size {
+		return nil, 0, fmt.Errorf("hybrid filter with %d-byte bloom: got %d bytes, want %d: %w",
+			bloomSize, len(data), size, ErrShortBuffer)
 	}
+	hf.BloomBits = slices.Clone(data[format.headerSize:bloomEnd])
+	hf.decodeTrailer(data[bloomEnd:], format.withNumBlocks)
+	return hf, size, nil
+}
 
-	hf := &HybridFilter{}
-	offset := 0
+// HybridFilterView parses a filter written by Serialize without copying its
+// bloom bits: the returned filter's BloomBits is a subslice of data, e.g. of
+// an mmap'd SSTable. data must hold exactly one filter, since the bloom size
+// is taken to be whatever lies between the header and the fixed-width
+// trailer. Compact payloads cannot be viewed and yield ErrUnsupportedVersion.
+//
+// The caller must keep data alive and unmodified for as long as the filter is
+// in use; in particular the region must not be unmapped. Writes to data are
+// visible through the filter.
+func HybridFilterView(data []byte) (*HybridFilter, error) {
+	hf, format, err := decodeHybridHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if format.compact {
+		return nil, fmt.Errorf("hybrid filter view of version %d: %w", format.version, ErrUnsupportedVersion)
+	}
+	bloomEnd := len(data) - format.trailerSize()
+	if bloomEnd < format.headerSize {
+		return nil, fmt.Errorf("hybrid filter view: got %d bytes, want at least %d: %w",
+			len(data), format.headerSize+format.trailerSize(), ErrShortBuffer)
+	}
+	// Cap the slice so that appending to BloomBits cannot overwrite the trailer.
+	hf.BloomBits = data[format.headerSize:bloomEnd:bloomEnd]
+	hf.decodeTrailer(data[bloomEnd:], format.withNumBlocks)
+	return hf, nil
+}
 
-	hf.BloomBits = make([]byte, bloomSize)
-	copy(hf.BloomBits, data[offset:offset+bloomSize])
-	offset += bloomSize
+// decodeHybridHeader validates the header at the start of data and returns a
+// filter with the flags it carries applied, along with the format the header
+// selects.
+func decodeHybridHeader(data []byte) (*HybridFilter, hybridFormat, error) {
+	if len(data) < hybridFilterLegacyHeaderSize {
+		return nil, hybridFormat{}, fmt.Errorf("hybrid filter header: got %d bytes, want %d: %w",
+			len(data), hybridFilterLegacyHeaderSize, ErrShortBuffer)
+	}
+	if string(data[:len(hybridFilterMagic)]) != hybridFilterMagic {
+		return nil, hybridFormat{}, fmt.Errorf("hybrid filter magic %q: %w",
+			data[:len(hybridFilterMagic)], ErrBadMagic)
+	}
+	format := hybridFormat{version: data[len(hybridFilterMagic)], headerSize: hybridFilterHeaderSize}
+	switch format.version {
+	case hybridFilterVersionLegacy:
+		format.headerSize = hybridFilterLegacyHeaderSize
+		return &HybridFilter{}, format, nil
+	case hybridFilterVersion:
+	case hybridFilterVersionNumBlocks:
+		format.withNumBlocks = true
+	case hybridFilterVersionCompact:
+		format.compact = true
+	case hybridFilterVersionCompactNumBlocks:
+		format.compact, format.withNumBlocks = true, true
+	default:
+		return nil, hybridFormat{}, fmt.Errorf("hybrid filter version %d: %w", format.version, ErrUnsupportedVersion)
+	}
+	if len(data) < hybridFilterHeaderSize {
+		return nil, hybridFormat{}, fmt.Errorf("hybrid filter header: got %d bytes, want %d: %w",
+			len(data), hybridFilterHeaderSize, ErrShortBuffer)
+	}
+	flags := data[len(hybridFilterMagic)+1]
+	if flags&^hybridFlagsKnown != 0 {
+		return nil, hybridFormat{}, fmt.Errorf("hybrid filter flags %#x: %w", flags, ErrUnsupportedVersion)
+	}
+	mode := RoundMode(flags & hybridFlagRoundModeMask >> hybridFlagRoundModeShift)
+	if mode > RoundCeil {
+		return nil, hybridFormat{}, fmt.Errorf("hybrid filter round mode %d: %w", mode, ErrUnsupportedVersion)
+	}
+	return &HybridFilter{
+		ProbabilisticBounds: flags&hybridFlagProbabilisticBounds != 0,
+		RoundMode:           mode,
+	}, format, nil
+}
+
+// decodeTrailer reads the fields written by encodeTrailer, including a block
+// count if withNumBlocks is set.
+func (hf *HybridFilter) decodeTrailer(data []byte, withNumBlocks bool) {
+	offset := 0
 	hf.BloomHashK = data[offset]
 	offset++
 
@@ -254,28 +1632,333 @@ func DeserializeHybridFilter(data []byte, bloomSize int) *HybridFilter {
 	offset += 8
 	hf.Intercept = math.Float64frombits(binary.LittleEndian.Uint64(data[offset:]))
 	offset += 8
-	hf.MinErr = int32(binary.LittleEndian.Uint32(data[offset:]))
+	hf.MinErr = getInt32(data[offset:])
 	offset += 4
-	hf.MaxErr = int32(binary.LittleEndian.Uint32(data[offset:]))
+	hf.MaxErr = getInt32(data[offset:])
 	offset += 4
 	hf.MaxPos = binary.LittleEndian.Uint32(data[offset:])
 	offset += 4
 	hf.KeyCount = binary.LittleEndian.Uint32(data[offset:])
+	offset += 4
 
-	return hf
+	hf.MinTimestamp = int64(binary.LittleEndian.Uint64(data[offset:]))
+	offset += 8
+	hf.MaxTimestamp = int64(binary.LittleEndian.Uint64(data[offset:]))
+	offset += 8
+	if withNumBlocks {
+		hf.numBlocks = binary.LittleEndian.Uint32(data[offset:])
+	}
+}
+
+// decodeCompactTrailer reads the fields written after the bloom bits by
+// SerializeCompact, including a block count if withNumBlocks is set, and
+// returns the number of bytes they took up.
+func (hf *HybridFilter) decodeCompactTrailer(data []byte, withNumBlocks bool) (int, error) {
+	size := len(data)
+	uvarint := func(field string, limit uint64) (uint64, error) {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return 0, fmt.Errorf("compact hybrid filter %s: %w", field, ErrShortBuffer)
+		}
+		if v > limit {
+			return 0, fmt.Errorf("compact hybrid filter %s %d out of range", field, v)
+		}
+		data = data[n:]
+		return v, nil
+	}
+	varint32 := func(field string) (int32, error) {
+		v, n := binary.Varint(data)
+		if n <= 0 {
+			return 0, fmt.Errorf("compact hybrid filter %s: %w", field, ErrShortBuffer)
+		}
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return 0, fmt.Errorf("compact hybrid filter %s %d out of range", field, v)
+		}
+		data = data[n:]
+		return int32(v), nil
+	}
+	fixed64 := func(field string) (uint64, error) {
+		if len(data) < 8 {
+			return 0, fmt.Errorf("compact hybrid filter %s: %w", field, ErrShortBuffer)
+		}
+		v := binary.LittleEndian.Uint64(data)
+		data = data[8:]
+		return v, nil
+	}
+
+	k, err := uvarint("bloom hash count", math.MaxUint8)
+	if err != nil {
+		return 0, err
+	}
+	hf.BloomHashK = uint8(k)
+	slope, err := fixed64("slope")
+	if err != nil {
+		return 0, err
+	}
+	hf.Slope = math.Float64frombits(slope)
+	intercept, err := fixed64("intercept")
+	if err != nil {
+		return 0, err
+	}
+	hf.Intercept = math.Float64frombits(intercept)
+	if hf.MinErr, err = varint32("min error"); err != nil {
+		return 0, err
+	}
+	if hf.MaxErr, err = varint32("max error"); err != nil {
+		return 0, err
+	}
+	maxPos, err := uvarint("max position", math.MaxUint32)
+	if err != nil {
+		return 0, err
+	}
+	hf.MaxPos = uint32(maxPos)
+	keyCount, err := uvarint("key count", math.MaxUint32)
+	if err != nil {
+		return 0, err
+	}
+	hf.KeyCount = uint32(keyCount)
+	minTs, err := fixed64("min timestamp")
+	if err != nil {
+		return 0, err
+	}
+	hf.MinTimestamp = int64(minTs)
+	maxTs, err := fixed64("max timestamp")
+	if err != nil {
+		return 0, err
+	}
+	hf.MaxTimestamp = int64(maxTs)
+	if withNumBlocks {
+		numBlocks, err := uvarint("block count", math.MaxUint32)
+		if err != nil {
+			return 0, err
+		}
+		hf.numBlocks = uint32(numBlocks)
+	}
+	return size - len(data), nil
+}
+
+// WriteTo implements io.WriterTo. It streams the filter to w without building
+// the serialized form in memory: a 4-byte bloom size, followed by exactly the
+// bytes Serialize would produce. The size prefix lets ReadHybridFilterFrom
+// read the filter back without knowing its bloom size in advance.
+func (hf *HybridFilter) WriteTo(w io.Writer) (int64, error) {
+	var head [4 + hybridFilterHeaderSize]byte
+	binary.LittleEndian.PutUint32(head[:4], uint32(len(hf.BloomBits)))
+	hf.encodeHeader(head[4:], false)
+	var tail [hybridFilterTrailerSize + hybridNumBlocksSize]byte
+	tailSize := hf.encodeTrailer(tail[:])
+
+	var total int64
+	for _, part := range [][]byte{head[:], hf.BloomBits, tail[:tailSize]} {
+		n, err := w.Write(part)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// ReadFrom implements io.ReaderFrom, replacing hf with a filter read from r in
+// the format written by WriteTo. It reads exactly one filter, so several
+// filters can be stored back to back in one stream. The bloom size comes from
+// the stream, so the bloom is read into a buffer that grows as its bytes
+// arrive rather than allocated up front, and a size beyond what a filter can
+// probe is rejected with ErrUnsupportedVersion.
+func (hf *HybridFilter) ReadFrom(r io.Reader) (int64, error) {
+	var head [4 + hybridFilterHeaderSize]byte
+	total, err := io.ReadFull(r, head[:])
+	if err != nil {
+		return int64(total), hybridStreamError("header", err)
+	}
+	decoded, format, err := decodeHybridHeader(head[4:])
+	if err != nil {
+		return int64(total), err
+	}
+	if format.compact || format.version == hybridFilterVersionLegacy {
+		return int64(total), fmt.Errorf("hybrid filter stream version %d: %w", format.version, ErrUnsupportedVersion)
+	}
+	bloomSize := binary.LittleEndian.Uint32(head[:4])
+	if bloomSize > hybridMaxBloomBytes {
+		return int64(total), fmt.Errorf("hybrid filter stream bloom of %d bytes, at most %d: %w",
+			bloomSize, hybridMaxBloomBytes, ErrUnsupportedVersion)
+	}
+	var bloom bytes.Buffer
+	copied, err := io.CopyN(&bloom, r, int64(bloomSize))
+	total += int(copied)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return int64(total), hybridStreamError("bloom", err)
+	}
+	decoded.BloomBits = bloom.Bytes()
+	var tail [hybridFilterTrailerSize + hybridNumBlocksSize]byte
+	n, err := io.ReadFull(r, tail[:format.trailerSize()])
+	total += n
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return int64(total), hybridStreamError("trailer", err)
+	}
+	decoded.decodeTrailer(tail[:], format.withNumBlocks)
+	*hf = *decoded
+	return int64(total), nil
+}
+
+// hybridStreamError wraps an error reading part of a filter from a stream. A
+// stream cut short partway through a filter also wraps ErrShortBuffer.
+func hybridStreamError(part string, err error) error {
+	if err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("hybrid filter stream %s: %w: %w", part, ErrShortBuffer, err)
+	}
+	return fmt.Errorf("hybrid filter stream %s: %w", part, err)
+}
+
+// ReadHybridFilterFrom reads a filter written by WriteTo from r. A stream that
+// ends early yields an error wrapping ErrShortBuffer and io.ErrUnexpectedEOF
+// (or only io.EOF if it is empty).
+func ReadHybridFilterFrom(r io.Reader) (*HybridFilter, error) {
+	hf := &HybridFilter{}
+	if _, err := hf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return hf, nil
+}
+
+// hybridFilterJSON is the JSON form of a HybridFilter, for inspection tools.
+type hybridFilterJSON struct {
+	Slope               float64 `json:"slope"`
+	Intercept           float64 `json:"intercept"`
+	MinErr              int32   `json:"min_err"`
+	MaxErr              int32   `json:"max_err"`
+	MaxPos              uint32  `json:"max_pos"`
+	KeyCount            uint32  `json:"key_count"`
+	BloomHashK          uint8   `json:"bloom_hash_k"`
+	BloomBits           []byte  `json:"bloom_bits"` // base64 encoded
+	MinTimestamp        int64   `json:"min_timestamp,omitempty"`
+	MaxTimestamp        int64   `json:"max_timestamp,omitempty"`
+	ProbabilisticBounds bool    `json:"probabilistic_bounds,omitempty"`
+	NumBlocks           uint32  `json:"num_blocks,omitempty"` // Only with MaxPosOverride
+	RoundMode           uint8   `json:"round_mode,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler, giving a human-readable dump of the
+// filter with the bloom bits base64 encoded.
+func (hf *HybridFilter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hybridFilterJSON{
+		Slope:               hf.Slope,
+		Intercept:           hf.Intercept,
+		MinErr:              hf.MinErr,
+		MaxErr:              hf.MaxErr,
+		MaxPos:              hf.MaxPos,
+		KeyCount:            hf.KeyCount,
+		BloomHashK:          hf.BloomHashK,
+		BloomBits:           hf.BloomBits,
+		MinTimestamp:        hf.MinTimestamp,
+		MaxTimestamp:        hf.MaxTimestamp,
+		ProbabilisticBounds: hf.ProbabilisticBounds,
+		NumBlocks:           hf.numBlocks,
+		RoundMode:           uint8(hf.RoundMode),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (hf *HybridFilter) UnmarshalJSON(data []byte) error {
+	var j hybridFilterJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	if mode := RoundMode(j.RoundMode); mode > RoundCeil {
+		return fmt.Errorf("hybrid filter round mode %d: %w", mode, ErrUnsupportedVersion)
+	}
+	*hf = HybridFilter{
+		BloomBits:           j.BloomBits,
+		BloomHashK:          j.BloomHashK,
+		Slope:               j.Slope,
+		Intercept:           j.Intercept,
+		MinErr:              j.MinErr,
+		MaxErr:              j.MaxErr,
+		MaxPos:              j.MaxPos,
+		KeyCount:            j.KeyCount,
+		MinTimestamp:        j.MinTimestamp,
+		MaxTimestamp:        j.MaxTimestamp,
+		ProbabilisticBounds: j.ProbabilisticBounds,
+		RoundMode:           RoundMode(j.RoundMode),
+		numBlocks:           j.NumBlocks,
+	}
+	return nil
+}
+
+// GobEncode implements gob.GobEncoder. The payload is the WriteTo stream,
+// which carries the bloom size, magic and format version, so it can be
+// decoded without any outside knowledge of the filter's layout.
+func (hf *HybridFilter) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(4 + hf.SerializedSize())
+	if _, err := hf.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode implements gob.GobDecoder, reading a payload written by
+// GobEncode. Trailing bytes after the filter are an error.
+func (hf *HybridFilter) GobDecode(data []byte) error {
+	r := bytes.NewReader(data)
+	if _, err := hf.ReadFrom(r); err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("hybrid filter gob: %d trailing bytes", r.Len())
+	}
+	return nil
 }
 
 // Stats returns statistics about the hybrid filter
 func (hf *HybridFilter) Stats() HybridFilterStats {
 	return HybridFilterStats{
-		TotalSizeBytes:   len(hf.BloomBits) + 33,
+		TotalSizeBytes:   hf.SerializedSize(),
 		BloomSizeBytes:   len(hf.BloomBits),
-		LearnedSizeBytes: 33,
+		LearnedSizeBytes: hybridLearnedSizeBytes,
 		BloomBits:        len(hf.BloomBits) * 8,
 		BloomHashFuncs:   int(hf.BloomHashK),
 		ErrorRange:       int(hf.MaxErr - hf.MinErr),
 		KeyCount:         int(hf.KeyCount),
+		BloomBitDensity:  bitDensity(hf.BloomBits),
+		OverProvisioned: hf.KeyCount > 0 && len(hf.BloomBits) > 0 &&
+			bitDensity(hf.BloomBits) < hybridOverProvisionedDensity,
+		DuplicateKeys:    int(hf.duplicateKeys),
+		ModelInformative: hf.modelInformative(),
+		Descending:       hf.KeyCount > 0 && hf.Slope < 0,
+	}
+}
+
+// String returns a one-line summary of the filter for logs and debuggers, e.g.
+// HybridFilter{keys=10000 bloom=64B k=4 slope=0.0001 range=[-2,2] informative=true},
+// where range is [MinErr, MaxErr] and informative is Stats().ModelInformative.
+func (hf *HybridFilter) String() string {
+	if hf == nil {
+		return "HybridFilter(nil)"
+	}
+	return fmt.Sprintf("HybridFilter{keys=%d bloom=%dB k=%d slope=%g range=[%d,%d] informative=%t}",
+		hf.KeyCount, len(hf.BloomBits), hf.BloomHashK, hf.Slope, hf.MinErr, hf.MaxErr, hf.modelInformative())
+}
+
+// modelInformative reports whether the learned component narrows any lookup.
+// It does not when the prediction moves by less than a block over the whole
+// hash domain, i.e. the slope is about 0, and the error bounds then span the
+// whole table: PredictRange is a full scan for every key, as after training
+// on hashed keys or with SkipLearned. A flat model with narrow bounds, such as
+// one trained on keys that all lie in one block of a larger table, still
+// pins down the block and is informative.
+func (hf *HybridFilter) modelInformative() bool {
+	if hf.KeyCount == 0 {
+		return false
 	}
+	flat := math.Abs(hf.Slope)*math.MaxUint32 < 1
+	return !flat || int64(hf.MaxErr)-int64(hf.MinErr) < int64(hf.MaxPos)
 }
 
 // HybridFilterStats contains statistics about the hybrid filter
@@ -287,4 +1970,9 @@ type HybridFilterStats struct {
 	BloomHashFuncs   int
 	ErrorRange       int
 	KeyCount         int
+	BloomBitDensity  float64 // Fraction of bloom bits set; near 1.0 means saturated
+	OverProvisioned  bool    // Bloom density is so low that a much smaller bloom would do
+	DuplicateKeys    int     // Training keys sharing a position with a key in another block
+	ModelInformative bool    // The learned component narrows the search; false if every PredictRange is the whole table
+	Descending       bool    // Later positions map to earlier blocks, as for a table stored in descending key order
 }
@@ -0,0 +1,111 @@
+/*
+ * HybridFilter64 - a hybrid filter over 64-bit block indices
+ *
+ * HybridFilter stores block indices, MaxPos and the error bounds in 32 bits,
+ * which covers any realistic table but forces a cast on callers that address
+ * blocks by int64 offsets. HybridFilter64 is trained the same way, by the same
+ * code, from []uint64 block indices and keeps every block quantity in 64 bits.
+ * On indices that fit in 32 bits, both filters hold the same bloom and model
+ * and predict the same ranges.
+ */
+
+package y
+
+import (
+	"context"
+	"slices"
+)
+
+// HybridFilter64 is a HybridFilter whose block indices, MaxPos and error
+// bounds are 64 bits wide. It is built by TrainHybridFilter64 and queried with
+// MayContain and PredictRange64. Like HybridFilter, a trained filter is safe
+// for concurrent queries.
+type HybridFilter64 struct {
+	BloomBits  []byte
+	BloomHashK uint8
+
+	Slope     float64
+	Intercept float64
+	MinErr    int64
+	MaxErr    int64
+	MaxPos    uint64
+	KeyCount  uint64
+
+	// MinHash, MaxHash, ProbabilisticBounds and RoundMode are as for
+	// HybridFilter.
+	MinHash             uint32
+	MaxHash             uint32
+	ProbabilisticBounds bool
+	RoundMode           RoundMode
+}
+
+// TrainHybridFilter64 is TrainHybridFilter for []uint64 block indices, with
+// the same config. The model's sums are computed serially, so for inputs
+// above the parallel training threshold the fit may differ from
+// TrainHybridFilter's in the last bits. Block indices out of range are always
+// clamped, whatever config.StrictBlockIndices says.
+func TrainHybridFilter64(keyHashes []uint32, blockIndices []uint64, numBlocks uint64,
+	config HybridFilterConfig) *HybridFilter64 {
+	if config.MaxPosOverride > 0 {
+		numBlocks = uint64(config.MaxPosOverride) + 1
+	}
+	// Without a deadline or strict block indices, training cannot fail.
+	blockIndices, err := checkBlockIndices(blockIndices, numBlocks, false)
+	Check(err)
+
+	hf := &HybridFilter64{
+		BloomBits: make([]byte, config.BloomSizeBytes),
+		MaxPos:    max(numBlocks, 1) - 1,
+		RoundMode: config.RoundMode,
+	}
+	if len(keyHashes) == 0 {
+		hf.BloomHashK = 1
+		return hf
+	}
+	hf.KeyCount = uint64(len(keyHashes))
+	hf.MinHash, hf.MaxHash = slices.Min(keyHashes), slices.Max(keyHashes)
+
+	ctx := context.Background()
+	hf.BloomHashK = config.bloomK(len(keyHashes))
+	Check(fillHybridBloom(ctx, hf.BloomBits, hf.BloomHashK, keyHashes))
+
+	if config.SkipLearned {
+		hf.MaxErr = int64(hf.MaxPos)
+		return hf
+	}
+
+	m, err := fitHybridModel(ctx, keyHashes, blockIndices[:len(keyHashes)], config)
+	Check(err)
+	hf.Slope, hf.Intercept = m.slope, m.intercept
+	hf.MinErr, hf.MaxErr = m.minErr, m.maxErr
+	hf.ProbabilisticBounds = m.probabilistic
+	return hf
+}
+
+// MayContain returns true if the key MIGHT be in the table (Bloom filter check).
+// As with HybridFilter, a filter trained on no keys rejects every key.
+func (hf *HybridFilter64) MayContain(keyHash uint32) bool {
+	if hf.KeyCount == 0 {
+		return false
+	}
+	if hf.MaxHash != 0 && (keyHash < hf.MinHash || keyHash > hf.MaxHash) {
+		return false
+	}
+	return hybridBloomMayContain(hf.BloomBits, hf.BloomHashK, keyHash)
+}
+
+// PredictRange64 returns the predicted block range for a key, as
+// HybridFilter.PredictRange does.
+func (hf *HybridFilter64) PredictRange64(keyHash uint32) (minBlock, maxBlock int64) {
+	if hf.KeyCount == 0 {
+		return 0, int64(hf.MaxPos)
+	}
+
+	pos := hf.Slope*float64(keyHash) + hf.Intercept
+	predicted := int64(hf.RoundMode.round(pos))
+
+	maxPos := int64(hf.MaxPos)
+	minBlock = min(max(predicted+hf.MinErr, 0), maxPos)
+	maxBlock = min(max(predicted+hf.MaxErr, 0), maxPos)
+	return minBlock, maxBlock
+}
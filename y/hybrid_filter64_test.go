@@ -0,0 +1,88 @@
+package y
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestHybridFilter64Parity(t *testing.T) {
+	keyCount, numBlocks := 10000, 100
+	hashes := GenerateSortedKeyHashes(keyCount)
+	positions := make([]uint32, keyCount)
+	for i := range positions {
+		positions[i] = uint32(i) * 1000
+	}
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+	blocks64 := make([]uint64, keyCount)
+	for i, b := range blocks {
+		blocks64[i] = uint64(b)
+	}
+
+	configs := map[string]HybridFilterConfig{"default": DefaultHybridConfig()}
+	for name, mutate := range map[string]func(*HybridFilterConfig){
+		"floor":      func(c *HybridFilterConfig) { c.RoundMode = RoundFloor },
+		"ceil":       func(c *HybridFilterConfig) { c.RoundMode = RoundCeil },
+		"percentile": func(c *HybridFilterConfig) { c.ErrorPercentile = 0.99 },
+		"bloom only": func(c *HybridFilterConfig) { c.SkipLearned = true },
+	} {
+		config := DefaultHybridConfig()
+		mutate(&config)
+		configs[name] = config
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for name, config := range configs {
+		for input, keys := range map[string][]uint32{"hashes": hashes, "positions": positions} {
+			hf := TrainHybridFilter(keys, blocks, numBlocks, config)
+			hf64 := TrainHybridFilter64(keys, blocks64, uint64(numBlocks), config)
+			if hf.Slope != hf64.Slope || hf.Intercept != hf64.Intercept ||
+				int64(hf.MinErr) != hf64.MinErr || int64(hf.MaxErr) != hf64.MaxErr ||
+				uint64(hf.MaxPos) != hf64.MaxPos || uint64(hf.KeyCount) != hf64.KeyCount ||
+				hf.ProbabilisticBounds != hf64.ProbabilisticBounds || hf.BloomHashK != hf64.BloomHashK ||
+				hf.MinHash != hf64.MinHash || hf.MaxHash != hf64.MaxHash {
+				t.Fatalf("%s/%s: models differ:\n 32: %+v\n 64: %+v", name, input, hf, hf64)
+			}
+			probes := append(append([]uint32(nil), keys...), make([]uint32, 1000)...)
+			for i := len(keys); i < len(probes); i++ {
+				probes[i] = rng.Uint32()
+			}
+			for _, h := range probes {
+				minB, maxB := hf.PredictRange(h)
+				minB64, maxB64 := hf64.PredictRange64(h)
+				if int64(minB) != minB64 || int64(maxB) != maxB64 {
+					t.Fatalf("%s/%s: key hash %d: [%d,%d] vs [%d,%d]", name, input, h, minB, maxB, minB64, maxB64)
+				}
+				if hf.MayContain(h) != hf64.MayContain(h) {
+					t.Fatalf("%s/%s: key hash %d: MayContain differs", name, input, h)
+				}
+			}
+		}
+	}
+}
+
+func TestHybridFilter64BeyondUint32(t *testing.T) {
+	keyCount := 10000
+	positions := make([]uint32, keyCount)
+	blocks := make([]uint64, keyCount)
+	for i := range positions {
+		positions[i] = uint32(i) * 1000
+		// About a million blocks per key, ending near 1e10.
+		blocks[i] = uint64(i)<<20 + uint64(i%7)
+	}
+	numBlocks := blocks[keyCount-1] + 1
+	hf := TrainHybridFilter64(positions, blocks, numBlocks, DefaultHybridConfig())
+	if hf.MaxPos != numBlocks-1 {
+		t.Fatalf("MaxPos = %d, want %d", hf.MaxPos, numBlocks-1)
+	}
+	maxWidth := int64(0)
+	for i, p := range positions {
+		minB, maxB := hf.PredictRange64(p)
+		if int64(blocks[i]) < minB || int64(blocks[i]) > maxB {
+			t.Fatalf("Key %d in block %d, predicted [%d,%d]", i, blocks[i], minB, maxB)
+		}
+		maxWidth = max(maxWidth, maxB-minB+1)
+	}
+	if maxWidth > 16 {
+		t.Errorf("Search range up to %d blocks on a nearly linear mapping", maxWidth)
+	}
+}
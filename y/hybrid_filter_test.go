@@ -13,10 +13,22 @@
 package y
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"math/rand"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -47,9 +59,6 @@ func TestHybridFilterComparison(t *testing.T) {
 			keys[i] = []byte(fmt.Sprintf("key_%010d", i))
 			hashes[i] = Hash(keys[i])
 			blockIndices[i] = uint32(i / keysPerBlock)
-			if blockIndices[i] >= uint32(numBlocks) {
-				blockIndices[i] = uint32(numBlocks - 1)
-			}
 		}
 
 		// ============ BUILD ALL THREE ============
@@ -179,27 +188,29 @@ func TestHybridFilterComparison(t *testing.T) {
 		hybridTablesSearched := lookups - hybridTablesSkipped
 		hybridBlocksPerSearch := int(avgHybridRange)
 
+		bloomTotal := EstimateBlockReads(lookups, hitRate, 0, numBlocks, float64(numBlocks))
+		learnedTotal := EstimateBlockReads(lookups, hitRate, 1, numBlocks, avgLearnedRange)
+		hybridTotal := EstimateBlockReads(lookups, hitRate, hybridFPRate/100, numBlocks, avgHybridRange)
+
 		fmt.Printf("     Scenario: %d lookups, %.0f%% hit rate\n", lookups, hitRate*100)
 		fmt.Println()
 		fmt.Printf("     Bloom Filter:\n")
 		fmt.Printf("       Tables searched: %d (skipped %d on definite miss)\n",
 			bloomTablesSearched, misses)
 		fmt.Printf("       Blocks per table: %d\n", bloomBlocksPerSearch)
-		fmt.Printf("       Total block reads: %d\n", bloomTablesSearched*bloomBlocksPerSearch)
+		fmt.Printf("       Total block reads: %d\n", bloomTotal)
 		fmt.Println()
 		fmt.Printf("     Learned Index:\n")
 		fmt.Printf("       Tables searched: %d (cannot skip tables)\n", learnedTablesSearched)
 		fmt.Printf("       Blocks per table: %d\n", learnedBlocksPerSearch)
-		fmt.Printf("       Total block reads: %d\n", learnedTablesSearched*learnedBlocksPerSearch)
+		fmt.Printf("       Total block reads: %d\n", learnedTotal)
 		fmt.Println()
 		fmt.Printf("     Hybrid Filter (OURS):\n")
 		fmt.Printf("       Tables searched: %d (skipped %d on bloom miss)\n",
 			hybridTablesSearched, hybridTablesSkipped)
 		fmt.Printf("       Blocks per table: %d\n", hybridBlocksPerSearch)
-		fmt.Printf("       Total block reads: %d\n", hybridTablesSearched*hybridBlocksPerSearch)
+		fmt.Printf("       Total block reads: %d\n", hybridTotal)
 
-		bloomTotal := bloomTablesSearched * bloomBlocksPerSearch
-		hybridTotal := hybridTablesSearched * hybridBlocksPerSearch
 		if hybridTotal > 0 && hybridTotal < bloomTotal {
 			fmt.Printf("\n     ✅ Hybrid reduces block reads by %.1fx vs Bloom!\n",
 				float64(bloomTotal)/float64(hybridTotal))
@@ -232,125 +243,2679 @@ func TestHybridFilterComparison(t *testing.T) {
      - The learned index component compensates by reducing search range`)
 }
 
-// BenchmarkHybridBuild measures build time for all three approaches
-func BenchmarkHybridBuild(b *testing.B) {
-	sizes := []int{1000, 10000, 100000}
-	numBlocks := 100
+func TestEstimateBlockReads(t *testing.T) {
+	tests := []struct {
+		name      string
+		numTables int
+		hitRate   float64
+		fpRate    float64
+		avgRange  float64
+		want      int
+	}{
+		// 50/50, as in TestHybridFilterComparison at 100 blocks.
+		{"bloom 50/50", 1000, 0.5, 0, 100, 50000},
+		{"learned 50/50", 1000, 0.5, 1, 100, 100000},
+		{"hybrid 50/50", 1000, 0.5, 0.8549, 100, 92800}, // 72 misses skipped
+		{"hybrid 50/50 narrow", 1000, 0.5, 0.05, 3.4, 1575},
+		// Every probe hits: nothing to skip, whatever the FP rate.
+		{"all hit", 1000, 1, 0.05, 3.4, 3000},
+		{"all hit bloom", 1000, 1, 0, 100, 100000},
+		// Every probe misses: only false positives are searched.
+		{"all miss", 1000, 0, 0.05, 3.4, 150},
+		{"all miss bloom", 1000, 0, 0, 100, 0},
+		{"all miss learned", 1000, 0, 1, 3.4, 3000},
+		// The range is clamped to the table.
+		{"range clamp", 10, 1, 0, 250, 1000},
+		{"negative range", 10, 1, 0, -1, 0},
+	}
+	for _, tt := range tests {
+		if got := EstimateBlockReads(tt.numTables, tt.hitRate, tt.fpRate, 100, tt.avgRange); got != tt.want {
+			t.Errorf("%s: got %d block reads, want %d", tt.name, got, tt.want)
+		}
+	}
+}
 
-	for _, size := range sizes {
-		hashes := make([]uint32, size)
-		blocks := make([]uint32, size)
-		keysPerBlock := size / numBlocks
-		for i := 0; i < size; i++ {
+func TestTrainHybridFilterMemProfiled(t *testing.T) {
+	numBlocks := 100
+	var prev uint64
+	for _, keyCount := range []int{1000, 10000, 100000} {
+		hashes := make([]uint32, keyCount)
+		blocks := make([]uint32, keyCount)
+		for i := 0; i < keyCount; i++ {
 			hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
-			blocks[i] = uint32(i / keysPerBlock)
+			blocks[i] = uint32(i / (keyCount / numBlocks))
 		}
-		bitsPerKey := BloomBitsPerKey(size, 0.01)
-		config := DefaultHybridConfig()
+		// Size the bloom component per key, as a flush would.
+		config := HybridFilterConfig{BloomSizeBytes: keyCount * 10 / 8, TargetFPRate: 0.01}
 
-		b.Run(fmt.Sprintf("Bloom/size=%d", size), func(b *testing.B) {
-			for i := 0; i < b.N; i++ {
-				NewFilter(hashes, bitsPerKey)
-			}
-		})
+		hf, peak := TrainHybridFilterMemProfiled(hashes, blocks, numBlocks, config)
+		if hf == nil || hf.KeyCount != uint32(keyCount) {
+			t.Fatalf("keyCount=%d: unexpected filter %+v", keyCount, hf)
+		}
+		if peak == 0 {
+			t.Fatalf("keyCount=%d: expected a positive high-water mark", keyCount)
+		}
+		if peak < uint64(config.BloomSizeBytes) {
+			t.Errorf("keyCount=%d: peak %d smaller than the bloom itself (%d)",
+				keyCount, peak, config.BloomSizeBytes)
+		}
+		if peak <= prev {
+			t.Errorf("keyCount=%d: peak %d did not grow from %d", keyCount, peak, prev)
+		}
+		prev = peak
+	}
+}
 
-		b.Run(fmt.Sprintf("Learned/size=%d", size), func(b *testing.B) {
-			for i := 0; i < b.N; i++ {
-				TrainLearnedIndex(hashes, blocks, numBlocks)
-			}
-		})
+func TestTrainHybridFilterIntoReuse(t *testing.T) {
+	numBlocks := 100
+	config := DefaultHybridConfig()
+	hf := &HybridFilter{}
 
-		b.Run(fmt.Sprintf("Hybrid/size=%d", size), func(b *testing.B) {
-			for i := 0; i < b.N; i++ {
-				TrainHybridFilter(hashes, blocks, numBlocks, config)
-			}
-		})
+	for _, keyCount := range []int{10000, 1, 0, 5000} {
+		hashes := make([]uint32, keyCount)
+		blocks := make([]uint32, keyCount)
+		for i := 0; i < keyCount; i++ {
+			hashes[i] = Hash([]byte(fmt.Sprintf("table%d_key_%010d", keyCount, i)))
+			blocks[i] = uint32(i * numBlocks / keyCount)
+		}
+
+		prevBits := hf.BloomBits
+		TrainHybridFilterInto(hf, hashes, blocks, numBlocks, config)
+		fresh := TrainHybridFilter(hashes, blocks, numBlocks, config)
+
+		if prevBits != nil && &prevBits[0] != &hf.BloomBits[0] {
+			t.Errorf("keyCount=%d: BloomBits was reallocated", keyCount)
+		}
+		if !bytes.Equal(hf.Serialize(), fresh.Serialize()) {
+			t.Errorf("keyCount=%d: reused filter differs from fresh filter", keyCount)
+		}
 	}
 }
 
-// BenchmarkHybridQuery measures query time for all three approaches
-func BenchmarkHybridQuery(b *testing.B) {
-	size := 100000
-	numBlocks := 100
-	hashes := make([]uint32, size)
-	blocks := make([]uint32, size)
-	keysPerBlock := size / numBlocks
+func TestHybridFilterReset(t *testing.T) {
+	hashes := []uint32{100, 200, 300, 400}
+	blocks := []uint32{0, 1, 2, 3}
+	hf := TrainHybridFilter(hashes, blocks, 4, DefaultHybridConfig())
+	hf.Reset()
 
-	for i := 0; i < size; i++ {
-		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
-		blocks[i] = uint32(i / keysPerBlock)
+	if len(hf.BloomBits) != DefaultHybridConfig().BloomSizeBytes {
+		t.Fatalf("Reset should keep the bloom allocation, got %d bytes", len(hf.BloomBits))
+	}
+	for i, b := range hf.BloomBits {
+		if b != 0 {
+			t.Fatalf("BloomBits[%d] = %d after Reset", i, b)
+		}
 	}
+	if hf.KeyCount != 0 || hf.Slope != 0 || hf.Intercept != 0 || hf.MinErr != 0 ||
+		hf.MaxErr != 0 || hf.MaxPos != 0 || hf.BloomHashK != 0 {
+		t.Errorf("learned fields not cleared: %+v", hf)
+	}
+}
 
-	bitsPerKey := BloomBitsPerKey(size, 0.01)
-	bloomFilter := NewFilter(hashes, bitsPerKey)
-	learnedIndex := TrainLearnedIndex(hashes, blocks, numBlocks)
-	hybridFilter := TrainHybridFilter(hashes, blocks, numBlocks, DefaultHybridConfig())
+func TestHybridFilterExpiredBefore(t *testing.T) {
+	hashes := []uint32{100, 200, 300, 400}
+	blocks := []uint32{0, 1, 2, 3}
+	config := DefaultHybridConfig()
 
-	b.Run("Bloom/MayContain", func(b *testing.B) {
-		for i := 0; i < b.N; i++ {
-			Filter(bloomFilter).MayContain(rand.Uint32())
+	hf := TrainHybridFilter(hashes, blocks, 4, config)
+	if hf.ExpiredBefore(1 << 40) {
+		t.Error("A filter without timestamps should never be skipped")
+	}
+
+	hf.SetTimestampRange(1000, 2000)
+	if !hf.ExpiredBefore(2001) {
+		t.Error("Expected table with max timestamp 2000 to be skipped for cutoff 2001")
+	}
+	if hf.ExpiredBefore(2000) || hf.ExpiredBefore(1500) {
+		t.Error("Table with keys at or after the cutoff must not be skipped")
+	}
+
+	data := hf.Serialize()
+	if len(data) != HybridFilterSize(config) {
+		t.Errorf("Expected serialized size %d, got %d", HybridFilterSize(config), len(data))
+	}
+	restored, err := DeserializeHybridFilter(data, config.BloomSizeBytes)
+	if err != nil {
+		t.Fatalf("DeserializeHybridFilter: %v", err)
+	}
+	if restored.MinTimestamp != 1000 || restored.MaxTimestamp != 2000 {
+		t.Errorf("Timestamp mismatch after roundtrip: [%d,%d]",
+			restored.MinTimestamp, restored.MaxTimestamp)
+	}
+	if !restored.ExpiredBefore(2001) {
+		t.Error("Expected restored filter to be skipped for cutoff 2001")
+	}
+}
+
+func TestHybridFilterLookup(t *testing.T) {
+	keyCount := 10000
+	keysPerBlock := 100
+	numBlocks := keyCount / keysPerBlock
+
+	keys := make([][]byte, keyCount)
+	var firstKeys [][]byte
+	for i := 0; i < keyCount; i++ {
+		keys[i] = []byte(fmt.Sprintf("key_%010d", i))
+		if i%keysPerBlock == 0 {
+			firstKeys = append(firstKeys, keys[i])
 		}
-	})
+	}
+	bi := NewBlockIndex(firstKeys)
 
-	b.Run("Learned/Predict", func(b *testing.B) {
-		for i := 0; i < b.N; i++ {
-			learnedIndex.Predict(rand.Uint32())
+	hashes := make([]uint32, keyCount)
+	positions := make([]uint32, keyCount)
+	blocks := make([]uint32, keyCount)
+	for i, key := range keys {
+		hashes[i] = Hash(key)
+		positions[i], _ = bi.Position(key)
+		blocks[i] = uint32(i / keysPerBlock)
+	}
+	config := HybridFilterConfig{BloomSizeBytes: keyCount * 10 / 8, TargetFPRate: 0.01}
+	hf := TrainHybridFilterWithPositions(hashes, positions, blocks, numBlocks, config)
+
+	for i, key := range keys {
+		maybePresent, minB, maxB := hf.Lookup(key, bi)
+		if !maybePresent {
+			t.Fatalf("Key %d: false negative", i)
 		}
-	})
+		if int(blocks[i]) < minB || int(blocks[i]) > maxB {
+			t.Fatalf("Key %d: block %d not in range [%d,%d]", i, blocks[i], minB, maxB)
+		}
+		if maxB-minB+1 > 4 {
+			t.Fatalf("Key %d: range [%d,%d] too wide for position-trained model", i, minB, maxB)
+		}
+	}
 
-	b.Run("Hybrid/Query", func(b *testing.B) {
-		for i := 0; i < b.N; i++ {
-			hybridFilter.Query(rand.Uint32())
+	// Absent keys within the table's key range should mostly be skipped.
+	absent := 10000
+	skipped := 0
+	for i := 0; i < absent; i++ {
+		if ok, _, _ := hf.Lookup([]byte(fmt.Sprintf("key_%010d_absent", i)), bi); !ok {
+			skipped++
 		}
-	})
-}
+	}
+	if skipRate := float64(skipped) / float64(absent); skipRate < 0.95 {
+		t.Errorf("Expected >95%% of absent keys to be skipped, got %.2f%%", skipRate*100)
+	}
 
-// TestHybridFilterVariations tests different hybrid configurations
-func TestHybridFilterVariations(t *testing.T) {
-	fmt.Println("\n" + strings.Repeat("=", 70))
-	fmt.Println("  HYBRID FILTER SIZE vs ACCURACY TRADE-OFF")
-	fmt.Println(strings.Repeat("=", 70))
+	// Keys before the first block are never present.
+	if ok, _, _ := hf.Lookup([]byte("aaa"), bi); ok {
+		t.Error("Expected key before first block to be skipped")
+	}
+}
 
+func TestHybridFilterCostOnHitAndMiss(t *testing.T) {
 	keyCount := 10000
 	numBlocks := 100
-
 	hashes := make([]uint32, keyCount)
+	positions := make([]uint32, keyCount)
 	blocks := make([]uint32, keyCount)
-	keysPerBlock := keyCount / numBlocks
-
 	for i := 0; i < keyCount; i++ {
 		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
-		blocks[i] = uint32(i / keysPerBlock)
+		positions[i] = uint32(i)
+		blocks[i] = uint32(i / (keyCount / numBlocks))
 	}
+	config := HybridFilterConfig{BloomSizeBytes: keyCount, TargetFPRate: 0.05}
+	hf := TrainHybridFilterWithPositions(hashes, positions, blocks, numBlocks, config)
 
-	// Test different bloom sizes
-	bloomSizes := []int{16, 32, 64, 128, 256}
+	hit := hf.CostOnHit()
+	if hit > 4 {
+		t.Errorf("Expected a small CostOnHit on sorted data, got %.2f blocks", hit)
+	}
 
-	fmt.Printf("\n  %-12s %-12s %-15s %-15s\n",
-		"Bloom Size", "Total Size", "FP Rate", "Tables Skipped")
-	fmt.Println(strings.Repeat("-", 60))
+	fp := 0
+	tests := 100000
+	for i := 0; i < tests; i++ {
+		if hf.MayContain(rand.Uint32()) {
+			fp++
+		}
+	}
+	measuredFP := float64(fp) / float64(tests)
+	miss := hf.CostOnMiss()
+	t.Logf("CostOnHit %.2f, CostOnMiss %.4f, measured FP %.4f", hit, miss, measuredFP)
 
-	for _, bloomSize := range bloomSizes {
-		config := HybridFilterConfig{
-			BloomSizeBytes: bloomSize,
-			TargetFPRate:   0.05,
+	if math.Abs(miss/hit-measuredFP) > 0.01 {
+		t.Errorf("CostOnMiss/CostOnHit = %.4f does not reflect measured FP rate %.4f",
+			miss/hit, measuredFP)
+	}
+
+	empty := TrainHybridFilter(nil, nil, numBlocks, config)
+	if got := empty.CostOnHit(); got != float64(numBlocks) {
+		t.Errorf("Expected CostOnHit %d for empty filter, got %.2f", numBlocks, got)
+	}
+	if got := empty.CostOnMiss(); got != 0 {
+		t.Errorf("Expected CostOnMiss 0 for empty filter, got %.2f", got)
+	}
+}
+
+func TestHybridFilterModelInformative(t *testing.T) {
+	keyCount := 10000
+	hashes := GenerateSortedKeyHashes(keyCount)
+	positions := make([]uint32, keyCount)
+	for i := range positions {
+		positions[i] = uint32(i)
+	}
+	constant := func(block uint32) []uint32 {
+		blocks := make([]uint32, keyCount)
+		for i := range blocks {
+			blocks[i] = block
 		}
-		hf := TrainHybridFilter(hashes, blocks, numBlocks, config)
-		stats := hf.Stats()
+		return blocks
+	}
+	skip := DefaultHybridConfig()
+	skip.SkipLearned = true
 
-		// Measure FP rate
-		fp := 0
-		tests := 10000
-		for i := 0; i < tests; i++ {
-			if hf.MayContain(rand.Uint32()) {
-				fp++
+	tests := []struct {
+		name      string
+		keys      []uint32
+		blocks    []uint32
+		numBlocks int
+		config    HybridFilterConfig
+		want      bool
+	}{
+		{"constant, single block", hashes, constant(0), 1, DefaultHybridConfig(), false},
+		{"constant, one of many blocks", hashes, constant(7), 100, DefaultHybridConfig(), true},
+		{"hashed keys", hashes, GenerateBlockIndices(keyCount, 100), 100, DefaultHybridConfig(), false},
+		{"sorted positions", positions, GenerateBlockIndices(keyCount, 100), 100, DefaultHybridConfig(), true},
+		{"skip learned", positions, GenerateBlockIndices(keyCount, 100), 100, skip, false},
+		{"no keys", nil, nil, 100, DefaultHybridConfig(), false},
+	}
+	for _, tt := range tests {
+		hf := TrainHybridFilter(tt.keys, tt.blocks, tt.numBlocks, tt.config)
+		if got := hf.Stats().ModelInformative; got != tt.want {
+			t.Errorf("%s: ModelInformative = %v, want %v (slope %g, errors [%d,%d])",
+				tt.name, got, tt.want, hf.Slope, hf.MinErr, hf.MaxErr)
+		}
+		if tt.want || len(tt.keys) == 0 {
+			continue
+		}
+		// An uninformative model searches the whole table for every key.
+		for _, h := range tt.keys[:100] {
+			if minB, maxB := hf.PredictRange(h); minB != 0 || maxB != tt.numBlocks-1 {
+				t.Fatalf("%s: PredictRange = [%d,%d], want the whole table", tt.name, minB, maxB)
 			}
 		}
-		fpRate := float64(fp) / float64(tests) * 100
+	}
+}
 
-		fmt.Printf("  %-12d %-12d %-15.2f%% %-15.1f%%\n",
-			bloomSize, stats.TotalSizeBytes, fpRate, 100-fpRate)
+func TestSolveHybridConfig(t *testing.T) {
+	keyCount, numBlocks := 100, 10
+	config, err := SolveHybridConfig(keyCount, numBlocks, 64)
+	if err != nil {
+		t.Fatalf("SolveHybridConfig: %v", err)
 	}
+	if config.BloomSizeBytes != 31 {
+		t.Errorf("BloomSizeBytes = %d for a 64-byte budget, want 31", config.BloomSizeBytes)
+	}
+	hashes := GenerateSortedKeyHashes(keyCount)
+	stats := TrainHybridFilter(hashes, GenerateBlockIndices(keyCount, numBlocks), numBlocks, config).Stats()
+	if got := stats.BloomSizeBytes + stats.LearnedSizeBytes; got != 64 {
+		t.Errorf("Bloom and learned parts take %d bytes, want 64", got)
+	}
+	if config.TargetFPRate <= 0 || config.TargetFPRate >= 1 {
+		t.Errorf("TargetFPRate = %f", config.TargetFPRate)
+	}
+	t.Logf("64-byte budget for %d keys: %d-byte bloom at FP %.3f", keyCount, config.BloomSizeBytes, config.TargetFPRate)
 
-	fmt.Println("\n  Insight: Even a 16-byte bloom component can skip ~70% of tables!")
+	// A larger budget buys a better bloom.
+	bigger, err := SolveHybridConfig(keyCount, numBlocks, 96)
+	if err != nil {
+		t.Fatalf("SolveHybridConfig: %v", err)
+	}
+	if bigger.BloomSizeBytes != 63 || bigger.TargetFPRate >= config.TargetFPRate {
+		t.Errorf("96-byte budget: %+v, expected a 63-byte bloom below FP %f", bigger, config.TargetFPRate)
+	}
+
+	if _, err := SolveHybridConfig(keyCount, numBlocks, 40); !errors.Is(err, ErrBudgetTooSmall) {
+		t.Errorf("40-byte budget: expected ErrBudgetTooSmall, got %v", err)
+	}
+	if _, err := SolveHybridConfig(keyCount, numBlocks, 41); err != nil {
+		t.Errorf("41-byte budget: %v", err)
+	}
+}
+
+func TestHybridFilterSerializedSize(t *testing.T) {
+	keyCount := 1000
+	hashes := make([]uint32, keyCount)
+	blocks := make([]uint32, keyCount)
+	for i := 0; i < keyCount; i++ {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+		blocks[i] = uint32(i / 10)
+	}
+
+	for _, bloomSize := range []int{1, 16, 64, 128, 1000} {
+		for _, maxPosOverride := range []uint32{0, 999} {
+			config := HybridFilterConfig{BloomSizeBytes: bloomSize, TargetFPRate: 0.05, MaxPosOverride: maxPosOverride}
+			hf := TrainHybridFilter(hashes, blocks, keyCount/10, config)
+			want := HybridFilterSize(config)
+			if got := hf.SerializedSize(); got != want {
+				t.Errorf("bloomSize=%d, override=%d: SerializedSize %d, HybridFilterSize %d",
+					bloomSize, maxPosOverride, got, want)
+			}
+			if got := len(hf.Serialize()); got != want {
+				t.Errorf("bloomSize=%d, override=%d: len(Serialize()) %d, HybridFilterSize %d",
+					bloomSize, maxPosOverride, got, want)
+			}
+		}
+	}
+}
+
+// countdownContext reports cancellation once Err has been called n times,
+// which cancels a build at a deterministic point partway through.
+type countdownContext struct {
+	context.Context
+	n     int
+	calls int
+}
+
+func (c *countdownContext) Err() error {
+	c.calls++
+	if c.calls > c.n {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestTrainHybridFilterContextCancel(t *testing.T) {
+	n := 4 * ctxCheckInterval * 8
+	hashes := make([]uint32, n)
+	blocks := make([]uint32, n)
+	for i := 0; i < n; i++ {
+		hashes[i] = uint32(i) * 2654435761
+		blocks[i] = uint32(i / 1000)
+	}
+	config := HybridFilterConfig{BloomSizeBytes: n * 10 / 8}
+	numBlocks := n / 1000
+
+	// A background context never interrupts and matches TrainHybridFilter.
+	hf, err := TrainHybridFilterContext(context.Background(), hashes, blocks, numBlocks, config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(hf.Serialize(), TrainHybridFilter(hashes, blocks, numBlocks, config).Serialize()) {
+		t.Error("TrainHybridFilterContext result differs from TrainHybridFilter")
+	}
+
+	// Cancel during the bloom fill, during the regression and during the error
+	// bound pass. Each stage makes n/ctxCheckInterval checks.
+	checksPerStage := n / ctxCheckInterval
+	for _, after := range []int{0, checksPerStage / 2, checksPerStage + 3, 2*checksPerStage + 1} {
+		parent, cancel := context.WithCancel(context.Background())
+		ctx := &countdownContext{Context: parent, n: after}
+		start := time.Now()
+		hf, err := TrainHybridFilterContext(ctx, hashes, blocks, numBlocks, config)
+		elapsed := time.Since(start)
+		cancel()
+
+		if err != context.Canceled {
+			t.Errorf("after=%d: expected context.Canceled, got %v", after, err)
+		}
+		if hf != nil {
+			t.Errorf("after=%d: expected nil filter on cancellation", after)
+		}
+		// The build must stop at the first check that sees the cancellation.
+		if ctx.calls != after+1 {
+			t.Errorf("after=%d: build kept going for %d more checks", after, ctx.calls-after-1)
+		}
+		t.Logf("after=%d checks: returned in %v", after, elapsed)
+	}
+}
+
+func TestDeserializeHybridFilterErrors(t *testing.T) {
+	config := DefaultHybridConfig()
+	hf := TrainHybridFilter([]uint32{100, 200, 300}, []uint32{0, 1, 2}, 3, config)
+	data := hf.Serialize()
+
+	restored, err := DeserializeHybridFilter(data, config.BloomSizeBytes)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(restored.Serialize(), data) {
+		t.Error("Roundtrip mismatch")
+	}
+
+	badMagic := bytes.Clone(data)
+	badMagic[0] = 'X'
+	badVersion := bytes.Clone(data)
+	badVersion[2] = 99
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrShortBuffer},
+		{"header only", data[:2], ErrShortBuffer},
+		{"truncated bloom", data[:10], ErrShortBuffer},
+		{"truncated fields", data[:len(data)-1], ErrShortBuffer},
+		{"bad magic", badMagic, ErrBadMagic},
+		{"bad version", badVersion, ErrUnsupportedVersion},
+	}
+	for _, tc := range tests {
+		hf, err := DeserializeHybridFilter(tc.data, config.BloomSizeBytes)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, err)
+		}
+		if hf != nil {
+			t.Errorf("%s: expected nil filter on error", tc.name)
+		}
+	}
+}
+
+func TestHybridFilterView(t *testing.T) {
+	keyCount := 1000
+	hashes := GenerateSortedKeyHashes(keyCount)
+	blocks := GenerateBlockIndices(keyCount, 10)
+	hf := TrainHybridFilter(hashes, blocks, 10, DefaultHybridConfig())
+	// Pad the filter as it would sit inside a larger mapped region.
+	region := append(append([]byte("prefix"), hf.Serialize()...), "suffix"...)
+	data := region[len("prefix") : len(region)-len("suffix")]
+
+	view, err := HybridFilterView(data)
+	if err != nil {
+		t.Fatalf("HybridFilterView: %v", err)
+	}
+	if !bytes.Equal(view.Serialize(), hf.Serialize()) {
+		t.Fatal("View does not match the serialized filter")
+	}
+	for _, h := range hashes {
+		if maybe, lo, hi := view.Query(h); !maybe || lo > hi {
+			t.Fatalf("Query(%d) on view = %v [%d,%d]", h, maybe, lo, hi)
+		}
+		if view.MayContain(h) != hf.MayContain(h) {
+			t.Fatalf("View and original disagree on %d", h)
+		}
+	}
+
+	// The bloom bits alias the region: clearing them there rejects every key.
+	clear(data[hybridFilterHeaderSize : len(data)-hybridFilterTrailerSize])
+	for _, h := range hashes {
+		if view.MayContain(h) {
+			t.Fatalf("Hash %d still present after clearing the mapped bloom", h)
+		}
+	}
+	if view.BloomBits = append(view.BloomBits, 0xff); data[len(data)-hybridFilterTrailerSize] == 0xff {
+		t.Error("Appending to the view's bloom overwrote the trailer")
+	}
+
+	if _, err := HybridFilterView(data[:10]); !errors.Is(err, ErrShortBuffer) {
+		t.Errorf("Short view: expected ErrShortBuffer, got %v", err)
+	}
+	if _, err := HybridFilterView(hf.SerializeCompact()); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Compact view: expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestHybridFilterSerializeCompact(t *testing.T) {
+	keyCount := 10000
+	numBlocks := 100
+	hashes := GenerateSortedKeyHashes(keyCount)
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+	config := DefaultHybridConfig()
+	hf := TrainHybridFilter(hashes, blocks, numBlocks, config)
+	hf.MinTimestamp, hf.MaxTimestamp = -5, 1700000000
+
+	fixed := hf.Serialize()
+	compact := hf.SerializeCompact()
+	t.Logf("Serialized size: fixed %d bytes, compact %d bytes (%.1f%% smaller)",
+		len(fixed), len(compact), 100*float64(len(fixed)-len(compact))/float64(len(fixed)))
+	if len(compact) >= len(fixed) {
+		t.Errorf("Compact encoding is %d bytes, fixed-width %d", len(compact), len(fixed))
+	}
+
+	restored, err := DeserializeHybridFilter(compact, config.BloomSizeBytes)
+	if err != nil {
+		t.Fatalf("DeserializeHybridFilter(compact): %v", err)
+	}
+	if !bytes.Equal(restored.Serialize(), fixed) {
+		t.Error("Compact roundtrip does not reproduce the fixed-width encoding")
+	}
+	if !bytes.Equal(restored.SerializeCompact(), compact) {
+		t.Error("Compact roundtrip mismatch")
+	}
+	for i := 0; i < len(compact); i++ {
+		if _, err := DeserializeHybridFilter(compact[:i], config.BloomSizeBytes); !errors.Is(err, ErrShortBuffer) {
+			t.Fatalf("Truncated to %d bytes: expected ErrShortBuffer, got %v", i, err)
+		}
+	}
+
+	// Negative error bounds and a wide model exercise multi-byte varints.
+	wide := &HybridFilter{BloomBits: []byte{0xff}, BloomHashK: 7, Slope: -1.5, Intercept: 1e9,
+		MinErr: math.MinInt32, MaxErr: 70000, MaxPos: math.MaxUint32, KeyCount: 1 << 20}
+	restored, err = DeserializeHybridFilter(wide.SerializeCompact(), 1)
+	if err != nil {
+		t.Fatalf("DeserializeHybridFilter(wide): %v", err)
+	}
+	if !bytes.Equal(restored.Serialize(), wide.Serialize()) {
+		t.Error("Compact roundtrip of wide fields mismatch")
+	}
+}
+
+func TestHybridFilterVersionDisambiguates(t *testing.T) {
+	config := DefaultHybridConfig()
+	hf := TrainHybridFilter([]uint32{100, 200, 300}, []uint32{0, 1, 2}, 3, config)
+	fixed := hf.Serialize()
+	compact := hf.SerializeCompact()
+	if fixed[2] == compact[2] {
+		t.Fatalf("Fixed and compact payloads share version byte %d", fixed[2])
+	}
+
+	// The same call reads both formats, each by its own layout.
+	for name, data := range map[string][]byte{"fixed": fixed, "compact": compact} {
+		restored, err := DeserializeHybridFilter(data, config.BloomSizeBytes)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(restored.Serialize(), fixed) {
+			t.Errorf("%s: decoded filter differs from the original", name)
+		}
+	}
+
+	// Relabelled payloads are not silently misread: the compact trailer is
+	// too short for the fixed layout.
+	relabelled := bytes.Clone(compact)
+	relabelled[2] = fixed[2]
+	if _, err := DeserializeHybridFilter(relabelled, config.BloomSizeBytes); !errors.Is(err, ErrShortBuffer) {
+		t.Errorf("Compact payload labelled fixed-width: expected ErrShortBuffer, got %v", err)
+	}
+
+	// The streaming format is fixed-width only.
+	var buf bytes.Buffer
+	if _, err := hf.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	stream := buf.Bytes()
+	stream[4+2] = compact[2]
+	if _, err := ReadHybridFilterFrom(bytes.NewReader(stream)); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Compact version in stream: expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestDeserializeHybridFilterLegacy(t *testing.T) {
+	config := DefaultHybridConfig()
+	hashes := GenerateSortedKeyHashes(1000)
+	hf := TrainHybridFilter(hashes, GenerateBlockIndices(1000, 10), 10, config)
+	hf.SetTimestampRange(10, 20)
+	fixed := hf.Serialize()
+	if fixed[len(hybridFilterMagic)] == hybridFilterVersionLegacy {
+		t.Fatalf("Serialize writes version %d, that of the layout without flags", hybridFilterVersionLegacy)
+	}
+
+	// Version 1 is the fixed-width layout without the flags byte.
+	legacy := append([]byte(hybridFilterMagic), hybridFilterVersionLegacy)
+	legacy = append(legacy, fixed[hybridFilterHeaderSize:]...)
+	restored, err := DeserializeHybridFilter(legacy, config.BloomSizeBytes)
+	if err != nil {
+		t.Fatalf("DeserializeHybridFilter(legacy): %v", err)
+	}
+	if !bytes.Equal(restored.Serialize(), fixed) {
+		t.Error("Legacy payload decoded to a different filter")
+	}
+	view, err := HybridFilterView(legacy)
+	if err != nil {
+		t.Fatalf("HybridFilterView(legacy): %v", err)
+	}
+	if !bytes.Equal(view.Serialize(), fixed) {
+		t.Error("Legacy view differs from the original filter")
+	}
+	if _, err := DeserializeHybridFilter(legacy[:len(legacy)-1], config.BloomSizeBytes); !errors.Is(err, ErrShortBuffer) {
+		t.Errorf("Truncated legacy payload: expected ErrShortBuffer, got %v", err)
+	}
+}
+
+func TestHybridFilterPredictBlock(t *testing.T) {
+	keyCount := 10000
+	numBlocks := 100
+	positions := make([]uint32, keyCount)
+	blocks := make([]uint32, keyCount)
+	for i := 0; i < keyCount; i++ {
+		positions[i] = uint32(i)
+		blocks[i] = uint32(i / (keyCount / numBlocks))
+	}
+	hf := TrainHybridFilterWithPositions(positions, positions, blocks, numBlocks, DefaultHybridConfig())
+
+	for _, pos := range []uint32{0, 1, 49, 50, 500, 5000, 9999} {
+		block := hf.PredictBlock(pos)
+		minB, maxB := hf.PredictRange(pos)
+		if block < minB || block > maxB {
+			t.Errorf("pos=%d: block %d outside range [%d,%d]", pos, block, minB, maxB)
+		}
+		// Away from the table edges, the range is the prediction widened by
+		// the error bounds.
+		if block+int(hf.MinErr) >= 0 && block+int(hf.MaxErr) <= int(hf.MaxPos) {
+			if minB != block+int(hf.MinErr) || maxB != block+int(hf.MaxErr) {
+				t.Errorf("pos=%d: block %d is not the center of range [%d,%d]", pos, block, minB, maxB)
+			}
+		}
+	}
+
+	empty := TrainHybridFilter(nil, nil, numBlocks, DefaultHybridConfig())
+	if got := empty.PredictBlock(5); got != 0 {
+		t.Errorf("Expected block 0 for empty filter, got %d", got)
+	}
+}
+
+func TestHybridFilterExactFit(t *testing.T) {
+	numBlocks := 100
+	// One key per block at evenly spaced positions reaching the top of the
+	// hash space: block = (position - base) / stride exactly.
+	base, stride := uint32(12345), uint32(40_000_000)
+	positions := make([]uint32, numBlocks)
+	blocks := make([]uint32, numBlocks)
+	for b := range positions {
+		positions[b] = base + uint32(b)*stride
+		blocks[b] = uint32(b)
+	}
+	for _, percentile := range []float64{0, 0.95} {
+		config := DefaultHybridConfig()
+		config.ErrorPercentile = percentile
+		hf := TrainHybridFilterWithPositions(positions, positions, blocks, numBlocks, config)
+		if hf.MinErr != 0 || hf.MaxErr != 0 || hf.ProbabilisticBounds {
+			t.Errorf("percentile %.2f: bounds [%d,%d] (probabilistic %v), want exact [0,0]",
+				percentile, hf.MinErr, hf.MaxErr, hf.ProbabilisticBounds)
+		}
+		for b, p := range positions {
+			if minB, maxB := hf.PredictRange(p); minB != b || maxB != b {
+				t.Fatalf("percentile %.2f: block %d predicted [%d,%d]", percentile, b, minB, maxB)
+			}
+		}
+	}
+
+	// Several keys per block is a staircase, not a line, and keeps its margin.
+	keyCount := 10000
+	positions = make([]uint32, keyCount)
+	for i := range positions {
+		positions[i] = uint32(i)
+	}
+	blocks = GenerateBlockIndices(keyCount, numBlocks)
+	hf := TrainHybridFilterWithPositions(positions, positions, blocks, numBlocks, DefaultHybridConfig())
+	if hf.MinErr >= 0 || hf.MaxErr <= 0 {
+		t.Errorf("Staircase data: bounds [%d,%d], expected a margin on both sides", hf.MinErr, hf.MaxErr)
+	}
+}
+
+func TestHybridFilterRoundMode(t *testing.T) {
+	keyCount, numBlocks := 10000, 100
+	positions := make([]uint32, keyCount)
+	for i := range positions {
+		positions[i] = uint32(i) * 1000
+	}
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+
+	filters := make(map[RoundMode]*HybridFilter)
+	for _, mode := range []RoundMode{RoundNearest, RoundFloor, RoundCeil} {
+		config := DefaultHybridConfig()
+		config.RoundMode = mode
+		hf := TrainHybridFilter(positions, blocks, numBlocks, config)
+		filters[mode] = hf
+		for i, p := range positions {
+			if minB, maxB := hf.PredictRange(p); int(blocks[i]) < minB || int(blocks[i]) > maxB {
+				t.Fatalf("mode %d: key %d in block %d, predicted [%d,%d]", mode, i, blocks[i], minB, maxB)
+			}
+		}
+
+		for _, data := range [][]byte{hf.Serialize(), hf.SerializeCompact()} {
+			got, err := DeserializeHybridFilter(data, config.BloomSizeBytes)
+			if err != nil {
+				t.Fatalf("mode %d: deserialize: %v", mode, err)
+			}
+			if got.RoundMode != mode {
+				t.Errorf("mode %d: deserialized as mode %d", mode, got.RoundMode)
+			}
+		}
+		js, err := json.Marshal(hf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got HybridFilter
+		if err := json.Unmarshal(js, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.RoundMode != mode {
+			t.Errorf("mode %d: JSON roundtrip gave mode %d", mode, got.RoundMode)
+		}
+	}
+
+	// The model is the same in every mode; only the center moves.
+	nearest, floor, ceil := filters[RoundNearest], filters[RoundFloor], filters[RoundCeil]
+	shifted := 0
+	for _, p := range positions {
+		pos := nearest.Slope*float64(p) + nearest.Intercept
+		if pos < 0 || pos > float64(nearest.MaxPos) {
+			continue
+		}
+		f, n, c := floor.PredictBlock(p), nearest.PredictBlock(p), ceil.PredictBlock(p)
+		if f != int(math.Floor(pos)) || n != int(math.Round(pos)) || c != int(math.Ceil(pos)) {
+			t.Fatalf("prediction %v: floor %d, nearest %d, ceil %d", pos, f, n, c)
+		}
+		if f != c {
+			shifted++
+		}
+	}
+	if shifted < keyCount/2 {
+		t.Errorf("Only %d of %d predictions were fractional", shifted, keyCount)
+	}
+
+	// Exact offsets need no padding, so the range is no wider than nearest's.
+	for _, hf := range []*HybridFilter{floor, ceil} {
+		if hf.MaxErr-hf.MinErr > nearest.MaxErr-nearest.MinErr {
+			t.Errorf("mode %d: bounds [%d,%d] wider than nearest's [%d,%d]",
+				hf.RoundMode, hf.MinErr, hf.MaxErr, nearest.MinErr, nearest.MaxErr)
+		}
+	}
+
+	seeds := int64(100)
+	if testing.Short() {
+		seeds = 20
+	}
+	for seed := int64(0); seed < seeds; seed++ {
+		positions, blocks, numBlocks := randomTrainingSet(seed)
+		for _, mode := range []RoundMode{RoundFloor, RoundCeil} {
+			hf := TrainHybridFilter(positions, blocks, numBlocks,
+				HybridFilterConfig{BloomSizeBytes: 64, RoundMode: mode})
+			for i, p := range positions {
+				if minB, maxB := hf.PredictRange(p); int(blocks[i]) < minB || int(blocks[i]) > maxB {
+					t.Fatalf("seed %d mode %d: key %d in block %d, predicted [%d,%d]",
+						seed, mode, i, blocks[i], minB, maxB)
+				}
+			}
+		}
+	}
+}
+
+func TestHybridFilterResidualHistogram(t *testing.T) {
+	keyCount, numBlocks := 10000, 100
+	config := DefaultHybridConfig()
+	if hf := TrainHybridFilter(GenerateSortedKeyHashes(keyCount), GenerateBlockIndices(keyCount, numBlocks),
+		numBlocks, config); hf.ResidualHistogram() != nil {
+		t.Fatalf("Histogram kept without ResidualHistogram set")
+	}
+	config.ResidualHistogram = true
+
+	// outerShare is the fraction of keys in the five buckets at either end.
+	center := residualHistogramBuckets / 2
+	outerShare := func(hist []int) float64 {
+		outer, total := 0, 0
+		for b, c := range hist {
+			if b < center-5 || b > center+5 {
+				outer += c
+			}
+			total += c
+		}
+		return float64(outer) / float64(total)
+	}
+
+	// Evenly spaced keys fit the line to within half a block, so every
+	// residual lands in the middle of [MinErr, MaxErr].
+	positions := make([]uint32, keyCount)
+	for i := range positions {
+		positions[i] = uint32(i) * 1000
+	}
+	hf := TrainHybridFilter(positions, GenerateBlockIndices(keyCount, numBlocks), numBlocks, config)
+	hist := hf.ResidualHistogram()
+	if len(hist) != residualHistogramBuckets {
+		t.Fatalf("Got %d buckets, want %d", len(hist), residualHistogramBuckets)
+	}
+	if share := outerShare(hist); share != 0 || hist[center] == 0 {
+		t.Errorf("Uniform data: %.2f of keys in the outer buckets, histogram %v", share, hist)
+	}
+
+	// Dense clusters each cover a run of blocks at almost the same position,
+	// so the line misses most keys by many blocks and the residuals pile up
+	// away from zero.
+	positions, blocks := clusteredPositions(keyCount, 4, numBlocks)
+	hf = TrainHybridFilter(positions, blocks, numBlocks, config)
+	hist = hf.ResidualHistogram()
+	if share := outerShare(hist); share < 0.4 {
+		t.Errorf("Clustered data: only %.2f of keys in the outer buckets, histogram %v", share, hist)
+	}
+	total := 0
+	for _, c := range hist {
+		total += c
+	}
+	if total != keyCount {
+		t.Errorf("Histogram counts %d keys, want %d", total, keyCount)
+	}
+
+	if err := hf.Update([]uint32{math.MaxUint32}, []uint32{uint32(numBlocks - 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if hf.ResidualHistogram() != nil {
+		t.Errorf("Update kept a stale histogram")
+	}
+}
+
+func TestHybridFilterBlockIndexOutOfRange(t *testing.T) {
+	// Integer division leaves the last key in block numBlocks.
+	keyCount, numBlocks := 1001, 100
+	hashes := GenerateSortedKeyHashes(keyCount)
+	blocks := make([]uint32, keyCount)
+	for i := range blocks {
+		blocks[i] = uint32(i / (keyCount / numBlocks))
+	}
+	if blocks[keyCount-1] != uint32(numBlocks) {
+		t.Fatalf("Last key in block %d, want the boundary block %d", blocks[keyCount-1], numBlocks)
+	}
+	clamped := slices.Clone(blocks)
+	clamped[keyCount-1] = uint32(numBlocks - 1)
+
+	hf := TrainHybridFilter(hashes, blocks, numBlocks, DefaultHybridConfig())
+	want := TrainHybridFilter(hashes, clamped, numBlocks, DefaultHybridConfig())
+	if hf.Slope != want.Slope || hf.Intercept != want.Intercept ||
+		hf.MinErr != want.MinErr || hf.MaxErr != want.MaxErr {
+		t.Errorf("Unclamped input trained (%v, %v, [%d,%d]), want (%v, %v, [%d,%d]) as if clamped",
+			hf.Slope, hf.Intercept, hf.MinErr, hf.MaxErr, want.Slope, want.Intercept, want.MinErr, want.MaxErr)
+	}
+	if blocks[keyCount-1] != uint32(numBlocks) {
+		t.Errorf("Training modified the caller's block indices")
+	}
+
+	config := DefaultHybridConfig()
+	config.StrictBlockIndices = true
+	if _, err := TrainHybridFilterContext(context.Background(), hashes, blocks, numBlocks, config); !errors.Is(err, ErrBlockOutOfRange) {
+		t.Errorf("Strict mode: got %v, want ErrBlockOutOfRange", err)
+	}
+	if _, err := TrainHybridFilterContext(context.Background(), hashes, clamped, numBlocks, config); err != nil {
+		t.Errorf("Strict mode rejected valid block indices: %v", err)
+	}
+	// The builders without an error result clamp regardless.
+	if hf := TrainHybridFilter(hashes, blocks, numBlocks, config); hf.Slope != want.Slope || hf.MaxErr != want.MaxErr {
+		t.Errorf("TrainHybridFilter in strict mode did not clamp")
+	}
+	if hf := TrainHybridFilterWithPositions(hashes, hashes, blocks, numBlocks, config); hf.Slope != want.Slope {
+		t.Errorf("TrainHybridFilterWithPositions in strict mode did not clamp")
+	}
+	blocks64 := make([]uint64, keyCount)
+	for i, b := range blocks {
+		blocks64[i] = uint64(b)
+	}
+	if hf := TrainHybridFilter64(hashes, blocks64, uint64(numBlocks), config); hf.MaxPos != uint64(numBlocks-1) {
+		t.Errorf("TrainHybridFilter64 in strict mode: MaxPos %d, want %d", hf.MaxPos, numBlocks-1)
+	}
+}
+
+func TestHybridFilterWidenBounds(t *testing.T) {
+	keyCount, numBlocks := 10000, 100
+	positions := make([]uint32, keyCount)
+	blocks := make([]uint32, keyCount)
+	rng := rand.New(rand.NewSource(1))
+	for i := range positions {
+		positions[i] = uint32(i)
+		blocks[i] = uint32(min(max(i/100+rng.Intn(11)-5, 0), numBlocks-1))
+	}
+	// Percentile bounds stand in for a model that queries have drifted from:
+	// some keys fall outside the predicted range.
+	config := DefaultHybridConfig()
+	config.ErrorPercentile = 0.8
+	hf := TrainHybridFilter(positions, blocks, numBlocks, config)
+	missed := func(hf *HybridFilter) []int {
+		var out []int
+		for i, p := range positions {
+			if minB, maxB := hf.PredictRange(p); int(blocks[i]) < minB || int(blocks[i]) > maxB {
+				out = append(out, i)
+			}
+		}
+		return out
+	}
+	before := missed(hf)
+	if len(before) == 0 {
+		t.Fatal("Expected percentile bounds to miss some keys")
+	}
+
+	widened := *hf
+	widened.WidenBounds(2)
+	if widened.MinErr >= hf.MinErr || widened.MaxErr <= hf.MaxErr {
+		t.Errorf("Bounds [%d,%d] widened to [%d,%d]", hf.MinErr, hf.MaxErr, widened.MinErr, widened.MaxErr)
+	}
+	if after := missed(&widened); len(after) != 0 {
+		t.Errorf("%d of %d missed keys still missed after widening 2x", len(after), len(before))
+	}
+	t.Logf("Bounds [%d,%d] missed %d keys; widened to [%d,%d]",
+		hf.MinErr, hf.MaxErr, len(before), widened.MinErr, widened.MaxErr)
+
+	// Exact bounds of 0 still widen: one key per block.
+	exact := TrainHybridFilter(positions[:numBlocks], positions[:numBlocks], numBlocks, DefaultHybridConfig())
+	if exact.MinErr != 0 || exact.MaxErr != 0 {
+		t.Fatalf("Expected an exact fit, got bounds [%d,%d]", exact.MinErr, exact.MaxErr)
+	}
+	exact.WidenBounds(1.5)
+	if exact.MinErr != -1 || exact.MaxErr != 1 {
+		t.Errorf("Exact fit widened to [%d,%d], want [-1,1]", exact.MinErr, exact.MaxErr)
+	}
+
+	// The bounds stop at the table size and never narrow.
+	exact.WidenBounds(1e6)
+	if exact.MinErr != -int32(numBlocks-1) || exact.MaxErr != int32(numBlocks-1) {
+		t.Errorf("Widened past the table to [%d,%d]", exact.MinErr, exact.MaxErr)
+	}
+	for _, factor := range []float64{1, 0.5, 0, -2, math.NaN()} {
+		before := widened
+		widened.WidenBounds(factor)
+		if widened.MinErr != before.MinErr || widened.MaxErr != before.MaxErr {
+			t.Errorf("Factor %v changed bounds [%d,%d] to [%d,%d]",
+				factor, before.MinErr, before.MaxErr, widened.MinErr, widened.MaxErr)
+		}
+	}
+}
+
+func TestHybridFilterHashBounds(t *testing.T) {
+	keyCount, numBlocks := 10000, 100
+	positions := make([]uint32, keyCount)
+	for i := range positions {
+		positions[i] = 1<<30 + uint32(i)*1000
+	}
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+	hf := TrainHybridFilter(positions, blocks, numBlocks, DefaultHybridConfig())
+	if hf.MinHash != positions[0] || hf.MaxHash != positions[keyCount-1] {
+		t.Fatalf("Bounds [%d,%d], want [%d,%d]", hf.MinHash, hf.MaxHash, positions[0], positions[keyCount-1])
+	}
+
+	// With every bloom bit set, only the bounds can reject a hash.
+	saturated := *hf
+	saturated.BloomBits = bytes.Repeat([]byte{0xff}, len(hf.BloomBits))
+	for _, h := range []uint32{0, hf.MinHash - 1, hf.MaxHash + 1, math.MaxUint32} {
+		if saturated.MayContain(h) {
+			t.Errorf("Out-of-range hash %d accepted", h)
+		}
+	}
+	for _, h := range []uint32{hf.MinHash, hf.MinHash + 1, hf.MaxHash - 1, hf.MaxHash} {
+		if !saturated.MayContain(h) {
+			t.Errorf("In-range hash %d rejected", h)
+		}
+	}
+
+	// In range, the answer is the bloom's, as before.
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		h := hf.MinHash + rng.Uint32()%(hf.MaxHash-hf.MinHash+1)
+		if hf.MayContain(h) != hybridBloomMayContain(hf.BloomBits, hf.BloomHashK, h) {
+			t.Fatalf("Hash %d: MayContain differs from the bloom alone", h)
+		}
+	}
+
+	// Bounds are not serialized: a deserialized filter falls back to the bloom.
+	restored, err := DeserializeHybridFilter(hf.Serialize(), DefaultHybridConfig().BloomSizeBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.MaxHash != 0 || restored.MayContain(0) != hybridBloomMayContain(hf.BloomBits, hf.BloomHashK, 0) {
+		t.Errorf("Deserialized filter: bounds [%d,%d]", restored.MinHash, restored.MaxHash)
+	}
+
+	// Update and MergeHybrid widen the bounds to the new keys.
+	if err := hf.Update([]uint32{1 << 20}, []uint32{0}); err != nil {
+		t.Fatal(err)
+	}
+	if hf.MinHash != 1<<20 || !hf.MayContain(1<<20) {
+		t.Errorf("After Update: bounds [%d,%d]", hf.MinHash, hf.MaxHash)
+	}
+	a := TrainHybridFilter(positions[:keyCount/2], blocks[:keyCount/2], numBlocks, DefaultHybridConfig())
+	b := TrainHybridFilter(positions[keyCount/2:], blocks[keyCount/2:], numBlocks, DefaultHybridConfig())
+	merged, err := MergeHybrid(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.MinHash != positions[0] || merged.MaxHash != positions[keyCount-1] {
+		t.Errorf("Merged bounds [%d,%d], want [%d,%d]", merged.MinHash, merged.MaxHash, positions[0], positions[keyCount-1])
+	}
+	if merged, _ := MergeHybrid(a, restored); merged.MaxHash != 0 {
+		t.Errorf("Merge with an unbounded filter: bounds [%d,%d], want none", merged.MinHash, merged.MaxHash)
+	}
+}
+
+func TestHybridFilterPredictWeighted(t *testing.T) {
+	keyCount := 10000
+	numBlocks := 100
+	positions := make([]uint32, keyCount)
+	blocks := make([]uint32, keyCount)
+	for i := 0; i < keyCount; i++ {
+		positions[i] = uint32(i)
+		blocks[i] = uint32(i / (keyCount / numBlocks))
+	}
+	// Noise widens the error bounds so that ranges span several blocks.
+	for _, i := range []int{10, 5000, 9990} {
+		blocks[i] = uint32(min(int(blocks[i])+3, numBlocks-1))
+	}
+	hf := TrainHybridFilterWithPositions(positions, positions, blocks, numBlocks, DefaultHybridConfig())
+
+	for _, pos := range []uint32{0, 1, 49, 2500, 5000, 7777, 9999} {
+		scores := hf.PredictWeighted(pos)
+		minB, maxB := hf.PredictRange(pos)
+		if len(scores) != maxB-minB+1 {
+			t.Fatalf("pos=%d: %d scores for range [%d,%d]", pos, len(scores), minB, maxB)
+		}
+		if scores[0].Block != hf.PredictBlock(pos) {
+			t.Errorf("pos=%d: block %d ranked first, want center block %d", pos, scores[0].Block, hf.PredictBlock(pos))
+		}
+		seen := make(map[int]bool)
+		for i, s := range scores {
+			if s.Block < minB || s.Block > maxB || seen[s.Block] {
+				t.Fatalf("pos=%d: unexpected block %d in %v", pos, s.Block, scores)
+			}
+			seen[s.Block] = true
+			if s.Score <= 0 || s.Score > 1 {
+				t.Errorf("pos=%d: block %d has score %f", pos, s.Block, s.Score)
+			}
+			if i > 0 && s.Score > scores[i-1].Score {
+				t.Errorf("pos=%d: score rises from %f to %f at rank %d", pos, scores[i-1].Score, s.Score, i)
+			}
+		}
+		center := hf.Slope*float64(pos) + hf.Intercept
+		if len(scores) > 1 && math.Abs(float64(scores[1].Block)-center) < math.Abs(float64(scores[0].Block)-center) {
+			t.Errorf("pos=%d: block %d is closer to %f than the first block %d", pos, scores[1].Block, center, scores[0].Block)
+		}
+	}
+
+	empty := TrainHybridFilter(nil, nil, 4, DefaultHybridConfig())
+	want := []BlockScore{{0, 1}, {1, 1}, {2, 1}, {3, 1}}
+	if got := empty.PredictWeighted(5); !slices.Equal(got, want) {
+		t.Errorf("Empty filter: got %v, want %v", got, want)
+	}
+}
+
+func TestHybridFilterEvaluateAccuracy(t *testing.T) {
+	keyCount := 20000
+	numBlocks := 100
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+	// Train on every other key and evaluate on the rest.
+	split := func(xs []uint32) (train, heldOut []uint32) {
+		for i, x := range xs {
+			if i%2 == 0 {
+				train = append(train, x)
+			} else {
+				heldOut = append(heldOut, x)
+			}
+		}
+		return train, heldOut
+	}
+	trainBlocks, heldOutBlocks := split(blocks)
+
+	sorted := make([]uint32, keyCount)
+	for i := range sorted {
+		sorted[i] = uint32(i) * 100
+	}
+	trainKeys, heldOutKeys := split(sorted)
+	hf := TrainHybridFilter(trainKeys, trainBlocks, numBlocks, DefaultHybridConfig())
+	report, err := hf.EvaluateAccuracy(heldOutKeys, heldOutBlocks)
+	if err != nil {
+		t.Fatalf("EvaluateAccuracy: %v", err)
+	}
+	t.Logf("Sorted: %+v", report)
+	if report.HitRate < 0.99 || report.AvgRangeWidth > 5 || report.MaxRangeWidth > 5 {
+		t.Errorf("Sorted data: expected high hit rate and narrow ranges, got %+v", report)
+	}
+
+	hashed := make([]uint32, keyCount)
+	for i := range hashed {
+		hashed[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+	}
+	trainKeys, heldOutKeys = split(hashed)
+	hf = TrainHybridFilter(trainKeys, trainBlocks, numBlocks, DefaultHybridConfig())
+	if report, err = hf.EvaluateAccuracy(heldOutKeys, heldOutBlocks); err != nil {
+		t.Fatalf("EvaluateAccuracy: %v", err)
+	}
+	t.Logf("Hashed: %+v", report)
+	if report.AvgRangeWidth < float64(numBlocks)/2 {
+		t.Errorf("Hashed data: expected ranges over most of the table, got %+v", report)
+	}
+
+	if got, err := hf.EvaluateAccuracy(nil, nil); err != nil || got != (AccuracyReport{}) {
+		t.Errorf("Empty set: got %+v, %v", got, err)
+	}
+	if _, err := hf.EvaluateAccuracy(heldOutKeys, heldOutBlocks[1:]); err == nil {
+		t.Error("Expected an error for mismatched lengths")
+	}
+}
+
+func TestHybridFilterSkipLearned(t *testing.T) {
+	keyCount := 10000
+	numBlocks := 100
+	hashes := make([]uint32, keyCount)
+	for i := range hashes {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+	}
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+	config := HybridFilterConfig{BloomSizeBytes: keyCount * 10 / 8, TargetFPRate: 0.01}
+	full := TrainHybridFilter(hashes, blocks, numBlocks, config)
+	config.SkipLearned = true
+	hf := TrainHybridFilter(hashes, blocks, numBlocks, config)
+
+	if !bytes.Equal(hf.BloomBits, full.BloomBits) || hf.BloomHashK != full.BloomHashK {
+		t.Error("SkipLearned changed the bloom component")
+	}
+	if hf.Slope != 0 || hf.Intercept != 0 || hf.MinErr != 0 || hf.MaxErr != int32(numBlocks-1) {
+		t.Errorf("Unexpected learned fields: %+v", hf.Stats())
+	}
+	for i, h := range hashes {
+		if minB, maxB := hf.PredictRange(h); minB != 0 || maxB != numBlocks-1 {
+			t.Fatalf("Key %d: PredictRange = [%d,%d], want [0,%d]", i, minB, maxB, numBlocks-1)
+		}
+		if maybe, _, _ := hf.Query(h); !maybe {
+			t.Fatalf("Key %d: bloom-only Query missed a present key", i)
+		}
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		h := rng.Uint32()
+		if maybe, _, _ := hf.Query(h); maybe != full.MayContain(h) {
+			t.Fatalf("Query(%d) = %v, bloom says %v", h, maybe, full.MayContain(h))
+		}
+	}
+
+	// The filter survives serialization like any other.
+	restored, err := DeserializeHybridFilter(hf.Serialize(), len(hf.BloomBits))
+	if err != nil {
+		t.Fatalf("DeserializeHybridFilter: %v", err)
+	}
+	if minB, maxB := restored.PredictRange(hashes[0]); minB != 0 || maxB != numBlocks-1 {
+		t.Errorf("Restored PredictRange = [%d,%d], want [0,%d]", minB, maxB, numBlocks-1)
+	}
+}
+
+func TestHybridFilterErrorPercentile(t *testing.T) {
+	keyCount := 10000
+	numBlocks := 100
+	positions := make([]uint32, keyCount)
+	blocks := make([]uint32, keyCount)
+	for i := 0; i < keyCount; i++ {
+		positions[i] = uint32(i)
+		blocks[i] = uint32(i / (keyCount / numBlocks))
+	}
+	// A handful of outliers sit far from where the model puts them.
+	for _, i := range []int{10, 2000, 7000} {
+		blocks[i] = uint32(numBlocks - 1 - int(blocks[i]))
+	}
+
+	avgRange := func(hf *HybridFilter) (avg float64, missed int) {
+		total := 0
+		for i, pos := range positions {
+			minB, maxB := hf.PredictRange(pos)
+			total += maxB - minB + 1
+			if int(blocks[i]) < minB || int(blocks[i]) > maxB {
+				missed++
+			}
+		}
+		return float64(total) / float64(keyCount), missed
+	}
+
+	worst := TrainHybridFilterWithPositions(positions, positions, blocks, numBlocks, DefaultHybridConfig())
+	config := DefaultHybridConfig()
+	config.ErrorPercentile = 0.99
+	p99 := TrainHybridFilterWithPositions(positions, positions, blocks, numBlocks, config)
+
+	worstAvg, worstMissed := avgRange(worst)
+	p99Avg, p99Missed := avgRange(p99)
+	t.Logf("Worst case: avg range %.1f blocks, %d misses", worstAvg, worstMissed)
+	t.Logf("p99:        avg range %.1f blocks, %d misses", p99Avg, p99Missed)
+
+	if worst.ProbabilisticBounds || worstMissed != 0 {
+		t.Errorf("Worst-case bounds must be exact: probabilistic=%v, %d misses",
+			worst.ProbabilisticBounds, worstMissed)
+	}
+	if !p99.ProbabilisticBounds {
+		t.Error("Expected p99 bounds to be flagged as probabilistic")
+	}
+	if p99Avg*2 > worstAvg {
+		t.Errorf("Expected p99 range (%.1f) to be much tighter than worst case (%.1f)", p99Avg, worstAvg)
+	}
+	if p99Missed > keyCount/100 {
+		t.Errorf("p99 bounds missed %d keys, more than 1%%", p99Missed)
+	}
+
+	restored, err := DeserializeHybridFilter(p99.Serialize(), config.BloomSizeBytes)
+	if err != nil || !restored.ProbabilisticBounds {
+		t.Errorf("Expected probabilistic flag to survive serialization: %v", err)
+	}
+}
+
+func TestHybridFilterDuplicateKeys(t *testing.T) {
+	keyCount := 10000
+	numBlocks := 100
+	positions := make([]uint32, keyCount)
+	blocks := make([]uint32, keyCount)
+	for i := 0; i < keyCount; i++ {
+		positions[i] = uint32(i)
+		blocks[i] = uint32(i / (keyCount / numBlocks))
+	}
+	// Collide keys across block boundaries, as distinct keys with equal hashes
+	// would. The last pair shares a block and is not a conflict.
+	pairs := [][2]int{{99, 100}, {1234, 1500}, {5000, 9000}, {7000, 7001}}
+	for _, p := range pairs {
+		positions[p[1]] = positions[p[0]]
+	}
+
+	for _, percentile := range []float64{0, 0.9} {
+		config := DefaultHybridConfig()
+		config.ErrorPercentile = percentile
+		hf := TrainHybridFilterWithPositions(positions, positions, blocks, numBlocks, config)
+		if got := hf.Stats().DuplicateKeys; got != 6 {
+			t.Errorf("percentile %.1f: DuplicateKeys = %d, want 6", percentile, got)
+		}
+		for _, p := range pairs {
+			for _, i := range p {
+				if minB, maxB := hf.PredictRange(positions[i]); int(blocks[i]) < minB || int(blocks[i]) > maxB {
+					t.Errorf("percentile %.1f: colliding key %d in block %d, predicted [%d,%d]",
+						percentile, i, blocks[i], minB, maxB)
+				}
+			}
+		}
+	}
+
+	clean := TrainHybridFilter(GenerateSortedKeyHashes(keyCount), blocks, numBlocks, DefaultHybridConfig())
+	if got := clean.Stats().DuplicateKeys; got != 0 {
+		t.Errorf("DuplicateKeys = %d without collisions", got)
+	}
+}
+
+func TestTrainHybridFilterFiltered(t *testing.T) {
+	keyCount := 10000
+	numBlocks := 100
+	hashes := GenerateSortedKeyHashes(keyCount)
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+	config := HybridFilterConfig{BloomSizeBytes: keyCount * 10 / 8}
+
+	full := TrainHybridFilter(hashes, blocks, numBlocks, config)
+	// Every other key is a tombstone.
+	live := func(i int) bool { return i%2 == 0 }
+	hf := TrainHybridFilterFiltered(hashes, blocks, numBlocks, config, live)
+
+	if hf.KeyCount != uint32(keyCount/2) {
+		t.Errorf("Expected %d live keys, got %d", keyCount/2, hf.KeyCount)
+	}
+	if hf.MaxPos != full.MaxPos {
+		t.Errorf("Expected MaxPos %d to be kept, got %d", full.MaxPos, hf.MaxPos)
+	}
+	t.Logf("Full: k=%d density %.3f; live only: k=%d density %.3f",
+		full.BloomHashK, full.Stats().BloomBitDensity, hf.BloomHashK, hf.Stats().BloomBitDensity)
+
+	deletedHits := 0
+	for i, h := range hashes {
+		if live(i) {
+			if !hf.MayContain(h) {
+				t.Fatalf("Live key %d: false negative", i)
+			}
+			minB, maxB := hf.PredictRange(h)
+			if int(blocks[i]) < minB || int(blocks[i]) > maxB {
+				t.Fatalf("Live key %d: block %d not in range [%d,%d]", i, blocks[i], minB, maxB)
+			}
+		} else if hf.MayContain(h) {
+			deletedHits++
+		}
+	}
+	// The bloom has twice the bits per key it was sized for, so deleted keys
+	// pass only at a low false positive rate.
+	if rate := float64(deletedHits) / float64(keyCount/2); rate > 0.01 {
+		t.Errorf("Deleted keys pass the filter at %.2f%%, expected a low FP rate", rate*100)
+	}
+}
+
+func TestHybridFilterWriteToReadFrom(t *testing.T) {
+	keyCount := 5000
+	numBlocks := 50
+	hashes := GenerateSortedKeyHashes(keyCount)
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+	hf := TrainHybridFilter(hashes, blocks, numBlocks, HybridFilterConfig{BloomSizeBytes: 1024})
+	hf.SetTimestampRange(100, 200)
+	other := TrainHybridFilter(hashes[:100], blocks[:100], 1, HybridFilterConfig{BloomSizeBytes: 64})
+
+	var buf bytes.Buffer
+	n, err := hf.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if want := int64(4 + hf.SerializedSize()); n != want || int64(buf.Len()) != want {
+		t.Errorf("WriteTo reported %d bytes and wrote %d, want %d", n, buf.Len(), want)
+	}
+	if !bytes.Equal(buf.Bytes()[4:], hf.Serialize()) {
+		t.Error("Streamed bytes after the size prefix differ from Serialize")
+	}
+	if _, err := other.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+
+	// Filters written back to back are read back one at a time.
+	for _, want := range []*HybridFilter{hf, other} {
+		got, err := ReadHybridFilterFrom(&buf)
+		if err != nil {
+			t.Fatalf("ReadHybridFilterFrom: %v", err)
+		}
+		if !bytes.Equal(got.Serialize(), want.Serialize()) {
+			t.Error("Filter read back differs from the one written")
+		}
+	}
+	if _, err := ReadHybridFilterFrom(&buf); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected io.EOF on an empty stream, got %v", err)
+	}
+
+	// Truncated streams.
+	var full bytes.Buffer
+	hf.WriteTo(&full)
+	for _, cut := range []int{3, 10, 500, full.Len() - 1} {
+		_, err := ReadHybridFilterFrom(bytes.NewReader(full.Bytes()[:cut]))
+		if !errors.Is(err, io.ErrUnexpectedEOF) || !errors.Is(err, ErrShortBuffer) {
+			t.Errorf("cut at %d: expected io.ErrUnexpectedEOF and ErrShortBuffer, got %v", cut, err)
+		}
+	}
+
+	// The bloom size is untrusted: a header claiming a huge bloom over a
+	// short stream must fail without allocating what it claims.
+	for _, tc := range []struct {
+		bloomSize uint32
+		want      error
+	}{
+		{400 << 20, ErrShortBuffer},
+		{math.MaxUint32, ErrUnsupportedVersion},
+	} {
+		huge := bytes.Clone(full.Bytes())
+		binary.LittleEndian.PutUint32(huge, tc.bloomSize)
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := ReadHybridFilterFrom(bytes.NewReader(huge))
+		runtime.ReadMemStats(&after)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("Bloom size %d: expected %v, got %v", tc.bloomSize, tc.want, err)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+			t.Errorf("Bloom size %d: allocated %d bytes for a %d-byte stream", tc.bloomSize, allocated, len(huge))
+		}
+	}
+	bad := bytes.Clone(full.Bytes())
+	bad[4] = 'X'
+	if _, err := ReadHybridFilterFrom(bytes.NewReader(bad)); !errors.Is(err, ErrBadMagic) {
+		t.Errorf("Expected ErrBadMagic, got %v", err)
+	}
+}
+
+// TestHybridFilterReadFromPipe streams a filter through an io.Pipe and reads
+// it back one byte per Read call.
+func TestHybridFilterReadFromPipe(t *testing.T) {
+	keyCount := 5000
+	numBlocks := 50
+	hashes := GenerateSortedKeyHashes(keyCount)
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+	hf := TrainHybridFilter(hashes, blocks, numBlocks, HybridFilterConfig{BloomSizeBytes: 1024})
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := hf.WriteTo(pw)
+		pw.CloseWithError(err)
+	}()
+	var got HybridFilter
+	n, err := got.ReadFrom(iotest.OneByteReader(pr))
+	if err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	if n != int64(4+hf.SerializedSize()) {
+		t.Errorf("ReadFrom reported %d bytes, want %d", n, 4+hf.SerializedSize())
+	}
+	if !bytes.Equal(got.Serialize(), hf.Serialize()) {
+		t.Error("Filter read through the pipe differs from the one written")
+	}
+	for _, h := range hashes[:100] {
+		if !got.MayContain(h) {
+			t.Fatalf("False negative for %d after streaming", h)
+		}
+	}
+}
+
+func TestHybridFilterJSONRoundtrip(t *testing.T) {
+	keyCount := 5000
+	numBlocks := 50
+	hashes := GenerateSortedKeyHashes(keyCount)
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+	hf := TrainHybridFilter(hashes, blocks, numBlocks, HybridFilterConfig{BloomSizeBytes: 1024})
+	hf.SetTimestampRange(100, 200)
+
+	data, err := json.Marshal(hf)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	t.Logf("JSON: %.160s...", data)
+	for _, field := range []string{`"slope"`, `"intercept"`, `"min_err"`, `"max_err"`,
+		`"max_pos"`, `"key_count"`, `"bloom_hash_k"`, `"bloom_bits"`} {
+		if !strings.Contains(string(data), field) {
+			t.Errorf("Expected field %s in JSON", field)
+		}
+	}
+
+	var restored HybridFilter
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !bytes.Equal(restored.Serialize(), hf.Serialize()) {
+		t.Error("Restored filter differs from original")
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	probes := append(hashes[:100:100], make([]uint32, 1000)...)
+	for i := 100; i < len(probes); i++ {
+		probes[i] = rng.Uint32()
+	}
+	for _, h := range probes {
+		if restored.MayContain(h) != hf.MayContain(h) {
+			t.Fatalf("MayContain(%d) differs after roundtrip", h)
+		}
+		minA, maxA := restored.PredictRange(h)
+		minB, maxB := hf.PredictRange(h)
+		if minA != minB || maxA != maxB {
+			t.Fatalf("PredictRange(%d) differs after roundtrip: [%d,%d] vs [%d,%d]",
+				h, minA, maxA, minB, maxB)
+		}
+	}
+
+	var bad HybridFilter
+	if err := json.Unmarshal([]byte(`{"round_mode":3}`), &bad); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Unknown round mode: got %v, want ErrUnsupportedVersion", err)
+	}
+}
+
+func TestHybridFilterGobRoundtrip(t *testing.T) {
+	keyCount := 5000
+	hashes := GenerateSortedKeyHashes(keyCount)
+	blocks := GenerateBlockIndices(keyCount, 50)
+	hf := TrainHybridFilter(hashes, blocks, 50, HybridFilterConfig{BloomSizeBytes: 256, ErrorPercentile: 0.99})
+	hf.MinTimestamp, hf.MaxTimestamp = 10, 20
+
+	type table struct {
+		Name   string
+		Filter *HybridFilter
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(table{"t1", hf}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	var got table
+	if err := gob.NewDecoder(&buf).Decode(&got); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if got.Name != "t1" || !bytes.Equal(got.Filter.Serialize(), hf.Serialize()) {
+		t.Fatal("Gob roundtrip changed the filter")
+	}
+	rng := rand.New(rand.NewSource(1))
+	probes := append(hashes[:500:500], make([]uint32, 500)...)
+	for i := 500; i < len(probes); i++ {
+		probes[i] = rng.Uint32()
+	}
+	for _, h := range probes {
+		wantMaybe, wantLo, wantHi := hf.Query(h)
+		if maybe, lo, hi := got.Filter.Query(h); maybe != wantMaybe || lo != wantLo || hi != wantHi {
+			t.Fatalf("Query(%d) = %v [%d,%d] after gob, want %v [%d,%d]", h, maybe, lo, hi, wantMaybe, wantLo, wantHi)
+		}
+	}
+
+	payload, err := hf.GobEncode()
+	if err != nil {
+		t.Fatalf("GobEncode: %v", err)
+	}
+	var decoded HybridFilter
+	if err := decoded.GobDecode(payload[:len(payload)-1]); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Truncated payload: expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if err := decoded.GobDecode(append(payload, 0)); err == nil {
+		t.Error("Expected an error for trailing bytes")
+	}
+	payload[4+2] = 99 // Format version
+	if err := decoded.GobDecode(payload); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Bad version: expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestHybridQueryDetailed(t *testing.T) {
+	keyCount := 10000
+	numBlocks := 100
+	positions := make([]uint32, keyCount)
+	for i := range positions {
+		positions[i] = uint32(i)
+	}
+	hashes := GenerateSortedKeyHashes(keyCount)
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+	config := HybridFilterConfig{BloomSizeBytes: 16 * 1024}
+
+	// Narrow range: order-preserving "hashes" that the model can learn.
+	hf := TrainHybridFilter(positions, blocks, numBlocks, config)
+	r := hf.QueryDetailed(positions[5000])
+	if !r.MaybePresent || r.SkippedByBloom {
+		t.Fatalf("Expected present key, got %+v", r)
+	}
+	if r.RangeWidth > 5 || r.Confidence < 0.2 {
+		t.Errorf("Expected a narrow confident range, got %+v", r)
+	}
+	if r.RangeWidth != r.MaxBlock-r.MinBlock+1 {
+		t.Errorf("RangeWidth %d does not match [%d,%d]", r.RangeWidth, r.MinBlock, r.MaxBlock)
+	}
+
+	// Bloom miss: find a hash the bloom rules out.
+	var miss uint32
+	for miss = 1; hf.MayContain(miss); miss++ {
+	}
+	r = hf.QueryDetailed(miss)
+	if r.MaybePresent || !r.SkippedByBloom || r.RangeWidth != 0 || r.Confidence != 1 {
+		t.Errorf("Expected bloom skip, got %+v", r)
+	}
+
+	// Full range: hashes carry no order, so the model spans the whole table.
+	hf = TrainHybridFilter(hashes, blocks, numBlocks, config)
+	r = hf.QueryDetailed(hashes[5000])
+	if !r.MaybePresent || r.RangeWidth != numBlocks {
+		t.Errorf("Expected a full-table range of %d blocks, got %+v", numBlocks, r)
+	}
+	if want := 1 / float64(numBlocks); math.Abs(r.Confidence-want) > 1e-9 {
+		t.Errorf("Expected confidence %.3f for a full scan, got %.3f", want, r.Confidence)
+	}
+}
+
+// TestHybridFilterConcurrentQueries queries one filter from many goroutines.
+// Run with -race to check that queries do not write to the filter.
+func TestHybridFilterConcurrentQueries(t *testing.T) {
+	keyCount := 10000
+	numBlocks := 100
+	hashes := GenerateSortedKeyHashes(keyCount)
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+	hf := TrainHybridFilter(hashes, blocks, numBlocks, HybridFilterConfig{BloomSizeBytes: 4096})
+
+	type result struct {
+		maybe    bool
+		min, max int
+		detailed QueryResult
+	}
+	rng := rand.New(rand.NewSource(1))
+	probes := append(hashes[:1000:1000], make([]uint32, 1000)...)
+	for i := 1000; i < len(probes); i++ {
+		probes[i] = rng.Uint32()
+	}
+	want := make([]result, len(probes))
+	for i, h := range probes {
+		maybe, lo, hi := hf.Query(h)
+		want[i] = result{maybe, lo, hi, hf.QueryDetailed(h)}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for round := 0; round < 20; round++ {
+				for i, h := range probes {
+					maybe, lo, hi := hf.Query(h)
+					got := result{maybe, lo, hi, hf.QueryDetailed(h)}
+					if got != want[i] || hf.MayContain(h) != want[i].maybe {
+						errs <- fmt.Errorf("goroutine %d: probe %d gave %+v, want %+v", g, i, got, want[i])
+						return
+					}
+					hf.PredictBlock(h)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestHybridFilterUpdate(t *testing.T) {
+	keyCount := 10000
+	numBlocks := 100
+	split := 6000
+	keys := make([]uint32, keyCount)
+	for i := range keys {
+		keys[i] = uint32(i) * 7
+	}
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+	// Big enough that k is capped at 30 both before and after the update, so
+	// the bloom parts are comparable bit for bit.
+	config := HybridFilterConfig{BloomSizeBytes: 64 * 1024}
+
+	incremental := TrainHybridFilter(keys[:split], blocks[:split], int(blocks[split-1])+1, config)
+	if err := incremental.Update(keys[split:], blocks[split:]); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	scratch := TrainHybridFilter(keys, blocks, numBlocks, config)
+
+	if incremental.KeyCount != scratch.KeyCount || incremental.MaxPos != scratch.MaxPos {
+		t.Errorf("KeyCount/MaxPos %d/%d, want %d/%d", incremental.KeyCount, incremental.MaxPos,
+			scratch.KeyCount, scratch.MaxPos)
+	}
+	if !bytes.Equal(incremental.BloomBits, scratch.BloomBits) {
+		t.Error("Bloom bits differ from a from-scratch build")
+	}
+	if math.Abs(incremental.Slope-scratch.Slope) > 1e-9*math.Abs(scratch.Slope) ||
+		math.Abs(incremental.Intercept-scratch.Intercept) > 1e-6 {
+		t.Errorf("Model %.9g*x+%.6f, want %.9g*x+%.6f", incremental.Slope, incremental.Intercept,
+			scratch.Slope, scratch.Intercept)
+	}
+	for i, k := range keys {
+		minBlock, maxBlock := incremental.PredictRange(k)
+		if int(blocks[i]) < minBlock || int(blocks[i]) > maxBlock {
+			t.Fatalf("Key %d: block %d not in updated range [%d,%d]", i, blocks[i], minBlock, maxBlock)
+		}
+	}
+	incRange := incremental.MaxErr - incremental.MinErr
+	scratchRange := scratch.MaxErr - scratch.MinErr
+	t.Logf("Error range: incremental %d, from scratch %d", incRange, scratchRange)
+	// The residual summary may widen the bounds, but not by much on a line.
+	if incRange > scratchRange+4 {
+		t.Errorf("Incremental error range %d much wider than from-scratch %d", incRange, scratchRange)
+	}
+
+	// Updating an empty filter is the same as training on the new keys.
+	empty := TrainHybridFilter(nil, nil, numBlocks, config)
+	if err := empty.Update(keys, blocks); err != nil {
+		t.Fatalf("Update of empty filter: %v", err)
+	}
+	if math.Abs(empty.Slope-scratch.Slope) > 1e-9*math.Abs(scratch.Slope) {
+		t.Errorf("Slope %.9g after updating an empty filter, want %.9g", empty.Slope, scratch.Slope)
+	}
+
+	// A deserialized filter has no regression sums to extend.
+	restored, err := DeserializeHybridFilter(scratch.Serialize(), len(scratch.BloomBits))
+	if err != nil {
+		t.Fatalf("DeserializeHybridFilter: %v", err)
+	}
+	if err := restored.Update(keys[:1], blocks[:1]); err == nil {
+		t.Error("Expected an error updating a filter without regression sums")
+	}
+}
+
+func TestHybridFilterAdaptiveK(t *testing.T) {
+	// 10 keys in 4096 bits: the FP-optimal k would be capped at 30, but a
+	// single hash already gives an FP rate far below the target.
+	keys := []uint32{3, 17, 99, 1000, 4242, 65537, 1 << 20, 1 << 24, 1 << 28, 1 << 31}
+	blocks := GenerateBlockIndices(len(keys), 2)
+	sparse := TrainHybridFilter(keys, blocks, 2, HybridFilterConfig{BloomSizeBytes: 512, TargetFPRate: 0.05})
+	if sparse.BloomHashK != 1 {
+		t.Errorf("BloomHashK = %d for 10 keys in 512 bytes, want 1", sparse.BloomHashK)
+	}
+	if !sparse.Stats().OverProvisioned {
+		t.Errorf("Expected 512-byte bloom over 10 keys to be over-provisioned (density %.4f)",
+			sparse.Stats().BloomBitDensity)
+	}
+
+	keyCount := 10000
+	hashes := make([]uint32, keyCount)
+	for i := range hashes {
+		hashes[i] = rand.Uint32()
+	}
+	blocks = GenerateBlockIndices(keyCount, 100)
+	sized := TrainHybridFilter(hashes, blocks, 100,
+		HybridFilterConfig{BloomSizeBytes: keyCount * 10 / 8, TargetFPRate: 0.01})
+	if sized.Stats().OverProvisioned {
+		t.Errorf("10 bits/key bloom reported as over-provisioned (density %.4f)",
+			sized.Stats().BloomBitDensity)
+	}
+	if fp := bloomFPRate(len(sized.BloomBits)*8, keyCount, int(sized.BloomHashK)); fp > 0.01 {
+		t.Errorf("Analytical FP rate %.4f with k=%d exceeds target 0.01", fp, sized.BloomHashK)
+	}
+	for _, h := range hashes {
+		if !sized.MayContain(h) {
+			t.Fatalf("False negative for %d", h)
+		}
+	}
+}
+
+// randomTrainingSet generates a valid training set for seed: sorted positions
+// drawn from one of several distributions, and blocks following a random line
+// plus noise, clamped to [0, numBlocks).
+func randomTrainingSet(seed int64) (positions, blocks []uint32, numBlocks int) {
+	rng := rand.New(rand.NewSource(seed))
+	n := 1 + rng.Intn(3000)
+	positions = make([]uint32, n)
+	switch rng.Intn(4) {
+	case 0: // Uniform over the whole hash space
+		for i := range positions {
+			positions[i] = rng.Uint32()
+		}
+	case 1: // A few tight clusters
+		centers := make([]uint32, 1+rng.Intn(5))
+		for i := range centers {
+			centers[i] = rng.Uint32()
+		}
+		for i := range positions {
+			c := int64(centers[rng.Intn(len(centers))]) + int64(rng.NormFloat64()*1e5)
+			positions[i] = uint32(min(max(c, 0), math.MaxUint32))
+		}
+	case 2: // Exponential gaps, many duplicates
+		var x float64
+		for i := range positions {
+			x += math.Floor(rng.ExpFloat64() * 1e4)
+			positions[i] = uint32(min(x, math.MaxUint32))
+		}
+	case 3: // Narrow range near the top of the hash space
+		for i := range positions {
+			positions[i] = math.MaxUint32 - uint32(rng.Intn(1000))
+		}
+	}
+	slices.Sort(positions)
+
+	numBlocks = 1 + rng.Intn(2000)
+	span := float64(positions[n-1]-positions[0]) + 1
+	slope := float64(numBlocks) / span * (rng.Float64()*2 - 0.5) // May be negative
+	intercept := rng.Float64()*100 - 50 - slope*float64(positions[0])
+	noise := rng.ExpFloat64() * float64(rng.Intn(20))
+	blocks = make([]uint32, n)
+	for i, p := range positions {
+		b := math.Round(slope*float64(p) + intercept + rng.NormFloat64()*noise)
+		blocks[i] = uint32(min(max(b, 0), float64(numBlocks-1)))
+	}
+	return positions, blocks, numBlocks
+}
+
+// TestPredictRangeContainsTrainingKeys checks, over many random training sets,
+// that every training key's true block lies in its predicted range. This is
+// the guarantee that makes a learned index free of false negatives.
+func TestPredictRangeContainsTrainingKeys(t *testing.T) {
+	seeds := int64(500)
+	if testing.Short() {
+		seeds = 50
+	}
+	for seed := int64(0); seed < seeds; seed++ {
+		positions, blocks, numBlocks := randomTrainingSet(seed)
+
+		hf := TrainHybridFilter(positions, blocks, numBlocks, HybridFilterConfig{BloomSizeBytes: 64})
+		li := TrainLearnedIndex(positions, blocks, numBlocks)
+		for i, p := range positions {
+			if minBlock, maxBlock := hf.PredictRange(p); int(blocks[i]) < minBlock || int(blocks[i]) > maxBlock {
+				t.Fatalf("seed %d: HybridFilter key %d (pos %d) in block %d, predicted [%d,%d]",
+					seed, i, p, blocks[i], minBlock, maxBlock)
+			}
+			if _, minBlock, maxBlock := li.Predict(p); int(blocks[i]) < minBlock || int(blocks[i]) > maxBlock {
+				t.Fatalf("seed %d: LearnedIndex key %d (pos %d) in block %d, predicted [%d,%d]",
+					seed, i, p, blocks[i], minBlock, maxBlock)
+			}
+		}
+	}
+}
+
+// BenchmarkHybridBuild measures build time for all three approaches. Keys are
+// hashed once, before any timing, so that the results measure the builds
+// alone; BenchmarkHashOnly gives the cost of hashing the same keys.
+func BenchmarkHybridBuild(b *testing.B) {
+	sizes := []int{1000, 10000, 100000}
+	numBlocks := 100
+
+	for _, size := range sizes {
+		hashes := GenerateSortedKeyHashes(size)
+		blocks := GenerateBlockIndices(size, numBlocks)
+		bitsPerKey := BloomBitsPerKey(size, 0.01)
+		config := DefaultHybridConfig()
+
+		b.Run(fmt.Sprintf("Bloom/size=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				NewFilter(hashes, bitsPerKey)
+			}
+		})
+
+		b.Run(fmt.Sprintf("Learned/size=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				TrainLearnedIndex(hashes, blocks, numBlocks)
+			}
+		})
+
+		b.Run(fmt.Sprintf("Hybrid/size=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				TrainHybridFilter(hashes, blocks, numBlocks, config)
+			}
+		})
+	}
+}
+
+// BenchmarkHybridBuildSkipLearned measures the build time saved by leaving
+// out the learned component for hashed keys.
+func BenchmarkHybridBuildSkipLearned(b *testing.B) {
+	size := 100000
+	numBlocks := 100
+	hashes := GenerateSortedKeyHashes(size)
+	blocks := GenerateBlockIndices(size, numBlocks)
+	config := HybridFilterConfig{BloomSizeBytes: size * 10 / 8, TargetFPRate: 0.01}
+
+	b.Run("Full", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			TrainHybridFilter(hashes, blocks, numBlocks, config)
+		}
+	})
+
+	config.SkipLearned = true
+	b.Run("SkipLearned", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			TrainHybridFilter(hashes, blocks, numBlocks, config)
+		}
+	})
+}
+
+// BenchmarkHybridBuildReuse compares allocating a fresh filter per table with
+// retraining into a single reused filter.
+func BenchmarkHybridBuildReuse(b *testing.B) {
+	size := 10000
+	numBlocks := 100
+	hashes := GenerateSortedKeyHashes(size)
+	blocks := GenerateBlockIndices(size, numBlocks)
+	config := DefaultHybridConfig()
+
+	b.Run("Fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			TrainHybridFilter(hashes, blocks, numBlocks, config)
+		}
+	})
+
+	b.Run("Reuse", func(b *testing.B) {
+		b.ReportAllocs()
+		hf := &HybridFilter{}
+		for i := 0; i < b.N; i++ {
+			TrainHybridFilterInto(hf, hashes, blocks, numBlocks, config)
+		}
+	})
+}
+
+// BenchmarkHybridQuery measures query time for all three approaches
+func BenchmarkHybridQuery(b *testing.B) {
+	size := 100000
+	numBlocks := 100
+	hashes := make([]uint32, size)
+	blocks := make([]uint32, size)
+	keysPerBlock := size / numBlocks
+
+	for i := 0; i < size; i++ {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+		blocks[i] = uint32(i / keysPerBlock)
+	}
+
+	bitsPerKey := BloomBitsPerKey(size, 0.01)
+	bloomFilter := NewFilter(hashes, bitsPerKey)
+	learnedIndex := TrainLearnedIndex(hashes, blocks, numBlocks)
+	hybridFilter := TrainHybridFilter(hashes, blocks, numBlocks, DefaultHybridConfig())
+
+	b.Run("Bloom/MayContain", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			Filter(bloomFilter).MayContain(rand.Uint32())
+		}
+	})
+
+	b.Run("Learned/Predict", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			learnedIndex.Predict(rand.Uint32())
+		}
+	})
+
+	b.Run("Hybrid/Query", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			hybridFilter.Query(rand.Uint32())
+		}
+	})
+}
+
+// BenchmarkHybridHashBounds compares MayContain for hashes the bounds reject
+// with hashes that reach the bloom.
+func BenchmarkHybridHashBounds(b *testing.B) {
+	keyCount, numBlocks := 100000, 100
+	positions := make([]uint32, keyCount)
+	for i := range positions {
+		positions[i] = 1<<30 + uint32(i)*1000
+	}
+	hf := TrainHybridFilter(positions, GenerateBlockIndices(keyCount, numBlocks), numBlocks, DefaultHybridConfig())
+	inRange, outOfRange := make([]uint32, 1<<12), make([]uint32, 1<<12)
+	rng := rand.New(rand.NewSource(1))
+	for i := range inRange {
+		inRange[i] = hf.MinHash + rng.Uint32()%(hf.MaxHash-hf.MinHash)
+		outOfRange[i] = rng.Uint32() % hf.MinHash
+	}
+
+	sink := 0
+	for _, bm := range []struct {
+		name   string
+		hashes []uint32
+	}{{"InRange", inRange}, {"OutOfRange", outOfRange}} {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if hf.MayContain(bm.hashes[i&(len(bm.hashes)-1)]) {
+					sink++
+				}
+			}
+		})
+	}
+	b.Logf("sink %d", sink)
+}
+
+// BenchmarkHybridPredictBlock compares the single-block fast path with the
+// full range computation.
+func BenchmarkHybridPredictBlock(b *testing.B) {
+	size := 100000
+	numBlocks := 100
+	positions := make([]uint32, size)
+	blocks := make([]uint32, size)
+	for i := 0; i < size; i++ {
+		positions[i] = uint32(i)
+		blocks[i] = uint32(i / (size / numBlocks))
+	}
+	hf := TrainHybridFilterWithPositions(positions, positions, blocks, numBlocks, DefaultHybridConfig())
+
+	// Accumulate results so the calls cannot be optimized away.
+	sink := 0
+	b.Run("PredictBlock", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sink += hf.PredictBlock(uint32(i % size))
+		}
+	})
+
+	b.Run("PredictRange", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			minB, _ := hf.PredictRange(uint32(i % size))
+			sink += minB
+		}
+	})
+	b.Logf("sink %d", sink)
+}
+
+// TestHybridFilterVariations tests different hybrid configurations
+func TestHybridFilterVariations(t *testing.T) {
+	fmt.Println("\n" + strings.Repeat("=", 70))
+	fmt.Println("  HYBRID FILTER SIZE vs ACCURACY TRADE-OFF")
+	fmt.Println(strings.Repeat("=", 70))
+
+	keyCount := 10000
+	numBlocks := 100
+
+	hashes := make([]uint32, keyCount)
+	blocks := make([]uint32, keyCount)
+	keysPerBlock := keyCount / numBlocks
+
+	for i := 0; i < keyCount; i++ {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+		blocks[i] = uint32(i / keysPerBlock)
+	}
+
+	// Test different bloom sizes
+	bloomSizes := []int{16, 32, 64, 128, 256}
+
+	fmt.Printf("\n  %-12s %-12s %-15s %-15s\n",
+		"Bloom Size", "Total Size", "FP Rate", "Tables Skipped")
+	fmt.Println(strings.Repeat("-", 60))
+
+	for _, bloomSize := range bloomSizes {
+		config := HybridFilterConfig{
+			BloomSizeBytes: bloomSize,
+			TargetFPRate:   0.05,
+		}
+		hf := TrainHybridFilter(hashes, blocks, numBlocks, config)
+		stats := hf.Stats()
+
+		// Measure FP rate
+		fp := 0
+		tests := 10000
+		for i := 0; i < tests; i++ {
+			if hf.MayContain(rand.Uint32()) {
+				fp++
+			}
+		}
+		fpRate := float64(fp) / float64(tests) * 100
+
+		fmt.Printf("  %-12d %-12d %-15.2f%% %-15.1f%%\n",
+			bloomSize, stats.TotalSizeBytes, fpRate, 100-fpRate)
+	}
+
+	fmt.Println("\n  Insight: Even a 16-byte bloom component can skip ~70% of tables!")
+}
+
+func TestHybridFilterQueryTrace(t *testing.T) {
+	keyCount, numBlocks := 5000, 50
+	keys := GenerateSortedKeyHashes(keyCount)
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+	rng := rand.New(rand.NewSource(1))
+	queries := append([]uint32(nil), keys[:500]...)
+	for i := 0; i < 500; i++ {
+		queries = append(queries, rng.Uint32())
+	}
+
+	for _, mode := range []RoundMode{RoundNearest, RoundFloor, RoundCeil} {
+		hf := TrainHybridFilter(keys, blocks, numBlocks, HybridFilterConfig{BloomSizeBytes: 512, RoundMode: mode})
+		for _, h := range queries {
+			qt := hf.QueryTrace(h)
+			if len(qt.Probes) != int(hf.BloomHashK) {
+				t.Fatalf("mode %d: hash %d traced %d bit checks, want %d", mode, h, len(qt.Probes), hf.BloomHashK)
+			}
+			allSet := true
+			for _, p := range qt.Probes {
+				allSet = allSet && p.Set
+			}
+			if qt.MayContain != hf.MayContain(h) || qt.MayContain != (qt.InHashBounds && allSet) {
+				t.Fatalf("mode %d: hash %d traced MayContain %v, in bounds %v, bits set %v",
+					mode, h, qt.MayContain, qt.InHashBounds, allSet)
+			}
+			if center := min(max(qt.PredictedBlock, 0), int(hf.MaxPos)); center != hf.PredictBlock(h) {
+				t.Fatalf("mode %d: hash %d traced block %d (pos %.3f), PredictBlock %d",
+					mode, h, qt.PredictedBlock, qt.PredictedPos, hf.PredictBlock(h))
+			}
+			if minB, maxB := hf.PredictRange(h); qt.MinBlock != minB || qt.MaxBlock != maxB ||
+				minB != min(max(qt.PredictedBlock+int(qt.MinErr), 0), int(hf.MaxPos)) {
+				t.Fatalf("mode %d: hash %d traced range [%d, %d], PredictRange [%d, %d]",
+					mode, h, qt.MinBlock, qt.MaxBlock, minB, maxB)
+			}
+		}
+	}
+
+	empty := TrainHybridFilter(nil, nil, numBlocks, DefaultHybridConfig())
+	if qt := empty.QueryTrace(keys[0]); qt.PredictedBlock != 0 || qt.MinBlock != 0 || qt.MaxBlock != numBlocks-1 {
+		t.Errorf("Untrained filter traced block %d, range [%d, %d]", qt.PredictedBlock, qt.MinBlock, qt.MaxBlock)
+	}
+}
+
+func TestHybridFilterKeysPerBlockEstimate(t *testing.T) {
+	keyCount, keysPerBlock := 10000, 100
+	positions := make([]uint32, keyCount)
+	for i := range positions {
+		positions[i] = uint32(i)
+	}
+	numBlocks := keyCount / keysPerBlock
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+
+	hf := TrainHybridFilter(positions, blocks, numBlocks, DefaultHybridConfig())
+	if got := hf.KeysPerBlockEstimate(); math.Abs(got-float64(keysPerBlock)) > 0.01*float64(keysPerBlock) {
+		t.Errorf("Expected ~%d keys per block, got %f", keysPerBlock, got)
+	}
+
+	config := DefaultHybridConfig()
+	config.SkipLearned = true
+	for name, hf := range map[string]*HybridFilter{
+		"untrained":    TrainHybridFilter(nil, nil, numBlocks, DefaultHybridConfig()),
+		"skip learned": TrainHybridFilter(positions, blocks, numBlocks, config),
+		"flat":         TrainHybridFilter([]uint32{7}, []uint32{3}, 4, DefaultHybridConfig()),
+	} {
+		if got := hf.KeysPerBlockEstimate(); got != 0 {
+			t.Errorf("%s: expected 0, got %f", name, got)
+		}
+	}
+}
+
+func TestHybridFilterString(t *testing.T) {
+	keyCount, numBlocks := 10000, 100
+	positions := make([]uint32, keyCount)
+	blocks := make([]uint32, keyCount)
+	for i := range positions {
+		positions[i] = uint32(i)
+		blocks[i] = uint32(i * numBlocks / keyCount)
+	}
+	hf := TrainHybridFilter(positions, blocks, numBlocks, DefaultHybridConfig())
+
+	s := fmt.Sprint(hf)
+	for _, want := range []string{
+		"keys=10000",
+		fmt.Sprintf("k=%d", hf.BloomHashK),
+		fmt.Sprintf("bloom=%dB", len(hf.BloomBits)),
+		fmt.Sprintf("range=[%d,%d]", hf.MinErr, hf.MaxErr),
+		"informative=true",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("String() = %q, missing %q", s, want)
+		}
+	}
+	if strings.Contains(s, "\n") {
+		t.Errorf("String() = %q, want a single line", s)
+	}
+	if got := (*HybridFilter)(nil).String(); got != "HybridFilter(nil)" {
+		t.Errorf("nil String() = %q", got)
+	}
+}
+
+func TestHybridFilterMaxRangeBlocks(t *testing.T) {
+	keyCount, numBlocks := 10000, 100
+	hashes := GenerateSortedKeyHashes(keyCount)
+	positions := make([]uint32, keyCount)
+	for i := range positions {
+		positions[i] = uint32(i)
+	}
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+	config := DefaultHybridConfig()
+	config.MaxRangeBlocks = numBlocks / 5
+
+	// Fitted on hashes, the model spans about the whole table for every key.
+	wide := TrainHybridFilter(hashes, blocks, numBlocks, config)
+	if minB, maxB := wide.PredictRange(hashes[0]); maxB-minB+1 <= config.MaxRangeBlocks {
+		t.Fatalf("Expected a range wider than %d blocks, got [%d,%d]", config.MaxRangeBlocks, minB, maxB)
+	}
+	r := wide.QueryDetailed(hashes[0])
+	if !r.Fallback || !r.MaybePresent || r.MinBlock != 0 || r.MaxBlock != numBlocks-1 || r.RangeWidth != numBlocks {
+		t.Errorf("Wide range: expected fallback to [0,%d], got %+v", numBlocks-1, r)
+	}
+	if ok, minB, maxB := wide.Query(hashes[0]); !ok || minB != 0 || maxB != numBlocks-1 {
+		t.Errorf("Wide range: Query returned (%v, %d, %d)", ok, minB, maxB)
+	}
+
+	// Fitted on positions, it narrows every lookup to a few blocks.
+	narrow := TrainHybridFilter(positions, blocks, numBlocks, config)
+	for i, p := range positions {
+		r := narrow.QueryDetailed(p)
+		minB, maxB := narrow.PredictRange(p)
+		if r.Fallback || r.MinBlock != minB || r.MaxBlock != maxB {
+			t.Fatalf("Key %d: expected [%d,%d] without fallback, got %+v", i, minB, maxB, r)
+		}
+	}
+
+	// The cap is not serialized, and applies once set on the restored filter.
+	restored, err := DeserializeHybridFilter(wide.Serialize(), len(wide.BloomBits))
+	if err != nil {
+		t.Fatalf("DeserializeHybridFilter: %v", err)
+	}
+	if restored.QueryDetailed(hashes[0]).Fallback {
+		t.Error("Fallback without a cap")
+	}
+	restored.MaxRangeBlocks = uint32(config.MaxRangeBlocks)
+	if !restored.QueryDetailed(hashes[0]).Fallback {
+		t.Error("No fallback after setting the cap")
+	}
+}
+
+func TestHybridFilterPredictRangeExtrapolated(t *testing.T) {
+	keyCount, numBlocks := 1000, 10
+	positions := make([]uint32, keyCount)
+	for i := range positions {
+		positions[i] = uint32(1000 + i)
+	}
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+	hf := TrainHybridFilter(positions, blocks, numBlocks, DefaultHybridConfig())
+
+	for _, p := range positions {
+		minB, maxB, extrapolated := hf.PredictRangeExtrapolated(p)
+		if wantMin, wantMax := hf.PredictRange(p); extrapolated || minB != wantMin || maxB != wantMax {
+			t.Fatalf("Trained position %d: [%d, %d] extrapolated %v, want [%d, %d]",
+				p, minB, maxB, extrapolated, wantMin, wantMax)
+		}
+	}
+	for _, tc := range []struct {
+		keyHash          uint32
+		wantMin, wantMax int
+	}{
+		{3000, numBlocks - 1, numBlocks - 1}, // Far beyond the max: clamped to the last block
+		{999, 0, 1},
+		{0, 0, 0},
+	} {
+		minB, maxB, extrapolated := hf.PredictRangeExtrapolated(tc.keyHash)
+		if !extrapolated || minB != tc.wantMin || maxB != tc.wantMax {
+			t.Errorf("Hash %d: [%d, %d] extrapolated %v, want [%d, %d] extrapolated",
+				tc.keyHash, minB, maxB, extrapolated, tc.wantMin, tc.wantMax)
+		}
+	}
+
+	restored, err := DeserializeHybridFilter(hf.Serialize(), len(hf.BloomBits))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, extrapolated := restored.PredictRangeExtrapolated(3000); extrapolated {
+		t.Error("Deserialized filter without hash bounds reported extrapolation")
+	}
+}
+
+func TestHybridFilterNoBloom(t *testing.T) {
+	keyCount, numBlocks := 1000, 10
+	hashes := GenerateSortedKeyHashes(keyCount)
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+	hf := TrainHybridFilter(hashes, blocks, numBlocks, HybridFilterConfig{BloomSizeBytes: 0})
+	if len(hf.BloomBits) != 0 || hf.KeyCount != uint32(keyCount) {
+		t.Fatalf("Expected no bloom bits and %d keys, got %s", keyCount, hf)
+	}
+	for _, h := range hashes {
+		if !hf.MayContain(h) {
+			t.Fatalf("Key hash %d rejected by a filter without bloom bits", h)
+		}
+	}
+}
+
+func TestHybridFilterMaxPosOverride(t *testing.T) {
+	// One table of 10 blocks, 500 to 509 in a global space of 1000 blocks.
+	keyCount, keysPerBlock, firstBlock := 1000, 100, 500
+	positions := make([]uint32, keyCount)
+	blocks := make([]uint32, keyCount)
+	for i := range positions {
+		positions[i] = uint32(firstBlock*keysPerBlock + i)
+		blocks[i] = uint32(firstBlock + i/keysPerBlock)
+	}
+	config := DefaultHybridConfig()
+	config.MaxPosOverride = 999
+	config.StrictBlockIndices = true
+
+	hf, err := TrainHybridFilterContext(context.Background(), positions, blocks, 10, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hf.MaxPos != 999 {
+		t.Fatalf("MaxPos %d, want the override", hf.MaxPos)
+	}
+	for i, p := range positions {
+		if minB, maxB := hf.PredictRange(p); int(blocks[i]) < minB || int(blocks[i]) > maxB {
+			t.Fatalf("Key %d in block %d, predicted [%d, %d]", i, blocks[i], minB, maxB)
+		}
+	}
+	// Beyond the table, predictions run on to the end of the global space.
+	if minB, maxB := hf.PredictRange(1 << 30); minB != 999 || maxB != 999 {
+		t.Errorf("Far key predicted [%d, %d], want [999, 999]", minB, maxB)
+	}
+	if minB, maxB := hf.PredictRange(uint32(520 * keysPerBlock)); minB <= 509 || maxB > 999 {
+		t.Errorf("Key at global block 520 predicted [%d, %d]", minB, maxB)
+	}
+
+	blocks64 := make([]uint64, keyCount)
+	for i, b := range blocks {
+		blocks64[i] = uint64(b)
+	}
+	hf64 := TrainHybridFilter64(positions, blocks64, 10, config)
+	if hf64.MaxPos != 999 {
+		t.Errorf("HybridFilter64 MaxPos %d, want the override", hf64.MaxPos)
+	}
+}
+
+func TestHybridFilterToFilter(t *testing.T) {
+	keyCount, numBlocks := 5000, 50
+	hashes := GenerateSortedKeyHashes(keyCount)
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+	hf := TrainHybridFilter(hashes, blocks, numBlocks, HybridFilterConfig{BloomSizeBytes: 4096})
+	f := hf.ToFilter()
+
+	// The two conventions: BloomBits is all bits with k kept apart, and is
+	// serialized with k in the byte after it; a Filter ends with k itself.
+	if len(f) != len(hf.BloomBits)+1 || f[len(f)-1] != hf.BloomHashK {
+		t.Fatalf("Filter of %d bytes ending in %d, want %d bytes ending in k=%d",
+			len(f), f[len(f)-1], len(hf.BloomBits)+1, hf.BloomHashK)
+	}
+	if stats := f.Stats(); stats.Bits != 8*len(hf.BloomBits) || stats.HashFuncs != int(hf.BloomHashK) {
+		t.Errorf("Filter has %d bits and k=%d, want %d and %d",
+			stats.Bits, stats.HashFuncs, 8*len(hf.BloomBits), hf.BloomHashK)
+	}
+	if data := hf.Serialize(); !bytes.Equal(data[hybridFilterHeaderSize:hybridFilterHeaderSize+len(f)], f) {
+		t.Error("Serialized bloom bits and k differ from the converted Filter")
+	}
+	if _, err := DeserializeFilter(f); err != nil {
+		t.Errorf("DeserializeFilter: %v", err)
+	}
+	if !f.ContainsAll(hashes) {
+		t.Error("Converted Filter has false negatives")
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	var accepted int
+	for i := 0; i < 100000; i++ {
+		h := rng.Uint32()
+		got := f.MayContain(h)
+		if want := hybridBloomMayContain(hf.BloomBits, hf.BloomHashK, h); got != want {
+			t.Fatalf("Key hash %d: Filter says %v, hybrid bloom %v", h, got, want)
+		}
+		if hf.inHashBounds(h) && got != hf.MayContain(h) {
+			t.Fatalf("Key hash %d within bounds: Filter says %v, hybrid %v", h, got, hf.MayContain(h))
+		}
+		if got {
+			accepted++
+		}
+	}
+	if accepted == 0 {
+		t.Error("No random key hash passed the bloom; the comparison only covered misses")
+	}
+
+	// Without bloom bits, the hybrid admits every key, or none if untrained.
+	config := HybridFilterConfig{BloomSizeBytes: 0}
+	for _, tc := range []struct {
+		name string
+		hf   *HybridFilter
+		want bool
+	}{
+		{"no bloom", TrainHybridFilter(hashes, blocks, numBlocks, config), true},
+		{"no bloom, no keys", TrainHybridFilter(nil, nil, numBlocks, config), false},
+	} {
+		f := tc.hf.ToFilter()
+		for _, h := range []uint32{0, 12345, math.MaxUint32} {
+			if f.MayContain(h) != tc.want {
+				t.Errorf("%s: MayContain(%d) = %v, want %v", tc.name, h, f.MayContain(h), tc.want)
+			}
+		}
+	}
+}
+
+func TestHybridFilterNumBlocks(t *testing.T) {
+	keyCount, keysPerBlock, firstBlock := 1000, 100, 500
+	positions := make([]uint32, keyCount)
+	blocks := make([]uint32, keyCount)
+	for i := range positions {
+		positions[i] = uint32(firstBlock*keysPerBlock + i)
+		blocks[i] = uint32(firstBlock + i/keysPerBlock)
+	}
+	config := DefaultHybridConfig()
+	config.MaxPosOverride = 999
+	global := TrainHybridFilter(positions, blocks, 10, config)
+	if global.NumBlocks() != 10 || global.MaxPos != 999 {
+		t.Fatalf("NumBlocks %d, MaxPos %d, want 10 and 999", global.NumBlocks(), global.MaxPos)
+	}
+	if got, want := len(global.Serialize()), HybridFilterSize(config); got != want {
+		t.Errorf("Serialized %d bytes, HybridFilterSize says %d", got, want)
+	}
+	// The block count changes the layout, so it gets versions of its own.
+	if v := global.Serialize()[len(hybridFilterMagic)]; v != hybridFilterVersionNumBlocks {
+		t.Errorf("Serialize wrote version %d, want %d", v, hybridFilterVersionNumBlocks)
+	}
+	if v := global.SerializeCompact()[len(hybridFilterMagic)]; v != hybridFilterVersionCompactNumBlocks {
+		t.Errorf("SerializeCompact wrote version %d, want %d", v, hybridFilterVersionCompactNumBlocks)
+	}
+
+	builder, err := NewHybridFilterBuilder(10, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.Add(positions, blocks); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.FitBounds(positions, blocks); err != nil {
+		t.Fatal(err)
+	}
+	built, err := builder.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built.NumBlocks() != 10 {
+		t.Errorf("Builder: NumBlocks %d, want 10", built.NumBlocks())
+	}
+
+	// Without the override, the block count is MaxPos+1 and is not stored.
+	local := TrainHybridFilter(positions, GenerateBlockIndices(keyCount, 10), 10, DefaultHybridConfig())
+	if local.NumBlocks() != 10 {
+		t.Errorf("Local: NumBlocks %d, want 10", local.NumBlocks())
+	}
+	if data := local.Serialize(); len(data) != HybridFilterSize(DefaultHybridConfig()) ||
+		data[len(hybridFilterMagic)] != hybridFilterVersion {
+		t.Errorf("Local: serialized %d bytes of version %d, want %d of version %d without a block count",
+			len(data), data[len(hybridFilterMagic)], HybridFilterSize(DefaultHybridConfig()), hybridFilterVersion)
+	}
+	if v := local.SerializeCompact()[len(hybridFilterMagic)]; v != hybridFilterVersionCompact {
+		t.Errorf("Local: SerializeCompact wrote version %d, want %d", v, hybridFilterVersionCompact)
+	}
+
+	// Flag bits readers do not know may change the layout, as the block count
+	// would have, so they are rejected rather than ignored.
+	for bit := 3; bit < 8; bit++ {
+		data := local.Serialize()
+		data[len(hybridFilterMagic)+1] |= 1 << bit
+		if _, err := DeserializeHybridFilter(data, len(local.BloomBits)); !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("Flag bit %d: got %v, want ErrUnsupportedVersion", bit, err)
+		}
+	}
+
+	for _, hf := range []*HybridFilter{global, local} {
+		decoders := map[string]func() (*HybridFilter, error){
+			"Serialize": func() (*HybridFilter, error) {
+				return DeserializeHybridFilter(hf.Serialize(), len(hf.BloomBits))
+			},
+			"SerializeCompact": func() (*HybridFilter, error) {
+				return DeserializeHybridFilter(hf.SerializeCompact(), len(hf.BloomBits))
+			},
+			"HybridFilterView": func() (*HybridFilter, error) {
+				return HybridFilterView(hf.Serialize())
+			},
+			"TableFilter": func() (*HybridFilter, error) {
+				f, err := DeserializeTableFilter(FilterKindHybrid, hf.Serialize())
+				if err != nil {
+					return nil, err
+				}
+				return f.(*HybridFilter), nil
+			},
+			"WriteTo": func() (*HybridFilter, error) {
+				var buf bytes.Buffer
+				if _, err := hf.WriteTo(&buf); err != nil {
+					return nil, err
+				}
+				return ReadHybridFilterFrom(&buf)
+			},
+			"JSON": func() (*HybridFilter, error) {
+				data, err := json.Marshal(hf)
+				if err != nil {
+					return nil, err
+				}
+				restored := &HybridFilter{}
+				return restored, json.Unmarshal(data, restored)
+			},
+		}
+		for name, decode := range decoders {
+			restored, err := decode()
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if restored.NumBlocks() != hf.NumBlocks() || restored.MaxPos != hf.MaxPos ||
+				restored.KeyCount != hf.KeyCount || restored.MaxTimestamp != hf.MaxTimestamp {
+				t.Errorf("%s: NumBlocks %d, MaxPos %d, KeyCount %d after roundtrip, want %d, %d, %d", name,
+					restored.NumBlocks(), restored.MaxPos, restored.KeyCount, hf.NumBlocks(), hf.MaxPos, hf.KeyCount)
+			}
+		}
+	}
+}
+
+func TestHybridFilterDescending(t *testing.T) {
+	for _, keyCount := range []int{2, 1000, 10000} {
+		for _, numBlocks := range []int{1, 7, 100} {
+			// Positions in ascending order, stored in blocks in descending order.
+			positions := make([]uint32, keyCount)
+			blocks := make([]uint32, keyCount)
+			ascending := GenerateBlockIndices(keyCount, numBlocks)
+			for i := range positions {
+				positions[i] = uint32(3 * i)
+				blocks[i] = ascending[keyCount-1-i]
+			}
+			li := TrainLearnedIndex(positions, blocks, numBlocks)
+			for _, mode := range []RoundMode{RoundNearest, RoundFloor, RoundCeil} {
+				hf := TrainHybridFilter(positions, blocks, numBlocks, HybridFilterConfig{BloomSizeBytes: 64, RoundMode: mode})
+				if descending := hf.Stats().Descending; descending != (numBlocks > 1) {
+					t.Errorf("n=%d blocks=%d mode %d: Descending %v with slope %g",
+						keyCount, numBlocks, mode, descending, hf.Slope)
+				}
+				for i, p := range positions {
+					minB, maxB := hf.PredictRange(p)
+					if int(blocks[i]) < minB || int(blocks[i]) > maxB {
+						t.Fatalf("n=%d blocks=%d mode %d: key %d in block %d, predicted [%d, %d]",
+							keyCount, numBlocks, mode, i, blocks[i], minB, maxB)
+					}
+					if b := hf.PredictBlock(p); b < minB || b > maxB {
+						t.Fatalf("n=%d blocks=%d mode %d: PredictBlock %d outside [%d, %d]",
+							keyCount, numBlocks, mode, b, minB, maxB)
+					}
+				}
+				// Keys beyond either end extrapolate past the first and last
+				// block, and must still get an ordered range within the table.
+				for _, h := range []uint32{0, 1 << 31, math.MaxUint32} {
+					if minB, maxB := hf.PredictRange(h); minB < 0 || minB > maxB || maxB > numBlocks-1 {
+						t.Fatalf("n=%d blocks=%d mode %d: hash %d predicted [%d, %d]",
+							keyCount, numBlocks, mode, h, minB, maxB)
+					}
+				}
+			}
+			for i, p := range positions {
+				if _, minB, maxB := li.Predict(p); int(blocks[i]) < minB || int(blocks[i]) > maxB {
+					t.Fatalf("n=%d blocks=%d: learned index predicted [%d, %d] for key %d in block %d",
+						keyCount, numBlocks, minB, maxB, i, blocks[i])
+				}
+			}
+		}
+	}
+
+	keys := GenerateSortedKeyHashes(1000)
+	hf := TrainHybridFilter(keys, GenerateBlockIndices(len(keys), 10), 10, DefaultHybridConfig())
+	if hf.Stats().Descending {
+		t.Error("Ascending model reported as descending")
+	}
+}
+
+func TestHybridFilterBatchBuffers(t *testing.T) {
+	keyCount, numBlocks := 10000, 100
+	keys := GenerateSortedKeyHashes(keyCount)
+	hf := TrainHybridFilter(keys, GenerateBlockIndices(keyCount, numBlocks), numBlocks, DefaultHybridConfig())
+	queries := append(slices.Clone(keys[:500]), GenerateSortedKeyHashes(500)...)
+
+	results := hf.QueryBatch(nil, queries)
+	for i, h := range queries {
+		if results[i] != hf.QueryDetailed(h) {
+			t.Fatalf("Query %d: batch %+v, single %+v", i, results[i], hf.QueryDetailed(h))
+		}
+	}
+	prefix := []BlockScore{{Block: -1, Score: 2}}
+	if got := hf.AppendPredictWeighted(slices.Clone(prefix), keys[10]); got[0] != prefix[0] ||
+		!slices.Equal(got[1:], hf.PredictWeighted(keys[10])) {
+		t.Errorf("AppendPredictWeighted after a prefix: %v", got)
+	}
+
+	// With buffers reused, none of the batch APIs allocate.
+	scores := hf.PredictWeighted(keys[0])
+	bloom := NewFilter(keys, 10)
+	mayContain := bloom.MayContainBatch(queries)
+	allocs := testing.AllocsPerRun(100, func() {
+		results = hf.QueryBatch(results[:0], queries)
+		for _, h := range queries[:100] {
+			scores = hf.AppendPredictWeighted(scores[:0], h)
+		}
+		mayContain = bloom.AppendMayContainBatch(mayContain[:0], queries)
+	})
+	if allocs != 0 {
+		t.Errorf("%v allocations per run with reused buffers", allocs)
+	}
+}
+
+// BenchmarkHybridFilterBatchBuffers compares the batch query APIs with a
+// fresh result slice per call and with one reused buffer. Run with -benchmem:
+// the reused variants make no allocations per op.
+func BenchmarkHybridFilterBatchBuffers(b *testing.B) {
+	keyCount, numBlocks := 100000, 1000
+	keys := GenerateSortedKeyHashes(keyCount)
+	hf := TrainHybridFilter(keys, GenerateBlockIndices(keyCount, numBlocks), numBlocks, DefaultHybridConfig())
+	queries := keys[:256]
+
+	b.Run("QueryBatch/Alloc", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			hf.QueryBatch(nil, queries)
+		}
+	})
+	b.Run("QueryBatch/Reused", func(b *testing.B) {
+		b.ReportAllocs()
+		var results []QueryResult
+		for i := 0; i < b.N; i++ {
+			results = hf.QueryBatch(results[:0], queries)
+		}
+	})
+	b.Run("PredictWeighted/Alloc", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			hf.PredictWeighted(queries[i%len(queries)])
+		}
+	})
+	b.Run("PredictWeighted/Reused", func(b *testing.B) {
+		b.ReportAllocs()
+		var scores []BlockScore
+		for i := 0; i < b.N; i++ {
+			scores = hf.AppendPredictWeighted(scores[:0], queries[i%len(queries)])
+		}
+	})
+}
+
+func TestHybridFilterPredictRangeSigma(t *testing.T) {
+	// Blocks scattered around the line with normal noise of 3 blocks.
+	keyCount, keysPerBlock, numBlocks := 100000, 100, 1000
+	positions := make([]uint32, keyCount)
+	blocks := make([]uint32, keyCount)
+	rng := rand.New(rand.NewSource(1))
+	for i := range positions {
+		positions[i] = uint32(i)
+		b := math.Round(float64(i/keysPerBlock) + 3*rng.NormFloat64())
+		blocks[i] = uint32(min(max(b, 0), float64(numBlocks-1)))
+	}
+	hf := TrainHybridFilter(positions, blocks, numBlocks, DefaultHybridConfig())
+	mean, stdDev, ok := hf.ResidualMoments()
+	if !ok || math.Abs(mean) > 0.1 || stdDev < 2.5 || stdDev > 3.5 {
+		t.Fatalf("Residual moments %v, %v, %v; want a mean near 0 and a deviation near 3", mean, stdDev, ok)
+	}
+
+	contained := func(sigmas float64) (fraction, avgWidth float64) {
+		hits, width := 0, 0
+		for i, p := range positions {
+			minB, maxB := hf.PredictRangeSigma(p, sigmas)
+			if minB > maxB {
+				t.Fatalf("Key %d: unordered range [%d, %d]", i, minB, maxB)
+			}
+			if int(blocks[i]) >= minB && int(blocks[i]) <= maxB {
+				hits++
+			}
+			width += maxB - minB + 1
+		}
+		return float64(hits) / float64(keyCount), float64(width) / float64(keyCount)
+	}
+	fraction, width := contained(2)
+	t.Logf("2 sigmas: %.4f of keys in %.1f blocks on average, PredictRange %d blocks",
+		fraction, width, hf.MaxErr-hf.MinErr+1)
+	if fraction < 0.93 || fraction > 0.97 {
+		t.Errorf("2 sigmas contain %.4f of keys, want about 0.95", fraction)
+	}
+	if width >= float64(hf.MaxErr-hf.MinErr+1) {
+		t.Errorf("2-sigma range of %.1f blocks is not narrower than PredictRange", width)
+	}
+	if fraction, _ := contained(3); fraction < 0.99 {
+		t.Errorf("3 sigmas contain %.4f of keys, want about 0.997", fraction)
+	}
+	if minB, maxB := hf.PredictRangeSigma(positions[50000], 0); minB != maxB {
+		t.Errorf("0 sigmas: range [%d, %d], want one block", minB, maxB)
+	}
+
+	// Without moments, the hard bounds are used.
+	restored, err := DeserializeHybridFilter(hf.Serialize(), len(hf.BloomBits))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := restored.ResidualMoments(); ok {
+		t.Error("Deserialized filter has residual moments")
+	}
+	for _, p := range positions[:100] {
+		minB, maxB := restored.PredictRangeSigma(p, 2)
+		if wantMin, wantMax := restored.PredictRange(p); minB != wantMin || maxB != wantMax {
+			t.Fatalf("Deserialized filter: [%d, %d], want PredictRange [%d, %d]", minB, maxB, wantMin, wantMax)
+		}
+	}
+}
+
+func TestShouldRebuild(t *testing.T) {
+	keyCount, keysPerBlock, numBlocks := 10000, 100, 100
+	sample := func(seed int64, offset uint32, perBlock int) ([]uint32, []uint32) {
+		rng := rand.New(rand.NewSource(seed))
+		positions := make([]uint32, keyCount)
+		blocks := make([]uint32, keyCount)
+		for i := range positions {
+			p := rng.Intn(numBlocks * perBlock)
+			positions[i] = offset + uint32(p)
+			blocks[i] = uint32(p / perBlock)
+		}
+		return positions, blocks
+	}
+	positions, blocks := sample(1, 0, keysPerBlock)
+	old := TrainHybridFilter(positions, blocks, numBlocks, DefaultHybridConfig())
+
+	shouldRebuild := func(old *HybridFilter, keyHashes, blockIndices []uint32) bool {
+		t.Helper()
+		rebuild, err := ShouldRebuild(old, keyHashes, blockIndices)
+		if err != nil {
+			t.Fatalf("ShouldRebuild: %v", err)
+		}
+		return rebuild
+	}
+	same, sameBlocks := sample(2, 0, keysPerBlock)
+	if shouldRebuild(old, same, sameBlocks) {
+		t.Error("Rebuild for a new sample of the same distribution")
+	}
+	shifted, shiftedBlocks := sample(3, 5*uint32(keysPerBlock), keysPerBlock)
+	if !shouldRebuild(old, shifted, shiftedBlocks) {
+		t.Error("No rebuild for keys shifted by 5 blocks")
+	}
+	denser, denserBlocks := sample(4, 0, 2*keysPerBlock)
+	if !shouldRebuild(old, denser, denserBlocks) {
+		t.Error("No rebuild for twice as many keys per block")
+	}
+
+	if shouldRebuild(old, nil, nil) {
+		t.Error("Rebuild for no new keys")
+	}
+	if !shouldRebuild(TrainHybridFilter(nil, nil, numBlocks, DefaultHybridConfig()), same, sameBlocks) {
+		t.Error("No rebuild of a filter without a model")
+	}
+	if _, err := ShouldRebuild(old, same, sameBlocks[1:]); err == nil {
+		t.Error("Expected an error for mismatched lengths")
+	}
 }
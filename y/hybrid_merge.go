@@ -0,0 +1,177 @@
+/*
+ * Merging hybrid filters during compaction
+ *
+ * When two tables with adjacent key ranges are compacted into one, the output
+ * filter can be derived from the input filters without re-reading any keys:
+ * the bloom parts are OR-ed together and the learned parts are refit from
+ * their summaries.
+ */
+
+package y
+
+import (
+	"fmt"
+	"math"
+)
+
+// MergeHybrid combines the filters of two tables whose key ranges are adjacent,
+// with every key of a sorting before every key of b, into the filter of the
+// table formed by concatenating them.
+//
+// The bloom parts are OR-ed, which requires both to have the same size and
+// number of hash functions. Training picks the number of hash functions from
+// the key count, so tables of different sizes usually get different ones;
+// train the filters of tables that may be merged with the same
+// HybridFilterConfig.BloomHashK. The learned parts must have been trained on key
+// positions (see TrainHybridFilterWithPositions): b's positions and blocks are
+// shifted past a's, a line is refit to both models, and the error bounds are
+// widened to cover the original bounds of both. The merged filter keeps the
+// tighter of the two MaxRangeBlocks caps.
+//
+// The merged model is an approximation of one trained on the merged keys, and
+// its search range is usually wider. When CostOnHit of merged filters drifts
+// well above that of freshly trained ones, retrain from the keys instead.
+func MergeHybrid(a, b *HybridFilter) (*HybridFilter, error) {
+	if a == nil || b == nil {
+		return nil, fmt.Errorf("MergeHybrid: nil filter")
+	}
+	if len(a.BloomBits) != len(b.BloomBits) {
+		return nil, fmt.Errorf("MergeHybrid: bloom size mismatch: %d vs %d bytes",
+			len(a.BloomBits), len(b.BloomBits))
+	}
+	if a.BloomHashK != b.BloomHashK {
+		return nil, fmt.Errorf("MergeHybrid: bloom hash count mismatch: %d vs %d",
+			a.BloomHashK, b.BloomHashK)
+	}
+
+	merged := &HybridFilter{
+		BloomBits:    make([]byte, len(a.BloomBits)),
+		BloomHashK:   a.BloomHashK,
+		MaxPos:       a.MaxPos + b.MaxPos + 1,
+		KeyCount:     a.KeyCount + b.KeyCount,
+		MinTimestamp: mergeMinTimestamp(a, b),
+		MaxTimestamp: max(a.MaxTimestamp, b.MaxTimestamp),
+
+		ProbabilisticBounds: a.ProbabilisticBounds || b.ProbabilisticBounds,
+		MaxRangeBlocks:      mergeMaxRangeBlocks(a.MaxRangeBlocks, b.MaxRangeBlocks),
+	}
+	merged.MinHash, merged.MaxHash = mergeHashBounds(a, b)
+	// The widened bounds below leave room for any rounding of the merged
+	// prediction, so keep a shared mode and fall back to nearest otherwise.
+	if a.RoundMode == b.RoundMode {
+		merged.RoundMode = a.RoundMode
+	}
+	for i := range merged.BloomBits {
+		merged.BloomBits[i] = a.BloomBits[i] | b.BloomBits[i]
+	}
+	if merged.KeyCount == 0 {
+		return merged, nil
+	}
+
+	// Each input model, placed in the merged coordinate space.
+	segments := []mergeSegment{
+		{n: a.KeyCount, xOff: 0, yOff: 0, hf: a},
+		{n: b.KeyCount, xOff: float64(a.KeyCount), yOff: float64(a.MaxPos + 1), hf: b},
+	}
+
+	var sums regressionSums
+	for _, seg := range segments {
+		sums.merge(seg.sums())
+	}
+	merged.Slope, merged.Intercept = sums.fit()
+
+	// The gap between an input model and the merged line is linear in x, so
+	// over each segment it is extreme at the first or last position.
+	minErr, maxErr := math.Inf(1), math.Inf(-1)
+	for _, seg := range segments {
+		if seg.n == 0 {
+			continue
+		}
+		for _, x := range []float64{0, float64(seg.n - 1)} {
+			gap := seg.predict(x) - (merged.Slope*(x+seg.xOff) + merged.Intercept)
+			minErr = math.Min(minErr, gap+float64(seg.hf.MinErr))
+			maxErr = math.Max(maxErr, gap+float64(seg.hf.MaxErr))
+		}
+	}
+	merged.MinErr = int32(math.Floor(minErr)) - 1
+	merged.MaxErr = int32(math.Ceil(maxErr)) + 1
+	return merged, nil
+}
+
+// mergeSegment is one input model of a merge, covering positions
+// [xOff, xOff+n) and blocks shifted by yOff in the merged table.
+type mergeSegment struct {
+	n          uint32
+	xOff, yOff float64
+	hf         *HybridFilter
+}
+
+// predict returns the input model's block for local position x, in merged
+// block coordinates.
+func (s mergeSegment) predict(x float64) float64 {
+	return s.hf.Slope*x + s.hf.Intercept + s.yOff
+}
+
+// sums returns the regression sums of the segment's predictions at positions
+// 0..n-1 in closed form, so no per-key work is needed.
+func (s mergeSegment) sums() regressionSums {
+	n := float64(s.n)
+	if n == 0 {
+		return regressionSums{}
+	}
+	// Over local x = 0..n-1, the mean is (n-1)/2 and the sum of squared
+	// deviations n(n²-1)/12. Shifting x by xOff moves only the mean, and
+	// y = slope*x + intercept deviates from its mean by slope times x's.
+	mean := (n - 1) / 2
+	sxx := n * (n*n - 1) / 12
+	slope, intercept := s.hf.Slope, s.hf.Intercept+s.yOff
+	return regressionSums{
+		n:     n,
+		meanX: mean + s.xOff,
+		meanY: slope*mean + intercept,
+		sxx:   sxx,
+		sxy:   slope * sxx,
+	}
+}
+
+// mergeMinTimestamp returns the smaller recorded MinTimestamp, ignoring
+// filters that have no timestamps.
+func mergeMinTimestamp(a, b *HybridFilter) int64 {
+	switch {
+	case a.MaxTimestamp == 0:
+		return b.MinTimestamp
+	case b.MaxTimestamp == 0:
+		return a.MinTimestamp
+	default:
+		return min(a.MinTimestamp, b.MinTimestamp)
+	}
+}
+
+// mergeMaxRangeBlocks returns the smaller of two MaxRangeBlocks caps, where 0
+// means no cap.
+func mergeMaxRangeBlocks(a, b uint32) uint32 {
+	switch {
+	case a == 0:
+		return b
+	case b == 0:
+		return a
+	default:
+		return min(a, b)
+	}
+}
+
+// mergeHashBounds returns the union of the hash bounds of a and b, or no
+// bounds if a filter with keys has none recorded. A filter without keys adds
+// nothing to the union.
+func mergeHashBounds(a, b *HybridFilter) (minHash, maxHash uint32) {
+	switch {
+	case a.KeyCount == 0:
+		return b.MinHash, b.MaxHash
+	case b.KeyCount == 0:
+		return a.MinHash, a.MaxHash
+	case a.MaxHash == 0 || b.MaxHash == 0:
+		return 0, 0
+	default:
+		return min(a.MinHash, b.MinHash), max(a.MaxHash, b.MaxHash)
+	}
+}
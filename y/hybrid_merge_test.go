@@ -0,0 +1,125 @@
+/*
+ * Tests for merging hybrid filters
+ */
+
+package y
+
+import (
+	"fmt"
+	"testing"
+)
+
+// buildPositionTable trains a position-based hybrid filter over keys
+// [start, start+count) with keysPerBlock keys per block.
+func buildPositionTable(start, count, keysPerBlock int, config HybridFilterConfig) ([]uint32, *HybridFilter) {
+	hashes := make([]uint32, count)
+	positions := make([]uint32, count)
+	blocks := make([]uint32, count)
+	for i := 0; i < count; i++ {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", start+i)))
+		positions[i] = uint32(i)
+		blocks[i] = uint32(i / keysPerBlock)
+	}
+	numBlocks := (count + keysPerBlock - 1) / keysPerBlock
+	return hashes, TrainHybridFilterWithPositions(hashes, positions, blocks, numBlocks, config)
+}
+
+func TestMergeHybridContiguous(t *testing.T) {
+	keysPerBlock := 100
+	config := HybridFilterConfig{BloomSizeBytes: 2048, TargetFPRate: 0.05, BloomHashK: 2}
+	hashesA, a := buildPositionTable(0, 5000, keysPerBlock, config)
+	hashesB, b := buildPositionTable(5000, 4000, keysPerBlock, config)
+
+	merged, err := MergeHybrid(a, b)
+	if err != nil {
+		t.Fatalf("MergeHybrid: %v", err)
+	}
+	if merged.KeyCount != 9000 || merged.MaxPos != 89 {
+		t.Errorf("Expected 9000 keys over 90 blocks, got %d keys, MaxPos %d",
+			merged.KeyCount, merged.MaxPos)
+	}
+	t.Logf("Merged: slope=%f intercept=%f err=[%d,%d]",
+		merged.Slope, merged.Intercept, merged.MinErr, merged.MaxErr)
+
+	hashes := append(append([]uint32{}, hashesA...), hashesB...)
+	for i, h := range hashes {
+		if !merged.MayContain(h) {
+			t.Fatalf("Key %d: false negative after merge", i)
+		}
+		block := i / keysPerBlock
+		minB, maxB := merged.PredictRange(uint32(i))
+		if block < minB || block > maxB {
+			t.Fatalf("Key %d: block %d not in merged range [%d,%d]", i, block, minB, maxB)
+		}
+	}
+
+	// Without the pinned k, the two sizes train different bloom k and
+	// cannot be merged.
+	config.BloomHashK = 0
+	_, a = buildPositionTable(0, 5000, keysPerBlock, config)
+	_, b = buildPositionTable(5000, 4000, keysPerBlock, config)
+	if a.BloomHashK == b.BloomHashK {
+		t.Fatalf("Test setup: expected different k without BloomHashK, got %d for both", a.BloomHashK)
+	}
+	if _, err := MergeHybrid(a, b); err == nil {
+		t.Error("Expected an error merging filters with different k")
+	}
+}
+
+func TestMergeHybridMismatch(t *testing.T) {
+	_, a := buildPositionTable(0, 1000, 100, HybridFilterConfig{BloomSizeBytes: 128})
+	_, b := buildPositionTable(1000, 1000, 100, HybridFilterConfig{BloomSizeBytes: 256})
+	if _, err := MergeHybrid(a, b); err == nil {
+		t.Error("Expected error for mismatched bloom sizes")
+	}
+
+	_, c := buildPositionTable(1000, 10, 100, HybridFilterConfig{BloomSizeBytes: 128})
+	if a.BloomHashK == c.BloomHashK {
+		t.Fatalf("Test setup: expected different k, got %d for both", a.BloomHashK)
+	}
+	if _, err := MergeHybrid(a, c); err == nil {
+		t.Error("Expected error for mismatched hash counts")
+	}
+}
+
+func TestMergeHybridTimestamps(t *testing.T) {
+	config := HybridFilterConfig{BloomSizeBytes: 128}
+	_, a := buildPositionTable(0, 100, 10, config)
+	_, b := buildPositionTable(100, 100, 10, config)
+	a.SetTimestampRange(50, 100)
+
+	merged, err := MergeHybrid(a, b)
+	if err != nil {
+		t.Fatalf("MergeHybrid: %v", err)
+	}
+	if merged.MinTimestamp != 50 || merged.MaxTimestamp != 100 {
+		t.Errorf("Expected timestamps [50,100], got [%d,%d]", merged.MinTimestamp, merged.MaxTimestamp)
+	}
+
+	b.SetTimestampRange(20, 80)
+	merged, _ = MergeHybrid(a, b)
+	if merged.MinTimestamp != 20 || merged.MaxTimestamp != 100 {
+		t.Errorf("Expected timestamps [20,100], got [%d,%d]", merged.MinTimestamp, merged.MaxTimestamp)
+	}
+}
+
+func TestMergeHybridMaxRangeBlocks(t *testing.T) {
+	config := HybridFilterConfig{BloomSizeBytes: 128}
+	_, a := buildPositionTable(0, 100, 10, config)
+	_, b := buildPositionTable(100, 100, 10, config)
+	for _, tc := range []struct{ a, b, want uint32 }{
+		{0, 0, 0},
+		{4, 0, 4},
+		{0, 6, 6},
+		{8, 3, 3},
+	} {
+		a.MaxRangeBlocks, b.MaxRangeBlocks = tc.a, tc.b
+		merged, err := MergeHybrid(a, b)
+		if err != nil {
+			t.Fatalf("MergeHybrid: %v", err)
+		}
+		if merged.MaxRangeBlocks != tc.want {
+			t.Errorf("MaxRangeBlocks %d and %d: merged %d, want %d", tc.a, tc.b, merged.MaxRangeBlocks, tc.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+/*
+ * Splitting a hybrid filter when compaction divides a table
+ *
+ * The converse of MergeHybrid. A bloom filter cannot be split: its bits do not
+ * record which key set them. Nor can a line fitted over the whole table be cut
+ * into two lines with tight bounds, since its error bounds hold for the table
+ * as a whole. Both halves are therefore retrained from the table's keys, which
+ * compaction has at hand while it writes the two output tables.
+ */
+
+package y
+
+import (
+	"context"
+	"fmt"
+)
+
+// SplitHybrid divides the filter of a table into the filters of two tables:
+// left holds the keys in blocks [0, splitAtBlock) and right those in blocks
+// [splitAtBlock, MaxPos], renumbered from 0. keyHashes and blockIndices are
+// the table's keys, as passed to TrainHybridFilter, and both halves are
+// trained from them with config; hf only supplies MaxPos and the timestamp
+// range, so neither half inherits its bits or its model.
+//
+// Passing the config hf was trained with gives each half about half the keys
+// in the same number of bits, so a lower false positive rate than hf's. It
+// returns an error if keyHashes and blockIndices differ in length, if
+// splitAtBlock is not in [1, MaxPos], or if training a half fails, as for
+// TrainHybridFilterContext.
+func SplitHybrid(hf *HybridFilter, keyHashes, blockIndices []uint32, splitAtBlock int,
+	config HybridFilterConfig) (left, right *HybridFilter, err error) {
+	if len(keyHashes) != len(blockIndices) {
+		return nil, nil, fmt.Errorf("SplitHybrid: %d key hashes but %d block indices",
+			len(keyHashes), len(blockIndices))
+	}
+	if splitAtBlock < 1 || splitAtBlock > int(hf.MaxPos) {
+		return nil, nil, fmt.Errorf("SplitHybrid: split at block %d of a table with %d blocks",
+			splitAtBlock, int(hf.MaxPos)+1)
+	}
+
+	var leftHashes, leftBlocks, rightHashes, rightBlocks []uint32
+	for i, h := range keyHashes {
+		if b := blockIndices[i]; int(b) < splitAtBlock {
+			leftHashes = append(leftHashes, h)
+			leftBlocks = append(leftBlocks, b)
+		} else {
+			rightHashes = append(rightHashes, h)
+			rightBlocks = append(rightBlocks, b-uint32(splitAtBlock))
+		}
+	}
+
+	ctx := context.Background()
+	if left, err = TrainHybridFilterContext(ctx, leftHashes, leftBlocks, splitAtBlock, config); err != nil {
+		return nil, nil, err
+	}
+	if right, err = TrainHybridFilterContext(ctx, rightHashes, rightBlocks, int(hf.MaxPos)+1-splitAtBlock, config); err != nil {
+		return nil, nil, err
+	}
+	for _, half := range []*HybridFilter{left, right} {
+		half.MinTimestamp, half.MaxTimestamp = hf.MinTimestamp, hf.MaxTimestamp
+	}
+	return left, right, nil
+}
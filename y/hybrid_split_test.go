@@ -0,0 +1,106 @@
+/*
+ * Tests for splitting hybrid filters
+ */
+
+package y
+
+import "testing"
+
+func TestSplitHybrid(t *testing.T) {
+	keyCount, keysPerBlock, splitAt := 10000, 100, 30
+	numBlocks := keyCount / keysPerBlock
+	positions := make([]uint32, keyCount)
+	blocks := make([]uint32, keyCount)
+	for i := range positions {
+		positions[i] = uint32(i)
+		blocks[i] = uint32(i / keysPerBlock)
+	}
+	config := HybridFilterConfig{BloomSizeBytes: 2048}
+	hf := TrainHybridFilter(positions, blocks, numBlocks, config)
+	hf.SetTimestampRange(100, 200)
+
+	left, right, err := SplitHybrid(hf, positions, blocks, splitAt, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left.KeyCount != 3000 || left.MaxPos != 29 || right.KeyCount != 7000 || right.MaxPos != 69 {
+		t.Fatalf("Expected 3000 keys in 30 blocks and 7000 in 70, got %d/%d and %d/%d",
+			left.KeyCount, left.MaxPos+1, right.KeyCount, right.MaxPos+1)
+	}
+	for _, half := range []*HybridFilter{left, right} {
+		if half.MinTimestamp != 100 || half.MaxTimestamp != 200 || len(half.BloomBits) != 2048 {
+			t.Errorf("Half lost hf's settings: %s, timestamps [%d,%d]", half, half.MinTimestamp, half.MaxTimestamp)
+		}
+	}
+
+	for i, h := range positions {
+		own, other := left, right
+		block := int(blocks[i])
+		if block >= splitAt {
+			own, other = right, left
+			block -= splitAt
+		}
+		if !own.MayContain(h) {
+			t.Fatalf("Key %d: false negative in its half", i)
+		}
+		if other.MayContain(h) {
+			t.Fatalf("Key %d: accepted by the other half", i)
+		}
+		minB, maxB := own.PredictRange(h)
+		if block < minB || block > maxB || maxB-minB > 2 {
+			t.Fatalf("Key %d: local block %d, predicted [%d,%d]", i, block, minB, maxB)
+		}
+	}
+}
+
+func TestSplitHybridHashedKeys(t *testing.T) {
+	keyCount, numBlocks, splitAt := 20000, 40, 25
+	hashes := GenerateSortedKeyHashes(keyCount)
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+	// 10 bits per key in hf, so about 20 in each half.
+	config := HybridFilterConfig{BloomSizeBytes: keyCount * 10 / 8}
+	hf := TrainHybridFilter(hashes, blocks, numBlocks, config)
+
+	left, right, err := SplitHybrid(hf, hashes, blocks, splitAt, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leftKeys := int(left.KeyCount)
+	// Hashes interleave across the halves, so only the blooms tell them apart.
+	var falsePositives int
+	for i, h := range hashes {
+		own, other := left, right
+		if i >= leftKeys {
+			own, other = right, left
+		}
+		if !own.MayContain(h) {
+			t.Fatalf("Key %d: false negative in its half", i)
+		}
+		if other.MayContain(h) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / float64(keyCount); rate > hf.estimatedFPRate() {
+		t.Errorf("Other half accepted %.4f of keys, more than hf's estimated FP rate %.4f", rate, hf.estimatedFPRate())
+	}
+}
+
+func TestSplitHybridErrors(t *testing.T) {
+	keys := GenerateSortedKeyHashes(100)
+	blocks := GenerateBlockIndices(100, 10)
+	config := DefaultHybridConfig()
+	hf := TrainHybridFilter(keys, blocks, 10, config)
+	for _, tc := range []struct {
+		name    string
+		blocks  []uint32
+		splitAt int
+	}{
+		{"mismatched lengths", blocks[1:], 5},
+		{"split at block 0", blocks, 0},
+		{"split past the last block", blocks, 10},
+	} {
+		if _, _, err := SplitHybrid(hf, keys, tc.blocks, tc.splitAt, config); err == nil {
+			t.Errorf("%s: no error", tc.name)
+		}
+	}
+}
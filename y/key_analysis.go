@@ -0,0 +1,115 @@
+/*
+ * Key analysis - would a learned index help this table?
+ *
+ * A learned index only pays off when the input it is trained on follows the
+ * order in which keys are laid out over blocks. Hashing destroys that order,
+ * so over a sorted table only key positions correlate with blocks. Before
+ * enabling one, AnalyzeKeys takes a sample of keys in table order, measures
+ * how well key positions and key hashes each correlate with the block a key
+ * lands in, fits a quick linear model on the better of the two, and reports
+ * the share of the table a lookup would still have to search.
+ */
+
+package y
+
+import (
+	"bytes"
+	"math"
+	"sort"
+)
+
+// KeyAnalysis is the result of AnalyzeKeys.
+type KeyAnalysis struct {
+	// PositionCorrelation is the Pearson correlation between each key's rank
+	// in byte order and its block. It is close to 1 when the table stores keys
+	// in key order, which a model on positions (see BlockIndex) can exploit.
+	PositionCorrelation float64
+	// HashCorrelation is the Pearson correlation between Hash of each key and
+	// its block. It is close to 0 unless the table is laid out in hash order.
+	HashCorrelation float64
+	// UsePositions reports whether the model was fitted on positions rather
+	// than hashes, being the more strongly correlated input.
+	UsePositions bool
+	// EstimatedSearchRangePct is the average share of the table's blocks, in
+	// percent, that the fitted model leaves to search for a sample key.
+	EstimatedSearchRangePct float64
+	// RecommendLearned reports whether the search range is small enough for a
+	// learned index to be worth storing, by the threshold TrainBestIndex uses.
+	RecommendLearned bool
+}
+
+// AnalyzeKeys estimates how much a learned index would help a table, without
+// building one. keys is a sample of the table's keys in the order they are
+// stored, spread evenly over numBlocks blocks as by GenerateBlockIndices. An
+// empty sample is never recommended.
+func AnalyzeKeys(keys [][]byte, numBlocks int) KeyAnalysis {
+	if len(keys) == 0 || numBlocks <= 0 {
+		return KeyAnalysis{EstimatedSearchRangePct: 100}
+	}
+	blockIndices := GenerateBlockIndices(len(keys), numBlocks)
+
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return bytes.Compare(keys[order[a]], keys[order[b]]) < 0 })
+	positions := make([]uint32, len(keys))
+	for rank, i := range order {
+		positions[i] = uint32(rank)
+	}
+	hashes := make([]uint32, len(keys))
+	for i, key := range keys {
+		hashes[i] = Hash(key)
+	}
+
+	a := KeyAnalysis{
+		PositionCorrelation: correlation(positions, blockIndices),
+		HashCorrelation:     correlation(hashes, blockIndices),
+	}
+	a.UsePositions = math.Abs(a.PositionCorrelation) >= math.Abs(a.HashCorrelation)
+	inputs := hashes
+	if a.UsePositions {
+		inputs = positions
+	}
+
+	li := TrainLearnedIndex(inputs, blockIndices, numBlocks)
+	total := 0
+	for _, x := range inputs {
+		_, minBlock, maxBlock := li.Predict(x)
+		total += maxBlock - minBlock + 1
+	}
+	rangeFraction := float64(total) / float64(len(inputs)) / float64(numBlocks)
+	a.EstimatedSearchRangePct = 100 * rangeFraction
+	a.RecommendLearned = rangeFraction <= bestIndexMaxRangeFraction
+	return a
+}
+
+// correlation returns the Pearson correlation coefficient of x and y, or 0 if
+// their lengths differ, they are empty, or either is constant.
+func correlation(x []uint32, y []uint32) float64 {
+	n := len(x)
+	if n == 0 || n != len(y) {
+		return 0
+	}
+
+	var sumX, sumY float64
+	for i := 0; i < n; i++ {
+		sumX += float64(x[i])
+		sumY += float64(y[i])
+	}
+	meanX := sumX / float64(n)
+	meanY := sumY / float64(n)
+
+	var numerator, denomX, denomY float64
+	for i := 0; i < n; i++ {
+		dx := float64(x[i]) - meanX
+		dy := float64(y[i]) - meanY
+		numerator += dx * dy
+		denomX += dx * dx
+		denomY += dy * dy
+	}
+	if denomX == 0 || denomY == 0 {
+		return 0
+	}
+	return numerator / math.Sqrt(denomX*denomY)
+}
@@ -0,0 +1,44 @@
+package y
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func TestAnalyzeKeys(t *testing.T) {
+	keyCount, numBlocks := 10000, 100
+
+	sorted := make([][]byte, keyCount)
+	for i := range sorted {
+		sorted[i] = []byte(fmt.Sprintf("key_%010d", i))
+	}
+	a := AnalyzeKeys(sorted, numBlocks)
+	t.Logf("Sorted keys: %+v", a)
+	if !a.RecommendLearned || !a.UsePositions {
+		t.Errorf("Sorted keys: want a recommendation on positions, got %+v", a)
+	}
+	if a.PositionCorrelation < 0.99 {
+		t.Errorf("Sorted keys: position correlation %.4f, want near 1", a.PositionCorrelation)
+	}
+
+	// Keys that are themselves hashes, stored in the order of the keys they
+	// were hashed from: neither their byte order nor their hash follows the
+	// layout.
+	hashed := make([][]byte, keyCount)
+	for i, h := range GenerateSortedKeyHashes(keyCount) {
+		hashed[i] = binary.BigEndian.AppendUint32(nil, h)
+	}
+	a = AnalyzeKeys(hashed, numBlocks)
+	t.Logf("Hashed keys: %+v", a)
+	if a.RecommendLearned {
+		t.Errorf("Hashed keys: want no recommendation, got %+v", a)
+	}
+	if a.EstimatedSearchRangePct < 50 {
+		t.Errorf("Hashed keys: search range %.1f%%, want most of the table", a.EstimatedSearchRangePct)
+	}
+
+	if a := AnalyzeKeys(nil, numBlocks); a.RecommendLearned || a.EstimatedSearchRangePct != 100 {
+		t.Errorf("Empty sample: got %+v", a)
+	}
+}
@@ -6,8 +6,15 @@
 package y
 
 import (
+	"cmp"
+	"context"
 	"encoding/binary"
+	"fmt"
 	"math"
+	"math/bits"
+	"runtime"
+	"slices"
+	"sync"
 )
 
 // LearnedIndex represents a simple linear regression model for predicting
@@ -24,8 +31,20 @@ type LearnedIndex struct {
 	MaxPos    uint32  // Maximum position (number of blocks - 1)
 }
 
-// LearnedIndexSize is the serialized size in bytes: 8+8+4+4+4+4 = 32 bytes
-const LearnedIndexSize = 32
+// exactFitEpsilon is the largest residual, in blocks, that training treats as
+// zero. When every training key is predicted this closely, e.g. one key per
+// block at evenly spaced positions, the error bounds are left at 0 instead of
+// being widened by a block on each side, giving single-block lookups.
+const exactFitEpsilon = 1e-6
+
+// LearnedIndexSize is the serialized size in bytes of Serialize: Slope,
+// Intercept, MinErr, MaxErr, KeyCount and MaxPos, 8+8+4+4+4+4 = 32 bytes.
+const LearnedIndexSize = 8 + 8 + 4 + 4 + 4 + 4
+
+// LearnedIndexLegacySize is the serialized size in bytes of SerializeLegacy,
+// which has no KeyCount: Slope, Intercept, MinErr, MaxErr and MaxPos,
+// 8+8+4+4+4 = 28 bytes.
+const LearnedIndexLegacySize = 8 + 8 + 4 + 4 + 4
 
 // TrainLearnedIndex builds a linear regression model from sorted key hashes.
 // Each hash corresponds to a block index (position).
@@ -36,6 +55,8 @@ const LearnedIndexSize = 32
 //   - numBlocks: total number of blocks in the table
 //
 // The model learns: blockIndex ≈ slope * keyHash + intercept
+//
+// With no keys, MayContainInRange rejects every key, as for NewFilter.
 func TrainLearnedIndex(keyHashes []uint32, blockIndices []uint32, numBlocks int) *LearnedIndex {
 	n := len(keyHashes)
 	if n == 0 {
@@ -58,35 +79,15 @@ func TrainLearnedIndex(keyHashes []uint32, blockIndices []uint32, numBlocks int)
 	// We want to minimize: sum((y - (slope*x + intercept))^2)
 	// where x = keyHash, y = blockIndex
 
-	var sumX, sumY, sumXY, sumX2 float64
-	for i := 0; i < n; i++ {
-		x := float64(keyHashes[i])
-		y := float64(blockIndices[i])
-		sumX += x
-		sumY += y
-		sumXY += x * y
-		sumX2 += x * x
-	}
-
-	nf := float64(n)
-	denominator := nf*sumX2 - sumX*sumX
-
-	var slope, intercept float64
-	if math.Abs(denominator) < 1e-10 {
-		// All keys have same hash (unlikely but handle it)
-		// Just predict the average position
-		slope = 0
-		intercept = sumY / nf
-	} else {
-		slope = (nf*sumXY - sumX*sumY) / denominator
-		intercept = (sumY - slope*sumX) / nf
-	}
+	slope, intercept := computeRegressionSums(keyHashes, blockIndices).fit()
 
 	// Calculate error bounds by checking prediction error for all keys
 	var minErr, maxErr int32
+	var maxAbsResidual float64
 	for i := 0; i < n; i++ {
 		predicted := slope*float64(keyHashes[i]) + intercept
 		actual := float64(blockIndices[i])
+		maxAbsResidual = math.Max(maxAbsResidual, math.Abs(actual-predicted))
 		err := int32(actual - predicted) // positive if we predicted too low
 
 		if err < minErr {
@@ -97,9 +98,12 @@ func TrainLearnedIndex(keyHashes []uint32, blockIndices []uint32, numBlocks int)
 		}
 	}
 
-	// Add small buffer to error bounds for safety
-	minErr -= 1
-	maxErr += 1
+	// Add small buffer to error bounds for safety, unless the keys lie exactly
+	// on the line and the rounded prediction is always the true block.
+	if maxAbsResidual >= exactFitEpsilon {
+		minErr -= 1
+		maxErr += 1
+	}
 
 	return &LearnedIndex{
 		Slope:     slope,
@@ -111,6 +115,296 @@ func TrainLearnedIndex(keyHashes []uint32, blockIndices []uint32, numBlocks int)
 	}
 }
 
+// LearnedIndexOptions controls input validation in TrainLearnedIndexWithOptions.
+type LearnedIndexOptions struct {
+	// RequireSorted makes training fail with ErrUnsorted unless positions are
+	// ascending and blockIndices never decrease along them.
+	RequireSorted bool
+
+	// AutoSort sorts positions, together with their blockIndices, before
+	// training. The input slices are not modified.
+	AutoSort bool
+}
+
+// TrainLearnedIndexWithOptions is TrainLearnedIndex with input validation.
+//
+// The least squares fit itself does not depend on the order of the input
+// pairs, so a model only comes out wrong when the pairs themselves are: when
+// blockIndices were assigned in a different order than positions, a block
+// index no longer grows with its position. With RequireSorted this is caught
+// and reported as ErrUnsorted instead of training a useless model. AutoSort
+// first puts pairs given in some other consistent order (e.g. reversed) into
+// position order, so only genuinely mismatched pairs are rejected.
+func TrainLearnedIndexWithOptions(positions []uint32, blockIndices []uint32, numBlocks int,
+	opts LearnedIndexOptions) (*LearnedIndex, error) {
+	if len(positions) != len(blockIndices) {
+		return nil, fmt.Errorf("TrainLearnedIndex: %d positions but %d block indices",
+			len(positions), len(blockIndices))
+	}
+	if opts.AutoSort && !ValidateSorted(positions) {
+		positions, blockIndices = sortPairs(positions, blockIndices)
+	}
+	if opts.RequireSorted {
+		if !ValidateSorted(positions) {
+			return nil, fmt.Errorf("TrainLearnedIndex: positions: %w", ErrUnsorted)
+		}
+		if !ValidateSorted(blockIndices) {
+			return nil, fmt.Errorf("TrainLearnedIndex: block indices decrease along positions: %w",
+				ErrUnsorted)
+		}
+	}
+	return TrainLearnedIndex(positions, blockIndices, numBlocks), nil
+}
+
+// ValidateSorted reports whether values are in non-decreasing order.
+func ValidateSorted(values []uint32) bool {
+	return slices.IsSorted(values)
+}
+
+// sortPairs returns copies of positions and blockIndices sorted by position,
+// then by block index.
+func sortPairs(positions []uint32, blockIndices []uint32) ([]uint32, []uint32) {
+	type pair struct{ pos, block uint32 }
+	pairs := make([]pair, len(positions))
+	for i := range pairs {
+		pairs[i] = pair{positions[i], blockIndices[i]}
+	}
+	slices.SortFunc(pairs, func(a, b pair) int {
+		if c := cmp.Compare(a.pos, b.pos); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.block, b.block)
+	})
+	sortedPos := make([]uint32, len(pairs))
+	sortedBlocks := make([]uint32, len(pairs))
+	for i, p := range pairs {
+		sortedPos[i], sortedBlocks[i] = p.pos, p.block
+	}
+	return sortedPos, sortedBlocks
+}
+
+// conflictingPositions returns the indices of the keys whose position is
+// shared with a key in a different block. Such keys get the same prediction,
+// so no model can place them all exactly. positions need not be sorted.
+func conflictingPositions[B blockIndex](positions []uint32, blockIndices []B) []int {
+	at := func(i int) int { return i }
+	if !slices.IsSorted(positions) {
+		order := make([]uint64, len(positions))
+		for i, p := range positions {
+			order[i] = uint64(p)<<32 | uint64(i)
+		}
+		radixSortHigh32(order)
+		at = func(i int) int { return int(uint32(order[i])) }
+	}
+
+	var conflicts []int
+	for start := 0; start < len(positions); {
+		lo, hi := blockIndices[at(start)], blockIndices[at(start)]
+		end := start + 1
+		for ; end < len(positions) && positions[at(end)] == positions[at(start)]; end++ {
+			lo, hi = min(lo, blockIndices[at(end)]), max(hi, blockIndices[at(end)])
+		}
+		if lo != hi {
+			for j := start; j < end; j++ {
+				conflicts = append(conflicts, at(j))
+			}
+		}
+		start = end
+	}
+	return conflicts
+}
+
+// radixSortHigh32 stably sorts vals by their upper 32 bits, one byte per pass.
+// Training sorts every key of a table this way when checking for duplicate
+// positions, and it is several times faster than a comparison sort there.
+func radixSortHigh32(vals []uint64) {
+	buf := make([]uint64, len(vals))
+	src, dst := vals, buf
+	for shift := 32; shift < 64; shift += 8 {
+		var offsets [256]int
+		for _, v := range src {
+			offsets[(v>>shift)&0xff]++
+		}
+		total := 0
+		for b, count := range offsets {
+			offsets[b] = total
+			total += count
+		}
+		for _, v := range src {
+			b := (v >> shift) & 0xff
+			dst[offsets[b]] = v
+			offsets[b]++
+		}
+		src, dst = dst, src
+	}
+	// An even number of passes leaves the result back in vals.
+}
+
+// BlockIndicesFromBoundaries builds the blockIndices input of TrainLearnedIndex
+// for tables whose blocks hold different numbers of keys. blockStarts holds the
+// index of the first key of each block, in ascending order; key i is assigned
+// to the last block starting at or before i. Keys before blockStarts[0] are
+// assigned to block 0.
+func BlockIndicesFromBoundaries(keyCount int, blockStarts []int) []uint32 {
+	blockIndices := make([]uint32, keyCount)
+	block := 0
+	for i := range blockIndices {
+		for block+1 < len(blockStarts) && blockStarts[block+1] <= i {
+			block++
+		}
+		blockIndices[i] = uint32(block)
+	}
+	return blockIndices
+}
+
+// parallelTrainThreshold is the number of keys above which the regression sums
+// are accumulated across runtime.NumCPU() goroutines. Below it, the cost of
+// spawning goroutines outweighs the gain, so training stays serial.
+const parallelTrainThreshold = 1 << 18
+
+// regressionSums holds what a least squares fit needs of n points: the means
+// of x and y, and the sums of squared and cross deviations from them,
+// sxx = Σ(x-meanX)² and sxy = Σ(x-meanX)(y-meanY).
+//
+// The textbook form n·Σx² - (Σx)² loses every significant digit for hashes in
+// a narrow band far from zero: with x near 4e9, both terms are around
+// n²·1.6e19 while their difference is n² times the variance, and float64 only
+// carries 16 digits. Centered sums never form that difference. Scaling x as
+// well, as QuadraticLearnedIndex does for its fourth powers, would only shift
+// exponents here, which float64 does exactly.
+type regressionSums struct {
+	n            float64
+	meanX, meanY float64
+	sxx, sxy     float64
+}
+
+// merge combines the sums of two disjoint sets of points (Chan et al.,
+// "Updating Formulae and a Pairwise Algorithm for Computing Sample
+// Variances", 1979).
+func (s *regressionSums) merge(o regressionSums) {
+	if o.n == 0 {
+		return
+	}
+	if s.n == 0 {
+		*s = o
+		return
+	}
+	n := s.n + o.n
+	dx, dy := o.meanX-s.meanX, o.meanY-s.meanY
+	w := s.n * o.n / n
+	s.sxx += o.sxx + dx*dx*w
+	s.sxy += o.sxy + dx*dy*w
+	s.meanX += dx * o.n / n
+	s.meanY += dy * o.n / n
+	s.n = n
+}
+
+// fit returns the slope and intercept of the least squares line.
+func (s regressionSums) fit() (slope, intercept float64) {
+	// Positions are integers, so any two distinct ones give sxx >= 1/2.
+	if s.sxx < 0.25 {
+		// All keys have same hash (unlikely but handle it)
+		// Just predict the average position
+		return 0, s.meanY
+	}
+	slope = s.sxy / s.sxx
+	intercept = s.meanY - slope*s.meanX
+	return slope, intercept
+}
+
+// blockIndex is the type of the block indices a model is trained on: uint32
+// for HybridFilter and the other models, uint64 for HybridFilter64.
+type blockIndex interface {
+	~uint32 | ~uint64
+}
+
+// accumulateSums computes the regression sums serially, in two passes: the
+// means first, then the deviations from them. The first pass sums positions in
+// uint64, which is exact for fewer than 2^32 keys, and block indices in 128
+// bits, which 64-bit indices need.
+func accumulateSums[B blockIndex](keyHashes []uint32, blockIndices []B) regressionSums {
+	if len(keyHashes) == 0 {
+		return regressionSums{}
+	}
+	blockIndices = blockIndices[:len(keyHashes)]
+	var sumX, sumY, sumYHi, carry uint64
+	for i, x := range keyHashes {
+		sumX += uint64(x)
+		sumY, carry = bits.Add64(sumY, uint64(blockIndices[i]), 0)
+		sumYHi += carry
+	}
+	n := float64(len(keyHashes))
+	meanX, meanY := float64(sumX)/n, (float64(sumYHi)*0x1p64+float64(sumY))/n
+	var sxx, sxy float64
+	for i, x := range keyHashes {
+		dx := float64(x) - meanX
+		sxx += dx * dx
+		sxy += dx * (float64(blockIndices[i]) - meanY)
+	}
+	return regressionSums{n: n, meanX: meanX, meanY: meanY, sxx: sxx, sxy: sxy}
+}
+
+// parallelSums partitions the input across workers goroutines, each computing
+// partial sums, and combines the partials in order.
+func parallelSums[B blockIndex](keyHashes []uint32, blockIndices []B, workers int) regressionSums {
+	n := len(keyHashes)
+	chunk := (n + workers - 1) / workers
+	partials := make([]regressionSums, workers)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		lo := w * chunk
+		if lo >= n {
+			break
+		}
+		hi := min(lo+chunk, n)
+		wg.Add(1)
+		go func(w, lo, hi int) {
+			defer wg.Done()
+			partials[w] = accumulateSums(keyHashes[lo:hi], blockIndices[lo:hi])
+		}(w, lo, hi)
+	}
+	wg.Wait()
+
+	var s regressionSums
+	for _, p := range partials {
+		s.merge(p)
+	}
+	return s
+}
+
+// computeRegressionSums picks the serial or parallel path based on input size.
+func computeRegressionSums[B blockIndex](keyHashes []uint32, blockIndices []B) regressionSums {
+	workers := runtime.NumCPU()
+	if len(keyHashes) < parallelTrainThreshold || workers < 2 {
+		return accumulateSums(keyHashes, blockIndices)
+	}
+	return parallelSums(keyHashes, blockIndices, workers)
+}
+
+// ctxCheckInterval is the number of keys processed between context checks in
+// cancellable training.
+const ctxCheckInterval = 1 << 16
+
+// computeRegressionSumsContext is computeRegressionSums with a context check
+// every ctxCheckInterval keys. Contexts that can be cancelled are processed in
+// serial chunks, so they forgo the parallel path.
+func computeRegressionSumsContext[B blockIndex](ctx context.Context, keyHashes []uint32,
+	blockIndices []B) (regressionSums, error) {
+	if ctx.Done() == nil {
+		return computeRegressionSums(keyHashes, blockIndices), nil
+	}
+	var s regressionSums
+	for lo := 0; lo < len(keyHashes); lo += ctxCheckInterval {
+		if err := ctx.Err(); err != nil {
+			return s, err
+		}
+		hi := min(lo+ctxCheckInterval, len(keyHashes))
+		s.merge(accumulateSums(keyHashes[lo:hi], blockIndices[lo:hi]))
+	}
+	return s, nil
+}
+
 // Predict returns the predicted block index for a given key hash.
 // Returns (predictedBlock, minBlock, maxBlock) where the key should be
 // searched in the range [minBlock, maxBlock].
@@ -152,15 +446,95 @@ func (li *LearnedIndex) Predict(keyHash uint32) (predicted, minBlock, maxBlock i
 	return predicted, minBlock, maxBlock
 }
 
+// PredictMany returns the search range [minBlocks[i], maxBlocks[i]] that
+// Predict gives for each of positions, e.g. the positions a range scan visits.
+// Any order is accepted, but ascending input is faster: consecutive positions
+// usually fall in the same block, and a run of them is answered from the
+// first one's range instead of evaluating the model for each.
+//
+// The results equal Predict's exactly. Predict's range is monotone in the
+// position, as every step from position to clamped block is, so once two
+// positions are found to have the same range, every position between them
+// has it too.
+func (li *LearnedIndex) PredictMany(positions []uint32) (minBlocks, maxBlocks []int) {
+	minBlocks = make([]int, len(positions))
+	maxBlocks = make([]int, len(positions))
+	// Every position in [lo, hi] has the range [curMin, curMax].
+	lo, hi := uint32(1), uint32(0)
+	var curMin, curMax int
+	for i, x := range positions {
+		if x < lo || x > hi {
+			_, curMin, curMax = li.Predict(x)
+			lo, hi = x, x
+			// Only look for a run when the next position would fall in it,
+			// so that sparse input costs one division more than Predict.
+			if i+1 < len(positions) && positions[i+1] > x {
+				if end := li.predictRunEnd(x); positions[i+1] <= end {
+					if _, endMin, endMax := li.Predict(end); endMin == curMin && endMax == curMax {
+						hi = end
+					}
+				}
+			}
+		}
+		minBlocks[i], maxBlocks[i] = curMin, curMax
+	}
+	return minBlocks, maxBlocks
+}
+
+// predictRunEnd estimates the last position, at or after x, before the model's
+// rounded prediction moves on from the block it gives at x. The estimate
+// stops a position short of the rounding boundary, but it is not exact, so
+// PredictMany checks it. It returns x if there is no run to speak of.
+func (li *LearnedIndex) predictRunEnd(x uint32) uint32 {
+	if li.KeyCount == 0 || li.Slope == 0 {
+		return math.MaxUint32
+	}
+	p := math.Round(li.Slope*float64(x) + li.Intercept)
+	boundary := p + 0.5
+	if li.Slope < 0 {
+		boundary = p - 0.5
+	}
+	end := math.Floor((boundary-li.Intercept)/li.Slope) - 1
+	if !(end > float64(x)) {
+		// Also taken for a NaN slope or intercept.
+		return x
+	}
+	return uint32(math.Min(end, math.MaxUint32))
+}
+
+// IntersectRanges intersects the inclusive [min, max] block ranges predicted by
+// several models for the same key. If the intersection is empty, the key cannot
+// satisfy every model and the table can be skipped. Calling it with no ranges
+// also reports an empty result.
+func IntersectRanges(ranges ...[2]int) (minBlock, maxBlock int, empty bool) {
+	if len(ranges) == 0 {
+		return 0, 0, true
+	}
+	minBlock, maxBlock = ranges[0][0], ranges[0][1]
+	for _, r := range ranges[1:] {
+		minBlock = max(minBlock, r[0])
+		maxBlock = min(maxBlock, r[1])
+	}
+	if minBlock > maxBlock {
+		return 0, 0, true
+	}
+	return minBlock, maxBlock, false
+}
+
 // MayContainInRange returns true if the key might be in this table.
 // This is a probabilistic check similar to Bloom filter's MayContain.
 // Unlike Bloom filters, learned index can give false negatives in rare cases
 // if error bounds are exceeded, but this is very unlikely with proper training.
+//
+// An index trained on no keys rejects every key, as NewFilter does.
 func (li *LearnedIndex) MayContainInRange(keyHash uint32) bool {
-	if li == nil || li.KeyCount == 0 {
-		// No model trained - assume key might be present
+	if li == nil {
+		// No index - assume key might be present
 		return true
 	}
+	if li.KeyCount == 0 {
+		return false
+	}
 	// With a learned index, we always say "may contain" because
 	// we'll do a bounded search. The value is in the search efficiency,
 	// not in filtering tables entirely.
@@ -173,13 +547,26 @@ func (li *LearnedIndex) Serialize() []byte {
 	buf := make([]byte, LearnedIndexSize)
 	binary.LittleEndian.PutUint64(buf[0:8], math.Float64bits(li.Slope))
 	binary.LittleEndian.PutUint64(buf[8:16], math.Float64bits(li.Intercept))
-	binary.LittleEndian.PutUint32(buf[16:20], uint32(li.MinErr))
-	binary.LittleEndian.PutUint32(buf[20:24], uint32(li.MaxErr))
+	putInt32(buf[16:20], li.MinErr)
+	putInt32(buf[20:24], li.MaxErr)
 	binary.LittleEndian.PutUint32(buf[24:28], li.KeyCount)
 	binary.LittleEndian.PutUint32(buf[28:32], li.MaxPos)
 	return buf
 }
 
+// putInt32 stores a signed value in 4 little-endian bytes as its two's
+// complement bit pattern. Taking int32 rather than int makes a value that
+// was widened on the way (e.g. an int error bound) a compile error instead of
+// silently truncating it.
+func putInt32(buf []byte, v int32) {
+	binary.LittleEndian.PutUint32(buf, uint32(v))
+}
+
+// getInt32 reads a value stored by putInt32.
+func getInt32(buf []byte) int32 {
+	return int32(binary.LittleEndian.Uint32(buf))
+}
+
 // DeserializeLearnedIndex reads a LearnedIndex from bytes.
 func DeserializeLearnedIndex(data []byte) *LearnedIndex {
 	if len(data) < LearnedIndexSize {
@@ -188,13 +575,50 @@ func DeserializeLearnedIndex(data []byte) *LearnedIndex {
 	return &LearnedIndex{
 		Slope:     math.Float64frombits(binary.LittleEndian.Uint64(data[0:8])),
 		Intercept: math.Float64frombits(binary.LittleEndian.Uint64(data[8:16])),
-		MinErr:    int32(binary.LittleEndian.Uint32(data[16:20])),
-		MaxErr:    int32(binary.LittleEndian.Uint32(data[20:24])),
+		MinErr:    getInt32(data[16:20]),
+		MaxErr:    getInt32(data[20:24]),
 		KeyCount:  binary.LittleEndian.Uint32(data[24:28]),
 		MaxPos:    binary.LittleEndian.Uint32(data[28:32]),
 	}
 }
 
+// SerializeLegacy converts the LearnedIndex to the 28-byte layout without
+// KeyCount used by external tooling: Slope, Intercept, MinErr, MaxErr and
+// MaxPos, with MaxPos at bytes 24:28. An untrained index is written as a
+// model that spans every block, since without KeyCount it cannot be told
+// apart from a trained one. DeserializeLegacyLearnedIndex reads it back.
+func (li *LearnedIndex) SerializeLegacy() []byte {
+	legacy := *li
+	if legacy.KeyCount == 0 {
+		legacy = LearnedIndex{MaxErr: int32(li.MaxPos), MaxPos: li.MaxPos}
+	}
+	buf := make([]byte, LearnedIndexLegacySize)
+	binary.LittleEndian.PutUint64(buf[0:8], math.Float64bits(legacy.Slope))
+	binary.LittleEndian.PutUint64(buf[8:16], math.Float64bits(legacy.Intercept))
+	putInt32(buf[16:20], legacy.MinErr)
+	putInt32(buf[20:24], legacy.MaxErr)
+	binary.LittleEndian.PutUint32(buf[24:28], legacy.MaxPos)
+	return buf
+}
+
+// DeserializeLegacyLearnedIndex reads a LearnedIndex written by
+// SerializeLegacy. The blob has no key count, so the model is always used:
+// KeyCount is read back as 1. Returns nil if data is shorter than
+// LearnedIndexLegacySize.
+func DeserializeLegacyLearnedIndex(data []byte) *LearnedIndex {
+	if len(data) < LearnedIndexLegacySize {
+		return nil
+	}
+	return &LearnedIndex{
+		Slope:     math.Float64frombits(binary.LittleEndian.Uint64(data[0:8])),
+		Intercept: math.Float64frombits(binary.LittleEndian.Uint64(data[8:16])),
+		MinErr:    getInt32(data[16:20]),
+		MaxErr:    getInt32(data[20:24]),
+		KeyCount:  1,
+		MaxPos:    binary.LittleEndian.Uint32(data[24:28]),
+	}
+}
+
 // ErrorRange returns the search range size (max - min error).
 // Useful for statistics and debugging.
 func (li *LearnedIndex) ErrorRange() int {
@@ -203,3 +627,25 @@ func (li *LearnedIndex) ErrorRange() int {
 	}
 	return int(li.MaxErr - li.MinErr)
 }
+
+// String returns a one-line summary of the model for logs and debuggers, e.g.
+// LearnedIndex{keys=10000 slope=0.0001 intercept=-0.5 range=[-2,2] maxPos=99},
+// where range is [MinErr, MaxErr].
+func (li *LearnedIndex) String() string {
+	if li == nil {
+		return "LearnedIndex(nil)"
+	}
+	return fmt.Sprintf("LearnedIndex{keys=%d slope=%g intercept=%g range=[%d,%d] maxPos=%d}",
+		li.KeyCount, li.Slope, li.Intercept, li.MinErr, li.MaxErr, li.MaxPos)
+}
+
+// ApproxKeysPerBlock infers the number of keys per block from the model alone,
+// as the inverse of the slope. This is only an approximation: it assumes the
+// model was trained on key positions with roughly uniform block sizes. Returns
+// 0 if the model has no usable slope.
+func (li *LearnedIndex) ApproxKeysPerBlock() float64 {
+	if li == nil || li.Slope == 0 || math.IsNaN(li.Slope) {
+		return 0
+	}
+	return 1 / math.Abs(li.Slope)
+}
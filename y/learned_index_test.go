@@ -5,7 +5,15 @@
 package y
 
 import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
 	"math/rand"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -26,6 +34,123 @@ func TestLearnedIndexEmpty(t *testing.T) {
 	}
 }
 
+// TestEmptyInputContract checks that every constructor, given no keys, yields
+// a filter that rejects every key, as documented on NewFilter.
+func TestEmptyInputContract(t *testing.T) {
+	noBloom := DefaultHybridConfig()
+	noBloom.BloomSizeBytes = 0
+	learned := TrainLearnedIndex(nil, nil, 10)
+	hybrid := TrainHybridFilter(nil, nil, 10, DefaultHybridConfig())
+	restoredHybrid, err := DeserializeHybridFilter(hybrid.Serialize(), len(hybrid.BloomBits))
+	if err != nil {
+		t.Fatalf("DeserializeHybridFilter: %v", err)
+	}
+	filters := map[string]func(uint32) bool{
+		"NewFilter":                  NewFilter(nil, 10).MayContain,
+		"TrainLearnedIndex":          learned.MayContainInRange,
+		"DeserializeLearnedIndex":    DeserializeLearnedIndex(learned.Serialize()).MayContainInRange,
+		"TrainHybridFilter":          hybrid.MayContain,
+		"TrainHybridFilter no bloom": TrainHybridFilter(nil, nil, 10, noBloom).MayContain,
+		"DeserializeHybridFilter":    restoredHybrid.MayContain,
+		"TrainHybridFilter64":        TrainHybridFilter64(nil, nil, 10, noBloom).MayContain,
+		"TrainCompactHybridFilter":   TrainCompactHybridFilter(nil, 10, DefaultCompactConfig()).MayContain,
+	}
+	hashes := append(GenerateSortedKeyHashes(1000), 0, math.MaxUint32)
+	for name, mayContain := range filters {
+		for _, h := range hashes {
+			if mayContain(h) {
+				t.Errorf("%s: empty filter may contain %d", name, h)
+				break
+			}
+		}
+	}
+
+	// Without a filter at all, every key must still be searched for.
+	if !(*LearnedIndex)(nil).MayContainInRange(1) || !(*HybridFilter)(nil).MayContain(1) {
+		t.Error("nil filter rejected a key")
+	}
+}
+
+func TestLearnedIndexString(t *testing.T) {
+	keyCount, numBlocks := 1000, 10
+	positions := make([]uint32, keyCount)
+	blocks := make([]uint32, keyCount)
+	for i := range positions {
+		positions[i] = uint32(i)
+		blocks[i] = uint32(i * numBlocks / keyCount)
+	}
+	for _, model := range []fmt.Stringer{
+		TrainLearnedIndex(positions, blocks, numBlocks),
+		TrainQuadraticLearnedIndex(positions, blocks, numBlocks),
+		TrainSegmentedLearnedIndex(positions, blocks, numBlocks, 4),
+	} {
+		s := model.String()
+		if !strings.Contains(s, "keys=1000 ") || !strings.Contains(s, "maxPos=9") {
+			t.Errorf("String() = %q, want keys=1000 and maxPos=9", s)
+		}
+	}
+	if got := (*LearnedIndex)(nil).String(); got != "LearnedIndex(nil)" {
+		t.Errorf("nil String() = %q", got)
+	}
+}
+
+func TestLearnedIndexPredictMany(t *testing.T) {
+	n, numBlocks := 10000, 100
+	positions := make([]uint32, n)
+	blocks := make([]uint32, n)
+	for i := range positions {
+		positions[i] = uint32(i * 3)
+		blocks[i] = uint32(i * numBlocks / n)
+	}
+	rng := rand.New(rand.NewSource(1))
+	shuffled := slices.Clone(positions)
+	rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	descendingBlocks := slices.Clone(blocks)
+	slices.Reverse(descendingBlocks)
+
+	// Ascending input with repeats, gaps and both ends of the hash domain,
+	// where predictions are clamped.
+	var ascending []uint32
+	for p := uint32(0); p < uint32(4*n); p += uint32(rng.Intn(5)) {
+		ascending = append(ascending, p)
+	}
+	ascending = append(ascending, math.MaxUint32-1, math.MaxUint32, math.MaxUint32)
+	sparse := make([]uint32, 1000)
+	for i := range sparse {
+		sparse[i] = uint32(i) * 4000000
+	}
+
+	models := map[string]*LearnedIndex{
+		"ascending":  TrainLearnedIndex(positions, blocks, numBlocks),
+		"descending": TrainLearnedIndex(positions, descendingBlocks, numBlocks),
+		"hashed":     TrainLearnedIndex(GenerateSortedKeyHashes(n), blocks, numBlocks),
+		"empty":      TrainLearnedIndex(nil, nil, numBlocks),
+		"nan":        {Slope: math.NaN(), KeyCount: 1, MaxPos: 9},
+	}
+	inputs := map[string][]uint32{
+		"ascending": ascending,
+		"sparse":    sparse,
+		"shuffled":  shuffled,
+		"empty":     nil,
+	}
+	for name, li := range models {
+		for inputName, input := range inputs {
+			minBlocks, maxBlocks := li.PredictMany(input)
+			if len(minBlocks) != len(input) || len(maxBlocks) != len(input) {
+				t.Fatalf("%s/%s: got %d and %d ranges for %d positions",
+					name, inputName, len(minBlocks), len(maxBlocks), len(input))
+			}
+			for i, p := range input {
+				_, minB, maxB := li.Predict(p)
+				if minBlocks[i] != minB || maxBlocks[i] != maxB {
+					t.Fatalf("%s/%s: position %d: PredictMany gave [%d,%d], Predict [%d,%d]",
+						name, inputName, p, minBlocks[i], maxBlocks[i], minB, maxB)
+				}
+			}
+		}
+	}
+}
+
 func TestLearnedIndexSingleKey(t *testing.T) {
 	hashes := []uint32{Hash([]byte("key1"))}
 	blocks := []uint32{5}
@@ -68,11 +193,15 @@ func TestLearnedIndexLinearData(t *testing.T) {
 		if pred != expected {
 			t.Errorf("For hash %d: expected pred=%d, got %d", hashes[i], expected, pred)
 		}
-		if minB > expected || maxB < expected {
-			t.Errorf("For hash %d: expected range to include %d, got [%d,%d]",
-				hashes[i], expected, minB, maxB)
+		// The data lies exactly on the line, so no margin is added.
+		if minB != expected || maxB != expected {
+			t.Errorf("For hash %d: expected zero-width range [%d,%d], got [%d,%d]",
+				hashes[i], expected, expected, minB, maxB)
 		}
 	}
+	if li.MinErr != 0 || li.MaxErr != 0 {
+		t.Errorf("Expected zero error bounds, got [%d,%d]", li.MinErr, li.MaxErr)
+	}
 }
 
 func TestLearnedIndexRealisticData(t *testing.T) {
@@ -150,6 +279,56 @@ func TestLearnedIndexSerializationRoundtrip(t *testing.T) {
 	}
 }
 
+func TestLearnedIndexLegacySerialization(t *testing.T) {
+	keys := GenerateSortedKeyHashes(1000)
+	blocks := GenerateBlockIndices(len(keys), 10)
+	trained := TrainLearnedIndex(keys, blocks, 10)
+	empty := TrainLearnedIndex(nil, nil, 10)
+
+	for name, li := range map[string]*LearnedIndex{"trained": trained, "empty": empty} {
+		data := li.SerializeLegacy()
+		if len(data) != LearnedIndexLegacySize || len(li.Serialize()) != LearnedIndexSize {
+			t.Fatalf("%s: legacy %d bytes, current %d bytes, want %d and %d", name, len(data),
+				len(li.Serialize()), LearnedIndexLegacySize, LearnedIndexSize)
+		}
+		if maxPos := binary.LittleEndian.Uint32(data[24:28]); maxPos != li.MaxPos {
+			t.Errorf("%s: legacy blob stores MaxPos %d at bytes 24:28, want %d", name, maxPos, li.MaxPos)
+		}
+		restored := DeserializeLegacyLearnedIndex(data)
+		if restored == nil {
+			t.Fatalf("%s: failed to deserialize", name)
+		}
+		if restored.KeyCount == 0 || restored.MaxPos != li.MaxPos {
+			t.Errorf("%s: restored KeyCount %d, MaxPos %d", name, restored.KeyCount, restored.MaxPos)
+		}
+		for i, h := range keys {
+			_, wantMin, wantMax := li.Predict(h)
+			_, minB, maxB := restored.Predict(h)
+			if minB != wantMin || maxB != wantMax {
+				t.Fatalf("%s: key %d predicted [%d, %d], want [%d, %d]", name, i, minB, maxB, wantMin, wantMax)
+			}
+		}
+	}
+
+	// A blob built by hand in the documented layout.
+	blob := binary.LittleEndian.AppendUint64(nil, math.Float64bits(0.5))
+	blob = binary.LittleEndian.AppendUint64(blob, math.Float64bits(-1.25))
+	minErr := int32(-2)
+	blob = binary.LittleEndian.AppendUint32(blob, uint32(minErr))
+	blob = binary.LittleEndian.AppendUint32(blob, 3)
+	blob = binary.LittleEndian.AppendUint32(blob, 99)
+	want := LearnedIndex{Slope: 0.5, Intercept: -1.25, MinErr: -2, MaxErr: 3, KeyCount: 1, MaxPos: 99}
+	if restored := DeserializeLegacyLearnedIndex(blob); restored == nil || *restored != want {
+		t.Errorf("Hand-built blob read as %+v, want %+v", restored, want)
+	}
+	if got := want.SerializeLegacy(); !slices.Equal(got, blob) {
+		t.Errorf("SerializeLegacy wrote %x, want %x", got, blob)
+	}
+	if DeserializeLegacyLearnedIndex(blob[:LearnedIndexLegacySize-1]) != nil {
+		t.Error("Short blob deserialized")
+	}
+}
+
 func TestLearnedIndexBoundsClamping(t *testing.T) {
 	hashes := []uint32{1000, 2000, 3000}
 	blocks := []uint32{0, 5, 10}
@@ -175,6 +354,184 @@ func TestLearnedIndexBoundsClamping(t *testing.T) {
 	}
 }
 
+// TestRegressionNormalizedFit trains on hashes squeezed into a narrow band at
+// the top of the hash space, where x² dwarfs the variance of x, and compares
+// the slope against an exact rational reference.
+func TestRegressionNormalizedFit(t *testing.T) {
+	n := 100000
+	positions := GenerateSortedKeyHashes(n)
+	for i := range positions {
+		positions[i] = 0xFFF00000 + positions[i]>>12
+	}
+	slices.Sort(positions)
+	blocks := GenerateBlockIndices(n, 1000)
+
+	var bx, by, bxy, bx2 big.Int
+	for i := range positions {
+		x, y := big.NewInt(int64(positions[i])), big.NewInt(int64(blocks[i]))
+		bx.Add(&bx, x)
+		by.Add(&by, y)
+		bxy.Add(&bxy, new(big.Int).Mul(x, y))
+		bx2.Add(&bx2, new(big.Int).Mul(x, x))
+	}
+	bn := big.NewInt(int64(n))
+	num := new(big.Int).Sub(new(big.Int).Mul(bn, &bxy), new(big.Int).Mul(&bx, &by))
+	den := new(big.Int).Sub(new(big.Int).Mul(bn, &bx2), new(big.Int).Mul(&bx, &bx))
+	exact, _ := new(big.Rat).SetFrac(num, den).Float64()
+
+	// The textbook formula on raw float64 sums.
+	var sumX, sumY, sumXY, sumX2 float64
+	for i := range positions {
+		x, y := float64(positions[i]), float64(blocks[i])
+		sumX, sumY, sumXY, sumX2 = sumX+x, sumY+y, sumXY+x*y, sumX2+x*x
+	}
+	nf := float64(n)
+	raw := (nf*sumXY - sumX*sumY) / (nf*sumX2 - sumX*sumX)
+
+	normalized, _ := accumulateSums(positions, blocks).fit()
+	rawErr, normErr := math.Abs(raw-exact)/exact, math.Abs(normalized-exact)/exact
+	t.Logf("Slope: exact %.17g, raw %.17g (rel err %.1e), normalized %.17g (rel err %.1e)",
+		exact, raw, rawErr, normalized, normErr)
+	if normErr > 1e-12 {
+		t.Errorf("Normalized slope %g off the exact %g by %.1e", normalized, exact, normErr)
+	}
+	if rawErr < 100*normErr {
+		t.Errorf("Raw slope error %.1e is not worse than normalized %.1e; the data is not ill-conditioned",
+			rawErr, normErr)
+	}
+
+	// Reproducible regardless of how the keys are split or ordered.
+	rng := rand.New(rand.NewSource(1))
+	perm := rng.Perm(n)
+	shuffledPos, shuffledBlocks := make([]uint32, n), make([]uint32, n)
+	for i, j := range perm {
+		shuffledPos[i], shuffledBlocks[i] = positions[j], blocks[j]
+	}
+	for name, sums := range map[string]regressionSums{
+		"parallel": parallelSums(positions, blocks, 8),
+		"shuffled": accumulateSums(shuffledPos, shuffledBlocks),
+	} {
+		if slope, _ := sums.fit(); math.Abs(slope-exact)/exact > 1e-12 {
+			t.Errorf("%s: slope %.17g, want %.17g", name, slope, exact)
+		}
+	}
+}
+
+func TestLearnedIndexParallelSumsMatchSerial(t *testing.T) {
+	n := 1 << 20
+	numBlocks := 1000
+	hashes := make([]uint32, n)
+	blocks := make([]uint32, n)
+	for i := 0; i < n; i++ {
+		hashes[i] = uint32(i * 4096)
+		blocks[i] = uint32(i / (n / numBlocks))
+	}
+
+	serialSlope, serialIntercept := accumulateSums(hashes, blocks).fit()
+	parallelSlope, parallelIntercept := parallelSums(hashes, blocks, 8).fit()
+
+	if math.Abs(serialSlope-parallelSlope) > 1e-9*math.Abs(serialSlope) {
+		t.Errorf("Slope mismatch: serial %g, parallel %g", serialSlope, parallelSlope)
+	}
+	if math.Abs(serialIntercept-parallelIntercept) > 1e-6 {
+		t.Errorf("Intercept mismatch: serial %g, parallel %g", serialIntercept, parallelIntercept)
+	}
+
+	// The public entry point takes the parallel path at this size.
+	li := TrainLearnedIndex(hashes, blocks, numBlocks)
+	if math.Abs(li.Slope-serialSlope) > 1e-9*math.Abs(serialSlope) {
+		t.Errorf("TrainLearnedIndex slope %g differs from serial %g", li.Slope, serialSlope)
+	}
+}
+
+func TestLearnedIndexApproxKeysPerBlock(t *testing.T) {
+	n := 10000
+	keysPerBlock := 128
+	positions := make([]uint32, n)
+	blocks := make([]uint32, n)
+	for i := 0; i < n; i++ {
+		positions[i] = uint32(i)
+		blocks[i] = uint32(i / keysPerBlock)
+	}
+	numBlocks := (n + keysPerBlock - 1) / keysPerBlock
+
+	li := TrainLearnedIndex(positions, blocks, numBlocks)
+	got := li.ApproxKeysPerBlock()
+	if math.Abs(got-float64(keysPerBlock)) > 0.01*float64(keysPerBlock) {
+		t.Errorf("Expected ~%d keys per block, got %f", keysPerBlock, got)
+	}
+
+	// A flat model carries no layout information.
+	flat := TrainLearnedIndex([]uint32{7}, []uint32{3}, 4)
+	if got := flat.ApproxKeysPerBlock(); got != 0 {
+		t.Errorf("Expected 0 for zero slope, got %f", got)
+	}
+}
+
+func TestBlockIndicesFromBoundaries(t *testing.T) {
+	sizes := []int{50, 200, 10, 500}
+	var blockStarts []int
+	keyCount := 0
+	for _, size := range sizes {
+		blockStarts = append(blockStarts, keyCount)
+		keyCount += size
+	}
+
+	blocks := BlockIndicesFromBoundaries(keyCount, blockStarts)
+	if len(blocks) != keyCount {
+		t.Fatalf("Expected %d block indices, got %d", keyCount, len(blocks))
+	}
+	i := 0
+	for b, size := range sizes {
+		for j := 0; j < size; j++ {
+			if blocks[i] != uint32(b) {
+				t.Fatalf("Key %d: expected block %d, got %d", i, b, blocks[i])
+			}
+			i++
+		}
+	}
+
+	positions := make([]uint32, keyCount)
+	for i := range positions {
+		positions[i] = uint32(i)
+	}
+	li := TrainLearnedIndex(positions, blocks, len(sizes))
+	for i, pos := range positions {
+		_, minB, maxB := li.Predict(pos)
+		if int(blocks[i]) < minB || int(blocks[i]) > maxB {
+			t.Fatalf("Key %d: block %d not in predicted range [%d,%d]", i, blocks[i], minB, maxB)
+		}
+	}
+
+	if got := BlockIndicesFromBoundaries(3, nil); len(got) != 3 || got[0] != 0 || got[2] != 0 {
+		t.Errorf("Expected all keys in block 0 without boundaries, got %v", got)
+	}
+}
+
+func TestIntersectRanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		ranges   [][2]int
+		min, max int
+		empty    bool
+	}{
+		{"overlapping", [][2]int{{2, 8}, {5, 12}}, 5, 8, false},
+		{"disjoint", [][2]int{{0, 3}, {5, 9}}, 0, 0, true},
+		{"nested", [][2]int{{0, 20}, {4, 6}}, 4, 6, false},
+		{"touching", [][2]int{{0, 5}, {5, 9}}, 5, 5, false},
+		{"three", [][2]int{{0, 10}, {3, 12}, {1, 7}}, 3, 7, false},
+		{"single", [][2]int{{4, 9}}, 4, 9, false},
+		{"none", nil, 0, 0, true},
+	}
+	for _, tc := range tests {
+		minB, maxB, empty := IntersectRanges(tc.ranges...)
+		if minB != tc.min || maxB != tc.max || empty != tc.empty {
+			t.Errorf("%s: got (%d, %d, %v), want (%d, %d, %v)",
+				tc.name, minB, maxB, empty, tc.min, tc.max, tc.empty)
+		}
+	}
+}
+
 func BenchmarkLearnedIndexTrain(b *testing.B) {
 	n := 10000
 	numBlocks := 500
@@ -192,6 +549,29 @@ func BenchmarkLearnedIndexTrain(b *testing.B) {
 	}
 }
 
+func BenchmarkLearnedIndexTrain1M(b *testing.B) {
+	n := 1 << 20
+	numBlocks := 5000
+	hashes := make([]uint32, n)
+	blocks := make([]uint32, n)
+
+	for i := 0; i < n; i++ {
+		hashes[i] = rand.Uint32()
+		blocks[i] = uint32(i / (n / numBlocks))
+	}
+
+	b.Run("Serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			accumulateSums(hashes, blocks).fit()
+		}
+	})
+	b.Run("Parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			computeRegressionSums(hashes, blocks).fit()
+		}
+	})
+}
+
 func BenchmarkLearnedIndexPredict(b *testing.B) {
 	n := 10000
 	numBlocks := 500
@@ -211,6 +591,34 @@ func BenchmarkLearnedIndexPredict(b *testing.B) {
 	}
 }
 
+// BenchmarkLearnedIndexPredictMany compares PredictMany with a loop over
+// Predict on 100000 ascending positions, about 100 to a block.
+func BenchmarkLearnedIndexPredictMany(b *testing.B) {
+	n, numBlocks := 100000, 1000
+	positions := make([]uint32, n)
+	blocks := make([]uint32, n)
+	for i := range positions {
+		positions[i] = uint32(i)
+		blocks[i] = uint32(i * numBlocks / n)
+	}
+	li := TrainLearnedIndex(positions, blocks, numBlocks)
+
+	b.Run("Loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			minBlocks := make([]int, n)
+			maxBlocks := make([]int, n)
+			for j, p := range positions {
+				_, minBlocks[j], maxBlocks[j] = li.Predict(p)
+			}
+		}
+	})
+	b.Run("PredictMany", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			li.PredictMany(positions)
+		}
+	})
+}
+
 func BenchmarkLearnedIndexSerialize(b *testing.B) {
 	li := &LearnedIndex{
 		Slope:     0.001234,
@@ -226,3 +634,130 @@ func BenchmarkLearnedIndexSerialize(b *testing.B) {
 		li.Serialize()
 	}
 }
+
+func TestTrainLearnedIndexWithOptions(t *testing.T) {
+	n := 1000
+	numBlocks := 10
+	positions := make([]uint32, n)
+	for i := range positions {
+		positions[i] = uint32(i) * 3
+	}
+	blocks := GenerateBlockIndices(n, numBlocks)
+	sorted := TrainLearnedIndex(positions, blocks, numBlocks)
+
+	if !ValidateSorted(positions) || !ValidateSorted(nil) {
+		t.Error("Expected ascending input to validate")
+	}
+
+	// Already sorted.
+	li, err := TrainLearnedIndexWithOptions(positions, blocks, numBlocks,
+		LearnedIndexOptions{RequireSorted: true})
+	if err != nil {
+		t.Fatalf("Sorted input rejected: %v", err)
+	}
+	if *li != *sorted {
+		t.Errorf("Model %+v differs from TrainLearnedIndex %+v", *li, *sorted)
+	}
+
+	// Reverse-sorted: consistent pairs in the wrong order.
+	revPos := slices.Clone(positions)
+	revBlocks := slices.Clone(blocks)
+	slices.Reverse(revPos)
+	slices.Reverse(revBlocks)
+	if ValidateSorted(revPos) {
+		t.Error("Reversed positions should not validate")
+	}
+	if _, err := TrainLearnedIndexWithOptions(revPos, revBlocks, numBlocks,
+		LearnedIndexOptions{RequireSorted: true}); !errors.Is(err, ErrUnsorted) {
+		t.Errorf("Expected ErrUnsorted for reversed input, got %v", err)
+	}
+	li, err = TrainLearnedIndexWithOptions(revPos, revBlocks, numBlocks,
+		LearnedIndexOptions{RequireSorted: true, AutoSort: true})
+	if err != nil {
+		t.Fatalf("Auto-sorted input rejected: %v", err)
+	}
+	if li.MinErr != sorted.MinErr || li.MaxErr != sorted.MaxErr ||
+		math.Abs(li.Slope-sorted.Slope) > 1e-12 {
+		t.Errorf("Auto-sorted model %+v differs from sorted model %+v", *li, *sorted)
+	}
+	if !slices.Equal(revPos[:3], []uint32{2997, 2994, 2991}) {
+		t.Error("AutoSort must not modify the caller's slices")
+	}
+
+	// Genuinely unordered: block indices assigned in key order but paired
+	// with hashes, which do not follow it. Sorting cannot fix that.
+	hashes := GenerateSortedKeyHashes(n)
+	if _, err := TrainLearnedIndexWithOptions(hashes, blocks, numBlocks,
+		LearnedIndexOptions{RequireSorted: true, AutoSort: true}); !errors.Is(err, ErrUnsorted) {
+		t.Errorf("Expected ErrUnsorted for mismatched pairs, got %v", err)
+	}
+	// Without RequireSorted, training proceeds as before.
+	if _, err := TrainLearnedIndexWithOptions(hashes, blocks, numBlocks,
+		LearnedIndexOptions{}); err != nil {
+		t.Errorf("Unexpected error without validation: %v", err)
+	}
+
+	if _, err := TrainLearnedIndexWithOptions(positions, blocks[:1], numBlocks,
+		LearnedIndexOptions{}); err == nil {
+		t.Error("Expected an error for mismatched slice lengths")
+	}
+}
+
+func TestSignedInt32RoundTrip(t *testing.T) {
+	values := []int32{math.MinInt32, math.MinInt32 + 1, -65536, -1, 0, 1, 65535, math.MaxInt32 - 1, math.MaxInt32}
+	for i := 0; i < 1000; i++ {
+		values = append(values, int32(rand.Uint32()))
+	}
+	buf := make([]byte, 4)
+	for _, v := range values {
+		putInt32(buf, v)
+		if got := getInt32(buf); got != v {
+			t.Fatalf("putInt32/getInt32(%d) = %d", v, got)
+		}
+	}
+	putInt32(buf, -1)
+	if !slices.Equal(buf, []byte{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("-1 encoded as %x, want ffffffff", buf)
+	}
+	putInt32(buf, math.MinInt32)
+	if !slices.Equal(buf, []byte{0, 0, 0, 0x80}) {
+		t.Errorf("MinInt32 encoded as %x, want 00000080", buf)
+	}
+
+	// Extreme error bounds survive every format that stores them.
+	for _, bounds := range [][2]int32{{math.MinInt32, math.MaxInt32}, {-1, 0}, {0, -1}} {
+		li := &LearnedIndex{MinErr: bounds[0], MaxErr: bounds[1], KeyCount: 1}
+		if got := DeserializeLearnedIndex(li.Serialize()); *got != *li {
+			t.Errorf("LearnedIndex bounds %v became %d/%d", bounds, got.MinErr, got.MaxErr)
+		}
+		qi := &QuadraticLearnedIndex{MinErr: bounds[0], MaxErr: bounds[1], KeyCount: 1}
+		if got := DeserializeQuadraticLearnedIndex(qi.Serialize()); *got != *qi {
+			t.Errorf("QuadraticLearnedIndex bounds %v became %d/%d", bounds, got.MinErr, got.MaxErr)
+		}
+		hf := &HybridFilter{BloomBits: make([]byte, 8), MinErr: bounds[0], MaxErr: bounds[1]}
+		got, err := DeserializeHybridFilter(hf.Serialize(), len(hf.BloomBits))
+		if err != nil {
+			t.Fatalf("DeserializeHybridFilter: %v", err)
+		}
+		if got.MinErr != bounds[0] || got.MaxErr != bounds[1] {
+			t.Errorf("HybridFilter bounds %v became %d/%d", bounds, got.MinErr, got.MaxErr)
+		}
+	}
+}
+
+func TestRadixSortHigh32(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, n := range []int{0, 1, 7, 1000, 100000} {
+		vals := make([]uint64, n)
+		for i := range vals {
+			// Few distinct high halves, so stability is exercised.
+			vals[i] = uint64(rng.Intn(50))<<32 | uint64(i)
+		}
+		want := slices.Clone(vals)
+		slices.SortStableFunc(want, func(a, b uint64) int { return cmp.Compare(a>>32, b>>32) })
+		radixSortHigh32(vals)
+		if !slices.Equal(vals, want) {
+			t.Fatalf("n=%d: radix sort differs from a stable sort", n)
+		}
+	}
+}
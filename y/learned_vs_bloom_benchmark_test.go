@@ -304,10 +304,99 @@ func BenchmarkCompareStorageSize(b *testing.B) {
 	fmt.Println(strings.Repeat("-", 50))
 }
 
+// BenchmarkCompareFilters runs RunFilterBenchmark for each TableFilter.
+func BenchmarkCompareFilters(b *testing.B) {
+	numBlocks := 100
+	for _, size := range []int{1000, 10000, 100000} {
+		hashes := GenerateSortedKeyHashes(size)
+		for _, tc := range benchmarkFilters(numBlocks) {
+			b.Run(fmt.Sprintf("%s/size=%d", tc.name, size), func(b *testing.B) {
+				RunFilterBenchmark(tc.name, tc.build, hashes, b)
+			})
+		}
+	}
+}
+
+// benchmarkFilters returns the constructors BenchmarkCompareFilters compares,
+// for tables of numBlocks blocks.
+func benchmarkFilters(numBlocks int) []struct {
+	name  string
+	build func([]uint32) TableFilter
+} {
+	return []struct {
+		name  string
+		build func([]uint32) TableFilter
+	}{
+		{"Filter", func(hashes []uint32) TableFilter {
+			return BloomTableFilter{NewFilter(hashes, BloomBitsPerKey(len(hashes), 0.01))}
+		}},
+		{"HybridFilter", func(hashes []uint32) TableFilter {
+			blocks := GenerateBlockIndices(len(hashes), numBlocks)
+			return TrainHybridFilter(hashes, blocks, numBlocks, DefaultHybridConfig())
+		}},
+		{"CompactHybridFilter", func(hashes []uint32) TableFilter {
+			return TrainCompactHybridFilter(hashes, numBlocks, DefaultCompactConfig())
+		}},
+	}
+}
+
 // ============================================================================
 // HELPER FUNCTIONS
 // ============================================================================
 
+// RunFilterBenchmark benchmarks a filter built from hashes by build: each
+// iteration builds it, queries every hash and as many hashes outside the set.
+// It reports the build time, the time per hit and per miss query, the
+// serialized size and the false positive rate of the misses, so a new filter
+// type needs only its constructor to be compared with the others.
+func RunFilterBenchmark(name string, build func([]uint32) TableFilter, hashes []uint32, b *testing.B) {
+	members := make(map[uint32]struct{}, len(hashes))
+	for _, h := range hashes {
+		members[h] = struct{}{}
+	}
+	rng := rand.New(rand.NewSource(1))
+	misses := make([]uint32, 0, len(hashes))
+	for len(misses) < len(hashes) {
+		h := rng.Uint32()
+		if _, ok := members[h]; !ok {
+			misses = append(misses, h)
+		}
+	}
+
+	var buildTime, hitTime, missTime time.Duration
+	var size, falsePositives int
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		f := build(hashes)
+		buildTime += time.Since(start)
+
+		start = time.Now()
+		for _, h := range hashes {
+			if !f.MayContain(h) {
+				b.Fatalf("%s: false negative for key hash %d", name, h)
+			}
+		}
+		hitTime += time.Since(start)
+
+		start = time.Now()
+		for _, h := range misses {
+			if f.MayContain(h) {
+				falsePositives++
+			}
+		}
+		missTime += time.Since(start)
+		size = f.Size()
+	}
+
+	queries := float64(b.N) * float64(max(len(hashes), 1))
+	b.ReportMetric(float64(buildTime.Nanoseconds())/float64(b.N), "build-ns/op")
+	b.ReportMetric(float64(hitTime.Nanoseconds())/queries, "hit-ns/query")
+	b.ReportMetric(float64(missTime.Nanoseconds())/queries, "miss-ns/query")
+	b.ReportMetric(float64(size), "bytes")
+	b.ReportMetric(float64(falsePositives)/queries, "fp-rate")
+}
+
 // generateSortedKeys creates a slice of sorted byte keys
 func generateSortedKeys(n int) [][]byte {
 	keys := make([][]byte, n)
@@ -318,6 +407,27 @@ func generateSortedKeys(n int) [][]byte {
 	return keys
 }
 
+func TestRunFilterBenchmark(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs benchmarks")
+	}
+	hashes := GenerateSortedKeyHashes(2000)
+	for _, tc := range benchmarkFilters(20) {
+		result := testing.Benchmark(func(b *testing.B) {
+			RunFilterBenchmark(tc.name, tc.build, hashes, b)
+		})
+		if result.N == 0 {
+			t.Fatalf("%s: benchmark did not run", tc.name)
+		}
+		for _, metric := range []string{"build-ns/op", "hit-ns/query", "miss-ns/query", "bytes"} {
+			if result.Extra[metric] <= 0 {
+				t.Errorf("%s: %s is %v", tc.name, metric, result.Extra[metric])
+			}
+		}
+		t.Logf("%s: %v", tc.name, result.Extra)
+	}
+}
+
 // TestPrintLearnedIndexDetails shows detailed learned index statistics
 func TestPrintLearnedIndexDetails(t *testing.T) {
 	fmt.Println("\n" + strings.Repeat("=", 60))
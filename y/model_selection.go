@@ -0,0 +1,140 @@
+/*
+ * Model selection - pick the best learned model for a table automatically
+ *
+ * Which model works best depends on how keys are distributed over blocks:
+ * sequential keys are served by a single line, clustered keys need a line per
+ * cluster, and hashed keys have no structure a model can learn at all. Rather
+ * than making the caller choose, TrainBestIndex trains each candidate, scores
+ * it by its average search range over the training keys, and keeps the
+ * tightest one that fits the byte budget. When even the best model would
+ * search a large part of the table, it reports bloom-only instead.
+ */
+
+package y
+
+// IndexKind identifies the model chosen by TrainBestIndex.
+type IndexKind int
+
+const (
+	// IndexBloomOnly means no model was worth storing; rely on the bloom filter.
+	IndexBloomOnly IndexKind = iota
+	IndexLinear
+	IndexQuadratic
+	IndexSegmented
+)
+
+func (k IndexKind) String() string {
+	switch k {
+	case IndexBloomOnly:
+		return "bloom-only"
+	case IndexLinear:
+		return "linear"
+	case IndexQuadratic:
+		return "quadratic"
+	case IndexSegmented:
+		return "segmented"
+	}
+	return "unknown"
+}
+
+const (
+	// bestIndexMaxRangeFraction is the largest average search range, as a
+	// fraction of the table's blocks, for which a model is worth keeping.
+	bestIndexMaxRangeFraction = 0.25
+	// bestIndexMinGain is the relative reduction in search range a larger
+	// model must achieve to be chosen over a smaller one.
+	bestIndexMinGain = 0.1
+	// bestIndexMaxSegments caps the segment count tried for the segmented model.
+	bestIndexMaxSegments = 64
+)
+
+// BestIndex is the result of TrainBestIndex. Exactly one of Linear, Quadratic
+// and Segmented is set, according to Kind; none is set for IndexBloomOnly.
+type BestIndex struct {
+	Kind           IndexKind
+	Linear         *LearnedIndex
+	Quadratic      *QuadraticLearnedIndex
+	Segmented      *SegmentedLearnedIndex
+	AvgSearchRange float64 // Average blocks searched per training key
+	SizeBytes      int     // Storage size of the chosen model
+	MaxPos         uint32  // Maximum position (number of blocks - 1)
+}
+
+// Predict returns the predicted block and search range of the chosen model.
+// For IndexBloomOnly every block must be searched.
+func (bi *BestIndex) Predict(position uint32) (predicted, minBlock, maxBlock int) {
+	switch bi.Kind {
+	case IndexLinear:
+		return bi.Linear.Predict(position)
+	case IndexQuadratic:
+		return bi.Quadratic.Predict(position)
+	case IndexSegmented:
+		return bi.Segmented.Predict(position)
+	}
+	return 0, 0, int(bi.MaxPos)
+}
+
+// TrainBestIndex trains linear, quadratic and segmented models on the sorted
+// positions and returns the one with the smallest average search range whose
+// size is at most budgetBytes. Smaller models win unless a larger one narrows
+// the range by more than bestIndexMinGain. If no model fits the budget or the
+// best one still searches more than bestIndexMaxRangeFraction of the blocks,
+// the result is IndexBloomOnly.
+func TrainBestIndex(positions, blockIndices []uint32, numBlocks int, budgetBytes int) *BestIndex {
+	best := &BestIndex{
+		Kind:           IndexBloomOnly,
+		AvgSearchRange: float64(max(numBlocks, 1)),
+		MaxPos:         uint32(max(0, numBlocks-1)),
+	}
+	if len(positions) == 0 {
+		return best
+	}
+
+	// Candidates are offered in increasing size order.
+	consider := func(candidate BestIndex) {
+		if candidate.SizeBytes > budgetBytes {
+			return
+		}
+		candidate.MaxPos = best.MaxPos
+		candidate.AvgSearchRange = avgSearchRange(&candidate, positions)
+		if best.Kind == IndexBloomOnly ||
+			candidate.AvgSearchRange < best.AvgSearchRange*(1-bestIndexMinGain) {
+			*best = candidate
+		}
+	}
+
+	linear := TrainLearnedIndex(positions, blockIndices, numBlocks)
+	consider(BestIndex{Kind: IndexLinear, Linear: linear, SizeBytes: LearnedIndexSize})
+
+	quad := TrainQuadraticLearnedIndex(positions, blockIndices, numBlocks)
+	consider(BestIndex{Kind: IndexQuadratic, Quadratic: quad, SizeBytes: quad.Size()})
+
+	for segments := 2; segments <= bestIndexMaxSegments && segments <= len(positions); segments *= 2 {
+		if segments*(4+LearnedIndexSize) > budgetBytes {
+			break
+		}
+		seg := TrainSegmentedLearnedIndex(positions, blockIndices, numBlocks, segments)
+		consider(BestIndex{Kind: IndexSegmented, Segmented: seg, SizeBytes: seg.Size()})
+	}
+
+	if best.Kind != IndexBloomOnly &&
+		best.AvgSearchRange > bestIndexMaxRangeFraction*float64(max(numBlocks, 1)) {
+		return &BestIndex{
+			Kind:           IndexBloomOnly,
+			AvgSearchRange: float64(max(numBlocks, 1)),
+			MaxPos:         best.MaxPos,
+		}
+	}
+	return best
+}
+
+// avgSearchRange returns the average number of blocks bi searches for the
+// given positions.
+func avgSearchRange(bi *BestIndex, positions []uint32) float64 {
+	total := 0
+	for _, pos := range positions {
+		_, minBlock, maxBlock := bi.Predict(pos)
+		total += maxBlock - minBlock + 1
+	}
+	return float64(total) / float64(len(positions))
+}
@@ -0,0 +1,88 @@
+/*
+ * Tests for automatic model selection
+ */
+
+package y
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestTrainBestIndex(t *testing.T) {
+	n := 10000
+	numBlocks := 100
+	budget := 1024
+
+	sequential := make([]uint32, n)
+	for i := range sequential {
+		sequential[i] = uint32(i)
+	}
+	clustered, _ := clusteredPositions(n, 4, numBlocks)
+	blocks := GenerateBlockIndices(n, numBlocks)
+
+	// Hashes of sorted keys bear no relation to the block order: sorted by
+	// hash, the blocks come out shuffled.
+	hashes := GenerateSortedKeyHashes(n)
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return hashes[order[a]] < hashes[order[b]] })
+	hashed := make([]uint32, n)
+	hashedBlocks := make([]uint32, n)
+	for i, j := range order {
+		hashed[i], hashedBlocks[i] = hashes[j], blocks[j]
+	}
+
+	tests := []struct {
+		name      string
+		positions []uint32
+		blocks    []uint32
+		want      IndexKind
+	}{
+		{"sequential", sequential, blocks, IndexLinear},
+		{"clustered", clustered, blocks, IndexSegmented},
+		{"hashed", hashed, hashedBlocks, IndexBloomOnly},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocks := tt.blocks
+			bi := TrainBestIndex(tt.positions, blocks, numBlocks, budget)
+			t.Logf("%s: chose %s, avg range %.1f blocks, %d bytes",
+				tt.name, bi.Kind, bi.AvgSearchRange, bi.SizeBytes)
+			if bi.Kind != tt.want {
+				t.Fatalf("Expected %s, got %s", tt.want, bi.Kind)
+			}
+			if bi.SizeBytes > budget {
+				t.Errorf("Model size %d exceeds budget %d", bi.SizeBytes, budget)
+			}
+			for i, pos := range tt.positions {
+				if _, lo, hi := bi.Predict(pos); int(blocks[i]) < lo || int(blocks[i]) > hi {
+					t.Fatalf("Key %d: block %d not in [%d,%d]", i, blocks[i], lo, hi)
+				}
+			}
+		})
+	}
+}
+
+func TestTrainBestIndexBudget(t *testing.T) {
+	n := 10000
+	numBlocks := 100
+	positions, blocks := clusteredPositions(n, 4, numBlocks)
+
+	// Too small for any model.
+	if bi := TrainBestIndex(positions, blocks, numBlocks, LearnedIndexSize-1); bi.Kind != IndexBloomOnly {
+		t.Errorf("Expected bloom-only under a tiny budget, got %s", bi.Kind)
+	}
+	// Room for the linear and quadratic models only.
+	bi := TrainBestIndex(positions, blocks, numBlocks, QuadraticLearnedIndexSize)
+	if bi.Kind == IndexSegmented {
+		t.Errorf("Segmented model of %d bytes chosen under a %d byte budget",
+			bi.SizeBytes, QuadraticLearnedIndexSize)
+	}
+
+	if bi := TrainBestIndex(nil, nil, numBlocks, 1024); bi.Kind != IndexBloomOnly {
+		t.Errorf("Expected bloom-only for empty input, got %s", bi.Kind)
+	}
+}
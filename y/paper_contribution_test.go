@@ -138,8 +138,8 @@ func TestPaperContribution(t *testing.T) {
 	fmt.Println()
 
 	// Calculate correlation between hash and block for hashed scenario
-	hashBlockCorrelation := calculateCorrelation(hashedPositions, blockIndices)
-	sortedBlockCorrelation := calculateCorrelation(sortedPositions, blockIndices)
+	hashBlockCorrelation := correlation(hashedPositions, blockIndices)
+	sortedBlockCorrelation := correlation(sortedPositions, blockIndices)
 
 	fmt.Printf("     Correlation (sorted position → block): %.4f (near perfect)\n",
 		sortedBlockCorrelation)
@@ -184,51 +184,6 @@ func TestPaperContribution(t *testing.T) {
 	fmt.Println()
 }
 
-// calculateCorrelation computes Pearson correlation coefficient
-func calculateCorrelation(x []uint32, y []uint32) float64 {
-	n := len(x)
-	if n == 0 || n != len(y) {
-		return 0
-	}
-
-	// Calculate means
-	var sumX, sumY float64
-	for i := 0; i < n; i++ {
-		sumX += float64(x[i])
-		sumY += float64(y[i])
-	}
-	meanX := sumX / float64(n)
-	meanY := sumY / float64(n)
-
-	// Calculate correlation
-	var numerator, denomX, denomY float64
-	for i := 0; i < n; i++ {
-		dx := float64(x[i]) - meanX
-		dy := float64(y[i]) - meanY
-		numerator += dx * dy
-		denomX += dx * dx
-		denomY += dy * dy
-	}
-
-	if denomX == 0 || denomY == 0 {
-		return 0
-	}
-
-	return numerator / (sqrt(denomX) * sqrt(denomY))
-}
-
-// sqrt computes square root (avoiding math import conflict)
-func sqrt(x float64) float64 {
-	if x <= 0 {
-		return 0
-	}
-	z := x
-	for i := 0; i < 10; i++ {
-		z -= (z*z - x) / (2 * z)
-	}
-	return z
-}
-
 // TestSortedVsHashedLearnedIndex provides detailed comparison
 func TestSortedVsHashedLearnedIndex(t *testing.T) {
 	fmt.Println("\n" + strings.Repeat("=", 70))
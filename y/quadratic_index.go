@@ -0,0 +1,206 @@
+/*
+ * Quadratic Learned Index - second-order regression for SSTable key lookup
+ *
+ * For position→block mappings that are smooth but mildly non-linear (e.g.
+ * blocks that grow or shrink across the table), a single quadratic term
+ * tightens the error bounds considerably for 8 more bytes than LearnedIndex:
+ * position = a * x² + b * x + c
+ */
+
+package y
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// QuadraticLearnedIndex is a least squares quadratic model predicting the block
+// index of a key from its position.
+type QuadraticLearnedIndex struct {
+	A        float64 // Quadratic coefficient
+	B        float64 // Linear coefficient
+	C        float64 // Constant term
+	MinErr   int32   // Minimum error (negative = predicted too high)
+	MaxErr   int32   // Maximum error (positive = predicted too low)
+	KeyCount uint32  // Number of keys used for training
+	MaxPos   uint32  // Maximum position (number of blocks - 1)
+}
+
+// QuadraticLearnedIndexSize is the serialized size in bytes: 8+8+8+4+4+4+4 = 40 bytes
+const QuadraticLearnedIndexSize = 40
+
+// TrainQuadraticLearnedIndex fits block ≈ a*x² + b*x + c over sorted positions.
+func TrainQuadraticLearnedIndex(positions []uint32, blockIndices []uint32, numBlocks int) *QuadraticLearnedIndex {
+	qi := &QuadraticLearnedIndex{
+		KeyCount: uint32(len(positions)),
+		MaxPos:   uint32(max(0, numBlocks-1)),
+	}
+	n := len(positions)
+	if n == 0 {
+		return qi
+	}
+
+	// Fit in a centered and scaled space u = (x - mean) / scale, so that the
+	// fourth-power sums stay well conditioned, then expand back to x.
+	var mean, scale float64
+	for _, x := range positions {
+		mean += float64(x)
+	}
+	mean /= float64(n)
+	for _, x := range positions {
+		scale = math.Max(scale, math.Abs(float64(x)-mean))
+	}
+	if scale == 0 {
+		scale = 1
+	}
+
+	var s [5]float64 // s[k] = sum(u^k)
+	var t [3]float64 // t[k] = sum(u^k * y)
+	for i, x := range positions {
+		u := (float64(x) - mean) / scale
+		y := float64(blockIndices[i])
+		uk := 1.0
+		for k := 0; k < 5; k++ {
+			s[k] += uk
+			if k < 3 {
+				t[k] += uk * y
+			}
+			uk *= u
+		}
+	}
+
+	// Normal equations for [c', b', a'] in the scaled space.
+	m := [3][4]float64{
+		{s[0], s[1], s[2], t[0]},
+		{s[1], s[2], s[3], t[1]},
+		{s[2], s[3], s[4], t[2]},
+	}
+	coef, ok := solve3(m)
+	if !ok {
+		// Degenerate input (fewer than three distinct positions); fall back to
+		// the linear fit.
+		slope, intercept := computeRegressionSums(positions, blockIndices).fit()
+		qi.B, qi.C = slope, intercept
+	} else {
+		c, b, a := coef[0], coef[1], coef[2]
+		qi.A = a / (scale * scale)
+		qi.B = b/scale - 2*a*mean/(scale*scale)
+		qi.C = a*mean*mean/(scale*scale) - b*mean/scale + c
+	}
+
+	var minErr, maxErr int32
+	for i, x := range positions {
+		err := int32(float64(blockIndices[i]) - qi.eval(x))
+		if err < minErr {
+			minErr = err
+		}
+		if err > maxErr {
+			maxErr = err
+		}
+	}
+	qi.MinErr = minErr - 1
+	qi.MaxErr = maxErr + 1
+	return qi
+}
+
+// solve3 solves a 3x3 augmented linear system using Gaussian elimination with
+// partial pivoting. Returns false if the system is singular.
+func solve3(m [3][4]float64) ([3]float64, bool) {
+	var x [3]float64
+	for col := 0; col < 3; col++ {
+		pivot := col
+		for row := col + 1; row < 3; row++ {
+			if math.Abs(m[row][col]) > math.Abs(m[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-12 {
+			return x, false
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		for row := col + 1; row < 3; row++ {
+			f := m[row][col] / m[col][col]
+			for k := col; k < 4; k++ {
+				m[row][k] -= f * m[col][k]
+			}
+		}
+	}
+	for row := 2; row >= 0; row-- {
+		sum := m[row][3]
+		for k := row + 1; k < 3; k++ {
+			sum -= m[row][k] * x[k]
+		}
+		x[row] = sum / m[row][row]
+	}
+	return x, true
+}
+
+func (qi *QuadraticLearnedIndex) eval(x uint32) float64 {
+	xf := float64(x)
+	return (qi.A*xf+qi.B)*xf + qi.C
+}
+
+// Predict returns the predicted block index for a given position.
+// Returns (predictedBlock, minBlock, maxBlock) where the key should be
+// searched in the range [minBlock, maxBlock]. A nil index returns zeros.
+func (qi *QuadraticLearnedIndex) Predict(position uint32) (predicted, minBlock, maxBlock int) {
+	if qi == nil {
+		return 0, 0, 0
+	}
+	if qi.KeyCount == 0 {
+		// No model - search all blocks
+		return 0, 0, int(qi.MaxPos)
+	}
+
+	predicted = int(math.Round(qi.eval(position)))
+	maxPosInt := int(qi.MaxPos)
+	minBlock = min(max(predicted+int(qi.MinErr), 0), maxPosInt)
+	maxBlock = min(max(predicted+int(qi.MaxErr), 0), maxPosInt)
+	predicted = min(max(predicted, 0), maxPosInt)
+	return predicted, minBlock, maxBlock
+}
+
+// Size returns the serialized size in bytes.
+func (qi *QuadraticLearnedIndex) Size() int {
+	return QuadraticLearnedIndexSize
+}
+
+// String returns a one-line summary of the model, as LearnedIndex.String does.
+func (qi *QuadraticLearnedIndex) String() string {
+	if qi == nil {
+		return "QuadraticLearnedIndex(nil)"
+	}
+	return fmt.Sprintf("QuadraticLearnedIndex{keys=%d a=%g b=%g c=%g range=[%d,%d] maxPos=%d}",
+		qi.KeyCount, qi.A, qi.B, qi.C, qi.MinErr, qi.MaxErr, qi.MaxPos)
+}
+
+// Serialize converts the QuadraticLearnedIndex to bytes for storage.
+// Format: [a:8][b:8][c:8][minErr:4][maxErr:4][keyCount:4][maxPos:4] = 40 bytes
+func (qi *QuadraticLearnedIndex) Serialize() []byte {
+	buf := make([]byte, QuadraticLearnedIndexSize)
+	binary.LittleEndian.PutUint64(buf[0:8], math.Float64bits(qi.A))
+	binary.LittleEndian.PutUint64(buf[8:16], math.Float64bits(qi.B))
+	binary.LittleEndian.PutUint64(buf[16:24], math.Float64bits(qi.C))
+	putInt32(buf[24:28], qi.MinErr)
+	putInt32(buf[28:32], qi.MaxErr)
+	binary.LittleEndian.PutUint32(buf[32:36], qi.KeyCount)
+	binary.LittleEndian.PutUint32(buf[36:40], qi.MaxPos)
+	return buf
+}
+
+// DeserializeQuadraticLearnedIndex reads a QuadraticLearnedIndex from bytes.
+func DeserializeQuadraticLearnedIndex(data []byte) *QuadraticLearnedIndex {
+	if len(data) < QuadraticLearnedIndexSize {
+		return nil
+	}
+	return &QuadraticLearnedIndex{
+		A:        math.Float64frombits(binary.LittleEndian.Uint64(data[0:8])),
+		B:        math.Float64frombits(binary.LittleEndian.Uint64(data[8:16])),
+		C:        math.Float64frombits(binary.LittleEndian.Uint64(data[16:24])),
+		MinErr:   getInt32(data[24:28]),
+		MaxErr:   getInt32(data[28:32]),
+		KeyCount: binary.LittleEndian.Uint32(data[32:36]),
+		MaxPos:   binary.LittleEndian.Uint32(data[36:40]),
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * Tests for the quadratic learned index
+ */
+
+package y
+
+import (
+	"testing"
+)
+
+func TestQuadraticLearnedIndexConvexMapping(t *testing.T) {
+	// Blocks get smaller towards the end of the table, so block index grows
+	// with the square of the position.
+	n := 10000
+	numBlocks := 100
+	positions := make([]uint32, n)
+	blocks := make([]uint32, n)
+	for i := 0; i < n; i++ {
+		f := float64(i) / float64(n)
+		positions[i] = uint32(i)
+		blocks[i] = uint32(f * f * float64(numBlocks))
+	}
+
+	linear := TrainLearnedIndex(positions, blocks, numBlocks)
+	quad := TrainQuadraticLearnedIndex(positions, blocks, numBlocks)
+
+	linearTotal, quadTotal := 0, 0
+	for i := 0; i < n; i++ {
+		_, lmin, lmax := linear.Predict(positions[i])
+		_, qmin, qmax := quad.Predict(positions[i])
+		actual := int(blocks[i])
+		if actual < qmin || actual > qmax {
+			t.Fatalf("Key %d: block %d not in quadratic range [%d,%d]", i, actual, qmin, qmax)
+		}
+		linearTotal += lmax - lmin + 1
+		quadTotal += qmax - qmin + 1
+	}
+	linearAvg := float64(linearTotal) / float64(n)
+	quadAvg := float64(quadTotal) / float64(n)
+	t.Logf("Average search range: linear %.1f blocks, quadratic %.1f blocks", linearAvg, quadAvg)
+
+	if quadAvg >= linearAvg {
+		t.Errorf("Expected quadratic range (%.1f) to beat linear range (%.1f)", quadAvg, linearAvg)
+	}
+}
+
+func TestQuadraticLearnedIndexDegenerate(t *testing.T) {
+	empty := TrainQuadraticLearnedIndex(nil, nil, 10)
+	if _, minB, maxB := empty.Predict(5); minB != 0 || maxB != 9 {
+		t.Errorf("Expected full range for empty model, got [%d,%d]", minB, maxB)
+	}
+	var nilIndex *QuadraticLearnedIndex
+	if predicted, minB, maxB := nilIndex.Predict(5); predicted != 0 || minB != 0 || maxB != 0 {
+		t.Errorf("Nil index: got (%d, %d, %d), want zeros", predicted, minB, maxB)
+	}
+
+	// Two distinct positions cannot determine a quadratic.
+	qi := TrainQuadraticLearnedIndex([]uint32{10, 20}, []uint32{1, 2}, 4)
+	for i, x := range []uint32{10, 20} {
+		if _, minB, maxB := qi.Predict(x); minB > i+1 || maxB < i+1 {
+			t.Errorf("Position %d: block %d not in range [%d,%d]", x, i+1, minB, maxB)
+		}
+	}
+}
+
+func TestQuadraticLearnedIndexSerializationRoundtrip(t *testing.T) {
+	positions := []uint32{0, 10, 20, 30, 40, 50}
+	blocks := []uint32{0, 0, 1, 2, 4, 6}
+	original := TrainQuadraticLearnedIndex(positions, blocks, 7)
+
+	data := original.Serialize()
+	if len(data) != original.Size() || original.Size() != LearnedIndexSize+8 {
+		t.Errorf("Expected serialized size %d, got %d", LearnedIndexSize+8, len(data))
+	}
+	restored := DeserializeQuadraticLearnedIndex(data)
+	if restored == nil {
+		t.Fatal("Failed to deserialize")
+	}
+	if *restored != *original {
+		t.Errorf("Roundtrip mismatch: %+v vs %+v", restored, original)
+	}
+}
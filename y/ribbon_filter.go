@@ -0,0 +1,209 @@
+/*
+ * Ribbon Filter - static approximate membership close to the space bound
+ *
+ * A ribbon filter stores r bits per slot in ~1.08 slots per key, for a false
+ * positive rate of 2^-r at ~1.08*r bits/key, against ~1.44*r for bloom and
+ * ~1.23*r for XOR. Each key maps to a 64-bit coefficient row starting at some
+ * slot; the filter is a solution S of the banded GF(2) system c·S = 0 over all
+ * keys, found by Gaussian elimination restricted to the 64-wide band. A query
+ * computes c·S for its own row and reports membership if all r result bits
+ * are zero.
+ *
+ * This is the homogeneous variant: the right hand side is zero for every key,
+ * so the system is always solvable and construction never has to retry. Slots
+ * left free by the elimination are filled with random bits, which is what
+ * makes c·S look random for keys outside the set.
+ *
+ * Reference: Dillinger & Walzer, "Ribbon filter: practically smaller than
+ * Bloom and Xor" (2021).
+ */
+
+package y
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+const (
+	// ribbonWidth is the coefficient row width w, in bits.
+	ribbonWidth = 64
+	// ribbonOverhead is the fraction of extra slots over the key count. With
+	// less slack the band saturates locally, queries start to fall in the span
+	// of the key rows and the FP rate rises well above 2^-r (1.9% instead of
+	// 0.39% at 5% overhead and r = 8).
+	ribbonOverhead = 0.08
+	// ribbonMaxBits caps the result bits per slot, and so the FP exponent.
+	ribbonMaxBits = 16
+	// ribbonSeed is mixed into every key hash. Construction of a homogeneous
+	// ribbon cannot fail, so unlike the XOR filter the seed never changes.
+	ribbonSeed = 0x2545f4914f6cdd1d
+)
+
+// ribbonFilterHeaderSize is the serialized size of NumSlots and ResultBits.
+const ribbonFilterHeaderSize = 4 + 1
+
+// RibbonFilter is a homogeneous ribbon filter over a set of key hashes. It is
+// immutable once built and safe for concurrent use.
+type RibbonFilter struct {
+	NumSlots   uint32     // Number of slots m
+	ResultBits uint8      // Result bits r per slot; FP rate is ~2^-r
+	Columns    [][]uint64 // r bit columns of the solution, m bits each (padded)
+}
+
+// NewRibbonFilter builds a ribbon filter from key hashes (from y.Hash) with a
+// false positive rate of about 2^-bitsPerKey, using slightly more than
+// bitsPerKey bits per key. bitsPerKey is clamped to [1, 16].
+func NewRibbonFilter(hashes []uint32, bitsPerKey int) *RibbonFilter {
+	r := min(max(bitsPerKey, 1), ribbonMaxBits)
+	m := uint32(float64(len(hashes))*(1+ribbonOverhead)) + ribbonWidth
+	rf := &RibbonFilter{NumSlots: m, ResultBits: uint8(r)}
+
+	// Banding: bring every row into upper-triangular form, indexed by the
+	// slot of its leading bit. Bit i of a row refers to slot start+i.
+	rows := make([]uint64, m)
+	for _, h := range hashes {
+		start, c := rf.row(h)
+		for {
+			if rows[start] == 0 {
+				rows[start] = c
+				break
+			}
+			c ^= rows[start]
+			if c == 0 {
+				// Linearly dependent, e.g. a duplicate key; already satisfied.
+				break
+			}
+			tz := uint32(bits.TrailingZeros64(c))
+			start += tz
+			c >>= tz
+		}
+	}
+
+	// Back substitution, one result bit column at a time. Pad each column by
+	// a word so that 64-bit windows near the end stay in bounds.
+	words := int(m+63)/64 + 1
+	rng := uint64(ribbonSeed)
+	rf.Columns = make([][]uint64, r)
+	for j := range rf.Columns {
+		col := make([]uint64, words)
+		for i := int(m) - 1; i >= 0; i-- {
+			var bit uint64
+			if rows[i] == 0 {
+				rng = splitmix64(rng)
+				bit = rng & 1
+			} else {
+				// rows[i] has bit 0 set and slot i is still zero, so this
+				// picks S[i] such that the row's equation holds.
+				bit = uint64(bits.OnesCount64(rows[i]&window(col, uint32(i))) & 1)
+			}
+			col[i/64] |= bit << (i % 64)
+		}
+		rf.Columns[j] = col
+	}
+	return rf
+}
+
+// MayContain returns whether the filter may contain the given key hash. False
+// positives are possible, false negatives are not.
+func (rf *RibbonFilter) MayContain(keyHash uint32) bool {
+	if rf == nil || rf.NumSlots == 0 {
+		return false
+	}
+	start, c := rf.row(keyHash)
+	for _, col := range rf.Columns {
+		if bits.OnesCount64(c&window(col, start))&1 != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Size returns the serialized size in bytes.
+func (rf *RibbonFilter) Size() int {
+	return ribbonFilterHeaderSize + int(rf.ResultBits)*rf.columnBytes()
+}
+
+// Serialize converts the RibbonFilter to bytes. Only the m meaningful bits of
+// each column are stored, rounded up to a whole byte.
+// Format: [numSlots:4][resultBits:1][columns:resultBits*ceil(numSlots/8)]
+func (rf *RibbonFilter) Serialize() []byte {
+	buf := make([]byte, rf.Size())
+	binary.LittleEndian.PutUint32(buf[0:4], rf.NumSlots)
+	buf[4] = rf.ResultBits
+	offset := ribbonFilterHeaderSize
+	n := rf.columnBytes()
+	for _, col := range rf.Columns {
+		for i := 0; i < n; i++ {
+			buf[offset+i] = byte(col[i/8] >> (8 * (i % 8)))
+		}
+		offset += n
+	}
+	return buf
+}
+
+// DeserializeRibbonFilter reads a RibbonFilter written by Serialize. The
+// returned error wraps ErrShortBuffer if data is too short for the slot count
+// it declares, or ErrUnsupportedVersion for a header no ribbon filter can
+// have: fewer slots than a coefficient row spans, result bits outside
+// [1, 16], or more column bytes than the slot count calls for.
+func DeserializeRibbonFilter(data []byte) (*RibbonFilter, error) {
+	if len(data) < ribbonFilterHeaderSize {
+		return nil, fmt.Errorf("ribbon filter: got %d bytes, want at least %d: %w",
+			len(data), ribbonFilterHeaderSize, ErrShortBuffer)
+	}
+	rf := &RibbonFilter{
+		NumSlots:   binary.LittleEndian.Uint32(data[0:4]),
+		ResultBits: data[4],
+	}
+	// row maps keys onto NumSlots-ribbonWidth+1 start slots, which must not
+	// underflow.
+	if rf.NumSlots < ribbonWidth || rf.ResultBits < 1 || rf.ResultBits > ribbonMaxBits {
+		return nil, fmt.Errorf("ribbon filter with %d slots and %d result bits: %w",
+			rf.NumSlots, rf.ResultBits, ErrUnsupportedVersion)
+	}
+	if len(data) < rf.Size() {
+		return nil, fmt.Errorf("ribbon filter with %d slots and %d result bits: got %d bytes, want %d: %w",
+			rf.NumSlots, rf.ResultBits, len(data), rf.Size(), ErrShortBuffer)
+	}
+	if len(data) > rf.Size() {
+		return nil, fmt.Errorf("ribbon filter with %d slots and %d result bits: got %d bytes, want %d: %w",
+			rf.NumSlots, rf.ResultBits, len(data), rf.Size(), ErrUnsupportedVersion)
+	}
+	n := rf.columnBytes()
+	words := int(rf.NumSlots+63)/64 + 1
+	offset := ribbonFilterHeaderSize
+	rf.Columns = make([][]uint64, rf.ResultBits)
+	for j := range rf.Columns {
+		col := make([]uint64, words)
+		for i := 0; i < n; i++ {
+			col[i/8] |= uint64(data[offset+i]) << (8 * (i % 8))
+		}
+		rf.Columns[j] = col
+		offset += n
+	}
+	return rf, nil
+}
+
+func (rf *RibbonFilter) columnBytes() int {
+	return int(rf.NumSlots+7) / 8
+}
+
+// row returns the start slot and coefficient row of a key hash. The row's
+// lowest bit is always set, so the row starts exactly at the returned slot.
+func (rf *RibbonFilter) row(keyHash uint32) (uint32, uint64) {
+	h := murmurMix64(uint64(keyHash) + ribbonSeed)
+	start := reduce32(uint32(h>>32), rf.NumSlots-ribbonWidth+1)
+	c := murmurMix64(h) | 1
+	return start, c
+}
+
+// window returns the 64 bits of col starting at bit i.
+func window(col []uint64, i uint32) uint64 {
+	w, b := i/64, i%64
+	if b == 0 {
+		return col[w]
+	}
+	return col[w]>>b | col[w+1]<<(64-b)
+}
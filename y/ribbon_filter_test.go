@@ -0,0 +1,173 @@
+/*
+ * Tests for the ribbon filter
+ */
+
+package y
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestRibbonFilterNoFalseNegatives(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 100, 10000} {
+		hashes := make([]uint32, n)
+		for i := 0; i < n; i++ {
+			hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+		}
+		rf := NewRibbonFilter(hashes, 8)
+		for i, h := range hashes {
+			if !rf.MayContain(h) {
+				t.Fatalf("n=%d: key %d missing from filter", n, i)
+			}
+		}
+	}
+}
+
+func TestRibbonFilterDuplicateHashes(t *testing.T) {
+	hashes := []uint32{42, 42, 7, 7, 7, 1000}
+	rf := NewRibbonFilter(hashes, 8)
+	for _, h := range hashes {
+		if !rf.MayContain(h) {
+			t.Errorf("hash %d missing from filter", h)
+		}
+	}
+}
+
+func TestRibbonFilterSerializationRoundtrip(t *testing.T) {
+	hashes := make([]uint32, 1000)
+	for i := range hashes {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+	}
+	original := NewRibbonFilter(hashes, 7)
+
+	data := original.Serialize()
+	if len(data) != original.Size() {
+		t.Errorf("Expected serialized size %d, got %d", original.Size(), len(data))
+	}
+	restored, err := DeserializeRibbonFilter(data)
+	if err != nil {
+		t.Fatalf("DeserializeRibbonFilter: %v", err)
+	}
+	if !bytes.Equal(restored.Serialize(), data) {
+		t.Error("Reserialized bytes differ")
+	}
+	for i := 0; i < 10000; i++ {
+		h := rand.Uint32()
+		if restored.MayContain(h) != original.MayContain(h) {
+			t.Fatalf("MayContain(%d) differs after roundtrip", h)
+		}
+	}
+	if _, err := DeserializeRibbonFilter(data[:len(data)-1]); !errors.Is(err, ErrShortBuffer) {
+		t.Errorf("Truncated data: expected ErrShortBuffer, got %v", err)
+	}
+}
+
+func TestDeserializeRibbonFilterCorrupt(t *testing.T) {
+	data := NewRibbonFilter([]uint32{1, 2, 3}, 8).Serialize()
+	withHeader := func(numSlots uint32, resultBits uint8) []byte {
+		d := bytes.Clone(data)
+		binary.LittleEndian.PutUint32(d, numSlots)
+		d[4] = resultBits
+		return d
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		// Fewer slots than a row spans underflowed the start slot range and
+		// made MayContain index past the columns.
+		{"too few slots", withHeader(10, 8)[:ribbonFilterHeaderSize+8*2], ErrUnsupportedVersion},
+		{"no slots", withHeader(0, 8)[:ribbonFilterHeaderSize], ErrUnsupportedVersion},
+		{"no result bits", withHeader(64, 0), ErrUnsupportedVersion},
+		{"too many result bits", withHeader(64, ribbonMaxBits+1), ErrUnsupportedVersion},
+		{"columns longer than the slots", withHeader(64, 1), ErrUnsupportedVersion},
+		{"columns shorter than the slots", withHeader(1<<20, 8), ErrShortBuffer},
+	}
+	for _, tc := range tests {
+		rf, err := DeserializeRibbonFilter(tc.data)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, err)
+		}
+		if rf != nil {
+			t.Errorf("%s: expected nil filter on error", tc.name)
+		}
+	}
+}
+
+// TestRibbonFilterVsBloomAndXOR compares the three static filters at the XOR
+// filter's ~0.39% false positive rate on 100000 keys.
+func TestRibbonFilterVsBloomAndXOR(t *testing.T) {
+	keyCount := 100000
+	hashes := make([]uint32, keyCount)
+	for i := 0; i < keyCount; i++ {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+	}
+
+	// 8 result bits give 2^-8 ≈ 0.39%, the rate of an 8-bit XOR filter.
+	target := math.Pow(2, -8)
+	rf := NewRibbonFilter(hashes, 8)
+	xf := newTestXORFilter(t, hashes)
+	// Optimal bloom sizing: -ln(p) / ln(2)² bits per key.
+	bloom := NewFilter(hashes, int(math.Ceil(-math.Log(target)/(math.Ln2*math.Ln2))))
+
+	tests := 200000
+	rng := rand.New(rand.NewSource(1))
+	var ribbonFP, xorFP, bloomFP int
+	for i := 0; i < tests; i++ {
+		h := rng.Uint32()
+		if rf.MayContain(h) {
+			ribbonFP++
+		}
+		if xf.MayContain(h) {
+			xorFP++
+		}
+		if bloom.MayContain(h) {
+			bloomFP++
+		}
+	}
+	report := func(name string, size, fp int) float64 {
+		rate := float64(fp) / float64(tests)
+		t.Logf("%-6s %7d bytes (%.2f bits/key), FP %.3f%%",
+			name, size, float64(size*8)/float64(keyCount), rate*100)
+		return rate
+	}
+	ribbonRate := report("Ribbon", rf.Size(), ribbonFP)
+	report("XOR", xf.Size(), xorFP)
+	report("Bloom", len(bloom), bloomFP)
+
+	if rf.Size() >= xf.Size() || rf.Size() >= len(bloom) {
+		t.Errorf("Ribbon filter (%d bytes) should be smaller than XOR (%d) and bloom (%d)",
+			rf.Size(), xf.Size(), len(bloom))
+	}
+	if ribbonRate > 1.5*target {
+		t.Errorf("Ribbon FP rate %.3f%% well above the %.3f%% target", ribbonRate*100, target*100)
+	}
+}
+
+func BenchmarkRibbonFilter(b *testing.B) {
+	keyCount := 100000
+	hashes := make([]uint32, keyCount)
+	for i := 0; i < keyCount; i++ {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+	}
+	rf := NewRibbonFilter(hashes, 8)
+
+	b.Run("Build", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			NewRibbonFilter(hashes, 8)
+		}
+	})
+
+	b.Run("Query", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			rf.MayContain(rand.Uint32())
+		}
+	})
+}
@@ -0,0 +1,91 @@
+/*
+ * Recursive Model Index - two-stage learned index for large sorted tables
+ *
+ * One line cannot follow the key distribution of a large table. A two-stage
+ * RMI keeps the models linear but stacks them: a root line maps a key to one
+ * of numLeaves leaf models, and each leaf is a LearnedIndex fitted only to the
+ * keys routed to it. The root is trained to spread keys evenly over the
+ * leaves by rank, so each leaf covers a small slice of the table where a line
+ * fits well.
+ *
+ * Reference: Kraska et al., "The Case for Learned Index Structures" (2018).
+ */
+
+package y
+
+import "math"
+
+// RMI is a two-stage recursive model index predicting the block index of a
+// key from its position.
+type RMI struct {
+	RootSlope     float64 // Root model: leaf ≈ RootSlope*position + RootIntercept
+	RootIntercept float64
+	Leaves        []*LearnedIndex // One model per leaf, with its own error bounds
+	MaxPos        uint32          // Maximum position (number of blocks - 1)
+}
+
+// TrainRMI trains a two-stage RMI with numLeaves leaf models over sorted
+// positions. The root is fit to map the i-th of n keys to leaf i*numLeaves/n;
+// each key is then routed through the trained root, so that training and
+// prediction agree on the leaf, and each leaf is fit to the keys it receives.
+// A leaf that receives no keys searches all blocks, which can only happen for
+// positions that were not in the training set.
+func TrainRMI(positions []uint32, blockIndices []uint32, numBlocks int, numLeaves int) *RMI {
+	numLeaves = max(numLeaves, 1)
+	rmi := &RMI{
+		Leaves: make([]*LearnedIndex, numLeaves),
+		MaxPos: uint32(max(0, numBlocks-1)),
+	}
+	n := len(positions)
+	if n > 1 {
+		targets := make([]uint32, n)
+		for i := range targets {
+			targets[i] = uint32(i * numLeaves / n)
+		}
+		rmi.RootSlope, rmi.RootIntercept = computeRegressionSums(positions, targets).fit()
+	}
+
+	// Route every key through the root and collect the keys of each leaf.
+	leafPositions := make([][]uint32, numLeaves)
+	leafBlocks := make([][]uint32, numLeaves)
+	for i, pos := range positions {
+		leaf := rmi.leaf(pos)
+		leafPositions[leaf] = append(leafPositions[leaf], pos)
+		leafBlocks[leaf] = append(leafBlocks[leaf], blockIndices[i])
+	}
+	for leaf := range rmi.Leaves {
+		rmi.Leaves[leaf] = TrainLearnedIndex(leafPositions[leaf], leafBlocks[leaf], numBlocks)
+	}
+	return rmi
+}
+
+// leaf returns the leaf model the root routes position to.
+func (rmi *RMI) leaf(position uint32) int {
+	l := math.Round(rmi.RootSlope*float64(position) + rmi.RootIntercept)
+	return int(min(max(l, 0), float64(len(rmi.Leaves)-1)))
+}
+
+// Predict returns the predicted block index for a given position.
+// Returns (predictedBlock, minBlock, maxBlock) where the key should be
+// searched in the range [minBlock, maxBlock]. A nil RMI returns zeros.
+func (rmi *RMI) Predict(position uint32) (predicted, minBlock, maxBlock int) {
+	if rmi == nil {
+		return 0, 0, 0
+	}
+	if len(rmi.Leaves) == 0 {
+		// No model - search all blocks
+		return 0, 0, int(rmi.MaxPos)
+	}
+	return rmi.Leaves[rmi.leaf(position)].Predict(position)
+}
+
+// NumLeaves returns the number of leaf models.
+func (rmi *RMI) NumLeaves() int {
+	return len(rmi.Leaves)
+}
+
+// Size returns the storage size in bytes: the root line plus a LearnedIndex
+// per leaf.
+func (rmi *RMI) Size() int {
+	return 8 + 8 + len(rmi.Leaves)*LearnedIndexSize
+}
@@ -0,0 +1,83 @@
+/*
+ * Tests for the recursive model index
+ */
+
+package y
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// TestRMISkewedKeys trains on 100000 sorted keys whose values are log-normally
+// distributed, so that a single line fits the position→block mapping poorly.
+func TestRMISkewedKeys(t *testing.T) {
+	n := 100000
+	numBlocks := 1000
+	numLeaves := 64
+	rng := rand.New(rand.NewSource(1))
+	positions := make([]uint32, n)
+	for i := range positions {
+		positions[i] = uint32(math.Min(math.Exp(rng.NormFloat64()+19), math.MaxUint32))
+	}
+	slices.Sort(positions)
+	blocks := GenerateBlockIndices(n, numBlocks)
+
+	linear := TrainLearnedIndex(positions, blocks, numBlocks)
+	rmi := TrainRMI(positions, blocks, numBlocks, numLeaves)
+	if rmi.NumLeaves() != numLeaves {
+		t.Fatalf("Expected %d leaves, got %d", numLeaves, rmi.NumLeaves())
+	}
+
+	linearTotal, rmiTotal := 0, 0
+	for i, pos := range positions {
+		_, lmin, lmax := linear.Predict(pos)
+		_, rmin, rmax := rmi.Predict(pos)
+		actual := int(blocks[i])
+		if actual < rmin || actual > rmax {
+			t.Fatalf("Key %d: block %d not in RMI range [%d,%d]", i, actual, rmin, rmax)
+		}
+		linearTotal += lmax - lmin + 1
+		rmiTotal += rmax - rmin + 1
+	}
+	linearAvg := float64(linearTotal) / float64(n)
+	rmiAvg := float64(rmiTotal) / float64(n)
+	t.Logf("Average search range: linear %.1f blocks (%d bytes), RMI %.1f blocks (%d bytes)",
+		linearAvg, LearnedIndexSize, rmiAvg, rmi.Size())
+
+	if rmiAvg*10 > linearAvg {
+		t.Errorf("RMI range %.1f should be far tighter than linear range %.1f", rmiAvg, linearAvg)
+	}
+	// A 1% FP bloom over the same keys would take ~120KB.
+	if rmi.Size() > 16+numLeaves*LearnedIndexSize || rmi.Size() > n/40 {
+		t.Errorf("RMI size %d bytes is not a small overhead", rmi.Size())
+	}
+}
+
+func TestRMIEdgeCases(t *testing.T) {
+	empty := TrainRMI(nil, nil, 10, 4)
+	if _, lo, hi := empty.Predict(123); lo != 0 || hi != 9 {
+		t.Errorf("Empty RMI should search all blocks, got [%d,%d]", lo, hi)
+	}
+	var nilRMI *RMI
+	if predicted, lo, hi := nilRMI.Predict(123); predicted != 0 || lo != 0 || hi != 0 {
+		t.Errorf("Nil RMI: got %d [%d,%d], want zeros", predicted, lo, hi)
+	}
+
+	single := TrainRMI([]uint32{50}, []uint32{3}, 10, 4)
+	if _, lo, hi := single.Predict(50); lo > 3 || hi < 3 {
+		t.Errorf("Single key: block 3 not in [%d,%d]", lo, hi)
+	}
+
+	// More leaves than keys.
+	positions := []uint32{1, 2, 3}
+	blocks := []uint32{0, 1, 2}
+	rmi := TrainRMI(positions, blocks, 3, 16)
+	for i, pos := range positions {
+		if _, lo, hi := rmi.Predict(pos); int(blocks[i]) < lo || int(blocks[i]) > hi {
+			t.Errorf("Position %d: block %d not in [%d,%d]", pos, blocks[i], lo, hi)
+		}
+	}
+}
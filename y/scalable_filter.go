@@ -0,0 +1,118 @@
+/*
+ * Scalable Bloom Filter - a bloom filter that grows as keys are added
+ *
+ * Memtables do not know their final key count up front, so a fixed-size bloom
+ * either wastes space or saturates. A scalable filter chains sub-filters: once
+ * the newest one is half full it is frozen and a larger one with a tighter
+ * false positive rate is started. A key may be in any sub-filter, so a query
+ * checks all of them and the false positive rates add up. With sub-filter i
+ * targeting p0 * r^i, the compound rate is bounded by
+ *
+ *   P <= p0 * (1 + r + r² + ...) = p0 / (1 - r)
+ *
+ * Reference: Almeida et al., "Scalable Bloom Filters" (2007).
+ */
+
+package y
+
+import "math"
+
+const (
+	// scalableGrowth is the capacity ratio between consecutive sub-filters.
+	scalableGrowth = 2
+	// scalableTightening is the FP rate ratio r between consecutive sub-filters.
+	scalableTightening = 0.5
+	// scalableMaxDensity is the fraction of set bits at which a sub-filter is
+	// considered full; 0.5 is the density of an optimally loaded bloom filter.
+	scalableMaxDensity = 0.5
+)
+
+// ScalableFilter is a bloom filter without a fixed capacity. It is not safe
+// for concurrent use: Add must be serialized with MayContain by the caller.
+type ScalableFilter struct {
+	filters []scalableSubFilter
+	count   int // Number of keys added
+}
+
+type scalableSubFilter struct {
+	bits     Filter  // Encoded as by NewFilter, with k in the trailing byte
+	setBits  int     // Number of bits set, maintained incrementally
+	capacity int     // Number of keys the sub-filter was sized for
+	fpRate   float64 // FP rate the sub-filter was sized for
+}
+
+// NewScalableFilter creates a filter whose first sub-filter holds about
+// initialCapacity keys at fpRate. The compound false positive rate never
+// exceeds fpRate / (1 - 0.5) = 2 * fpRate, however many keys are added.
+func NewScalableFilter(initialCapacity int, fpRate float64) *ScalableFilter {
+	sf := &ScalableFilter{}
+	sf.grow(max(initialCapacity, 1), fpRate)
+	return sf
+}
+
+// grow appends an empty sub-filter sized for capacity keys at fpRate.
+func (sf *ScalableFilter) grow(capacity int, fpRate float64) {
+	// m/n = -ln(p) / ln(2)², k = (m/n) * ln(2)
+	bitsPerKey := -math.Log(fpRate) / (math.Ln2 * math.Ln2)
+	nBits := int(math.Ceil(float64(capacity) * bitsPerKey))
+	k := int(math.Round(bitsPerKey * math.Ln2))
+	sf.filters = append(sf.filters, scalableSubFilter{
+		bits:     NewFilterK(nil, nBits, k),
+		capacity: capacity,
+		fpRate:   fpRate,
+	})
+}
+
+// Add inserts a key hash, starting a new sub-filter first if the current one is
+// full.
+func (sf *ScalableFilter) Add(keyHash uint32) {
+	cur := &sf.filters[len(sf.filters)-1]
+	nBits := 8 * (len(cur.bits) - 1)
+	if float64(cur.setBits) >= scalableMaxDensity*float64(nBits) {
+		sf.grow(cur.capacity*scalableGrowth, cur.fpRate*scalableTightening)
+		cur = &sf.filters[len(sf.filters)-1]
+		nBits = 8 * (len(cur.bits) - 1)
+	}
+
+	h := keyHash
+	delta := h>>17 | h<<15
+	k := cur.bits[len(cur.bits)-1]
+	for j := uint8(0); j < k; j++ {
+		bitPos := h % uint32(nBits)
+		if mask := byte(1 << (bitPos % 8)); cur.bits[bitPos/8]&mask == 0 {
+			cur.bits[bitPos/8] |= mask
+			cur.setBits++
+		}
+		h += delta
+	}
+	sf.count++
+}
+
+// MayContain returns whether any sub-filter may contain the key hash.
+func (sf *ScalableFilter) MayContain(keyHash uint32) bool {
+	for _, f := range sf.filters {
+		if f.bits.MayContain(keyHash) {
+			return true
+		}
+	}
+	return false
+}
+
+// Size returns the total size of all sub-filters in bytes.
+func (sf *ScalableFilter) Size() int {
+	size := 0
+	for _, f := range sf.filters {
+		size += len(f.bits)
+	}
+	return size
+}
+
+// NumFilters returns the number of sub-filters allocated so far.
+func (sf *ScalableFilter) NumFilters() int {
+	return len(sf.filters)
+}
+
+// Count returns the number of keys added.
+func (sf *ScalableFilter) Count() int {
+	return sf.count
+}
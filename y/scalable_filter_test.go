@@ -0,0 +1,62 @@
+/*
+ * Tests for the scalable bloom filter
+ */
+
+package y
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestScalableFilterGrowth(t *testing.T) {
+	fpRate := 0.01
+	ceiling := fpRate / (1 - scalableTightening)
+	sf := NewScalableFilter(1000, fpRate)
+
+	keyCount := 100000
+	hashes := make([]uint32, keyCount)
+	for i := 0; i < keyCount; i++ {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+		sf.Add(hashes[i])
+	}
+	if sf.Count() != keyCount {
+		t.Errorf("Expected %d keys, got %d", keyCount, sf.Count())
+	}
+	if sf.NumFilters() < 2 {
+		t.Errorf("Expected the filter to grow past its initial capacity, got %d sub-filters", sf.NumFilters())
+	}
+
+	for i, h := range hashes {
+		if !sf.MayContain(h) {
+			t.Fatalf("Key %d: false negative", i)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	fp := 0
+	tests := 100000
+	for i := 0; i < tests; i++ {
+		if sf.MayContain(rng.Uint32()) {
+			fp++
+		}
+	}
+	fpRateMeasured := float64(fp) / float64(tests)
+	t.Logf("%d keys: %d sub-filters, %d bytes (%.2f bytes/key), FP %.3f%% (ceiling %.1f%%)",
+		keyCount, sf.NumFilters(), sf.Size(), float64(sf.Size())/float64(keyCount),
+		fpRateMeasured*100, ceiling*100)
+	if fpRateMeasured > ceiling {
+		t.Errorf("Aggregate FP rate %.3f%% exceeds ceiling %.1f%%", fpRateMeasured*100, ceiling*100)
+	}
+}
+
+func TestScalableFilterEmpty(t *testing.T) {
+	sf := NewScalableFilter(0, 0.01)
+	if sf.NumFilters() != 1 || sf.Size() == 0 {
+		t.Errorf("Expected one allocated sub-filter, got %d (%d bytes)", sf.NumFilters(), sf.Size())
+	}
+	if sf.MayContain(Hash([]byte("missing"))) {
+		t.Error("Empty filter should not contain any key")
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * Segmented Learned Index - piecewise linear regression for SSTable key lookup
+ *
+ * Clustered key spaces (e.g. a few dense prefixes separated by large gaps)
+ * defeat a single line: the fit passes between the clusters and the error
+ * bounds grow to cover them all. Splitting the sorted positions into segments
+ * of equal key count and fitting one LearnedIndex per segment lets each line
+ * follow its own cluster, at LearnedIndexSize plus a boundary per segment.
+ */
+
+package y
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SegmentedLearnedIndex is a piecewise linear model predicting the block index
+// of a key from its position.
+type SegmentedLearnedIndex struct {
+	Boundaries []uint32        // First position of each segment, ascending
+	Segments   []*LearnedIndex // One model per segment
+	MaxPos     uint32          // Maximum position (number of blocks - 1)
+}
+
+// TrainSegmentedLearnedIndex splits sorted positions into numSegments runs of
+// about equal key count and fits a linear model to each. Equal positions are
+// never split across two segments, so fewer segments may be produced.
+func TrainSegmentedLearnedIndex(positions []uint32, blockIndices []uint32, numBlocks int, numSegments int) *SegmentedLearnedIndex {
+	si := &SegmentedLearnedIndex{MaxPos: uint32(max(0, numBlocks-1))}
+	n := len(positions)
+	if n == 0 {
+		return si
+	}
+	numSegments = min(max(numSegments, 1), n)
+
+	start := 0
+	for seg := 1; seg <= numSegments && start < n; seg++ {
+		end := seg * n / numSegments
+		for end < n && end > start && positions[end] == positions[end-1] {
+			end++
+		}
+		if end <= start {
+			continue
+		}
+		si.Boundaries = append(si.Boundaries, positions[start])
+		si.Segments = append(si.Segments,
+			TrainLearnedIndex(positions[start:end], blockIndices[start:end], numBlocks))
+		start = end
+	}
+	return si
+}
+
+// NumSegments returns the number of linear segments.
+func (si *SegmentedLearnedIndex) NumSegments() int {
+	return len(si.Segments)
+}
+
+// Predict returns the predicted block index for a given position.
+// Returns (predictedBlock, minBlock, maxBlock) where the key should be
+// searched in the range [minBlock, maxBlock]. A nil index returns zeros.
+func (si *SegmentedLearnedIndex) Predict(position uint32) (predicted, minBlock, maxBlock int) {
+	if si == nil {
+		return 0, 0, 0
+	}
+	if len(si.Segments) == 0 {
+		// No model - search all blocks
+		return 0, 0, int(si.MaxPos)
+	}
+	// Last segment whose first position is <= position.
+	seg := sort.Search(len(si.Boundaries), func(i int) bool {
+		return si.Boundaries[i] > position
+	})
+	return si.Segments[max(seg-1, 0)].Predict(position)
+}
+
+// Size returns the storage size in bytes: a boundary and a model per segment.
+func (si *SegmentedLearnedIndex) Size() int {
+	return len(si.Segments) * (4 + LearnedIndexSize)
+}
+
+// String returns a one-line summary of the model: its total key count and
+// number of segments. The segments can be printed one by one.
+func (si *SegmentedLearnedIndex) String() string {
+	if si == nil {
+		return "SegmentedLearnedIndex(nil)"
+	}
+	var keys uint64
+	for _, seg := range si.Segments {
+		keys += uint64(seg.KeyCount)
+	}
+	return fmt.Sprintf("SegmentedLearnedIndex{keys=%d segments=%d maxPos=%d}", keys, len(si.Segments), si.MaxPos)
+}
@@ -0,0 +1,82 @@
+/*
+ * Tests for the segmented learned index
+ */
+
+package y
+
+import (
+	"testing"
+)
+
+// clusteredPositions returns n positions in numClusters dense runs separated
+// by large gaps, assigned evenly to numBlocks blocks.
+func clusteredPositions(n, numClusters, numBlocks int) (positions, blocks []uint32) {
+	positions = make([]uint32, n)
+	perCluster := n / numClusters
+	for i := range positions {
+		cluster := i / perCluster
+		positions[i] = uint32(cluster)<<28 + uint32(i%perCluster)
+	}
+	return positions, GenerateBlockIndices(n, numBlocks)
+}
+
+func TestSegmentedLearnedIndexClustered(t *testing.T) {
+	n := 10000
+	numBlocks := 100
+	positions, blocks := clusteredPositions(n, 4, numBlocks)
+
+	linear := TrainLearnedIndex(positions, blocks, numBlocks)
+	seg := TrainSegmentedLearnedIndex(positions, blocks, numBlocks, 4)
+	if seg.NumSegments() != 4 {
+		t.Fatalf("Expected 4 segments, got %d", seg.NumSegments())
+	}
+	if seg.Size() != 4*(4+LearnedIndexSize) {
+		t.Errorf("Unexpected size %d", seg.Size())
+	}
+
+	linearTotal, segTotal := 0, 0
+	for i, pos := range positions {
+		_, lmin, lmax := linear.Predict(pos)
+		_, smin, smax := seg.Predict(pos)
+		actual := int(blocks[i])
+		if actual < smin || actual > smax {
+			t.Fatalf("Key %d: block %d not in segmented range [%d,%d]", i, actual, smin, smax)
+		}
+		linearTotal += lmax - lmin + 1
+		segTotal += smax - smin + 1
+	}
+	linearAvg := float64(linearTotal) / float64(n)
+	segAvg := float64(segTotal) / float64(n)
+	t.Logf("Average search range: linear %.1f blocks, segmented %.1f blocks", linearAvg, segAvg)
+	if segAvg*5 > linearAvg {
+		t.Errorf("Segmented range %.1f should be far below linear range %.1f", segAvg, linearAvg)
+	}
+}
+
+func TestSegmentedLearnedIndexEdgeCases(t *testing.T) {
+	empty := TrainSegmentedLearnedIndex(nil, nil, 10, 4)
+	if _, lo, hi := empty.Predict(123); lo != 0 || hi != 9 {
+		t.Errorf("Empty index should search all blocks, got [%d,%d]", lo, hi)
+	}
+	var nilIndex *SegmentedLearnedIndex
+	if predicted, lo, hi := nilIndex.Predict(123); predicted != 0 || lo != 0 || hi != 0 {
+		t.Errorf("Nil index: got (%d, %d, %d), want zeros", predicted, lo, hi)
+	}
+
+	// Duplicate positions must stay in one segment.
+	positions := []uint32{5, 5, 5, 5, 9, 9, 9, 9}
+	blocks := []uint32{0, 0, 0, 0, 1, 1, 1, 1}
+	si := TrainSegmentedLearnedIndex(positions, blocks, 2, 8)
+	if si.NumSegments() != 2 {
+		t.Errorf("Expected 2 segments for 2 distinct positions, got %d", si.NumSegments())
+	}
+	for i, pos := range positions {
+		if _, lo, hi := si.Predict(pos); int(blocks[i]) < lo || int(blocks[i]) > hi {
+			t.Errorf("Position %d: block %d not in [%d,%d]", pos, blocks[i], lo, hi)
+		}
+	}
+	// Positions before the first segment use the first segment.
+	if _, lo, _ := si.Predict(0); lo != 0 {
+		t.Errorf("Expected block 0 for a position before all segments, got %d", lo)
+	}
+}
@@ -0,0 +1,64 @@
+/*
+ * End-to-end LSM lookup simulation
+ *
+ * Runs point lookups through the skip-then-narrow path the hybrid filter is
+ * designed for: tables are probed newest first, the bloom component decides
+ * whether a table is touched at all, and the learned component decides how
+ * many of its blocks are read.
+ */
+
+package y
+
+// TableSpec describes one simulated SSTable.
+type TableSpec struct {
+	KeyHashes    []uint32 // Hash of each key in the table
+	BlockIndices []uint32 // Block of each key (parallel to KeyHashes)
+	NumBlocks    int
+	Config       HybridFilterConfig
+}
+
+// SimResult aggregates the cost of a simulated lookup workload.
+type SimResult struct {
+	Queries        int // Number of lookups run
+	Found          int // Lookups that found their key in some table
+	TablesTouched  int // Tables whose filter said "maybe", across all lookups
+	BlocksRead     int // Blocks read across all lookups
+	FalsePositives int // Tables touched that did not hold the key
+	WastedBlocks   int // Blocks read in those tables
+}
+
+// SimulateLSMLookups builds a hybrid filter per table and runs every query
+// through it. tables[0] is the newest table; a lookup stops at the first table
+// that holds its key. Each touched table costs the width of its predicted
+// block range.
+func SimulateLSMLookups(tables []TableSpec, queries []uint32) SimResult {
+	filters := make([]*HybridFilter, len(tables))
+	contents := make([]map[uint32]struct{}, len(tables))
+	for i, spec := range tables {
+		filters[i] = TrainHybridFilter(spec.KeyHashes, spec.BlockIndices, spec.NumBlocks, spec.Config)
+		contents[i] = make(map[uint32]struct{}, len(spec.KeyHashes))
+		for _, h := range spec.KeyHashes {
+			contents[i][h] = struct{}{}
+		}
+	}
+
+	res := SimResult{Queries: len(queries)}
+	for _, q := range queries {
+		for i, hf := range filters {
+			maybePresent, minBlock, maxBlock := hf.Query(q)
+			if !maybePresent {
+				continue
+			}
+			blocks := maxBlock - minBlock + 1
+			res.TablesTouched++
+			res.BlocksRead += blocks
+			if _, ok := contents[i][q]; ok {
+				res.Found++
+				break
+			}
+			res.FalsePositives++
+			res.WastedBlocks += blocks
+		}
+	}
+	return res
+}
@@ -0,0 +1,53 @@
+/*
+ * Tests for the end-to-end LSM lookup simulation
+ */
+
+package y
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSimulateLSMLookups(t *testing.T) {
+	// Newest table: three keys and a roomy bloom, so absent keys are rejected.
+	newest := []uint32{Hash([]byte("apple")), Hash([]byte("banana")), Hash([]byte("cherry"))}
+	// Oldest table: a one-byte bloom over 1000 keys is saturated, so every
+	// lookup that reaches it touches it.
+	oldest := make([]uint32, 1000)
+	for i := range oldest {
+		oldest[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+	}
+	// Single-block tables make every predicted range exactly one block wide.
+	tables := []TableSpec{
+		{KeyHashes: newest, BlockIndices: make([]uint32, len(newest)), NumBlocks: 1,
+			Config: HybridFilterConfig{BloomSizeBytes: 4096}},
+		{KeyHashes: oldest, BlockIndices: make([]uint32, len(oldest)), NumBlocks: 1,
+			Config: HybridFilterConfig{BloomSizeBytes: 1}},
+	}
+
+	queries := []uint32{
+		Hash([]byte("apple")),          // newest: hit, 1 block
+		Hash([]byte("key_0000000005")), // newest: skipped; oldest: hit, 1 block
+		Hash([]byte("absent")),         // newest: skipped; oldest: false positive, 1 block
+	}
+	got := SimulateLSMLookups(tables, queries)
+	want := SimResult{
+		Queries:        3,
+		Found:          2,
+		TablesTouched:  3,
+		BlocksRead:     3,
+		FalsePositives: 1,
+		WastedBlocks:   1,
+	}
+	if got != want {
+		t.Errorf("SimulateLSMLookups = %+v, want %+v", got, want)
+	}
+}
+
+func TestSimulateLSMLookupsNoTables(t *testing.T) {
+	got := SimulateLSMLookups(nil, []uint32{1, 2, 3})
+	if got != (SimResult{Queries: 3}) {
+		t.Errorf("Expected only query count with no tables, got %+v", got)
+	}
+}
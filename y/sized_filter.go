@@ -0,0 +1,181 @@
+/*
+ * Sized bloom filter - a bloom filter that records its own bit count
+ *
+ * Filter infers its bit count from its length, len(f)-1 bytes with the hash
+ * count in the last byte, so the filter must be stored in a slice of exactly
+ * its own size. SizedFilter stores the bit count in a header instead, which
+ * lets it live at the start of a larger pre-allocated or page-aligned buffer:
+ * anything after the bits is ignored. The bit count also need not be a
+ * multiple of 8.
+ *
+ * The header starts with a version byte, so the layout can change without
+ * being confused with this one. Filter keeps its legacy layout.
+ *
+ * Version 2 changes how the k probes of a key are spread. Filter and version 1
+ * step from one probe to the next by delta = h>>17 | h<<15, a rotation of the
+ * hash itself, so the probe sequence is a fixed function of h's bit layout;
+ * at 10 bits per key the measured false positive rate is about 1.4% where
+ * theory predicts 0.8%. Version 2 steps by a seeded remix of h (MurmurHash3's
+ * finalizer), which behaves as a second independent hash and brings the rate
+ * down to the theoretical one at the same k and bit count. Only the 32-bit
+ * key hash reaches the filter, so the remix cannot add entropy: keys whose
+ * hashes collide still collide, which at 2^32 hashes is rarely what limits a
+ * filter.
+ *
+ * Filter and FilterBuilder keep the rotation. Their layout records no probe
+ * scheme, so filters written by older releases are read by today's code and
+ * the other way round, and a change of scheme on either side would probe other
+ * bits than were set, turning present keys into false negatives. Only a
+ * versioned format, as here, can change it. HybridFilter keeps it too: its
+ * bloom converts bit for bit to a Filter (see ToFilter), and none of its
+ * format versions marks a probe scheme.
+ */
+
+package y
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// SizedFilter is a bloom filter with an explicit bit count.
+// Format: [version:1][k:1][nBits:4][bits:ceil(nBits/8)][ignored...]
+// Like Filter, it is never modified after it is built.
+type SizedFilter []byte
+
+const (
+	sizedFilterVersion      = 1 // Probes step by a rotation of the hash, as in Filter
+	sizedFilterVersionMixed = 2 // Probes step by a remix of the hash
+	sizedFilterHeaderSize   = 1 + 1 + 4
+
+	// mixedProbeSeed is XORed into the hash before remixing it, so that the
+	// one hash whose step is 0, probing the same bit k times, is not 0.
+	mixedProbeSeed = 0x9e3779b9
+)
+
+// NewSizedFilter returns a SizedFilter of nBits bits using k hash functions.
+// k is clamped to [1, 30] and nBits to [8, math.MaxUint32]. The hashing is the
+// same as Filter's, so a filter with nBits a multiple of 8 answers exactly as
+// NewFilterK(keys, nBits, k) does.
+func NewSizedFilter(keys []uint32, nBits, k int) SizedFilter {
+	return newSizedFilter(keys, nBits, k, sizedFilterVersion)
+}
+
+// NewSizedFilterMixed is NewSizedFilter with the probes of each key spread by
+// a remix of its hash rather than a rotation, which gives a lower false
+// positive rate at the same size and k. Readers that predate it consider such
+// a filter, as any of an unknown version, a match for every key.
+func NewSizedFilterMixed(keys []uint32, nBits, k int) SizedFilter {
+	return newSizedFilter(keys, nBits, k, sizedFilterVersionMixed)
+}
+
+func newSizedFilter(keys []uint32, nBits, k int, version byte) SizedFilter {
+	k = min(max(k, 1), 30)
+	n := uint32(min(max(int64(nBits), 8), math.MaxUint32))
+	f := make(SizedFilter, sizedFilterHeaderSize+int((uint64(n)+7)/8))
+	f[0] = version
+	f[1] = uint8(k)
+	binary.LittleEndian.PutUint32(f[2:], n)
+
+	bits := f[sizedFilterHeaderSize:]
+	for _, h := range keys {
+		delta := sizedFilterDelta(version, h)
+		for j := 0; j < k; j++ {
+			bitPos := h % n
+			bits[bitPos/8] |= 1 << (bitPos % 8)
+			h += delta
+		}
+	}
+	return f
+}
+
+// sizedFilterDelta returns the step between the probes of key hash h in a
+// filter of the given version.
+func sizedFilterDelta(version byte, h uint32) uint32 {
+	if version == sizedFilterVersionMixed {
+		return fmix32(h ^ mixedProbeSeed)
+	}
+	return h>>17 | h<<15
+}
+
+// Mixed reports whether the filter spreads its probes by a remix of the hash;
+// see NewSizedFilterMixed.
+func (f SizedFilter) Mixed() bool {
+	return len(f) > 0 && f[0] == sizedFilterVersionMixed
+}
+
+// NumBits returns the number of bits the filter uses, or 0 if f is too short
+// to hold a header.
+func (f SizedFilter) NumBits() int {
+	if len(f) < sizedFilterHeaderSize {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint32(f[2:]))
+}
+
+// MayContain returns whether the filter may contain given key. The bit count
+// is read from the header, not from len(f). Filters of an unknown version are
+// considered a match, and truncated ones never are.
+func (f SizedFilter) MayContain(h uint32) bool {
+	if len(f) < sizedFilterHeaderSize {
+		return false
+	}
+	version := f[0]
+	if version != sizedFilterVersion && version != sizedFilterVersionMixed {
+		return true
+	}
+	k := f[1]
+	nBits := binary.LittleEndian.Uint32(f[2:])
+	if nBits == 0 || uint64(len(f)-sizedFilterHeaderSize) < (uint64(nBits)+7)/8 {
+		return false
+	}
+	bits := f[sizedFilterHeaderSize:]
+	delta := sizedFilterDelta(version, h)
+	for j := uint8(0); j < k; j++ {
+		bitPos := h % nBits
+		if bits[bitPos/8]&(1<<(bitPos%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+// Size returns the size of the filter proper in bytes, excluding anything
+// that follows it in the backing slice.
+func (f SizedFilter) Size() int {
+	if len(f) < sizedFilterHeaderSize {
+		return len(f)
+	}
+	return min(len(f), sizedFilterHeaderSize+(f.NumBits()+7)/8)
+}
+
+// Serialize returns the filter proper, without copying.
+func (f SizedFilter) Serialize() []byte {
+	return f[:f.Size()]
+}
+
+// DeserializeSizedFilter validates data as a SizedFilter and returns it
+// without copying, trimmed to the filter proper. The returned error wraps
+// ErrShortBuffer if data is too short for the header or the bit count it
+// declares, or ErrUnsupportedVersion for an unknown version or a k reserved by
+// Filter for other encodings.
+func DeserializeSizedFilter(data []byte) (SizedFilter, error) {
+	if len(data) < sizedFilterHeaderSize {
+		return nil, fmt.Errorf("sized bloom filter: got %d bytes, want at least %d: %w",
+			len(data), sizedFilterHeaderSize, ErrShortBuffer)
+	}
+	if data[0] != sizedFilterVersion && data[0] != sizedFilterVersionMixed {
+		return nil, fmt.Errorf("sized bloom filter version %d: %w", data[0], ErrUnsupportedVersion)
+	}
+	if k := data[1]; k > 30 {
+		return nil, fmt.Errorf("sized bloom filter encoding k=%d: %w", k, ErrUnsupportedVersion)
+	}
+	f := SizedFilter(data)
+	if want := sizedFilterHeaderSize + (f.NumBits()+7)/8; f.NumBits() == 0 || len(data) < want {
+		return nil, fmt.Errorf("sized bloom filter of %d bits: got %d bytes, want %d: %w",
+			f.NumBits(), len(data), want, ErrShortBuffer)
+	}
+	return f[:f.Size()], nil
+}
@@ -0,0 +1,146 @@
+package y
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSizedFilterLargerBackingSlice(t *testing.T) {
+	keys := GenerateSortedKeyHashes(100)
+	// Not a multiple of 8, so the bit count cannot come from the length.
+	exact := NewSizedFilter(keys, 1003, 6)
+	if got := exact.NumBits(); got != 1003 {
+		t.Fatalf("NumBits = %d, want 1003", got)
+	}
+
+	// The filter at the start of a larger buffer whose tail is all ones, as
+	// if it held other data. A length-derived bit count would both hash to
+	// different positions and read set bits from the tail.
+	buf := make([]byte, 4*len(exact))
+	copy(buf, exact)
+	for i := len(exact); i < len(buf); i++ {
+		buf[i] = 0xff
+	}
+	padded := SizedFilter(buf)
+	if padded.NumBits() != 1003 || padded.Size() != len(exact) {
+		t.Fatalf("Padded filter: NumBits %d, Size %d, want 1003 and %d",
+			padded.NumBits(), padded.Size(), len(exact))
+	}
+	for _, h := range keys {
+		if !padded.MayContain(h) {
+			t.Fatalf("False negative for key hash %d", h)
+		}
+	}
+	for h := uint32(0); h < 100000; h++ {
+		if padded.MayContain(h*2654435761) != exact.MayContain(h*2654435761) {
+			t.Fatalf("Padded and exact filters disagree on key hash %d", h*2654435761)
+		}
+	}
+
+	f, err := DeserializeSizedFilter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f) != len(exact) {
+		t.Errorf("DeserializeSizedFilter kept %d bytes, want %d", len(f), len(exact))
+	}
+}
+
+func TestSizedFilterMatchesFilter(t *testing.T) {
+	keys := GenerateSortedKeyHashes(500)
+	sized := NewSizedFilter(keys, 4096, 7)
+	legacy := NewFilterK(keys, 4096, 7)
+	for h := uint32(0); h < 100000; h++ {
+		if sized.MayContain(h*2654435761) != legacy.MayContain(h*2654435761) {
+			t.Fatalf("Sized and legacy filters disagree on key hash %d", h*2654435761)
+		}
+	}
+}
+
+func TestDeserializeSizedFilterErrors(t *testing.T) {
+	f := NewSizedFilter(GenerateSortedKeyHashes(10), 100, 3)
+	for name, tc := range map[string]struct {
+		data []byte
+		want error
+	}{
+		"short header": {f[:sizedFilterHeaderSize-1], ErrShortBuffer},
+		"short bits":   {f[:len(f)-1], ErrShortBuffer},
+		"version":      {append([]byte{sizedFilterVersionMixed + 1}, f[1:]...), ErrUnsupportedVersion},
+		"reserved k":   {append([]byte{sizedFilterVersion, 31}, f[2:]...), ErrUnsupportedVersion},
+	} {
+		if _, err := DeserializeSizedFilter(tc.data); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", name, err, tc.want)
+		}
+	}
+	if f[:len(f)-1].MayContain(0) {
+		t.Errorf("Truncated filter reported a match")
+	}
+}
+
+// TestSizedFilterMixedFPRate compares the measured false positive rate of the
+// rotated and the remixed probe step at the same bit count and k.
+func TestSizedFilterMixedFPRate(t *testing.T) {
+	keyCount, queries := 100000, 200000
+	for _, hash := range []struct {
+		name string
+		fn   HashFunc
+	}{{"Hash", Hash}, {"FNV1a", FNV1aHash}, {"Murmur3", Murmur3Hash}} {
+		keys := make([]uint32, keyCount)
+		var buf []byte
+		for i := range keys {
+			buf = fmt.Appendf(buf[:0], "key_%010d", i)
+			keys[i] = hash.fn(buf)
+		}
+		for _, bitsPerKey := range []int{4, 10, 16} {
+			nBits, k := keyCount*bitsPerKey, filterK(bitsPerKey)
+			rotated := NewSizedFilter(keys, nBits, k)
+			mixed := NewSizedFilterMixed(keys, nBits, k)
+			if rotated.Mixed() || !mixed.Mixed() {
+				t.Fatalf("Mixed() = %v and %v", rotated.Mixed(), mixed.Mixed())
+			}
+			for _, h := range keys {
+				if !mixed.MayContain(h) {
+					t.Fatalf("%s: false negative for key hash %d", hash.name, h)
+				}
+			}
+
+			var rotatedFP, mixedFP int
+			for i := 0; i < queries; i++ {
+				buf = fmt.Appendf(buf[:0], "miss_%010d", i)
+				h := hash.fn(buf)
+				if rotated.MayContain(h) {
+					rotatedFP++
+				}
+				if mixed.MayContain(h) {
+					mixedFP++
+				}
+			}
+			t.Logf("%s, %d bits/key, k=%d: rotated %.4f%%, mixed %.4f%%, theory %.4f%%", hash.name,
+				bitsPerKey, k, 100*float64(rotatedFP)/float64(queries), 100*float64(mixedFP)/float64(queries),
+				100*bloomFPRate(nBits, keyCount, k))
+			// Allow for sampling noise where both schemes are near theory.
+			if float64(mixedFP) > 1.05*float64(rotatedFP)+10 {
+				t.Errorf("%s, %d bits/key: mixed step has %d false positives, rotated %d",
+					hash.name, bitsPerKey, mixedFP, rotatedFP)
+			}
+		}
+	}
+}
+
+func TestSizedFilterMixedRoundtrip(t *testing.T) {
+	keys := GenerateSortedKeyHashes(1000)
+	f := NewSizedFilterMixed(keys, 10000, 7)
+	restored, err := DeserializeSizedFilter(f.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored.Mixed() {
+		t.Fatal("Roundtrip lost the mixed probe step")
+	}
+	for h := uint32(0); h < 100000; h++ {
+		if restored.MayContain(h*2654435761) != f.MayContain(h*2654435761) {
+			t.Fatalf("Restored and original filters disagree on key hash %d", h*2654435761)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+/*
+ * Small filter - exact membership for tables with a handful of keys
+ *
+ * A bloom filter over a few keys still answers with its full false positive
+ * rate, and its 64-bit minimum size means a table with three keys pays for
+ * more bits per key than one with a thousand. Below SmallFilterMaxExactKeys
+ * keys, SmallFilter stores the sorted key hashes themselves and answers by
+ * binary search, with no false positives at all: only a key whose hash equals
+ * a stored one is accepted. That costs 4 bytes per key, more than a 10-bit
+ * bloom, but at most a few dozen bytes. Above the threshold it holds a plain
+ * bloom Filter, so callers query either through the same MayContain.
+ */
+
+package y
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// SmallFilterMaxExactKeys is the number of distinct key hashes below which
+// NewSmallFilter stores the hashes exactly.
+const SmallFilterMaxExactKeys = 16
+
+// Modes of a SmallFilter, stored in its first byte.
+const (
+	smallFilterExact = iota + 1
+	smallFilterBloom
+)
+
+// SmallFilter is a membership filter that is exact for small key sets and a
+// bloom filter otherwise. In the exact regime it has no false positives.
+// Format: [mode:1][sorted hashes:4*n] or [mode:1][Filter]
+// Like Filter, it is never modified after it is built.
+type SmallFilter []byte
+
+// NewSmallFilter returns a SmallFilter over keys: exact if there are fewer
+// than SmallFilterMaxExactKeys distinct hashes, otherwise holding
+// NewFilter(keys, bitsPerKey).
+func NewSmallFilter(keys []uint32, bitsPerKey int) SmallFilter {
+	sorted := slices.Compact(slices.Sorted(slices.Values(keys)))
+	if len(sorted) >= SmallFilterMaxExactKeys {
+		return SmallFilter(appendFilter([]byte{smallFilterBloom}, keys, bitsPerKey))
+	}
+	f := make(SmallFilter, 1, 1+4*len(sorted))
+	f[0] = smallFilterExact
+	for _, h := range sorted {
+		f = binary.LittleEndian.AppendUint32(f, h)
+	}
+	return f
+}
+
+// Exact reports whether the filter stores its key hashes exactly.
+func (f SmallFilter) Exact() bool {
+	return len(f) > 0 && f[0] == smallFilterExact
+}
+
+// MayContain returns whether the filter may contain the key hash. An exact
+// filter returns true only for the hashes it was built from. Filters of an
+// unknown mode are considered a match, and empty ones never are.
+func (f SmallFilter) MayContain(h uint32) bool {
+	if len(f) == 0 {
+		return false
+	}
+	switch f[0] {
+	case smallFilterExact:
+		hashes := f[1:]
+		n := len(hashes) / 4
+		i := sort.Search(n, func(i int) bool { return binary.LittleEndian.Uint32(hashes[4*i:]) >= h })
+		return i < n && binary.LittleEndian.Uint32(hashes[4*i:]) == h
+	case smallFilterBloom:
+		return Filter(f[1:]).MayContain(h)
+	default:
+		return true
+	}
+}
+
+// Size returns the serialized size in bytes.
+func (f SmallFilter) Size() int {
+	return len(f)
+}
+
+// Serialize returns the filter's bytes.
+func (f SmallFilter) Serialize() []byte {
+	return f
+}
+
+// DeserializeSmallFilter validates data as a SmallFilter and returns it
+// without copying. The returned error wraps ErrShortBuffer if data is empty
+// or ends partway through a hash, ErrUnsupportedVersion for an unknown mode
+// or exact hashes that are not strictly ascending, on which MayContain's
+// binary search would miss keys, or the error of DeserializeFilter for the
+// bloom.
+func DeserializeSmallFilter(data []byte) (SmallFilter, error) {
+	if len(data) < 1 {
+		return nil, fmt.Errorf("small filter: got no bytes: %w", ErrShortBuffer)
+	}
+	switch data[0] {
+	case smallFilterExact:
+		if (len(data)-1)%4 != 0 {
+			return nil, fmt.Errorf("small filter of %d bytes: partial hash: %w", len(data), ErrShortBuffer)
+		}
+		for i := 5; i < len(data); i += 4 {
+			if prev, h := binary.LittleEndian.Uint32(data[i-4:]), binary.LittleEndian.Uint32(data[i:]); h <= prev {
+				return nil, fmt.Errorf("small filter: hash %d follows %d: %w", h, prev, ErrUnsupportedVersion)
+			}
+		}
+	case smallFilterBloom:
+		if _, err := DeserializeFilter(data[1:]); err != nil {
+			return nil, fmt.Errorf("small filter: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("small filter mode %d: %w", data[0], ErrUnsupportedVersion)
+	}
+	return SmallFilter(data), nil
+}
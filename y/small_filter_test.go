@@ -0,0 +1,86 @@
+package y
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestSmallFilterExact(t *testing.T) {
+	keys := []uint32{900, 7, 123456, 7, 0xffffffff, 42}
+	f := NewSmallFilter(keys, 10)
+	if !f.Exact() {
+		t.Fatal("Filter over 5 distinct keys is not exact")
+	}
+	if want := 1 + 4*5; f.Size() != want {
+		t.Errorf("Size %d, want %d", f.Size(), want)
+	}
+	members := make(map[uint32]bool)
+	for _, h := range keys {
+		members[h] = true
+		if !f.MayContain(h) {
+			t.Fatalf("False negative for key hash %d", h)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	probes := []uint32{0, 6, 8, 41, 43, 0xfffffffe}
+	for i := 0; i < 100000; i++ {
+		probes = append(probes, rng.Uint32())
+	}
+	for _, h := range probes {
+		if !members[h] && f.MayContain(h) {
+			t.Fatalf("False positive for key hash %d", h)
+		}
+	}
+
+	restored, err := DeserializeSmallFilter(f.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restored, f) {
+		t.Error("Exact filter did not roundtrip")
+	}
+	if empty := NewSmallFilter(nil, 10); !empty.Exact() || empty.MayContain(0) {
+		t.Error("Empty filter is not an exact filter that rejects everything")
+	}
+}
+
+func TestSmallFilterBloomFallback(t *testing.T) {
+	keys := GenerateSortedKeyHashes(SmallFilterMaxExactKeys)
+	f := NewSmallFilter(keys, 10)
+	if f.Exact() {
+		t.Fatalf("Filter over %d keys is exact", len(keys))
+	}
+	if !bytes.Equal(f[1:], NewFilter(keys, 10)) {
+		t.Error("Fallback does not hold NewFilter's bloom")
+	}
+	for _, h := range keys {
+		if !f.MayContain(h) {
+			t.Fatalf("False negative for key hash %d", h)
+		}
+	}
+	if f := NewSmallFilter(keys[:SmallFilterMaxExactKeys-1], 10); !f.Exact() {
+		t.Errorf("Filter over %d keys is not exact", SmallFilterMaxExactKeys-1)
+	}
+}
+
+func TestDeserializeSmallFilterErrors(t *testing.T) {
+	for _, tc := range []struct {
+		data []byte
+		want error
+	}{
+		{nil, ErrShortBuffer},
+		{[]byte{smallFilterExact, 1, 2, 3}, ErrShortBuffer},
+		{[]byte{smallFilterExact, 2, 0, 0, 0, 1, 0, 0, 0}, ErrUnsupportedVersion},
+		{[]byte{smallFilterExact, 1, 0, 0, 0, 1, 0, 0, 0}, ErrUnsupportedVersion},
+		{[]byte{smallFilterBloom, 0}, ErrShortBuffer},
+		{[]byte{smallFilterBloom, 0, 31}, ErrUnsupportedVersion},
+		{[]byte{0, 1, 2, 3, 4}, ErrUnsupportedVersion},
+	} {
+		if _, err := DeserializeSmallFilter(tc.data); !errors.Is(err, tc.want) {
+			t.Errorf("%v: got %v, want %v", tc.data, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+/*
+ * TableFilter - a common interface over the per-table membership filters
+ *
+ * Bloom, hybrid, XOR and ribbon filters all answer the same question for the
+ * read path: may this table hold the key? TableFilter lets callers hold any of
+ * them without branching on the concrete type, and DeserializeTableFilter
+ * rebuilds one from its bytes given the kind tag stored next to them.
+ *
+ * SerializeFilters packs many filters, e.g. per-block mini-filters, into one
+ * blob. Serializations of filters built alike share leading and trailing
+ * bytes (the magic, version and flags of hybrid filters, the k byte of blooms),
+ * which the blob stores once, and their lengths are stored as deltas from the
+ * previous one, one byte each when the filters are the same size.
+ */
+
+package y
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// TableFilter is an approximate membership filter over the key hashes of a
+// table. False positives are possible, false negatives are not.
+type TableFilter interface {
+	// MayContain returns whether the filter may contain the key hash.
+	MayContain(keyHash uint32) bool
+	// Size returns the serialized size in bytes.
+	Size() int
+	// Serialize converts the filter to bytes that DeserializeTableFilter
+	// reads back given the filter's kind.
+	Serialize() []byte
+}
+
+// Kind tags for DeserializeTableFilter. Zero is left unused so that a missing
+// tag is never mistaken for a filter.
+const (
+	FilterKindBloom byte = iota + 1
+	FilterKindHybrid
+	FilterKindXOR
+	FilterKindRibbon
+	FilterKindSizedBloom
+	FilterKindBlockedBloom
+	FilterKindCascade
+	FilterKindSmall
+	FilterKindCompactHybrid
+)
+
+var (
+	_ TableFilter = BloomTableFilter{}
+	_ TableFilter = (*HybridFilter)(nil)
+	_ TableFilter = (*ExtendedHybridFilter)(nil)
+	_ TableFilter = (*XORFilter)(nil)
+	_ TableFilter = (*RibbonFilter)(nil)
+	_ TableFilter = SizedFilter(nil)
+	_ TableFilter = BlockedFilter(nil)
+	_ TableFilter = (*CascadeFilter)(nil)
+	_ TableFilter = SmallFilter(nil)
+	_ TableFilter = (*CompactHybridFilter)(nil)
+)
+
+// BloomTableFilter adapts a bloom Filter to TableFilter. The filter is used
+// as is: Serialize returns its bytes without copying.
+type BloomTableFilter struct {
+	Filter
+}
+
+// Size returns the serialized size in bytes.
+func (f BloomTableFilter) Size() int {
+	return len(f.Filter)
+}
+
+// Serialize returns the filter's bytes.
+func (f BloomTableFilter) Serialize() []byte {
+	return f.Filter
+}
+
+// DeserializeTableFilter reads a filter of the given kind, one of the
+// FilterKind constants, from data written by its Serialize method. Hybrid
+// filters must be in the fixed-width format, whose bloom size follows from
+// the length of data; a compact one is rejected. The returned error wraps
+// ErrUnsupportedVersion for an unknown kind or a compact hybrid filter, or the
+// error of the kind's own decoder.
+func DeserializeTableFilter(kind byte, data []byte) (TableFilter, error) {
+	switch kind {
+	case FilterKindBloom:
+		f, err := DeserializeFilter(data)
+		if err != nil {
+			return nil, err
+		}
+		return BloomTableFilter{f}, nil
+	case FilterKindHybrid:
+		_, format, err := decodeHybridHeader(data)
+		if err != nil {
+			return nil, err
+		}
+		if format.compact {
+			// The varint trailer's length depends on its values, so the bloom
+			// size cannot be derived from len(data).
+			return nil, fmt.Errorf("hybrid table filter in the compact format: %w", ErrUnsupportedVersion)
+		}
+		bloomSize := len(data) - format.headerSize - format.trailerSize()
+		if bloomSize < 0 {
+			return nil, fmt.Errorf("hybrid table filter: got %d bytes, want at least %d: %w",
+				len(data), format.headerSize+format.trailerSize(), ErrShortBuffer)
+		}
+		hf, err := DeserializeHybridFilter(data, bloomSize)
+		if err != nil {
+			return nil, err
+		}
+		return hf, nil
+	case FilterKindXOR:
+		if xf := DeserializeXORFilter(data); xf != nil {
+			return xf, nil
+		}
+		return nil, fmt.Errorf("xor table filter of %d bytes: %w", len(data), ErrShortBuffer)
+	case FilterKindRibbon:
+		rf, err := DeserializeRibbonFilter(data)
+		if err != nil {
+			return nil, err
+		}
+		return rf, nil
+	case FilterKindSizedBloom:
+		f, err := DeserializeSizedFilter(data)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	case FilterKindBlockedBloom:
+		f, err := DeserializeBlockedFilter(data)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	case FilterKindCascade:
+		f, err := DeserializeCascadeFilter(data)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	case FilterKindSmall:
+		f, err := DeserializeSmallFilter(data)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	case FilterKindCompactHybrid:
+		f, err := DeserializeCompactHybridFilter(data)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	default:
+		return nil, fmt.Errorf("table filter kind %d: %w", kind, ErrUnsupportedVersion)
+	}
+}
+
+// tableFilterKind returns the kind tag DeserializeTableFilter needs for f, or
+// false if it cannot read filters of f's type.
+func tableFilterKind(f TableFilter) (byte, bool) {
+	switch f.(type) {
+	case BloomTableFilter:
+		return FilterKindBloom, true
+	case *HybridFilter:
+		return FilterKindHybrid, true
+	case *XORFilter:
+		return FilterKindXOR, true
+	case *RibbonFilter:
+		return FilterKindRibbon, true
+	case SizedFilter:
+		return FilterKindSizedBloom, true
+	case BlockedFilter:
+		return FilterKindBlockedBloom, true
+	case *CascadeFilter:
+		return FilterKindCascade, true
+	case SmallFilter:
+		return FilterKindSmall, true
+	case *CompactHybridFilter:
+		return FilterKindCompactHybrid, true
+	default:
+		return 0, false
+	}
+}
+
+// Filter sets written by SerializeFilters start with a 2-byte magic and a
+// 1-byte format version.
+const (
+	filterSetMagic   = "FS"
+	filterSetVersion = 1
+
+	// filterSetMixedKinds in place of the shared kind means that the kind of
+	// each filter follows.
+	filterSetMixedKinds = 0
+)
+
+// SerializeFilters packs filters into one blob that DeserializeFilters reads
+// back. A filter of a type DeserializeTableFilter cannot read, such as
+// *ExtendedHybridFilter, is an error wrapping ErrUnsupportedVersion.
+// Format: [magic:2][version:1][count:uvarint][kind:1 or 0 + count kinds]
+// [prefixLen:uvarint][prefix][suffixLen:uvarint][suffix]
+// [count length deltas:varint][payloads]
+// where each filter's serialization is prefix + payload + suffix.
+func SerializeFilters(filters []TableFilter) ([]byte, error) {
+	data := make([][]byte, len(filters))
+	kinds := make([]byte, len(filters))
+	mixed := false
+	for i, f := range filters {
+		kind, ok := tableFilterKind(f)
+		if !ok {
+			return nil, fmt.Errorf("SerializeFilters: filter %d of type %T: %w", i, f, ErrUnsupportedVersion)
+		}
+		kinds[i], data[i] = kind, f.Serialize()
+		mixed = mixed || kind != kinds[0]
+	}
+	prefix, suffix := commonAffixes(data)
+
+	buf := append([]byte(filterSetMagic), filterSetVersion)
+	buf = binary.AppendUvarint(buf, uint64(len(filters)))
+	if len(filters) == 0 {
+		return buf, nil
+	}
+	if mixed {
+		buf = append(buf, filterSetMixedKinds)
+		buf = append(buf, kinds...)
+	} else {
+		buf = append(buf, kinds[0])
+	}
+	buf = binary.AppendUvarint(buf, uint64(prefix))
+	buf = append(buf, data[0][:prefix]...)
+	buf = binary.AppendUvarint(buf, uint64(suffix))
+	buf = append(buf, data[0][len(data[0])-suffix:]...)
+	prev := int64(0)
+	for _, d := range data {
+		n := int64(len(d) - prefix - suffix)
+		buf = binary.AppendVarint(buf, n-prev)
+		prev = n
+	}
+	for _, d := range data {
+		buf = append(buf, d[prefix:len(d)-suffix]...)
+	}
+	return buf, nil
+}
+
+// commonAffixes returns the lengths of the longest prefix and, in what the
+// prefix leaves of the shortest slice, the longest suffix that all of data
+// share.
+func commonAffixes(data [][]byte) (prefix, suffix int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	shortest := len(data[0])
+	for _, d := range data {
+		shortest = min(shortest, len(d))
+	}
+	first := data[0]
+prefixes:
+	for ; prefix < shortest; prefix++ {
+		for _, d := range data {
+			if d[prefix] != first[prefix] {
+				break prefixes
+			}
+		}
+	}
+suffixes:
+	for ; suffix < shortest-prefix; suffix++ {
+		for _, d := range data {
+			if d[len(d)-1-suffix] != first[len(first)-1-suffix] {
+				break suffixes
+			}
+		}
+	}
+	return prefix, suffix
+}
+
+// DeserializeFilters reads filters written by SerializeFilters. Each filter is
+// decoded from its own copy of its bytes, so none of them alias data. The
+// returned error wraps ErrShortBuffer, ErrBadMagic or ErrUnsupportedVersion,
+// or the error of a filter's own decoder.
+func DeserializeFilters(data []byte) ([]TableFilter, error) {
+	if len(data) < len(filterSetMagic)+1 {
+		return nil, fmt.Errorf("filter set: got %d bytes: %w", len(data), ErrShortBuffer)
+	}
+	if string(data[:len(filterSetMagic)]) != filterSetMagic {
+		return nil, fmt.Errorf("filter set magic %q: %w", data[:len(filterSetMagic)], ErrBadMagic)
+	}
+	if v := data[len(filterSetMagic)]; v != filterSetVersion {
+		return nil, fmt.Errorf("filter set version %d: %w", v, ErrUnsupportedVersion)
+	}
+	rest := data[len(filterSetMagic)+1:]
+
+	count, n := binary.Uvarint(rest)
+	// Every filter takes at least its kind or length byte, which bounds the
+	// allocations below.
+	if n <= 0 || count > uint64(len(rest)) {
+		return nil, fmt.Errorf("filter set count: %w", ErrShortBuffer)
+	}
+	rest = rest[n:]
+	if count == 0 {
+		return []TableFilter{}, nil
+	}
+
+	kinds := make([]byte, count)
+	if len(rest) < 1 {
+		return nil, fmt.Errorf("filter set kind: %w", ErrShortBuffer)
+	}
+	if kind := rest[0]; kind != filterSetMixedKinds {
+		for i := range kinds {
+			kinds[i] = kind
+		}
+		rest = rest[1:]
+	} else {
+		if len(rest) < 1+len(kinds) {
+			return nil, fmt.Errorf("filter set kinds: %w", ErrShortBuffer)
+		}
+		copy(kinds, rest[1:])
+		rest = rest[1+len(kinds):]
+	}
+
+	var affixes [2][]byte
+	for i := range affixes {
+		size, n := binary.Uvarint(rest)
+		if n <= 0 || size > uint64(len(rest)-n) {
+			return nil, fmt.Errorf("filter set shared bytes: %w", ErrShortBuffer)
+		}
+		affixes[i] = rest[n : n+int(size)]
+		rest = rest[n+int(size):]
+	}
+	prefix, suffix := affixes[0], affixes[1]
+
+	lengths := make([]int, count)
+	prev := int64(0)
+	for i := range lengths {
+		delta, n := binary.Varint(rest)
+		if n <= 0 {
+			return nil, fmt.Errorf("filter set length %d: %w", i, ErrShortBuffer)
+		}
+		rest = rest[n:]
+		prev += delta
+		if prev < 0 || prev > int64(len(data)) {
+			return nil, fmt.Errorf("filter set length %d of %d bytes: %w", i, prev, ErrShortBuffer)
+		}
+		lengths[i] = int(prev)
+	}
+
+	filters := make([]TableFilter, count)
+	for i, size := range lengths {
+		if size > len(rest) {
+			return nil, fmt.Errorf("filter set filter %d: %d bytes in %d: %w", i, size, len(rest), ErrShortBuffer)
+		}
+		buf := make([]byte, 0, len(prefix)+size+len(suffix))
+		buf = append(append(append(buf, prefix...), rest[:size]...), suffix...)
+		rest = rest[size:]
+		f, err := DeserializeTableFilter(kinds[i], buf)
+		if err != nil {
+			return nil, fmt.Errorf("filter set filter %d: %w", i, err)
+		}
+		filters[i] = f
+	}
+	return filters, nil
+}
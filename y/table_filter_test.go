@@ -0,0 +1,159 @@
+package y
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestTableFilterImplementations(t *testing.T) {
+	keyCount, numBlocks := 2000, 20
+	keys := GenerateSortedKeyHashes(keyCount)
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+
+	filters := []struct {
+		name string
+		kind byte // 0 if DeserializeTableFilter cannot read it
+		f    TableFilter
+	}{
+		{"bloom", FilterKindBloom, BloomTableFilter{NewFilter(keys, 10)}},
+		{"hybrid", FilterKindHybrid, TrainHybridFilter(keys, blocks, numBlocks, DefaultHybridConfig())},
+		{"extended", 0, TrainExtendedHybridFilter(keys, blocks, numBlocks, DefaultHybridConfig())},
+		{"xor", FilterKindXOR, newTestXORFilter(t, keys)},
+		{"ribbon", FilterKindRibbon, NewRibbonFilter(keys, 8)},
+		{"sized", FilterKindSizedBloom, NewSizedFilter(keys, keyCount*10, 7)},
+		{"sized mixed", FilterKindSizedBloom, NewSizedFilterMixed(keys, keyCount*10, 7)},
+		{"blocked", FilterKindBlockedBloom, NewBlockedFilter(keys, 10)},
+		{"cascade", FilterKindCascade, NewCascadeFilter(keys, 3, 10)},
+		{"small", FilterKindSmall, NewSmallFilter(keys, 10)},
+		{"compact", FilterKindCompactHybrid, TrainCompactHybridFilter(keys, numBlocks, DefaultCompactConfig())},
+	}
+	for _, tc := range filters {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, h := range keys {
+				if !tc.f.MayContain(h) {
+					t.Fatalf("False negative for key hash %d", h)
+				}
+			}
+			data := tc.f.Serialize()
+			if tc.f.Size() != len(data) {
+				t.Errorf("Size %d, but Serialize wrote %d bytes", tc.f.Size(), len(data))
+			}
+			if tc.kind == 0 {
+				return
+			}
+			restored, err := DeserializeTableFilter(tc.kind, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, h := range keys {
+				if !restored.MayContain(h) {
+					t.Fatalf("False negative after roundtrip for key hash %d", h)
+				}
+			}
+		})
+	}
+}
+
+func TestDeserializeTableFilterErrors(t *testing.T) {
+	if _, err := DeserializeTableFilter(0, []byte{1, 2, 3}); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Unknown kind: got %v, want ErrUnsupportedVersion", err)
+	}
+	for _, kind := range []byte{FilterKindBloom, FilterKindHybrid, FilterKindXOR, FilterKindRibbon, FilterKindSizedBloom,
+		FilterKindBlockedBloom, FilterKindCascade, FilterKindCompactHybrid} {
+		if _, err := DeserializeTableFilter(kind, []byte{1}); !errors.Is(err, ErrShortBuffer) {
+			t.Errorf("Kind %d: got %v for a 1-byte payload, want ErrShortBuffer", kind, err)
+		}
+		if f, err := DeserializeTableFilter(kind, []byte{1, 2, 3}); err != nil && f != nil {
+			t.Errorf("Kind %d: got a non-nil %T along with %v", kind, f, err)
+		}
+	}
+
+	keys := GenerateSortedKeyHashes(100)
+	hf := TrainHybridFilter(keys, GenerateBlockIndices(100, 10), 10, DefaultHybridConfig())
+	if _, err := DeserializeTableFilter(FilterKindHybrid, hf.SerializeCompact()); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Compact hybrid payload: got %v, want ErrUnsupportedVersion", err)
+	}
+}
+
+func TestSerializeFilters(t *testing.T) {
+	// A per-block mini-filter for each of 100 blocks.
+	keyCount, numBlocks := 10000, 100
+	keys := GenerateSortedKeyHashes(keyCount)
+	blocks := GenerateBlockIndices(keyCount, numBlocks)
+	config := HybridFilterConfig{BloomSizeBytes: 16}
+	filters := make([]TableFilter, numBlocks)
+	naive := 0
+	for b := range filters {
+		lo, hi := b*keyCount/numBlocks, (b+1)*keyCount/numBlocks
+		filters[b] = TrainHybridFilter(keys[lo:hi], blocks[lo:hi], numBlocks, config)
+		naive += len(filters[b].Serialize())
+	}
+
+	blob, err := SerializeFilters(filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%d filters: %d bytes packed, %d concatenated", len(filters), len(blob), naive)
+	if len(blob) >= naive {
+		t.Errorf("Packed blob of %d bytes is not smaller than the %d-byte concatenation", len(blob), naive)
+	}
+	restored, err := DeserializeFilters(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != len(filters) {
+		t.Fatalf("Got %d filters back, want %d", len(restored), len(filters))
+	}
+	for b, f := range restored {
+		if !bytes.Equal(f.Serialize(), filters[b].Serialize()) {
+			t.Fatalf("Filter %d did not roundtrip", b)
+		}
+		lo, hi := b*keyCount/numBlocks, (b+1)*keyCount/numBlocks
+		for _, h := range keys[lo:hi] {
+			if !f.MayContain(h) {
+				t.Fatalf("Filter %d: false negative for key hash %d", b, h)
+			}
+		}
+	}
+
+	// Mixed kinds and sizes.
+	mixed := []TableFilter{
+		BloomTableFilter{NewFilter(keys[:100], 10)},
+		newTestXORFilter(t, keys[100:300]),
+		filters[0],
+		NewSizedFilter(keys[:10], 200, 3),
+		NewSmallFilter(keys[:5], 10),
+	}
+	mixedBlob, err := SerializeFilters(mixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err = DeserializeFilters(mixedBlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range restored {
+		if !bytes.Equal(f.Serialize(), mixed[i].Serialize()) {
+			t.Errorf("Mixed filter %d (%T) did not roundtrip as %T", i, mixed[i], f)
+		}
+	}
+
+	if empty, err := SerializeFilters(nil); err != nil {
+		t.Errorf("Empty set: %v", err)
+	} else if restored, err := DeserializeFilters(empty); err != nil || len(restored) != 0 {
+		t.Errorf("Empty set: got %d filters, %v", len(restored), err)
+	}
+	extended := TrainExtendedHybridFilter(keys[:100], blocks[:100], numBlocks, DefaultHybridConfig())
+	if _, err := SerializeFilters([]TableFilter{filters[0], extended}); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Extended hybrid filter: got %v, want ErrUnsupportedVersion", err)
+	}
+	for i := 0; i < len(blob); i++ {
+		if _, err := DeserializeFilters(blob[:i]); err == nil {
+			t.Fatalf("Truncated to %d bytes: no error", i)
+		}
+	}
+	if _, err := DeserializeFilters([]byte("XX\x01\x00")); !errors.Is(err, ErrBadMagic) {
+		t.Errorf("Bad magic: got %v", err)
+	}
+}
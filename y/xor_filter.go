@@ -0,0 +1,210 @@
+/*
+ * XOR Filter - static approximate membership for SSTables
+ *
+ * SSTable filters are built once and never modified, so a static filter can
+ * beat bloom on space. An XOR filter stores one 8-bit fingerprint per slot in
+ * ~1.23 slots per key and answers a query by XOR-ing three slots, giving a
+ * ~0.39% false positive rate at ~9.8 bits/key.
+ *
+ * Reference: Graf & Lemire, "Xor Filters: Faster and Smaller Than Bloom and
+ * Cuckoo Filters" (2020).
+ */
+
+package y
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"slices"
+)
+
+// XORFilter is an 8-bit XOR filter over a set of key hashes. It is immutable
+// once built and safe for concurrent use.
+type XORFilter struct {
+	Seed         uint64  // Seed mixed into every key hash
+	BlockLength  uint32  // Number of slots in each of the three blocks
+	Fingerprints []uint8 // 3 * BlockLength fingerprints
+}
+
+// xorFilterHeaderSize is the serialized size of Seed and BlockLength.
+const xorFilterHeaderSize = 8 + 4
+
+// xorMaxAttempts bounds the number of seeds tried during construction. Each
+// attempt succeeds with high probability, so this is not reached in practice;
+// if it is, NewXORFilter fails rather than return a filter missing keys.
+const xorMaxAttempts = 100
+
+// NewXORFilter builds an XOR filter from key hashes (from y.Hash). Duplicate
+// hashes are allowed and are stored once. The returned error wraps
+// ErrFilterBuild if no seed peels every key, in which case a partial filter
+// would give false negatives and none is returned; callers can fall back to a
+// bloom filter.
+func NewXORFilter(hashes []uint32) (*XORFilter, error) {
+	keys := slices.Clone(hashes)
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+
+	capacity := 32 + uint32(1.23*float64(len(keys)))
+	blockLength := capacity / 3
+	xf := &XORFilter{
+		BlockLength:  blockLength,
+		Fingerprints: make([]uint8, 3*blockLength),
+	}
+
+	size := 3 * blockLength
+	xorMask := make([]uint64, size)
+	count := make([]uint32, size)
+	queue := make([]uint32, 0, size)
+	stackHash := make([]uint64, 0, len(keys))
+	stackSlot := make([]uint32, 0, len(keys))
+
+	seed := uint64(0x9e3779b97f4a7c15)
+	peeled := false
+	for attempt := 0; attempt < xorMaxAttempts && !peeled; attempt++ {
+		seed = splitmix64(seed)
+		xf.Seed = seed
+		clear(xorMask)
+		clear(count)
+		queue = queue[:0]
+		stackHash = stackHash[:0]
+		stackSlot = stackSlot[:0]
+
+		for _, k := range keys {
+			h := xf.mix(k)
+			for _, slot := range xf.slots(h) {
+				xorMask[slot] ^= h
+				count[slot]++
+			}
+		}
+		for slot := uint32(0); slot < size; slot++ {
+			if count[slot] == 1 {
+				queue = append(queue, slot)
+			}
+		}
+
+		// Peel slots that are referenced by exactly one key.
+		for len(queue) > 0 {
+			slot := queue[len(queue)-1]
+			queue = queue[:len(queue)-1]
+			if count[slot] != 1 {
+				continue
+			}
+			h := xorMask[slot]
+			stackHash = append(stackHash, h)
+			stackSlot = append(stackSlot, slot)
+			for _, other := range xf.slots(h) {
+				xorMask[other] ^= h
+				count[other]--
+				if count[other] == 1 {
+					queue = append(queue, other)
+				}
+			}
+		}
+
+		peeled = len(stackHash) == len(keys)
+	}
+	if !peeled {
+		return nil, fmt.Errorf("xor filter of %d keys: no peeling after %d seeds: %w",
+			len(keys), xorMaxAttempts, ErrFilterBuild)
+	}
+
+	// Assign fingerprints in reverse peeling order, so that each key's three
+	// slots XOR to its fingerprint.
+	for i := len(stackHash) - 1; i >= 0; i-- {
+		h, slot := stackHash[i], stackSlot[i]
+		s := xf.slots(h)
+		fp := fingerprint(h)
+		for _, other := range s {
+			if other != slot {
+				fp ^= xf.Fingerprints[other]
+			}
+		}
+		xf.Fingerprints[slot] = fp
+	}
+	return xf, nil
+}
+
+// MayContain returns whether the filter may contain the given key hash. False
+// positives are possible, false negatives are not.
+func (xf *XORFilter) MayContain(keyHash uint32) bool {
+	if xf == nil || xf.BlockLength == 0 {
+		return false
+	}
+	h := xf.mix(keyHash)
+	s := xf.slots(h)
+	return fingerprint(h) == xf.Fingerprints[s[0]]^xf.Fingerprints[s[1]]^xf.Fingerprints[s[2]]
+}
+
+// Size returns the serialized size in bytes.
+func (xf *XORFilter) Size() int {
+	return xorFilterHeaderSize + len(xf.Fingerprints)
+}
+
+// Serialize converts the XORFilter to bytes.
+// Format: [seed:8][blockLength:4][fingerprints:3*blockLength]
+func (xf *XORFilter) Serialize() []byte {
+	buf := make([]byte, xf.Size())
+	binary.LittleEndian.PutUint64(buf[0:8], xf.Seed)
+	binary.LittleEndian.PutUint32(buf[8:12], xf.BlockLength)
+	copy(buf[xorFilterHeaderSize:], xf.Fingerprints)
+	return buf
+}
+
+// DeserializeXORFilter reads an XORFilter from bytes. Returns nil if data is
+// too short for the block length it declares.
+func DeserializeXORFilter(data []byte) *XORFilter {
+	if len(data) < xorFilterHeaderSize {
+		return nil
+	}
+	xf := &XORFilter{
+		Seed:        binary.LittleEndian.Uint64(data[0:8]),
+		BlockLength: binary.LittleEndian.Uint32(data[8:12]),
+	}
+	n := 3 * int(xf.BlockLength)
+	if len(data)-xorFilterHeaderSize < n {
+		return nil
+	}
+	xf.Fingerprints = make([]uint8, n)
+	copy(xf.Fingerprints, data[xorFilterHeaderSize:])
+	return xf
+}
+
+// mix expands a 32-bit key hash into a seeded 64-bit hash.
+func (xf *XORFilter) mix(keyHash uint32) uint64 {
+	return murmurMix64(uint64(keyHash) + xf.Seed)
+}
+
+// slots returns the three slots, one per block, that a hash maps to.
+func (xf *XORFilter) slots(h uint64) [3]uint32 {
+	return [3]uint32{
+		reduce32(uint32(h), xf.BlockLength),
+		reduce32(uint32(bits.RotateLeft64(h, 21)), xf.BlockLength) + xf.BlockLength,
+		reduce32(uint32(bits.RotateLeft64(h, 42)), xf.BlockLength) + 2*xf.BlockLength,
+	}
+}
+
+func fingerprint(h uint64) uint8 {
+	return uint8(h ^ h>>32)
+}
+
+// reduce32 maps x uniformly onto [0, n) without a modulo.
+func reduce32(x, n uint32) uint32 {
+	return uint32(uint64(x) * uint64(n) >> 32)
+}
+
+func murmurMix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
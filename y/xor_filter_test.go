@@ -0,0 +1,143 @@
+/*
+ * Tests for the XOR filter implementation
+ */
+
+package y
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// newTestXORFilter builds an XOR filter from hashes, failing tb if it cannot.
+func newTestXORFilter(tb testing.TB, hashes []uint32) *XORFilter {
+	tb.Helper()
+	xf, err := NewXORFilter(hashes)
+	if err != nil {
+		tb.Fatalf("NewXORFilter(%d hashes): %v", len(hashes), err)
+	}
+	return xf
+}
+
+func TestXORFilterNoFalseNegatives(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 100, 10000} {
+		hashes := make([]uint32, n)
+		for i := 0; i < n; i++ {
+			hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+		}
+		xf := newTestXORFilter(t, hashes)
+		for i, h := range hashes {
+			if !xf.MayContain(h) {
+				t.Fatalf("n=%d: key %d missing from filter", n, i)
+			}
+		}
+	}
+}
+
+func TestXORFilterDuplicateHashes(t *testing.T) {
+	hashes := []uint32{42, 42, 7, 7, 7, 1000}
+	xf := newTestXORFilter(t, hashes)
+	for _, h := range hashes {
+		if !xf.MayContain(h) {
+			t.Errorf("hash %d missing from filter", h)
+		}
+	}
+}
+
+func TestXORFilterSerializationRoundtrip(t *testing.T) {
+	hashes := make([]uint32, 1000)
+	for i := range hashes {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+	}
+	original := newTestXORFilter(t, hashes)
+
+	data := original.Serialize()
+	if len(data) != original.Size() {
+		t.Errorf("Expected serialized size %d, got %d", original.Size(), len(data))
+	}
+
+	restored := DeserializeXORFilter(data)
+	if restored == nil {
+		t.Fatal("Failed to deserialize")
+	}
+	if restored.Seed != original.Seed || restored.BlockLength != original.BlockLength {
+		t.Errorf("Header mismatch: %d/%d vs %d/%d",
+			restored.Seed, restored.BlockLength, original.Seed, original.BlockLength)
+	}
+	if !bytes.Equal(restored.Fingerprints, original.Fingerprints) {
+		t.Error("Fingerprints mismatch")
+	}
+
+	if DeserializeXORFilter(data[:len(data)-1]) != nil {
+		t.Error("Expected nil for truncated data")
+	}
+}
+
+// TestXORFilterVsBloom compares an XOR filter against a bloom filter sized for
+// a 1% false positive rate on 100000 keys.
+func TestXORFilterVsBloom(t *testing.T) {
+	keyCount := 100000
+	hashes := make([]uint32, keyCount)
+	for i := 0; i < keyCount; i++ {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+	}
+
+	// 10 bits/key is the bloom sizing for a ~1% false positive rate.
+	bloom := NewFilter(hashes, 10)
+	xf := newTestXORFilter(t, hashes)
+
+	tests := 100000
+	bloomFP, xorFP := 0, 0
+	for i := 0; i < tests; i++ {
+		h := rand.Uint32()
+		if bloom.MayContain(h) {
+			bloomFP++
+		}
+		if xf.MayContain(h) {
+			xorFP++
+		}
+	}
+	bloomFPRate := float64(bloomFP) / float64(tests) * 100
+	xorFPRate := float64(xorFP) / float64(tests) * 100
+
+	t.Logf("Bloom: %d bytes (%.2f bytes/key), FP %.2f%%",
+		len(bloom), float64(len(bloom))/float64(keyCount), bloomFPRate)
+	t.Logf("XOR:   %d bytes (%.2f bytes/key), FP %.2f%%",
+		xf.Size(), float64(xf.Size())/float64(keyCount), xorFPRate)
+
+	if xf.Size() > len(bloom) {
+		t.Errorf("XOR filter (%d bytes) should not be larger than bloom (%d bytes)",
+			xf.Size(), len(bloom))
+	}
+	if xorFPRate >= bloomFPRate {
+		t.Errorf("XOR FP rate %.2f%% should be below bloom FP rate %.2f%%", xorFPRate, bloomFPRate)
+	}
+	if xorFPRate > 1 {
+		t.Errorf("XOR FP rate %.2f%% exceeds the 1%% target", xorFPRate)
+	}
+}
+
+func BenchmarkXORFilter(b *testing.B) {
+	keyCount := 100000
+	hashes := make([]uint32, keyCount)
+	for i := 0; i < keyCount; i++ {
+		hashes[i] = Hash([]byte(fmt.Sprintf("key_%010d", i)))
+	}
+	xf := newTestXORFilter(b, hashes)
+
+	b.Run("Build", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := NewXORFilter(hashes); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Query", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			xf.MayContain(rand.Uint32())
+		}
+	})
+}
@@ -32,6 +32,34 @@ var (
 	// ErrCommitAfterFinish indicates that write batch commit was called after
 	// finish
 	ErrCommitAfterFinish = stderrors.New("Batch commit not permitted after finish")
+
+	// ErrShortBuffer indicates that a serialized filter is shorter than its
+	// format requires.
+	ErrShortBuffer = stderrors.New("Serialized filter is too short")
+
+	// ErrBadMagic indicates that serialized data does not start with the
+	// expected filter magic.
+	ErrBadMagic = stderrors.New("Serialized filter has bad magic")
+
+	// ErrUnsupportedVersion indicates a serialized filter in a format version
+	// or encoding this code does not understand.
+	ErrUnsupportedVersion = stderrors.New("Serialized filter has unsupported version")
+
+	// ErrUnsorted indicates training input whose positions are not ascending,
+	// or whose block indices decrease as positions increase.
+	ErrUnsorted = stderrors.New("Training input is not sorted")
+
+	// ErrBudgetTooSmall indicates a size budget that cannot hold the smallest
+	// filter of the requested kind.
+	ErrBudgetTooSmall = stderrors.New("Size budget is too small for the filter")
+
+	// ErrBlockOutOfRange indicates training input with a block index at or
+	// beyond the table's number of blocks.
+	ErrBlockOutOfRange = stderrors.New("Block index is out of range")
+
+	// ErrFilterBuild indicates a filter whose construction did not succeed
+	// for the given keys, e.g. an XOR filter that no seed could peel.
+	ErrFilterBuild = stderrors.New("Filter construction failed")
 )
 
 type Flags int